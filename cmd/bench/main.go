@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/xaenox/memo-bot/internal/classifier"
+	"github.com/xaenox/memo-bot/internal/models"
+	"github.com/xaenox/memo-bot/internal/storage"
+	"github.com/xaenox/memo-bot/pkg/config"
+	"go.uber.org/zap"
+)
+
+// Fixed sample inputs so results are comparable between runs
+var samples = []string{
+	"Meeting with the design team on Thursday to review the Q3 project roadmap and deadlines",
+	"Buy milk, eggs, bread and coffee beans on the way home",
+	"Great article on Go concurrency patterns: https://go.dev/blog/pipelines",
+	"Flight to Berlin booked for May 12, hotel near Alexanderplatz still pending",
+	"Idea: a small app that reminds me to water the plants based on the weather forecast",
+}
+
+func main() {
+	configPath := flag.String("config", "config.yaml", "path to the config file")
+	iterations := flag.Int("n", 3, "number of passes over the sample set")
+	flag.Parse()
+
+	logger, _ := zap.NewProduction()
+	defer logger.Sync()
+
+	if *iterations < 1 {
+		logger.Fatal("Number of passes must be positive", zap.Int("n", *iterations))
+	}
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		logger.Fatal("Failed to load config", zap.Error(err), zap.String("path", *configPath))
+	}
+
+	// Nothing is persisted: threads go to a throwaway in-memory store
+	clf := classifier.NewGPTClassifier(
+		classifier.GPTConfig{
+			APIKey:             cfg.OpenAI.APIKey,
+			BaseURL:            cfg.OpenAI.BaseURL,
+			Azure:              cfg.OpenAI.APIType == config.APITypeAzure,
+			APIVersion:         cfg.OpenAI.APIVersion,
+			AssistantID:        cfg.OpenAI.AssistantID,
+			Model:              cfg.OpenAI.Model,
+			MaxTokens:          cfg.OpenAI.MaxTokens,
+			Temperature:        cfg.OpenAI.Temperature,
+			MaxTags:            cfg.Classifier.MaxTags,
+			RetryAttempts:      cfg.OpenAI.RetryAttempts,
+			RetryBaseDelay:     cfg.OpenAI.RetryBaseDelay,
+			Timeout:            cfg.OpenAI.Timeout,
+			Instructions:       cfg.Classifier.Instructions,
+			Models:             cfg.Classifier.Models,
+			MaxInputChars:      cfg.Classifier.MaxInputChars,
+			PreferExistingTags: cfg.Classifier.PreferExistingTags,
+			PollInterval:       cfg.Classifier.PollInterval,
+			PollMaxInterval:    cfg.Classifier.PollMaxInterval,
+		},
+		storage.NewMemoryStorage(storage.Limits{}),
+		zap.NewNop(),
+	)
+
+	var (
+		latencies []time.Duration
+		tokens    int
+		fallbacks int
+	)
+	for i := 0; i < *iterations; i++ {
+		for _, sample := range samples {
+			start := time.Now()
+			response := clf.GetStructuredAnalysis(context.Background(), sample, models.TextContent, 0)
+			latencies = append(latencies, time.Since(start))
+
+			tokens += response.TokensUsed
+			if response.Fallback {
+				fallbacks++
+			}
+		}
+	}
+
+	total := len(latencies)
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	fmt.Printf("model:         %s\n", cfg.OpenAI.Model)
+	fmt.Printf("assistant:     %s\n", cfg.OpenAI.AssistantID)
+	fmt.Printf("requests:      %d\n", total)
+	fmt.Printf("p50 latency:   %s\n", percentile(latencies, 0.50).Round(time.Millisecond))
+	fmt.Printf("p95 latency:   %s\n", percentile(latencies, 0.95).Round(time.Millisecond))
+	fmt.Printf("avg tokens:    %.1f\n", float64(tokens)/float64(total))
+	fmt.Printf("fallback rate: %.1f%%\n", float64(fallbacks)/float64(total)*100)
+}
+
+// percentile returns the nearest-rank percentile of sorted durations
+func percentile(sorted []time.Duration, q float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(q*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
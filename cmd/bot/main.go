@@ -1,67 +1,278 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+	// The runtime image has no zoneinfo; /timezone needs it
+	_ "time/tzdata"
+
+	"github.com/xaenox/memo-bot/internal/api"
 	"github.com/xaenox/memo-bot/internal/bot"
 	"github.com/xaenox/memo-bot/internal/classifier"
+	"github.com/xaenox/memo-bot/internal/health"
+	"github.com/xaenox/memo-bot/internal/metrics"
 	"github.com/xaenox/memo-bot/internal/storage"
+	"github.com/xaenox/memo-bot/internal/tracing"
 	"github.com/xaenox/memo-bot/pkg/config"
 	"go.uber.org/zap"
 )
 
-func main() {
-	// Initialize logger
-	logger, _ := zap.NewProduction()
-	defer logger.Sync()
+// version is set at build time with -ldflags "-X main.version=..."
+var version = "dev"
 
+func main() {
 	// Load configuration
 	cfg, err := config.LoadConfig("config.yaml")
 	if err != nil {
+		// The configured logger isn't known yet
+		logger, _ := zap.NewProduction()
 		logger.Fatal("Failed to load config", zap.Error(err), zap.String("path", "config.yaml"))
 	}
 
+	// Initialize logger
+	logger, err := newLogger(cfg.Logging)
+	if err != nil {
+		logger, _ := zap.NewProduction()
+		logger.Fatal("Failed to set up logging", zap.Error(err))
+	}
+	defer logger.Sync()
+
+	// Expose Prometheus metrics
+	metrics.SetBuildInfo(version)
+	var metricsServer *http.Server
+	if cfg.Metrics.ListenAddr != "" {
+		metricsServer = metrics.NewServer(cfg.Metrics.ListenAddr)
+		go func() {
+			logger.Info("Serving metrics", zap.String("listen_addr", cfg.Metrics.ListenAddr))
+			if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.Error("Metrics server failed", zap.Error(err))
+			}
+		}()
+	}
+
+	// Export traces when a collector is configured
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Telemetry.OTLPEndpoint, version)
+	if err != nil {
+		logger.Fatal("Failed to set up tracing", zap.Error(err))
+	}
+	if cfg.Telemetry.OTLPEndpoint != "" {
+		logger.Info("Exporting traces", zap.String("otlp_endpoint", cfg.Telemetry.OTLPEndpoint))
+	}
+
 	// Initialize storage
-	var store storage.Storage
-	if cfg.Database.UseInMemory {
-		logger.Info("Using in-memory storage")
-		store = storage.NewMemoryStorage()
-	} else {
-		logger.Info("Using PostgreSQL storage")
-		dbConfig := storage.DatabaseConfig{
-			Host:        cfg.Database.Host,
-			Port:        cfg.Database.Port,
-			User:        cfg.Database.User,
-			Password:    cfg.Database.Password,
-			DBName:      cfg.Database.DBName,
-			SSLMode:     cfg.Database.SSLMode,
-			UseInMemory: cfg.Database.UseInMemory,
-		}
-		store, err = storage.NewPostgresStorage(dbConfig, logger)
-		if err != nil {
-			logger.Fatal("Failed to initialize storage", zap.Error(err))
-		}
+	store, err := storage.New(cfg.Database, logger)
+	if err != nil {
+		logger.Fatal("Failed to initialize storage", zap.Error(err))
 	}
 	defer store.Close()
 
-	// Initialize classifier with storage
-	clf := classifier.NewGPTClassifier(
-		cfg.OpenAI.APIKey,
-		cfg.OpenAI.AssistantID,
-		cfg.OpenAI.Model,
-		cfg.OpenAI.MaxTokens,
-		cfg.OpenAI.Temperature,
-		cfg.Classifier.MaxTags,
-		store,
-		logger,
+	// Initialize the configured classifier
+	var (
+		clf classifier.Classifier
+		gpt *classifier.GPTClassifier
 	)
+	switch cfg.Classifier.Provider {
+	case config.ProviderSimple:
+		logger.Info("Using keyword classifier")
+		clf = classifier.NewSimpleClassifier(cfg.Classifier.MinConfidence, cfg.Classifier.MaxTags)
+	default:
+		logger.Info("Using GPT classifier", zap.String("model", cfg.OpenAI.Model))
+		var fallback classifier.Classifier
+		if cfg.Classifier.Fallback == config.FallbackSimple {
+			fallback = classifier.NewSimpleClassifier(cfg.Classifier.MinConfidence, cfg.Classifier.MaxTags)
+		}
+		gpt = classifier.NewGPTClassifier(
+			classifier.GPTConfig{
+				APIKey:               cfg.OpenAI.APIKey,
+				BaseURL:              cfg.OpenAI.BaseURL,
+				Azure:                cfg.OpenAI.APIType == config.APITypeAzure,
+				APIVersion:           cfg.OpenAI.APIVersion,
+				AssistantID:          cfg.OpenAI.AssistantID,
+				Model:                cfg.OpenAI.Model,
+				MaxTokens:            cfg.OpenAI.MaxTokens,
+				Temperature:          cfg.OpenAI.Temperature,
+				MaxTags:              cfg.Classifier.MaxTags,
+				RetryAttempts:        cfg.OpenAI.RetryAttempts,
+				RetryBaseDelay:       cfg.OpenAI.RetryBaseDelay,
+				Timeout:              cfg.OpenAI.Timeout,
+				CacheEnabled:         cfg.Classifier.CacheEnabled,
+				CacheSize:            cfg.Classifier.CacheSize,
+				CacheTTL:             cfg.Classifier.CacheTTL,
+				BatchConcurrency:     cfg.Classifier.BatchConcurrency,
+				Instructions:         cfg.Classifier.Instructions,
+				Models:               cfg.Classifier.Models,
+				MaxInputChars:        cfg.Classifier.MaxInputChars,
+				PreferExistingTags:   cfg.Classifier.PreferExistingTags,
+				PollInterval:         cfg.Classifier.PollInterval,
+				PollMaxInterval:      cfg.Classifier.PollMaxInterval,
+				Fallback:             fallback,
+				DailyTokenBudget:     cfg.Classifier.DailyTokenBudget,
+				UserDailyTokenBudget: cfg.Classifier.UserDailyTokenBudget,
+			},
+			store,
+			logger,
+		)
+		clf = gpt
+
+		if cfg.OpenAI.AssistantCheck != config.AssistantCheckOff {
+			checkCtx, cancel := context.WithTimeout(context.Background(), cfg.OpenAI.Timeout)
+			err := gpt.ValidateAssistant(checkCtx)
+			cancel()
+			switch {
+			case err == nil:
+			case cfg.OpenAI.AssistantCheck == config.AssistantCheckStrict:
+				logger.Fatal("OpenAI assistant is not usable", zap.Error(err))
+			default:
+				logger.Error("OpenAI assistant is not usable; notes will be classified by the fallback until it is fixed",
+					zap.Error(err))
+			}
+		}
+	}
 
 	// Initialize bot
-	b, err := bot.New(cfg.Telegram.Token, store, clf, logger)
+	botConfig := bot.Config{
+		Token:                  cfg.Telegram.Token,
+		MaxConcurrentUpdates:   cfg.Telegram.MaxConcurrentUpdates,
+		AdminIDs:               cfg.Telegram.AdminIDs,
+		LoadingMessage:         cfg.Telegram.LoadingMessage,
+		DetectDuplicates:       cfg.Telegram.DetectDuplicates,
+		UpdateDedup:            cfg.Telegram.UpdateDedup,
+		MinConfidence:          cfg.Classifier.MinConfidence,
+		MaxInputChars:          cfg.Classifier.MaxInputChars,
+		CategoryMatchThreshold: cfg.Classifier.CategoryMatchThreshold,
+		BotName:                cfg.Telegram.BotName,
+		WelcomeTemplate:        cfg.Telegram.WelcomeTemplate,
+		HelpTemplate:           cfg.Telegram.HelpTemplate,
+		GroupWindow:            cfg.Telegram.GroupWindow,
+		RespondInGroups:        cfg.Telegram.RespondInGroups,
+		WriteBufferSize:        cfg.Telegram.WriteBufferSize,
+		AutocorrectCommands:    cfg.Telegram.AutocorrectCommands,
+	}
+	b, err := bot.New(botConfig, store, clf, logger)
 	if err != nil {
 		logger.Fatal("Failed to create bot", zap.Error(err))
 	}
 
+	// Expose liveness and readiness probes
+	var healthServer *http.Server
+	if cfg.Health.ListenAddr != "" {
+		healthServer = health.NewServer(cfg.Health.ListenAddr, map[string]health.Check{
+			"storage":  store.CheckHealth,
+			"telegram": b.CheckHealth,
+		})
+		go func() {
+			logger.Info("Serving health checks", zap.String("listen_addr", cfg.Health.ListenAddr))
+			if err := healthServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.Error("Health server failed", zap.Error(err))
+			}
+		}()
+	}
+
+	// Let companion apps read notes
+	var apiServer *http.Server
+	if cfg.API.Enabled {
+		apiServer = api.NewServer(cfg.API.ListenAddr, cfg.API.Token, store, logger)
+		go func() {
+			logger.Info("Serving notes API", zap.String("listen_addr", cfg.API.ListenAddr))
+			if err := apiServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.Error("API server failed", zap.Error(err))
+			}
+		}()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Delete notes past their owner's retention now and then every day
+	purgeDone := make(chan struct{})
+	go func() {
+		defer close(purgeDone)
+		b.RunRetentionPurge(ctx, bot.RetentionPurgeInterval)
+	}()
+
+	// Save notes buffered during a database outage once it's back
+	writesDone := make(chan struct{})
+	go func() {
+		defer close(writesDone)
+		b.RunWriteBuffer(ctx)
+	}()
+
+	// Start a new token budget window every UTC midnight
+	if gpt != nil && (cfg.Classifier.DailyTokenBudget > 0 || cfg.Classifier.UserDailyTokenBudget > 0) {
+		go gpt.RunBudgetReset(ctx)
+	}
+
 	// Start the bot
-	if err := b.Start(); err != nil {
-		logger.Fatal("Bot error", zap.Error(err))
+	errCh := make(chan error, 1)
+	go func() {
+		if cfg.Telegram.WebhookURL != "" {
+			logger.Info("Using webhook mode", zap.String("listen_addr", cfg.Telegram.ListenAddr))
+			errCh <- b.StartWebhook(cfg.Telegram.ListenAddr, cfg.Telegram.WebhookURL)
+			return
+		}
+		logger.Info("Using long polling mode")
+		errCh <- b.Start()
+	}()
+
+	select {
+	case err := <-errCh:
+		if err != nil {
+			logger.Error("Bot error", zap.Error(err))
+		}
+	case <-ctx.Done():
+		logger.Info("Shutdown signal received")
+	}
+
+	// Stop the purge and the write buffer, let in-flight messages finish and
+	// save what is still buffered before storage is closed by the deferred
+	// Close
+	stop()
+	<-purgeDone
+	<-writesDone
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := b.Stop(shutdownCtx); err != nil {
+		logger.Error("Failed to stop bot gracefully", zap.Error(err))
+	}
+	b.FlushWrites(shutdownCtx)
+	if apiServer != nil {
+		if err := apiServer.Shutdown(shutdownCtx); err != nil {
+			logger.Error("Failed to stop API server", zap.Error(err))
+		}
+	}
+	if healthServer != nil {
+		if err := healthServer.Shutdown(shutdownCtx); err != nil {
+			logger.Error("Failed to stop health server", zap.Error(err))
+		}
+	}
+	if metricsServer != nil {
+		if err := metricsServer.Shutdown(shutdownCtx); err != nil {
+			logger.Error("Failed to stop metrics server", zap.Error(err))
+		}
+	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		logger.Error("Failed to flush traces", zap.Error(err))
+	}
+	logger.Info("Bot stopped")
+}
+
+// newLogger builds the logger described by the logging settings; both
+// formats log at the configured level and above
+func newLogger(cfg config.LoggingConfig) (*zap.Logger, error) {
+	level, err := zap.ParseAtomicLevel(cfg.Level)
+	if err != nil {
+		return nil, err
+	}
+
+	zapConfig := zap.NewProductionConfig()
+	if cfg.Format == config.LogFormatConsole {
+		zapConfig = zap.NewDevelopmentConfig()
 	}
+	zapConfig.Level = level
+	return zapConfig.Build()
 }
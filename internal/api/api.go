@@ -0,0 +1,170 @@
+// Package api serves users' notes over HTTP for companion apps.
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xaenox/memo-bot/internal/models"
+	"github.com/xaenox/memo-bot/internal/storage"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultLimit = 20
+	maxLimit     = 100
+)
+
+type server struct {
+	storage storage.Storage
+	token   string
+	logger  *zap.Logger
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+type messagesResponse struct {
+	Messages []*models.Message `json:"messages"`
+	Limit    int               `json:"limit"`
+	Offset   int               `json:"offset"`
+}
+
+type tagsResponse struct {
+	Tags []string `json:"tags"`
+}
+
+type categoriesResponse struct {
+	Categories []string `json:"categories"`
+}
+
+// NewServer returns an HTTP server with read-only endpoints for any user's
+// notes. Every request must carry "Authorization: Bearer <token>", so the
+// token belongs on the companion app's backend, never on users' devices.
+//
+//	GET /api/users/{id}/messages?limit=&offset=
+//	GET /api/users/{id}/tags
+//	GET /api/users/{id}/categories
+func NewServer(addr, token string, store storage.Storage, logger *zap.Logger) *http.Server {
+	s := &server{storage: store, token: token, logger: logger}
+
+	mux := http.NewServeMux()
+	mux.Handle("/api/users/", s.authorize(http.HandlerFunc(s.handleUser)))
+
+	return &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+}
+
+func (s *server) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSON(w, http.StatusUnauthorized, errorResponse{Error: "invalid or missing bearer token"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleUser routes /api/users/{id}/{resource}
+func (s *server) handleUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSON(w, http.StatusMethodNotAllowed, errorResponse{Error: "method not allowed"})
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/users/"), "/")
+	if len(parts) != 2 {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "not found"})
+		return
+	}
+	userID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || userID == 0 {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "invalid user id"})
+		return
+	}
+
+	switch parts[1] {
+	case "messages":
+		s.handleMessages(w, r, userID)
+	case "tags":
+		tags, err := s.storage.GetUserTags(r.Context(), userID)
+		if err != nil {
+			s.writeError(w, err, "Failed to get user tags", userID)
+			return
+		}
+		writeJSON(w, http.StatusOK, tagsResponse{Tags: nonNil(tags)})
+	case "categories":
+		categories, err := s.storage.GetUserCategories(r.Context(), userID)
+		if err != nil {
+			s.writeError(w, err, "Failed to get user categories", userID)
+			return
+		}
+		writeJSON(w, http.StatusOK, categoriesResponse{Categories: nonNil(categories)})
+	default:
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "not found"})
+	}
+}
+
+func (s *server) handleMessages(w http.ResponseWriter, r *http.Request, userID int64) {
+	limit, err := queryInt(r, "limit", defaultLimit)
+	if err != nil || limit < 1 {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "limit must be a positive integer"})
+		return
+	}
+	limit = min(limit, maxLimit)
+	offset, err := queryInt(r, "offset", 0)
+	if err != nil || offset < 0 {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "offset must be a non-negative integer"})
+		return
+	}
+
+	messages, err := s.storage.GetUserMessages(r.Context(), userID, limit, offset)
+	if err != nil {
+		s.writeError(w, err, "Failed to get user messages", userID)
+		return
+	}
+	if messages == nil {
+		messages = []*models.Message{}
+	}
+	writeJSON(w, http.StatusOK, messagesResponse{Messages: messages, Limit: limit, Offset: offset})
+}
+
+func (s *server) writeError(w http.ResponseWriter, err error, msg string, userID int64) {
+	s.logger.Error(msg,
+		zap.Error(err),
+		zap.Int64("user_id", userID))
+	writeJSON(w, http.StatusInternalServerError, errorResponse{Error: "internal error"})
+}
+
+func queryInt(r *http.Request, name string, fallback int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return fallback, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// nonNil makes empty lists encode as [] rather than null
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
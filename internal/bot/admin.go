@@ -0,0 +1,92 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/xaenox/memo-bot/internal/models"
+	"go.uber.org/zap"
+)
+
+const (
+	// Telegram allows about 30 messages per second across all chats; stay below
+	broadcastInterval = time.Second / 25
+	broadcastPageSize = 500
+)
+
+// isAdmin reports whether userID is listed in telegram.admin_ids
+func (b *Bot) isAdmin(userID int64) bool {
+	return b.admins[userID]
+}
+
+// handleBroadcast sends a message to every user; the command is admin-only
+func (b *Bot) handleBroadcast(ctx context.Context, message *tgbotapi.Message) {
+	text := strings.TrimSpace(message.CommandArguments())
+	if text == "" {
+		b.sendMessage(message.Chat.ID, "Please provide the message to send.\nUsage: /broadcast <message>")
+		return
+	}
+
+	// Collect everyone first so users who show up meanwhile don't shift pages
+	var users []*models.User
+	for offset := 0; ; offset += broadcastPageSize {
+		page, err := b.storage.ListUsers(ctx, broadcastPageSize, offset)
+		if err != nil {
+			b.log(ctx).Error("Failed to list users",
+				zap.Error(err),
+				zap.Int("offset", offset))
+			b.sendStorageError(ctx, message.Chat.ID, err, tr(ctx, errMsgRetrieval))
+			return
+		}
+		users = append(users, page...)
+		if len(page) < broadcastPageSize {
+			break
+		}
+	}
+
+	b.sendMessage(message.Chat.ID, fmt.Sprintf("📣 Sending to %d users...", len(users)))
+
+	ticker := time.NewTicker(broadcastInterval)
+	defer ticker.Stop()
+
+	sent, failed := 0, 0
+	for i, user := range users {
+		if i > 0 {
+			// Stop waits for handlers, so give up as soon as it is called
+			// rather than when the handler context is finally cancelled
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+			case <-b.stopPolling:
+			}
+		}
+		if b.stopping(ctx) {
+			// Shutting down; say how far we got
+			b.log(ctx).Warn("Broadcast interrupted",
+				zap.Int64("admin_id", message.From.ID),
+				zap.Int("sent", sent),
+				zap.Int("failed", failed),
+				zap.Int("remaining", len(users)-i))
+			b.sendMessage(message.Chat.ID, fmt.Sprintf("Broadcast interrupted by shutdown: %d delivered, %d failed, %d not sent.", sent, failed, len(users)-i))
+			return
+		}
+		// Users talk to the bot in a private chat whose ID is their user ID
+		if _, err := b.sender.SendMessage(user.ID, text); err != nil {
+			b.log(ctx).Warn("Failed to deliver broadcast",
+				zap.Error(err),
+				zap.Int64("recipient_id", user.ID))
+			failed++
+			continue
+		}
+		sent++
+	}
+
+	b.log(ctx).Info("Broadcast finished",
+		zap.Int64("admin_id", message.From.ID),
+		zap.Int("sent", sent),
+		zap.Int("failed", failed))
+	b.sendMessage(message.Chat.ID, fmt.Sprintf("Broadcast finished: %d delivered, %d failed.", sent, failed))
+}
@@ -0,0 +1,51 @@
+package bot
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/xaenox/memo-bot/internal/storage"
+)
+
+// stoppingSender calls stop once, when a message reaches a chat other than
+// the admin's
+type stoppingSender struct {
+	recordingSender
+	adminID int64
+	stop    sync.Once
+	onStop  func()
+}
+
+func (s *stoppingSender) SendMessage(chatID int64, text string) (tgbotapi.Message, error) {
+	if chatID != s.adminID {
+		s.stop.Do(s.onStop)
+	}
+	return s.recordingSender.SendMessage(chatID, text)
+}
+
+func TestBroadcastStopsWhenBotStops(t *testing.T) {
+	const adminID = 1
+	ctx := context.Background()
+	store := storage.NewMemoryStorage(storage.Limits{})
+	for _, userID := range []int64{2, 3, 4} {
+		if err := store.AddTag(ctx, userID, "tag"); err != nil {
+			t.Fatalf("AddTag: %v", err)
+		}
+	}
+
+	sender := &stoppingSender{adminID: adminID}
+	b, _ := newTestBot(t, Config{AdminIDs: []int64{adminID}}, store, &fakeClassifier{}, sender)
+	sender.onStop = func() { close(b.stopPolling) }
+
+	// The handler context stays live, as it does until Stop gives up waiting
+	b.handleBroadcast(ctx, commandMessage(adminID, "/broadcast hello"))
+
+	texts := sender.sentTexts()
+	last := texts[len(texts)-1]
+	if !strings.Contains(last, "interrupted") || !strings.Contains(last, "2 not sent") {
+		t.Errorf("last reply = %q, want an interrupted broadcast with 2 not sent", last)
+	}
+}
@@ -0,0 +1,64 @@
+package bot
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/xaenox/memo-bot/internal/storage"
+)
+
+var errUnclosedQuote = errors.New("unclosed quote")
+
+// commandArgs splits the command's arguments like splitArgs. When they can't
+// be split the user is told and ok is false.
+func (b *Bot) commandArgs(message *tgbotapi.Message) (args []string, ok bool) {
+	args, err := splitArgs(message.CommandArguments())
+	if err != nil {
+		b.sendMessage(message.Chat.ID, "Please close the quotes around multi-word names, e.g. /addcategory \"personal finance\"")
+		return nil, false
+	}
+	return args, true
+}
+
+// splitArgs splits command arguments at whitespace, keeping text in double
+// quotes together so "personal finance" is one argument. Curly quotes, which
+// phone keyboards often insert, work as well.
+func splitArgs(s string) ([]string, error) {
+	var (
+		args    []string
+		current strings.Builder
+		quoted  bool
+		started bool
+	)
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '“' || r == '”':
+			quoted = !quoted
+			started = true
+		case unicode.IsSpace(r) && !quoted:
+			if started {
+				args = append(args, current.String())
+				current.Reset()
+				started = false
+			}
+		default:
+			current.WriteRune(r)
+			started = true
+		}
+	}
+	if quoted {
+		return nil, errUnclosedQuote
+	}
+	if started {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
+
+// categoryArg reads arguments naming a single category, so both
+// "personal finance" and personal finance mean personal_finance
+func categoryArg(args []string) string {
+	return storage.NormalizeLabel(strings.Join(args, " "))
+}
@@ -0,0 +1,191 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/xaenox/memo-bot/internal/models"
+	"github.com/xaenox/memo-bot/internal/storage"
+	"go.uber.org/zap"
+)
+
+// backupVersion is the format /backup writes and the only one /restore reads
+const backupVersion = 1
+
+// handleBackup sends the user a JSON file with all of their data. Outside a
+// private chat the file goes to the user directly, so a group never sees it.
+func (b *Bot) handleBackup(ctx context.Context, message *tgbotapi.Message) {
+	backup, err := b.storage.BackupUser(ctx, message.From.ID)
+	if err != nil {
+		b.log(ctx).Error("Failed to collect backup",
+			zap.Error(err))
+		b.sendStorageError(ctx, message.Chat.ID, err, tr(ctx, errMsgRetrieval))
+		return
+	}
+	backup.Version = backupVersion
+	backup.CreatedAt = time.Now().UTC()
+
+	data, err := json.MarshalIndent(backup, "", "  ")
+	if err != nil {
+		b.log(ctx).Error("Failed to encode backup",
+			zap.Error(err))
+		b.sendErrorMessage(message.Chat.ID, tr(ctx, errMsgGeneral))
+		return
+	}
+	// Telegram won't hand a bigger file back to /restore
+	if len(data) > maxBackupSize {
+		b.log(ctx).Warn("Backup too large to restore",
+			zap.Int("bytes", len(data)),
+			zap.Int("notes", len(backup.Messages)))
+		b.sendMessage(message.Chat.ID, fmt.Sprintf("Your backup would be %d MB, more than the %d MB that can be restored. "+
+			"Delete some notes and try again.", len(data)>>20, maxBackupSize>>20))
+		return
+	}
+
+	chatID := message.Chat.ID
+	if !message.Chat.IsPrivate() {
+		chatID = message.From.ID
+	}
+	doc := tgbotapi.NewDocument(chatID, tgbotapi.FileBytes{
+		Name:  "memo-backup-" + backup.CreatedAt.Format("2006-01-02") + ".json",
+		Bytes: data,
+	})
+	doc.Caption = fmt.Sprintf("Backup of %d notes. Reply to this file with /restore to merge it into your notes, "+
+		"or /restore --replace to replace them.", len(backup.Messages))
+	if _, err := b.api.Send(doc); err != nil {
+		b.log(ctx).Error("Failed to send backup",
+			zap.Error(err))
+		if chatID != message.Chat.ID {
+			b.sendMessage(message.Chat.ID, "I couldn't message you privately. Please start a chat with me and try again.")
+			return
+		}
+		b.sendErrorMessage(message.Chat.ID, tr(ctx, errMsgGeneral))
+		return
+	}
+	if chatID != message.Chat.ID {
+		b.sendMessage(message.Chat.ID, "I sent your backup to you privately.")
+	}
+}
+
+// handleRestore reads a backup attached to or replied to with the command.
+// By default it is merged into the user's data; --replace swaps the data
+// for the backup's.
+func (b *Bot) handleRestore(ctx context.Context, message *tgbotapi.Message) {
+	var replace bool
+	switch strings.TrimSpace(message.CommandArguments()) {
+	case "":
+	case "--replace":
+		replace = true
+	default:
+		b.sendMessage(message.Chat.ID, restoreUsage)
+		return
+	}
+
+	doc := message.Document
+	if doc == nil && message.ReplyToMessage != nil {
+		doc = message.ReplyToMessage.Document
+	}
+	if doc == nil {
+		b.sendMessage(message.Chat.ID, restoreUsage)
+		return
+	}
+
+	if doc.FileSize > maxBackupSize {
+		b.sendMessage(message.Chat.ID, fmt.Sprintf("This file is larger than %d MB, so it can't be a backup I can restore.", maxBackupSize>>20))
+		return
+	}
+
+	data, err := b.downloadFile(doc.FileID, maxBackupSize)
+	if err != nil {
+		b.log(ctx).Error("Failed to download backup file",
+			zap.Error(err))
+		b.sendErrorMessage(message.Chat.ID, "Failed to download the backup file. Please try again.")
+		return
+	}
+
+	backup, err := parseBackup(data)
+	if err != nil {
+		b.sendMessage(message.Chat.ID, fmt.Sprintf("Invalid backup: %v", err))
+		return
+	}
+	// Restoring someone else's backup would hand you their notes
+	if backup.UserID != message.From.ID {
+		b.sendMessage(message.Chat.ID, "This backup belongs to another user and can't be restored here.")
+		return
+	}
+
+	restored, err := b.storage.RestoreUser(ctx, backup, replace)
+	if err != nil {
+		if errors.Is(err, storage.ErrInvalidInput) {
+			b.sendMessage(message.Chat.ID, fmt.Sprintf("Nothing was restored: %v", err))
+			return
+		}
+		b.log(ctx).Error("Failed to restore backup",
+			zap.Error(err),
+			zap.Bool("replace", replace),
+			zap.Int("notes", len(backup.Messages)))
+		b.sendStorageError(ctx, message.Chat.ID, err, "Failed to restore the backup. Nothing was changed, please try again.")
+		return
+	}
+
+	b.log(ctx).Info("Restored backup",
+		zap.Bool("replace", replace),
+		zap.Int("restored", restored),
+		zap.Int("notes", len(backup.Messages)))
+	if replace {
+		b.sendMessage(message.Chat.ID, fmt.Sprintf("Backup restored: your notes and settings were replaced with the backup's %d notes.", restored))
+		return
+	}
+	b.sendMessage(message.Chat.ID, fmt.Sprintf("Backup merged: %d notes added, %d already saved.",
+		restored, len(backup.Messages)-restored))
+}
+
+const restoreUsage = "Please attach a backup made with /backup.\n" +
+	"Usage: reply to the backup file with /restore to add its notes to yours, " +
+	"or with /restore --replace to delete your current notes and settings and use the backup's instead."
+
+// parseBackup decodes a backup document and checks its version and the
+// settings it carries, which would otherwise be trusted as if the user had
+// set them
+func parseBackup(data []byte) (*models.Backup, error) {
+	var backup models.Backup
+	if err := json.Unmarshal(data, &backup); err != nil {
+		return nil, fmt.Errorf("not valid JSON")
+	}
+	if backup.Version != backupVersion {
+		return nil, fmt.Errorf("unsupported version %d", backup.Version)
+	}
+	if backup.UserID == 0 {
+		return nil, fmt.Errorf("no user ID")
+	}
+
+	user := backup.User
+	if user == nil {
+		return &backup, nil
+	}
+	if user.DateFormat != "" {
+		if err := validateDateLayout(user.DateFormat); err != nil {
+			return nil, fmt.Errorf("invalid date format %q: %v", user.DateFormat, err)
+		}
+	}
+	if user.Language != "" && supportedLanguage(user.Language) != user.Language {
+		return nil, fmt.Errorf("unsupported language %q", user.Language)
+	}
+	if user.Timezone != "" {
+		if _, err := loadTimezone(user.Timezone); err != nil {
+			return nil, fmt.Errorf("unknown time zone %q", user.Timezone)
+		}
+	}
+	if t := user.Temperature; t != nil && (*t < 0 || *t > maxTemperature) {
+		return nil, fmt.Errorf("temperature %g is outside 0-%g", *t, maxTemperature)
+	}
+	if user.RetentionDays < 0 || user.RetentionDays > maxRetentionDays {
+		return nil, fmt.Errorf("retention of %d days is outside 0-%d", user.RetentionDays, maxRetentionDays)
+	}
+	return &backup, nil
+}
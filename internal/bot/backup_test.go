@@ -0,0 +1,60 @@
+package bot
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/xaenox/memo-bot/internal/models"
+)
+
+func TestParseBackup(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr bool
+	}{
+		{name: "valid", data: `{"version": 1, "user_id": 7, "user": {"language": "ru", "timezone": "Europe/Berlin"}}`},
+		{name: "no settings", data: `{"version": 1, "user_id": 7}`},
+		{name: "not JSON", data: `version 1`, wantErr: true},
+		{name: "other version", data: `{"version": 2, "user_id": 7}`, wantErr: true},
+		{name: "no user", data: `{"version": 1}`, wantErr: true},
+		{name: "unknown language", data: `{"version": 1, "user_id": 7, "user": {"language": "xx"}}`, wantErr: true},
+		{name: "unknown time zone", data: `{"version": 1, "user_id": 7, "user": {"timezone": "Mars/Olympus"}}`, wantErr: true},
+		{name: "negative retention", data: `{"version": 1, "user_id": 7, "user": {"retention_days": -1}}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseBackup([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseBackup() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// A file written by /backup is read back by /restore unchanged
+func TestParseBackupReadsWrittenBackup(t *testing.T) {
+	temperature := 0.4
+	backup := &models.Backup{
+		Version: backupVersion,
+		UserID:  7,
+		User:    &models.User{ID: 7, Language: "en", DateFormat: "2006-01-02", Temperature: &temperature, RetentionDays: 30},
+		Messages: []*models.Message{
+			{ID: "a", UserID: 7, Content: "note", Category: "work", Tags: []string{"meeting"}},
+		},
+		Links: []models.NoteLink{{FromID: "a", ToID: "b"}},
+	}
+	data, err := json.MarshalIndent(backup, "", "  ")
+	if err != nil {
+		t.Fatalf("MarshalIndent: %v", err)
+	}
+
+	parsed, err := parseBackup(data)
+	if err != nil {
+		t.Fatalf("parseBackup: %v", err)
+	}
+	if parsed.UserID != 7 || len(parsed.Messages) != 1 || parsed.Messages[0].Content != "note" ||
+		*parsed.User.Temperature != temperature || len(parsed.Links) != 1 {
+		t.Errorf("parseBackup() = %+v, want the written backup", parsed)
+	}
+}
@@ -2,75 +2,90 @@ package bot
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"text/template"
 	"time"
+	"unicode/utf8"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/google/uuid"
 	"github.com/xaenox/memo-bot/internal/classifier"
+	"github.com/xaenox/memo-bot/internal/logging"
+	"github.com/xaenox/memo-bot/internal/metrics"
 	"github.com/xaenox/memo-bot/internal/models"
 	"github.com/xaenox/memo-bot/internal/storage"
+	"github.com/xaenox/memo-bot/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
 )
 
+// MessageSender is the subset of the Bot API used to talk to chats
 type MessageSender interface {
 	SendMessage(chatID int64, text string) (tgbotapi.Message, error)
 	DeleteMessage(chatID int64, messageID int) error
 	SendReplyMessage(chatID int64, text string, replyToID int) (tgbotapi.Message, error)
+	SendChatAction(chatID int64, action string) error
 }
 
 func (b *Bot) handleAddCategory(ctx context.Context, message *tgbotapi.Message) {
-	args := strings.Fields(message.CommandArguments())
-	if len(args) == 0 {
+	args, ok := b.commandArgs(message)
+	if !ok {
+		return
+	}
+	category := categoryArg(args)
+	if category == "" {
 		b.sendMessage(message.Chat.ID, "Please provide a category name.\nUsage: /addcategory <category_name>")
 		return
 	}
 
-	category := strings.ToLower(args[0])
 	if err := b.storage.AddCategory(ctx, message.From.ID, category); err != nil {
-		b.logger.Error("Failed to add category",
+		b.log(ctx).Error("Failed to add category",
 			zap.Error(err),
-			zap.Int64("user_id", message.From.ID),
 			zap.String("category", category))
-		b.sendErrorMessage(message.Chat.ID, "Failed to add category. Please try again.")
+		b.sendStorageError(ctx, message.Chat.ID, err, "Failed to add category. Please try again.")
 		return
 	}
 
-	response := fmt.Sprintf("Added category: #%s", escapeMarkdown(category))
+	response := "Added category: " + escapeMarkdown(formatLabel(category))
 	msg := tgbotapi.NewMessage(message.Chat.ID, response)
 	msg.ParseMode = "MarkdownV2"
 	if _, err := b.api.Send(msg); err != nil {
-		b.logger.Error("Failed to send add category confirmation",
-			zap.Error(err),
-			zap.Int64("chat_id", message.Chat.ID))
+		b.log(ctx).Error("Failed to send add category confirmation",
+			zap.Error(err))
 	}
 }
 
 func (b *Bot) handleRemoveCategory(ctx context.Context, message *tgbotapi.Message) {
-	args := strings.Fields(message.CommandArguments())
-	if len(args) == 0 {
+	args, ok := b.commandArgs(message)
+	if !ok {
+		return
+	}
+	category := categoryArg(args)
+	if category == "" {
 		b.sendMessage(message.Chat.ID, "Please provide a category name.\nUsage: /removecategory <category_name>")
 		return
 	}
 
-	category := strings.ToLower(args[0])
 	if err := b.storage.RemoveCategory(ctx, message.From.ID, category); err != nil {
-		b.logger.Error("Failed to remove category",
+		b.log(ctx).Error("Failed to remove category",
 			zap.Error(err),
-			zap.Int64("user_id", message.From.ID),
 			zap.String("category", category))
-		b.sendErrorMessage(message.Chat.ID, "Failed to remove category. Please try again.")
+		b.sendStorageError(ctx, message.Chat.ID, err, "Failed to remove category. Please try again.")
 		return
 	}
 
-	response := fmt.Sprintf("Removed category: #%s", escapeMarkdown(category))
+	response := "Removed category: " + escapeMarkdown(formatLabel(category))
 	msg := tgbotapi.NewMessage(message.Chat.ID, response)
 	msg.ParseMode = "MarkdownV2"
 	if _, err := b.api.Send(msg); err != nil {
-		b.logger.Error("Failed to send remove category confirmation",
-			zap.Error(err),
-			zap.Int64("chat_id", message.Chat.ID))
+		b.log(ctx).Error("Failed to send remove category confirmation",
+			zap.Error(err))
 	}
 }
 
@@ -88,11 +103,10 @@ func (b *Bot) handleMaxTags(ctx context.Context, message *tgbotapi.Message) {
 	}
 
 	if err := b.storage.UpdateUserMaxTags(ctx, message.From.ID, maxTags); err != nil {
-		b.logger.Error("Failed to update max tags",
+		b.log(ctx).Error("Failed to update max tags",
 			zap.Error(err),
-			zap.Int64("user_id", message.From.ID),
 			zap.Int("max_tags", maxTags))
-		b.sendErrorMessage(message.Chat.ID, "Failed to update maximum tags. Please try again.")
+		b.sendStorageError(ctx, message.Chat.ID, err, "Failed to update maximum tags. Please try again.")
 		return
 	}
 
@@ -100,6 +114,8 @@ func (b *Bot) handleMaxTags(ctx context.Context, message *tgbotapi.Message) {
 	b.sendMessage(message.Chat.ID, response)
 }
 
+var _ MessageSender = (*TelegramMessageSender)(nil)
+
 type TelegramMessageSender struct {
 	api *tgbotapi.BotAPI
 }
@@ -125,58 +141,447 @@ func (t *TelegramMessageSender) SendReplyMessage(chatID int64, text string, repl
 	return t.api.Send(msg)
 }
 
-const (
-	errMsgGeneral    = "Sorry, something went wrong. Please try again later."
-	errMsgSave       = "Sorry, I couldn't save your message. Please try again."
-	errMsgRetrieval  = "Sorry, I couldn't retrieve the information. Please try again later."
-	errMsgClassify   = "Sorry, I had trouble analyzing your message. Please try again."
-	errMsgPermission = "Sorry, you don't have permission to do that."
-)
+func (t *TelegramMessageSender) SendChatAction(chatID int64, action string) error {
+	_, err := t.api.Request(tgbotapi.NewChatAction(chatID, action))
+	return err
+}
+
+// Config holds the bot's runtime settings
+type Config struct {
+	Token string
+	// MaxConcurrentUpdates bounds how many messages are handled at once
+	MaxConcurrentUpdates int
+	// AdminIDs may run admin commands
+	AdminIDs []int64
+	// MinConfidence is the assistant confidence below which the user is
+	// asked to confirm a classification
+	MinConfidence float64
+	// MaxInputChars is the classifier's input limit; notes far beyond it are
+	// refused. Zero accepts any length.
+	MaxInputChars int
+	// LoadingMessage posts a placeholder reply while a message is classified
+	LoadingMessage bool
+	// DetectDuplicates asks before saving a note the user already has
+	DetectDuplicates bool
+	// UpdateDedup is where handled update IDs are remembered: DedupMemory
+	// (the default), DedupStorage or DedupOff
+	UpdateDedup string
+	// CategoryMatchThreshold is how similar a new category must be to an
+	// existing one to be saved under it; zero keeps categories as given
+	CategoryMatchThreshold float64
+	// BotName is shown by the message templates; empty uses the bot's
+	// Telegram name
+	BotName string
+	// WelcomeTemplate and HelpTemplate replace the /start and /help texts
+	// when set; see templateData for what they can use
+	WelcomeTemplate string
+	HelpTemplate    string
+	// GroupWindow saves text messages a user sends within this long of each
+	// other as one note; zero saves every message on its own
+	GroupWindow time.Duration
+	// RespondInGroups classifies every message in group chats; otherwise
+	// only messages addressed to the bot are
+	RespondInGroups bool
+	// WriteBufferSize is how many notes are kept for a retry when the
+	// database is unreachable; zero reports the failure right away
+	WriteBufferSize int
+	// AutocorrectCommands runs a mistyped command when exactly one known
+	// command is an edit away; otherwise close commands are only suggested
+	AutocorrectCommands bool
+}
+
+const defaultMaxConcurrentUpdates = 10
 
 type Bot struct {
 	api        *tgbotapi.BotAPI
 	sender     MessageSender
 	storage    storage.Storage
-	classifier *classifier.GPTClassifier
+	classifier classifier.Classifier
 	logger     *zap.Logger
+	admins     map[int64]bool
+	// minConfidence triggers a review of less certain classifications
+	minConfidence float64
+	// maxNoteChars is the longest note accepted for classification
+	maxNoteChars int
+	// loadingMessage enables the "Analyzing..." placeholder reply
+	loadingMessage bool
+	// detectDuplicates checks new notes against the user's saved ones
+	detectDuplicates bool
+	// categoryMatchThreshold merges near-identical categories; see MatchCategory
+	categoryMatchThreshold float64
+	// botName, welcome and help customize /start and /help; nil templates
+	// send the built-in texts
+	botName string
+	welcome *template.Template
+	help    *template.Template
+	// callbacks maps the action prefix of callback data to its handler
+	callbacks map[string]callbackHandler
+	// commandList holds the commands in the order /help lists them and
+	// commands finds them by name
+	commandList []command
+	commands    map[string]command
+	// autocorrectCommands runs the command a mistyped one most likely meant
+	autocorrectCommands bool
+	// updates skips redelivered updates; nil handles every delivery
+	updates updateGuard
+	// grouper joins quick successive messages into one note; nil when
+	// grouping is off
+	grouper *noteGrouper
+	// respondInGroups classifies group messages not addressed to the bot
+	respondInGroups bool
+	// reclassify tracks /reclassify runs
+	reclassify *reclassifyJobs
+	// writes holds notes that couldn't be saved while the database was
+	// unreachable; nil when buffering is off
+	writes *writeBuffer
+
+	// inFlight tracks handler goroutines so Stop can wait for them
+	inFlight sync.WaitGroup
+	// handlerCtx is the parent of every update's context; Stop cancels it
+	// when handlers outlive the shutdown deadline
+	handlerCtx     context.Context
+	cancelHandlers context.CancelFunc
+	// polling is closed once Start or StartWebhook stops reading updates
+	polling chan struct{}
+	// stopPolling is closed by Stop to end long polling
+	stopPolling chan struct{}
+	stopOnce    sync.Once
+	// slots is a semaphore limiting concurrent handlers
+	slots chan struct{}
+
+	webhookMu       sync.Mutex
+	webhook         *http.Server
+	webhookDone     chan struct{}
+	webhookStopOnce sync.Once
 }
 
-func New(token string, storage storage.Storage, classifier *classifier.GPTClassifier, logger *zap.Logger) (*Bot, error) {
-	api, err := tgbotapi.NewBotAPI(token)
+func New(cfg Config, storage storage.Storage, classifier classifier.Classifier, logger *zap.Logger) (*Bot, error) {
+	api, err := tgbotapi.NewBotAPI(cfg.Token)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create bot: %w", err)
 	}
+	return newBot(cfg, api, storage, classifier, logger)
+}
 
+// newBot sets up a bot talking to Telegram through api
+func newBot(cfg Config, api *tgbotapi.BotAPI, storage storage.Storage, classifier classifier.Classifier, logger *zap.Logger) (*Bot, error) {
 	sender := NewTelegramMessageSender(api)
 
-	return &Bot{
-		api:        api,
-		sender:     sender,
-		storage:    storage,
-		classifier: classifier,
-		logger:     logger,
-	}, nil
+	welcome, err := parseTemplate("welcome", cfg.WelcomeTemplate)
+	if err != nil {
+		return nil, err
+	}
+	help, err := parseTemplate("help", cfg.HelpTemplate)
+	if err != nil {
+		return nil, err
+	}
+	botName := cfg.BotName
+	if botName == "" {
+		botName = api.Self.FirstName
+	}
+
+	maxConcurrent := cfg.MaxConcurrentUpdates
+	if maxConcurrent < 1 {
+		maxConcurrent = defaultMaxConcurrentUpdates
+	}
+
+	admins := make(map[int64]bool, len(cfg.AdminIDs))
+	for _, id := range cfg.AdminIDs {
+		admins[id] = true
+	}
+
+	b := &Bot{
+		api:                    api,
+		sender:                 sender,
+		storage:                storage,
+		classifier:             classifier,
+		logger:                 logger,
+		admins:                 admins,
+		minConfidence:          cfg.MinConfidence,
+		maxNoteChars:           noteCharLimit(cfg.MaxInputChars),
+		loadingMessage:         cfg.LoadingMessage,
+		detectDuplicates:       cfg.DetectDuplicates,
+		categoryMatchThreshold: cfg.CategoryMatchThreshold,
+		botName:                botName,
+		welcome:                welcome,
+		help:                   help,
+		polling:                make(chan struct{}),
+		stopPolling:            make(chan struct{}),
+		slots:                  make(chan struct{}, maxConcurrent),
+		updates:                newUpdateGuard(cfg.UpdateDedup, storage),
+		grouper:                newNoteGrouper(cfg.GroupWindow),
+		reclassify:             newReclassifyJobs(),
+		writes:                 newWriteBuffer(cfg.WriteBufferSize),
+		respondInGroups:        cfg.RespondInGroups,
+		autocorrectCommands:    cfg.AutocorrectCommands,
+	}
+	b.handlerCtx, b.cancelHandlers = context.WithCancel(context.Background())
+	b.registerCallbacks()
+	b.registerCommands()
+	return b, nil
+}
+
+// CheckHealth verifies that the Telegram Bot API is reachable with our token
+func (b *Bot) CheckHealth(ctx context.Context) error {
+	if _, err := b.api.GetMe(); err != nil {
+		return fmt.Errorf("telegram api unreachable: %w", err)
+	}
+	return nil
 }
 
 func (b *Bot) Start() error {
-	u := tgbotapi.NewUpdate(0)
+	defer close(b.polling)
+
+	ctx := context.Background()
+	b.publishMenu()
+
+	// Resume right after the last update we saw before the restart
+	offset, err := b.storage.GetUpdateOffset(ctx)
+	if err != nil {
+		b.logger.Warn("Failed to load update offset, starting from the beginning",
+			zap.Error(err))
+	}
+	if offset > 0 {
+		offset++
+	}
+
+	u := tgbotapi.NewUpdate(offset)
 	u.Timeout = 60
 
-	updates := b.api.GetUpdatesChan(u)
+	updates := make(chan tgbotapi.Update, b.api.Buffer)
+	pollErr := make(chan error, 1)
+	go func() {
+		defer close(updates)
+		pollErr <- b.pollUpdates(u, updates, b.stopPolling)
+	}()
+
+	b.processUpdates(ctx, updates, b.stopPolling, true)
+
+	select {
+	case err := <-pollErr:
+		return err
+	default:
+		// Stopped while a long poll is still in flight; it ends on its own
+		return nil
+	}
+}
+
+// processUpdates dispatches updates until the channel is closed or done is
+// closed. Polling persists the offset so a restart resumes where it left off.
+func (b *Bot) processUpdates(ctx context.Context, updates <-chan tgbotapi.Update, done <-chan struct{}, persistOffset bool) {
+	var offsets *updateOffsets
+	if persistOffset {
+		offsets = newUpdateOffsets()
+	}
+	for {
+		var update tgbotapi.Update
+		select {
+		case u, ok := <-updates:
+			if !ok {
+				return
+			}
+			update = u
+		case <-done:
+			return
+		}
+
+		updateID := update.UpdateID
+		offsets.begin(updateID)
+		if !b.firstDelivery(ctx, updateID) {
+			b.saveOffset(ctx, offsets, updateID)
+			continue
+		}
 
-	for update := range updates {
-		if update.Message == nil {
+		// Every log line about this update carries its request ID
+		reqCtx := logging.WithLogger(b.handlerCtx, b.logger.With(
+			zap.String("request_id", logging.NewRequestID()),
+			zap.Int("update_id", update.UpdateID)))
+
+		var (
+			chatID int64
+			handle func()
+		)
+		switch {
+		case update.Message != nil:
+			message := update.Message
+			chatID = message.Chat.ID
+			reqCtx = logging.With(reqCtx, b.logger, messageLogFields(message)...)
+			handle = func() { b.handleMessage(reqCtx, message) }
+		case update.EditedMessage != nil:
+			message := update.EditedMessage
+			chatID = message.Chat.ID
+			reqCtx = logging.With(reqCtx, b.logger, messageLogFields(message)...)
+			handle = func() { b.handleEditedMessage(reqCtx, message) }
+		case update.CallbackQuery != nil:
+			query := update.CallbackQuery
+			chatID = query.From.ID
+			fields := []zap.Field{zap.Int64("user_id", query.From.ID)}
+			if query.Message != nil {
+				chatID = query.Message.Chat.ID
+				fields = append(fields, zap.Int64("chat_id", chatID), zap.Int("telegram_message_id", query.Message.MessageID))
+			}
+			reqCtx = logging.With(reqCtx, b.logger, fields...)
+			handle = func() { b.handleCallback(reqCtx, query) }
+		default:
+			b.saveOffset(ctx, offsets, updateID)
 			continue
 		}
 
-		go b.handleMessage(update.Message)
+		b.acquireSlot(chatID)
+		b.inFlight.Add(1)
+		go func() {
+			defer b.inFlight.Done()
+			defer b.releaseSlot()
+			handle()
+			b.saveOffset(ctx, offsets, updateID)
+		}()
 	}
+}
 
-	return nil
+// messageLogFields identify a message's sender, chat and ID in logs
+func messageLogFields(message *tgbotapi.Message) []zap.Field {
+	fields := []zap.Field{
+		zap.Int64("chat_id", message.Chat.ID),
+		zap.Int("telegram_message_id", message.MessageID),
+	}
+	if message.From != nil {
+		fields = append(fields, zap.Int64("user_id", message.From.ID))
+	}
+	return fields
 }
 
-func (b *Bot) handleMessage(message *tgbotapi.Message) {
-	ctx := context.Background()
+// log returns the logger for the update being handled in ctx
+func (b *Bot) log(ctx context.Context) *zap.Logger {
+	return logging.FromContext(ctx, b.logger)
+}
+
+// acquireSlot blocks until a handler slot is free, letting the user know
+// their message is queued when the bot is saturated
+func (b *Bot) acquireSlot(chatID int64) {
+	select {
+	case b.slots <- struct{}{}:
+		return
+	default:
+	}
+
+	b.logger.Warn("All handler slots busy, queueing update",
+		zap.Int("max_concurrent_updates", cap(b.slots)),
+		zap.Int64("chat_id", chatID))
+	b.sendMessage(chatID, "⏳ I'm busy right now. Your message is queued and will be handled shortly.")
+	b.slots <- struct{}{}
+}
+
+func (b *Bot) releaseSlot() {
+	<-b.slots
+}
+
+// Stop stops receiving updates and waits for in-flight messages to be handled.
+// If handlers are still running when ctx is done, their contexts are cancelled
+// so pending OpenAI calls give up, and ctx's error is returned.
+func (b *Bot) Stop(ctx context.Context) error {
+	b.stopOnce.Do(func() {
+		close(b.stopPolling)
+		b.shutdownWebhook(ctx)
+	})
+
+	select {
+	case <-b.polling:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		b.inFlight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		b.cancelHandlers()
+		return ctx.Err()
+	}
+}
+
+// analyze classifies prompt on behalf of message's author, showing that we're
+// working on it in the meantime
+func (b *Bot) analyze(ctx context.Context, message *tgbotapi.Message, prompt string, contentType models.ContentType) classifier.GPTResponse {
+	stopTyping := b.keepTyping(message.Chat.ID)
+	defer stopTyping()
+
+	var loadingMsg tgbotapi.Message
+	if b.loadingMessage {
+		var err error
+		loadingMsg, err = b.sender.SendReplyMessage(
+			message.Chat.ID,
+			tr(ctx, msgAnalyzing),
+			message.MessageID,
+		)
+		if err != nil {
+			b.log(ctx).Error("Failed to send loading message",
+				zap.Error(err))
+		}
+	}
+
+	response := b.classifier.GetStructuredAnalysis(ctx, prompt, contentType, message.From.ID)
+
+	// Delete loading message
+	if loadingMsg.MessageID != 0 {
+		if err := b.sender.DeleteMessage(message.Chat.ID, loadingMsg.MessageID); err != nil {
+			b.log(ctx).Error("Failed to delete loading message",
+				zap.Error(err),
+				zap.Int("message_id", loadingMsg.MessageID))
+		}
+	}
+	if response.OverBudget {
+		b.sendMessage(message.Chat.ID, tr(ctx, msgBudgetExceeded))
+	}
+	return response
+}
+
+// Telegram shows a chat action for about five seconds, so it is refreshed
+// a little more often than that
+const typingRefreshInterval = 4 * time.Second
+
+// keepTyping shows the typing indicator in a chat until the returned
+// function is called
+func (b *Bot) keepTyping(chatID int64) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(typingRefreshInterval)
+		defer ticker.Stop()
+		for {
+			if err := b.sender.SendChatAction(chatID, tgbotapi.ChatTyping); err != nil {
+				b.logger.Debug("Failed to send typing action",
+					zap.Error(err),
+					zap.Int64("chat_id", chatID))
+			}
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+func (b *Bot) handleMessage(ctx context.Context, message *tgbotapi.Message) {
+	attrs := []attribute.KeyValue{
+		attribute.Int64("chat_id", message.Chat.ID),
+		attribute.Int("telegram_message_id", message.MessageID),
+	}
+	if message.From != nil {
+		attrs = append(attrs, attribute.Int64("user_id", message.From.ID))
+	}
+	ctx, span := tracing.Start(ctx, "bot.handleMessage", attrs...)
+	defer span.End()
+
+	ctx = withLanguage(ctx, b.userLanguage(ctx, message.From))
 
 	// Handle commands
 	if message.IsCommand() {
@@ -184,66 +589,151 @@ func (b *Bot) handleMessage(message *tgbotapi.Message) {
 		return
 	}
 
-	// Get content from message
-	content := message.Text
-	if message.Caption != "" {
-		content = message.Caption
+	// Replies to our classification messages correct them instead of being saved
+	if b.handleCorrection(ctx, message) {
+		return
 	}
 
-	// Send loading message
-	loadingMsg, err := b.sender.SendReplyMessage(
-		message.Chat.ID,
-		"🤔 Analyzing your message...",
-		message.MessageID,
-	)
-	if err != nil {
-		b.logger.Error("Failed to send loading message",
-			zap.Error(err),
-			zap.Int64("chat_id", message.Chat.ID))
+	// Muted chats only react to explicit commands
+	if b.isChatMuted(ctx, message.Chat.ID) {
+		return
 	}
 
-	// Get GPT analysis response
-	gptResponse := b.classifier.GetStructuredAnalysis(content, message.From.ID)
+	// In groups, members talk to each other; only what they address to us
+	// is a note
+	group := message.Chat.IsGroup() || message.Chat.IsSuperGroup()
+	if group && !b.respondInGroups && !b.addressedToBot(message) {
+		return
+	}
 
-	// Delete loading message
-	if err := b.sender.DeleteMessage(message.Chat.ID, loadingMsg.MessageID); err != nil {
-		b.logger.Error("Failed to delete loading message",
-			zap.Error(err),
-			zap.Int64("chat_id", message.Chat.ID),
-			zap.Int("message_id", loadingMsg.MessageID))
+	// Get content from message
+	content, ok := messageContent(message)
+	if ok && group {
+		content, ok = b.withoutMention(content)
 	}
+	if !ok {
+		kind := messageKind(message)
+		b.log(ctx).Info("Skipping unsupported message",
+			zap.String("type", kind))
+		// Nobody sent members joining or a pinned message to be classified
+		if kind == "service" {
+			return
+		}
+		b.sendMessage(message.Chat.ID, tr(ctx, msgNothingToClassify))
+		return
+	}
+	if b.noteTooLong(content) {
+		b.log(ctx).Info("Refusing overly long message",
+			zap.Int("length", utf8.RuneCountInString(content)))
+		b.sendMessage(message.Chat.ID, tr(ctx, msgNoteTooLong, b.maxNoteChars))
+		return
+	}
+
+	// Ask before saving the same note twice
+	if b.detectDuplicates && b.askAboutDuplicate(ctx, message, content) {
+		return
+	}
+
+	// Text the user writes in quick succession is saved as one note
+	if fileID, _ := messageMedia(message); b.grouper != nil && fileID == "" && messageSource(message) == "" {
+		b.groupMessage(ctx, message, content)
+		return
+	}
+
+	b.classifyAndSave(ctx, message, content)
+}
+
+// classifyAndSave classifies content taken from message, saves it as a note
+// and replies with the classification
+func (b *Bot) classifyAndSave(ctx context.Context, message *tgbotapi.Message, content string) {
+	// Get GPT analysis response
+	fileID, contentType := messageMedia(message)
+	gptResponse := b.analyze(ctx, message, classificationPrompt(message, content, contentType), contentType)
 
 	if gptResponse.Category == "" {
-		b.logger.Error("Failed to get GPT analysis",
-			zap.Int64("user_id", message.From.ID))
-		b.sendErrorMessage(message.Chat.ID, errMsgClassify)
+		b.log(ctx).Error("Failed to get GPT analysis")
+		metrics.MessagesProcessed.WithLabelValues(metrics.StatusFailed).Inc()
+		b.sendErrorMessage(message.Chat.ID, tr(ctx, errMsgClassify))
 		return
 	}
 
+	gptResponse.Category = b.matchCategory(ctx, message.From.ID, gptResponse.Category)
+	gptResponse.Keywords = b.withCategoryTags(ctx, message.From.ID, gptResponse.Category, gptResponse.Keywords)
+	if maxTags := b.userMaxTags(ctx, message.From.ID); len(gptResponse.Keywords) > maxTags {
+		gptResponse.Keywords = gptResponse.Keywords[:maxTags]
+	}
+
 	// Update user metadata with new category and tags
 	if err := b.storage.AddCategory(ctx, message.From.ID, gptResponse.Category); err != nil {
-		b.logger.Error("Failed to save category",
+		b.log(ctx).Error("Failed to save category",
 			zap.Error(err),
-			zap.Int64("user_id", message.From.ID),
 			zap.String("category", gptResponse.Category))
 	}
 
 	for _, tag := range gptResponse.Keywords {
 		if err := b.storage.AddTag(ctx, message.From.ID, tag); err != nil {
-			b.logger.Error("Failed to save tag",
+			b.log(ctx).Error("Failed to save tag",
 				zap.Error(err),
-				zap.Int64("user_id", message.From.ID),
 				zap.String("tag", tag))
 		}
 	}
 
-	// Send the response
-	b.sendClassificationResponse(message.Chat.ID, message.MessageID, &gptResponse)
-}
+	// Persist the classified message
+	note := &models.Message{
+		ID:                  uuid.New().String(),
+		UserID:              message.From.ID,
+		Content:             content,
+		Category:            gptResponse.Category,
+		Tags:                gptResponse.Keywords,
+		Summary:             gptResponse.Summary,
+		FileID:              fileID,
+		ContentType:         contentType,
+		CreatedAt:           time.Now(),
+		Source:              messageSource(message),
+		Links:               gptResponse.Links,
+		AttachmentsAnalysis: gptResponse.AttachmentsAnalysis,
+	}
+	if err := b.storage.SaveMessage(ctx, note); err != nil {
+		// During a brief outage the note is saved once the database is back
+		if errors.Is(err, storage.ErrConnection) && b.bufferNote(ctx, message, note, &gptResponse) {
+			return
+		}
+		metrics.MessagesProcessed.WithLabelValues(metrics.StatusFailed).Inc()
+		if errors.Is(err, storage.ErrInvalidInput) {
+			b.log(ctx).Warn("Refused to save message",
+				zap.Error(err))
+			b.sendErrorMessage(message.Chat.ID, tr(ctx, errMsgTooLarge))
+			return
+		}
+		b.log(ctx).Error("Failed to save message",
+			zap.Error(err))
+		b.sendStorageError(ctx, message.Chat.ID, err, tr(ctx, errMsgSave))
+		return
+	}
 
-func (b *Bot) handleStart(message *tgbotapi.Message) {
-	ctx := context.Background()
+	if gptResponse.Fallback {
+		metrics.MessagesProcessed.WithLabelValues(metrics.StatusFallback).Inc()
+	} else {
+		metrics.MessagesProcessed.WithLabelValues(metrics.StatusClassified).Inc()
+	}
 
+	// Send the response and remember it so replies to it can correct the note
+	var reviewID string
+	if b.needsReview(gptResponse) {
+		reviewID = note.ID
+	}
+	sent, err := b.sendClassificationResponse(message.Chat.ID, message.MessageID, &gptResponse, note.Source, b.userDisplayPrefs(ctx, message.From.ID), reviewID)
+	if err != nil {
+		return
+	}
+	if err := b.storage.SaveClassificationReply(ctx, message.Chat.ID, sent.MessageID, note.ID, message.MessageID); err != nil {
+		b.log(ctx).Error("Failed to save classification reply",
+			zap.Error(err),
+			zap.String("message_id", note.ID))
+	}
+}
+
+func (b *Bot) handleStart(ctx context.Context, message *tgbotapi.Message) {
 	// Initialize user in storage if needed
 	user := &models.User{
 		ID:         message.From.ID,
@@ -251,112 +741,116 @@ func (b *Bot) handleStart(message *tgbotapi.Message) {
 	}
 
 	if err := b.storage.UpdateUser(ctx, user); err != nil {
-		b.logger.Error("Failed to initialize user",
-			zap.Error(err),
-			zap.Int64("user_id", message.From.ID))
+		b.log(ctx).Error("Failed to initialize user",
+			zap.Error(err))
 	}
 
-	welcome := `Welcome to MemoBot! 📝
-I can help you organize your notes, images, and files with automatic classification.
-
-Just send me any message, photo, or document, and I'll:
-• Save it securely
-• Classify it automatically
-• Add relevant tags
-• Make it easily searchable
-
-Available commands:
-/help - Show all commands
-/tags - View your tags
-/categories - View your categories
-/history - View recent messages
-
-Send me something to get started!`
-
-	b.sendMessage(message.Chat.ID, welcome)
+	if b.welcome != nil && b.sendTemplate(ctx, message, b.welcome) {
+		return
+	}
+	b.sendMessage(message.Chat.ID, tr(ctx, msgWelcome))
 }
 
-func (b *Bot) handleHelp(message *tgbotapi.Message) {
-	help := `*Available Commands:*
-/start \- Start the bot
-/help \- Show this help message
-/tags \- Show your tags
-/categories \- Show your categories
-/addcategory \- Add a new category
-/removecategory \- Remove a category
-/maxtags \- Set maximum number of tags per message
-/history \- View recent messages
-
-*Usage:*
-/addcategory <category\_name>
-/removecategory <category\_name>
-/maxtags <number>
-
-*I can process:*
-• Text messages
-• Photos with captions
-• Documents
-• Videos
-
-Each message will be automatically:
-• Classified into a category
-• Tagged with relevant keywords
-• Summarized for easy reference
-
-*Tips:*
-• Use hashtags in your messages for custom tags
-• Long press any message to forward it to me
-• Reply to my classification with corrections
-
-Need help? Just send /help again\!`
+func (b *Bot) handleHelp(ctx context.Context, message *tgbotapi.Message) {
+	if b.help != nil && b.sendTemplate(ctx, message, b.help) {
+		return
+	}
 
-	msg := tgbotapi.NewMessage(message.Chat.ID, help)
-	msg.ParseMode = "MarkdownV2"
-	if _, err := b.api.Send(msg); err != nil {
-		b.logger.Error("Failed to send help message",
-			zap.Error(err),
-			zap.Int64("chat_id", message.Chat.ID))
+	if err := b.sendMarkdown(message.Chat.ID, b.helpText(ctx, b.isAdmin(message.From.ID)), nil); err != nil {
+		b.log(ctx).Error("Failed to send help message",
+			zap.Error(err))
 	}
 }
 
+// maxTagRows caps /tags for users with hundreds of tags
+const maxTagRows = 100
+
+// handleTags lists the user's tags with how many notes use each, most used
+// first or alphabetically with --alpha
 func (b *Bot) handleTags(ctx context.Context, message *tgbotapi.Message) {
+	var alpha bool
+	switch strings.TrimSpace(message.CommandArguments()) {
+	case "":
+	case "--alpha":
+		alpha = true
+	default:
+		b.sendMessage(message.Chat.ID, "Usage: /tags [--alpha]")
+		return
+	}
+
+	counts, err := b.storage.GetTagCounts(ctx, message.From.ID)
+	if err != nil {
+		b.log(ctx).Error("Failed to get tag counts",
+			zap.Error(err))
+		b.sendStorageError(ctx, message.Chat.ID, err, tr(ctx, errMsgRetrieval))
+		return
+	}
 	tags, err := b.storage.GetUserTags(ctx, message.From.ID)
 	if err != nil {
-		b.logger.Error("Failed to get user tags",
-			zap.Error(err),
-			zap.Int64("user_id", message.From.ID))
-		b.sendErrorMessage(message.Chat.ID, errMsgRetrieval)
+		b.log(ctx).Error("Failed to get user tags",
+			zap.Error(err))
+		b.sendStorageError(ctx, message.Chat.ID, err, tr(ctx, errMsgRetrieval))
 		return
 	}
 
-	if len(tags) == 0 {
+	type tagCount struct {
+		name  string
+		count int
+	}
+	seen := make(map[string]bool, len(counts))
+	rows := make([]tagCount, 0, len(counts)+len(tags))
+	for name, count := range counts {
+		seen[storage.NormalizeLabel(name)] = true
+		rows = append(rows, tagCount{name: name, count: count})
+	}
+	for _, name := range tags {
+		if key := storage.NormalizeLabel(name); !seen[key] {
+			seen[key] = true
+			rows = append(rows, tagCount{name: name})
+		}
+	}
+	if len(rows) == 0 {
 		b.sendMessage(message.Chat.ID, "You don't have any tags yet.")
 		return
 	}
+	sort.Slice(rows, func(i, j int) bool {
+		if !alpha && rows[i].count != rows[j].count {
+			return rows[i].count > rows[j].count
+		}
+		return rows[i].name < rows[j].name
+	})
 
 	response := "*Your tags:*\n"
-	for _, tag := range tags {
-		formattedTag := "#" + strings.ReplaceAll(tag, " ", "_")
-		response += escapeMarkdown(formattedTag) + "\n"
+	for _, row := range rows[:min(len(rows), maxTagRows)] {
+		response += escapeMarkdown(fmt.Sprintf("%s — %d", formatLabel(row.name), row.count)) + "\n"
+	}
+	if omitted := len(rows) - maxTagRows; omitted > 0 {
+		response += "_" + escapeMarkdown(fmt.Sprintf("…and %d more", omitted)) + "_\n"
 	}
 
-	msg := tgbotapi.NewMessage(message.Chat.ID, response)
-	msg.ParseMode = "MarkdownV2"
-	if _, err := b.api.Send(msg); err != nil {
-		b.logger.Error("Failed to send tags message",
-			zap.Error(err),
-			zap.Int64("chat_id", message.Chat.ID))
-		b.sendErrorMessage(message.Chat.ID, errMsgGeneral)
+	if err := b.sendMarkdown(message.Chat.ID, response, nil); err != nil {
+		b.log(ctx).Error("Failed to send tags message",
+			zap.Error(err))
+		b.sendErrorMessage(message.Chat.ID, tr(ctx, errMsgGeneral))
 	}
 }
 
 func (b *Bot) handleCategories(ctx context.Context, message *tgbotapi.Message) {
+	switch strings.TrimSpace(message.CommandArguments()) {
+	case "":
+	case "--counts":
+		b.handleCategoryCounts(ctx, message)
+		return
+	default:
+		b.sendMessage(message.Chat.ID, "Usage: /categories [--counts]")
+		return
+	}
+
 	categories, err := b.storage.GetUserCategories(ctx, message.From.ID)
 	if err != nil {
-		b.logger.Error("Failed to get user categories",
-			zap.Error(err),
-			zap.Int64("user_id", message.From.ID))
-		b.sendErrorMessage(message.Chat.ID, errMsgRetrieval)
+		b.log(ctx).Error("Failed to get user categories",
+			zap.Error(err))
+		b.sendStorageError(ctx, message.Chat.ID, err, tr(ctx, errMsgRetrieval))
 		return
 	}
 
@@ -367,28 +861,71 @@ func (b *Bot) handleCategories(ctx context.Context, message *tgbotapi.Message) {
 
 	response := "*Your categories:*\n"
 	for _, category := range categories {
-		formattedCategory := "#" + strings.ReplaceAll(category, " ", "_")
-		response += escapeMarkdown(formattedCategory) + "\n"
+		response += escapeMarkdown(formatLabel(category)) + "\n"
 	}
 
-	msg := tgbotapi.NewMessage(message.Chat.ID, response)
-	msg.ParseMode = "MarkdownV2"
-	if _, err := b.api.Send(msg); err != nil {
-		b.logger.Error("Failed to send categories message",
-			zap.Error(err),
-			zap.Int64("chat_id", message.Chat.ID))
-		b.sendErrorMessage(message.Chat.ID, errMsgGeneral)
+	if err := b.sendMarkdown(message.Chat.ID, response, nil); err != nil {
+		b.log(ctx).Error("Failed to send categories message",
+			zap.Error(err))
+		b.sendErrorMessage(message.Chat.ID, tr(ctx, errMsgGeneral))
 	}
 }
 
-// Add this helper function to escape special characters for MarkdownV2
-func escapeMarkdown(text string) string {
-	specialChars := []string{"_", "*", "[", "]", "(", ")", "~", "`", ">", "#", "+", "-", "=", "|", "{", "}", ".", "!"}
-	escaped := text
-	for _, char := range specialChars {
-		escaped = strings.ReplaceAll(escaped, char, "\\"+char)
+// handleCategoryCounts lists categories by how many notes they hold. The
+// user's categories without notes come last.
+func (b *Bot) handleCategoryCounts(ctx context.Context, message *tgbotapi.Message) {
+	counts, err := b.storage.GetCategoryCounts(ctx, message.From.ID)
+	if err != nil {
+		b.log(ctx).Error("Failed to get category counts",
+			zap.Error(err))
+		b.sendStorageError(ctx, message.Chat.ID, err, tr(ctx, errMsgRetrieval))
+		return
+	}
+	categories, err := b.storage.GetUserCategories(ctx, message.From.ID)
+	if err != nil {
+		b.log(ctx).Error("Failed to get user categories",
+			zap.Error(err))
+		b.sendStorageError(ctx, message.Chat.ID, err, tr(ctx, errMsgRetrieval))
+		return
+	}
+
+	type categoryCount struct {
+		name  string
+		count int
+	}
+	seen := make(map[string]bool, len(counts))
+	rows := make([]categoryCount, 0, len(counts)+len(categories))
+	for name, count := range counts {
+		seen[storage.NormalizeLabel(name)] = true
+		rows = append(rows, categoryCount{name: name, count: count})
+	}
+	for _, name := range categories {
+		if key := storage.NormalizeLabel(name); !seen[key] {
+			seen[key] = true
+			rows = append(rows, categoryCount{name: name})
+		}
+	}
+	if len(rows) == 0 {
+		b.sendMessage(message.Chat.ID, "You don't have any categories yet.")
+		return
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].count != rows[j].count {
+			return rows[i].count > rows[j].count
+		}
+		return rows[i].name < rows[j].name
+	})
+
+	response := "*Your categories:*\n"
+	for _, row := range rows {
+		response += escapeMarkdown(fmt.Sprintf("%s — %d", formatLabel(row.name), row.count)) + "\n"
+	}
+
+	if err := b.sendMarkdown(message.Chat.ID, response, nil); err != nil {
+		b.log(ctx).Error("Failed to send category counts message",
+			zap.Error(err))
+		b.sendErrorMessage(message.Chat.ID, tr(ctx, errMsgGeneral))
 	}
-	return escaped
 }
 
 func (b *Bot) sendMessage(chatID int64, text string) {
@@ -409,56 +946,81 @@ func (b *Bot) sendErrorMessage(chatID int64, text string) {
 			zap.Int64("chat_id", chatID))
 	}
 }
-func (b *Bot) handleCommand(ctx context.Context, message *tgbotapi.Message) {
-	switch message.Command() {
-	case "start":
-		b.handleStart(message)
-	case "help":
-		b.handleHelp(message)
-	case "tags":
-		b.handleTags(ctx, message)
-	case "categories":
-		b.handleCategories(ctx, message)
-	case "addcategory":
-		b.handleAddCategory(ctx, message)
-	case "removecategory":
-		b.handleRemoveCategory(ctx, message)
-	case "maxtags":
-		b.handleMaxTags(ctx, message)
-	default:
-		b.sendMessage(message.Chat.ID, "Unknown command. Use /help to see available commands.")
-	}
+
+// sendMarkdown sends MarkdownV2 text, split over several messages when it is
+// too long for one. keyboard, if not nil, is attached to the last message.
+func (b *Bot) sendMarkdown(chatID int64, text string, keyboard *tgbotapi.InlineKeyboardMarkup) error {
+	return b.sendMarkdownParts(chatID, splitMarkdown(text, maxMessageLength), keyboard)
 }
 
-func (b *Bot) sendClassificationResponse(chatID int64, replyToID int, response *classifier.GPTResponse) {
-	// Format category and tags
-	formattedCategory := "#" + strings.ReplaceAll(response.Category, " ", "_")
-	formattedTags := make([]string, len(response.Keywords))
-	for i, tag := range response.Keywords {
-		formattedTags[i] = "#" + strings.ReplaceAll(tag, " ", "_")
+func (b *Bot) sendMarkdownParts(chatID int64, parts []string, keyboard *tgbotapi.InlineKeyboardMarkup) error {
+	for i, part := range parts {
+		msg := tgbotapi.NewMessage(chatID, part)
+		msg.ParseMode = "MarkdownV2"
+		if keyboard != nil && i == len(parts)-1 {
+			msg.ReplyMarkup = *keyboard
+		}
+		if _, err := b.api.Send(msg); err != nil {
+			return fmt.Errorf("failed to send part %d of %d: %w", i+1, len(parts), err)
+		}
 	}
+	return nil
+}
 
-	// Escape special characters for Markdown
-	formattedCategory = escapeMarkdown(formattedCategory)
-	formattedSummary := escapeMarkdown(response.Summary)
-	for i, tag := range formattedTags {
-		formattedTags[i] = escapeMarkdown(tag)
+// sendClassificationResponse replies with the analysis of a note, naming the
+// source of forwarded notes. A non-empty reviewID asks the user to confirm it
+// with buttons carrying that note ID.
+func (b *Bot) sendClassificationResponse(chatID int64, replyToID int, response *classifier.GPTResponse, source string, prefs displayPrefs, reviewID string) (tgbotapi.Message, error) {
+	text := formatClassification(response, prefs)
+	if source != "" {
+		text = "↪️ _" + escapeMarkdown(prefs.t(msgForwardedFrom, source)) + "_\n\n" + text
 	}
-
-	// Build response message
-	text := fmt.Sprintf("*Category:* %s\n", formattedCategory)
-	if len(formattedTags) > 0 {
-		text += fmt.Sprintf("*Tags:* %s\n", strings.Join(formattedTags, " "))
+	if reviewID != "" {
+		text += "\n\n_" + escapeMarkdown(prefs.t(msgReviewQuestion)) + "_"
 	}
-	text += fmt.Sprintf("\n*Summary:* %s", formattedSummary)
 
 	msg := tgbotapi.NewMessage(chatID, text)
 	msg.ParseMode = "MarkdownV2"
 	msg.ReplyToMessageID = replyToID
+	if reviewID != "" {
+		msg.ReplyMarkup = reviewKeyboard(reviewID, prefs)
+	}
 
-	if _, err := b.api.Send(msg); err != nil {
+	sent, err := b.api.Send(msg)
+	if err != nil {
 		b.logger.Error("Failed to send classification response",
 			zap.Error(err),
 			zap.Int64("chat_id", chatID))
 	}
+	return sent, err
+}
+
+// formatClassification renders a classification as MarkdownV2
+func formatClassification(response *classifier.GPTResponse, prefs displayPrefs) string {
+	// Format category and tags
+	formattedTags := make([]string, len(response.Keywords))
+	for i, tag := range response.Keywords {
+		formattedTags[i] = escapeMarkdown(formatLabel(tag))
+	}
+
+	// Build response message; sections without content are left out, so a
+	// bare category is a single line
+	text := fmt.Sprintf("*%s* %s", escapeMarkdown(prefs.t(msgLabelCategory)), escapeMarkdown(prefs.categoryLabel(response.Category)))
+	if len(formattedTags) > 0 {
+		text += fmt.Sprintf("\n*%s* %s", escapeMarkdown(prefs.t(msgLabelTags)), strings.Join(formattedTags, " "))
+	}
+	if summary := strings.TrimSpace(response.Summary); summary != "" {
+		text += fmt.Sprintf("\n\n*%s* %s", escapeMarkdown(prefs.t(msgLabelSummary)), renderMarkdown(summary))
+	}
+	if analysis := strings.TrimSpace(response.AttachmentsAnalysis); analysis != "" {
+		text += fmt.Sprintf("\n\n*%s* %s", escapeMarkdown(prefs.t(msgLabelAttachments)), renderMarkdown(analysis))
+	}
+
+	if len(response.Links) > 0 {
+		text += fmt.Sprintf("\n\n*%s*", escapeMarkdown(prefs.t(msgLabelLinks)))
+		for _, link := range response.Links {
+			text += "\n• " + renderLink(link)
+		}
+	}
+	return text
 }
@@ -0,0 +1,183 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xaenox/memo-bot/internal/classifier"
+	"github.com/xaenox/memo-bot/internal/models"
+	"github.com/xaenox/memo-bot/internal/storage"
+)
+
+// failingSaves is a storage whose saves of notes fail with err
+type failingSaves struct {
+	storage.Storage
+	err error
+}
+
+func (s failingSaves) SaveMessage(ctx context.Context, message *models.Message) error {
+	return s.err
+}
+
+func TestHandleMessage(t *testing.T) {
+	const userID = 42
+
+	tests := []struct {
+		name     string
+		response classifier.GPTResponse
+		saveErr  error
+		// wantNote is the category of the saved note; empty when none is saved
+		wantNote    string
+		wantTags    []string
+		wantReply   bool
+		wantError   msgKey
+		wantNoError bool
+	}{
+		{
+			name:        "classified",
+			response:    classifier.GPTResponse{Category: "Work", Keywords: []string{"Meeting", "deadline"}, Summary: "A meeting"},
+			wantNote:    "work",
+			wantTags:    []string{"meeting", "deadline"},
+			wantReply:   true,
+			wantNoError: true,
+		},
+		{
+			name:        "fallback classification is saved",
+			response:    classifier.GPTResponse{Category: "personal", Keywords: []string{"family"}, Fallback: true},
+			wantNote:    "personal",
+			wantTags:    []string{"family"},
+			wantReply:   true,
+			wantNoError: true,
+		},
+		{
+			name:      "empty category",
+			response:  classifier.GPTResponse{Keywords: []string{"family"}},
+			wantError: errMsgClassify,
+		},
+		{
+			name:      "save fails",
+			response:  classifier.GPTResponse{Category: "work", Keywords: []string{"meeting"}},
+			saveErr:   errors.New("disk full"),
+			wantError: errMsgSave,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			memory := storage.NewMemoryStorage(storage.Limits{})
+			var store storage.Storage = memory
+			if tt.saveErr != nil {
+				store = failingSaves{Storage: memory, err: tt.saveErr}
+			}
+			sender := &recordingSender{}
+			fake := &fakeClassifier{response: tt.response}
+			b, telegram := newTestBot(t, Config{}, store, fake, sender)
+
+			b.handleMessage(ctx, textMessage(userID, 7, "Project meeting on Friday"))
+
+			if len(fake.contents) != 1 {
+				t.Fatalf("classifier called %d times, want 1", len(fake.contents))
+			}
+			notes, err := memory.GetUserMessages(ctx, userID, 10, 0)
+			if err != nil {
+				t.Fatalf("GetUserMessages: %v", err)
+			}
+			if tt.wantNote == "" {
+				if len(notes) != 0 {
+					t.Fatalf("saved %d notes, want none", len(notes))
+				}
+			} else {
+				if len(notes) != 1 {
+					t.Fatalf("saved %d notes, want 1", len(notes))
+				}
+				note := notes[0]
+				if note.Category != tt.wantNote {
+					t.Errorf("category = %q, want %q", note.Category, tt.wantNote)
+				}
+				if strings.Join(note.Tags, ",") != strings.Join(tt.wantTags, ",") {
+					t.Errorf("tags = %v, want %v", note.Tags, tt.wantTags)
+				}
+				if note.Content != "Project meeting on Friday" {
+					t.Errorf("content = %q", note.Content)
+				}
+			}
+
+			replies := telegram.sent("sendMessage")
+			if tt.wantReply != (len(replies) == 1) {
+				t.Fatalf("sent %d classification replies, want reply %v", len(replies), tt.wantReply)
+			}
+			if tt.wantReply && replies[0].Params["parse_mode"] != "MarkdownV2" {
+				t.Errorf("reply parse mode = %q", replies[0].Params["parse_mode"])
+			}
+
+			texts := sender.sentTexts()
+			if tt.wantNoError && len(texts) != 0 {
+				t.Errorf("sent %q, want no messages", texts)
+			}
+			if tt.wantError != "" {
+				want := "⚠️ " + translate(defaultLanguage, tt.wantError)
+				if len(texts) != 1 || texts[0] != want {
+					t.Errorf("sent %q, want %q", texts, want)
+				}
+			}
+		})
+	}
+}
+
+func TestFormatClassification(t *testing.T) {
+	prefs := displayPrefs{dateLayout: defaultDateLayout, location: time.UTC, lang: defaultLanguage}
+
+	tests := []struct {
+		name     string
+		response classifier.GPTResponse
+		want     string
+	}{
+		{
+			name:     "category only",
+			response: classifier.GPTResponse{Category: "work"},
+			want:     "*Category:* \\#work",
+		},
+		{
+			name:     "blank summary is left out",
+			response: classifier.GPTResponse{Category: "work", Keywords: []string{"meeting"}, Summary: "  \n "},
+			want:     "*Category:* \\#work\n*Tags:* \\#meeting",
+		},
+		{
+			name:     "summary without tags",
+			response: classifier.GPTResponse{Category: "work", Summary: "A meeting"},
+			want:     "*Category:* \\#work\n\n*Summary:* A meeting",
+		},
+		{
+			name:     "links without summary",
+			response: classifier.GPTResponse{Category: "work", Links: []string{"https://example.com"}},
+			want:     "*Category:* \\#work\n\n*Links found:*\n• [https://example\\.com](https://example.com)",
+		},
+		{
+			name:     "attachment notes only",
+			response: classifier.GPTResponse{Category: "work", AttachmentsAnalysis: "A receipt"},
+			want:     "*Category:* \\#work\n\n*Attachment notes:* A receipt",
+		},
+		{
+			name: "every section",
+			response: classifier.GPTResponse{
+				Category:            "work",
+				Keywords:            []string{"meeting", "q3"},
+				Summary:             "A meeting",
+				AttachmentsAnalysis: "A slide",
+				Links:               []string{"https://example.com"},
+			},
+			want: "*Category:* \\#work\n*Tags:* \\#meeting \\#q3\n\n*Summary:* A meeting\n\n*Attachment notes:* A slide\n\n*Links found:*\n• [https://example\\.com](https://example.com)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatClassification(&tt.response, prefs); got != tt.want {
+				t.Errorf("formatClassification() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package bot
+
+import (
+	"context"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.uber.org/zap"
+)
+
+// callbackHandler handles a button press. args is the callback data after
+// "<action>:". The returned text is shown to the user as a short notice; an
+// empty string just clears the button's loading indicator.
+type callbackHandler func(ctx context.Context, query *tgbotapi.CallbackQuery, args string) string
+
+// registerCallbacks sets up the handlers for each callback data action.
+// Buttons must use callback data of the form "<action>:<arguments>".
+func (b *Bot) registerCallbacks() {
+	b.callbacks = map[string]callbackHandler{
+		historyCallbackAction:   b.handleHistoryCallback,
+		reviewCallbackAction:    b.handleReviewCallback,
+		duplicateCallbackAction: b.handleDuplicateCallback,
+		forgetCallbackAction:    b.handleForgetCallback,
+	}
+}
+
+// handleCallback dispatches presses of our inline keyboard buttons by the
+// action prefix of their data and always answers the query
+func (b *Bot) handleCallback(ctx context.Context, query *tgbotapi.CallbackQuery) {
+	ctx = withLanguage(ctx, b.userLanguage(ctx, query.From))
+
+	var notice string
+	action, args, _ := strings.Cut(query.Data, ":")
+	if handler, ok := b.callbacks[action]; ok {
+		notice = handler(ctx, query, args)
+	} else {
+		b.log(ctx).Warn("Unknown callback action",
+			zap.String("data", query.Data))
+	}
+
+	if _, err := b.api.Request(tgbotapi.NewCallback(query.ID, notice)); err != nil {
+		b.log(ctx).Error("Failed to answer callback query",
+			zap.Error(err))
+	}
+}
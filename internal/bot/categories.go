@@ -0,0 +1,230 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/xaenox/memo-bot/internal/classifier"
+	"github.com/xaenox/memo-bot/internal/storage"
+	"go.uber.org/zap"
+)
+
+// matchCategory keeps category within the user's taxonomy when they set one
+// with /settaxonomy. Otherwise it returns the user's existing category closest
+// to category, so "finances" is saved under an existing "finance". The
+// category is returned unchanged when nothing is similar enough or matching
+// is disabled.
+func (b *Bot) matchCategory(ctx context.Context, userID int64, category string) string {
+	if category == "" {
+		return category
+	}
+	user, err := b.storage.GetUser(ctx, userID)
+	if err != nil {
+		b.log(ctx).Warn("Failed to get categories for matching",
+			zap.Error(err))
+		return category
+	}
+	return b.matchCategoryIn(ctx, category, user.Categories, user.AllowedCategories)
+}
+
+// matchCategoryIn is matchCategory against already fetched category lists
+func (b *Bot) matchCategoryIn(ctx context.Context, category string, existing, allowed []string) string {
+	if category == "" {
+		return category
+	}
+	if len(allowed) > 0 {
+		constrained := classifier.ConstrainCategory(category, allowed)
+		if constrained != category {
+			b.log(ctx).Info("Moved category into the user's taxonomy",
+				zap.String("from", category),
+				zap.String("to", constrained))
+		}
+		return constrained
+	}
+	if b.categoryMatchThreshold <= 0 {
+		return category
+	}
+	match, ok := classifier.MatchCategory(category, existing, b.categoryMatchThreshold)
+	if !ok || match == category {
+		return category
+	}
+	b.log(ctx).Info("Merged category into an existing one",
+		zap.String("from", category),
+		zap.String("to", match))
+	return match
+}
+
+func (b *Bot) handleMergeCategory(ctx context.Context, message *tgbotapi.Message) {
+	args, ok := b.commandArgs(message)
+	if !ok {
+		return
+	}
+	if len(args) != 2 {
+		b.sendMessage(message.Chat.ID, "Please provide the category to merge and the one to keep.\nUsage: /mergecategory <from> <into>")
+		return
+	}
+
+	from, into := storage.NormalizeLabel(args[0]), storage.NormalizeLabel(args[1])
+	if from == "" || into == "" || from == into {
+		b.sendMessage(message.Chat.ID, "Please provide two different categories.\nUsage: /mergecategory <from> <into>")
+		return
+	}
+
+	moved, err := b.storage.MergeCategory(ctx, message.From.ID, from, into)
+	if errors.Is(err, storage.ErrNotFound) {
+		b.sendMessage(message.Chat.ID, fmt.Sprintf("You don't have a category %s.", formatLabel(from)))
+		return
+	}
+	if err != nil {
+		b.log(ctx).Error("Failed to merge category",
+			zap.Error(err),
+			zap.String("from", from),
+			zap.String("into", into))
+		b.sendStorageError(ctx, message.Chat.ID, err, "Failed to merge categories. Please try again.")
+		return
+	}
+
+	b.log(ctx).Info("Merged category",
+		zap.String("from", from),
+		zap.String("into", into),
+		zap.Int("messages", moved))
+	b.sendMessage(message.Chat.ID, fmt.Sprintf("Merged %s into %s; %d notes moved.", formatLabel(from), formatLabel(into), moved))
+}
+
+func (b *Bot) handleRenameCategory(ctx context.Context, message *tgbotapi.Message) {
+	args, ok := b.commandArgs(message)
+	if !ok {
+		return
+	}
+	if len(args) != 2 {
+		b.sendMessage(message.Chat.ID, "Please provide the current and the new category name.\nUsage: /renamecategory <old> <new>")
+		return
+	}
+
+	oldName, newName := storage.NormalizeLabel(args[0]), storage.NormalizeLabel(args[1])
+	if oldName == "" || newName == "" {
+		b.sendMessage(message.Chat.ID, "Please provide two category names.\nUsage: /renamecategory <old> <new>")
+		return
+	}
+	if oldName == newName {
+		b.sendMessage(message.Chat.ID, "The new name is the same as the old one.")
+		return
+	}
+
+	moved, err := b.storage.RenameCategory(ctx, message.From.ID, oldName, newName)
+	if errors.Is(err, storage.ErrNotFound) {
+		b.sendMessage(message.Chat.ID, fmt.Sprintf("You don't have a category %s.", formatLabel(oldName)))
+		return
+	}
+	if err != nil {
+		b.log(ctx).Error("Failed to rename category",
+			zap.Error(err),
+			zap.String("old_name", oldName),
+			zap.String("new_name", newName))
+		b.sendStorageError(ctx, message.Chat.ID, err, "Failed to rename the category. Please try again.")
+		return
+	}
+
+	b.log(ctx).Info("Renamed category",
+		zap.String("old_name", oldName),
+		zap.String("new_name", newName),
+		zap.Int("messages", moved))
+	b.sendMessage(message.Chat.ID, fmt.Sprintf("Renamed %s to %s; %d notes updated.", formatLabel(oldName), formatLabel(newName), moved))
+}
+
+// withCategoryTags puts the default tags of category ahead of the classified
+// tags, so they survive the max-tags limit, and drops repeated tags
+func (b *Bot) withCategoryTags(ctx context.Context, userID int64, category string, tags []string) []string {
+	defaults, err := b.storage.GetCategoryTags(ctx, userID, category)
+	if err != nil {
+		b.log(ctx).Warn("Failed to get category default tags",
+			zap.Error(err),
+			zap.String("category", category))
+		return tags
+	}
+	if len(defaults) == 0 {
+		return tags
+	}
+
+	merged := make([]string, 0, len(defaults)+len(tags))
+	seen := make(map[string]bool, len(defaults)+len(tags))
+	for _, tag := range append(defaults, tags...) {
+		if key := storage.NormalizeLabel(tag); !seen[key] {
+			seen[key] = true
+			merged = append(merged, tag)
+		}
+	}
+	return merged
+}
+
+func (b *Bot) handleSetCategoryTags(ctx context.Context, message *tgbotapi.Message) {
+	args, ok := b.commandArgs(message)
+	if !ok {
+		return
+	}
+	category := ""
+	if len(args) > 0 {
+		category = storage.NormalizeLabel(args[0])
+	}
+	if len(args) < 2 || category == "" {
+		b.sendMessage(message.Chat.ID, "Please provide a category and at least one tag.\nUsage: /setcategorytags <category> <tag...>")
+		return
+	}
+
+	tags := make([]string, 0, len(args)-1)
+	seen := make(map[string]bool, len(args)-1)
+	for _, arg := range args[1:] {
+		tag, err := parseTag(arg)
+		if err != nil {
+			b.sendMessage(message.Chat.ID, err.Error())
+			return
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	if maxTags := b.userMaxTags(ctx, message.From.ID); len(tags) > maxTags {
+		b.sendMessage(message.Chat.ID, fmt.Sprintf("Notes can have at most %d tags. Please provide fewer tags or raise the limit with /maxtags.", maxTags))
+		return
+	}
+
+	if err := b.storage.SetCategoryTags(ctx, message.From.ID, category, tags); err != nil {
+		b.log(ctx).Error("Failed to set category default tags",
+			zap.Error(err),
+			zap.String("category", category))
+		b.sendStorageError(ctx, message.Chat.ID, err, "Failed to set category tags. Please try again.")
+		return
+	}
+
+	b.sendMessage(message.Chat.ID, fmt.Sprintf("New notes in %s will be tagged %s.", formatLabel(category), formatLabels(tags)))
+}
+
+func (b *Bot) handleClearCategoryTags(ctx context.Context, message *tgbotapi.Message) {
+	args, ok := b.commandArgs(message)
+	if !ok {
+		return
+	}
+	category := categoryArg(args)
+	if category == "" {
+		b.sendMessage(message.Chat.ID, "Please provide a category.\nUsage: /clearcategorytags <category>")
+		return
+	}
+
+	err := b.storage.ClearCategoryTags(ctx, message.From.ID, category)
+	if errors.Is(err, storage.ErrNotFound) {
+		b.sendMessage(message.Chat.ID, fmt.Sprintf("%s has no default tags.", formatLabel(category)))
+		return
+	}
+	if err != nil {
+		b.log(ctx).Error("Failed to clear category default tags",
+			zap.Error(err),
+			zap.String("category", category))
+		b.sendStorageError(ctx, message.Chat.ID, err, "Failed to clear category tags. Please try again.")
+		return
+	}
+
+	b.sendMessage(message.Chat.ID, fmt.Sprintf("Removed the default tags of %s.", formatLabel(category)))
+}
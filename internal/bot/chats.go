@@ -0,0 +1,102 @@
+package bot
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.uber.org/zap"
+)
+
+func (b *Bot) handleMute(ctx context.Context, message *tgbotapi.Message) {
+	b.setChatMuted(ctx, message, true)
+}
+
+func (b *Bot) handleUnmute(ctx context.Context, message *tgbotapi.Message) {
+	b.setChatMuted(ctx, message, false)
+}
+
+func (b *Bot) setChatMuted(ctx context.Context, message *tgbotapi.Message, muted bool) {
+	if !b.isChatAdmin(message) {
+		b.sendErrorMessage(message.Chat.ID, tr(ctx, errMsgPermission))
+		return
+	}
+
+	if err := b.storage.SetChatMuted(ctx, message.Chat.ID, muted); err != nil {
+		b.log(ctx).Error("Failed to update chat mute state",
+			zap.Error(err),
+			zap.Bool("muted", muted))
+		b.sendStorageError(ctx, message.Chat.ID, err, tr(ctx, errMsgGeneral))
+		return
+	}
+
+	if muted {
+		b.sendMessage(message.Chat.ID, "🔇 I'll stop classifying messages in this chat. Commands still work; use /unmute to resume.")
+		return
+	}
+	b.sendMessage(message.Chat.ID, "🔊 I'll classify messages in this chat again.")
+}
+
+// isChatAdmin reports whether the sender may change chat-wide settings.
+// Everyone is an admin of their private chat with the bot.
+func (b *Bot) isChatAdmin(message *tgbotapi.Message) bool {
+	if !message.Chat.IsGroup() && !message.Chat.IsSuperGroup() {
+		return true
+	}
+
+	member, err := b.api.GetChatMember(tgbotapi.GetChatMemberConfig{
+		ChatConfigWithUser: tgbotapi.ChatConfigWithUser{
+			ChatID: message.Chat.ID,
+			UserID: message.From.ID,
+		},
+	})
+	if err != nil {
+		b.logger.Error("Failed to get chat member",
+			zap.Error(err),
+			zap.Int64("chat_id", message.Chat.ID),
+			zap.Int64("user_id", message.From.ID))
+		return false
+	}
+	return member.IsCreator() || member.IsAdministrator()
+}
+
+// isChatMuted reports whether auto-classification is paused for the chat.
+// Lookup failures are logged and treated as not muted.
+func (b *Bot) isChatMuted(ctx context.Context, chatID int64) bool {
+	muted, err := b.storage.IsChatMuted(ctx, chatID)
+	if err != nil {
+		b.log(ctx).Error("Failed to get chat mute state",
+			zap.Error(err))
+		return false
+	}
+	return muted
+}
+
+// addressedToBot reports whether a group message is meant for the bot: it
+// mentions the bot or replies to one of its messages
+func (b *Bot) addressedToBot(message *tgbotapi.Message) bool {
+	self := b.api.Self
+	if reply := message.ReplyToMessage; reply != nil && reply.From != nil && reply.From.ID == self.ID {
+		return true
+	}
+	for _, entity := range append(message.Entities, message.CaptionEntities...) {
+		if entity.Type == "text_mention" && entity.User != nil && entity.User.ID == self.ID {
+			return true
+		}
+	}
+	mention := "@" + strings.ToLower(self.UserName)
+	return self.UserName != "" &&
+		(strings.Contains(strings.ToLower(message.Text), mention) || strings.Contains(strings.ToLower(message.Caption), mention))
+}
+
+// withoutMention removes the bot's @username from content. ok is false when
+// nothing else is left.
+func (b *Bot) withoutMention(content string) (string, bool) {
+	if b.api.Self.UserName != "" {
+		mention := regexp.MustCompile(`(?i)@` + regexp.QuoteMeta(b.api.Self.UserName) + `\b`)
+		content = mention.ReplaceAllString(content, "")
+	}
+	content = strings.TrimSpace(content)
+	return content, content != ""
+}
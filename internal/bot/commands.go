@@ -0,0 +1,446 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/xaenox/memo-bot/internal/classifier"
+	"go.uber.org/zap"
+)
+
+// commandHandler runs a bot command
+type commandHandler func(ctx context.Context, message *tgbotapi.Message)
+
+// localized is a short text by language; languages left out fall back to
+// English
+type localized map[string]string
+
+func (l localized) in(lang string) string {
+	if text, ok := l[lang]; ok {
+		return text
+	}
+	return l[defaultLanguage]
+}
+
+// command describes a bot command for dispatch, /help and Telegram's command
+// menu. Its texts are plain; /help escapes them for MarkdownV2.
+type command struct {
+	// name is the command without the slash
+	name        string
+	description localized
+	// usage shows the arguments after the name in /help; empty for commands
+	// that take none or are explained by their description
+	usage  localized
+	handle commandHandler
+	// adminOnly commands are refused to users not in telegram.admin_ids and
+	// left out of their help and the command menu
+	adminOnly bool
+	// safeToAutocorrect commands only read data, so a mistyped name may run
+	// them; any other command is only suggested
+	safeToAutocorrect bool
+}
+
+// registerCommands sets up the bot's commands in the order /help lists them
+func (b *Bot) registerCommands() {
+	b.commandList = []command{
+		{
+			name:        "start",
+			description: localized{"en": "Start the bot", "ru": "Запустить бота"},
+			handle:      b.handleStart,
+		},
+		{
+			name:              "help",
+			description:       localized{"en": "Show all commands", "ru": "Показать все команды"},
+			handle:            b.handleHelp,
+			safeToAutocorrect: true,
+		},
+		{
+			name:              "tags",
+			description:       localized{"en": "Show your tags with how many notes use each; add --alpha to sort them by name", "ru": "Показать ваши теги и число заметок с каждым; --alpha отсортирует их по имени"},
+			handle:            b.handleTags,
+			safeToAutocorrect: true,
+		},
+		{
+			name:              "categories",
+			description:       localized{"en": "Show your categories; add --counts to see how many notes each holds", "ru": "Показать ваши категории; --counts покажет число заметок в каждой"},
+			handle:            b.handleCategories,
+			safeToAutocorrect: true,
+		},
+		{
+			name:        "addcategory",
+			description: localized{"en": "Add a new category", "ru": "Добавить категорию"},
+			usage:       localized{"en": "<category_name>", "ru": "<категория>"},
+			handle:      b.handleAddCategory,
+		},
+		{
+			name:        "removecategory",
+			description: localized{"en": "Remove a category", "ru": "Удалить категорию"},
+			usage:       localized{"en": "<category_name>", "ru": "<категория>"},
+			handle:      b.handleRemoveCategory,
+		},
+		{
+			name:        "mergecategory",
+			description: localized{"en": "Merge one category into another", "ru": "Объединить категорию с другой"},
+			usage:       localized{"en": "<from> <into>", "ru": "<откуда> <куда>"},
+			handle:      b.handleMergeCategory,
+		},
+		{
+			name:        "renamecategory",
+			description: localized{"en": "Rename a category in all your notes", "ru": "Переименовать категорию во всех заметках"},
+			usage:       localized{"en": "<old> <new>", "ru": "<старое> <новое>"},
+			handle:      b.handleRenameCategory,
+		},
+		{
+			name:        "setcategorytags",
+			description: localized{"en": "Tag every new note in a category", "ru": "Добавлять теги ко всем новым заметкам категории"},
+			usage:       localized{"en": "<category_name> <tag_name...>", "ru": "<категория> <тег...>"},
+			handle:      b.handleSetCategoryTags,
+		},
+		{
+			name:        "clearcategorytags",
+			description: localized{"en": "Stop tagging a category's notes", "ru": "Перестать добавлять теги категории"},
+			usage:       localized{"en": "<category_name>", "ru": "<категория>"},
+			handle:      b.handleClearCategoryTags,
+		},
+		{
+			name:        "settaxonomy",
+			description: localized{"en": "Only allow your own set of categories", "ru": "Разрешить только свой набор категорий"},
+			usage:       localized{"en": "[category_name...] [--clear]", "ru": "[категория...] [--clear]"},
+			handle:      b.handleSetTaxonomy,
+		},
+		{
+			name:        "addtag",
+			description: localized{"en": "Add a tag", "ru": "Добавить тег"},
+			usage:       localized{"en": "<tag_name>", "ru": "<тег>"},
+			handle:      b.handleAddTag,
+		},
+		{
+			name:        "removetag",
+			description: localized{"en": "Remove a tag from your list", "ru": "Удалить тег из списка"},
+			usage:       localized{"en": "<tag_name>", "ru": "<тег>"},
+			handle:      b.handleRemoveTag,
+		},
+		{
+			name:        "renametag",
+			description: localized{"en": "Rename a tag in all your notes", "ru": "Переименовать тег во всех заметках"},
+			usage:       localized{"en": "<old_tag> <new_tag>", "ru": "<старый_тег> <новый_тег>"},
+			handle:      b.handleRenameTag,
+		},
+		{
+			name:        "maxtags",
+			description: localized{"en": "Set maximum number of tags per message", "ru": "Максимум тегов на сообщение"},
+			usage:       localized{"en": "<number>", "ru": "<число>"},
+			handle:      b.handleMaxTags,
+		},
+		{
+			name:              "history",
+			description:       localized{"en": "View recent messages", "ru": "Последние сообщения"},
+			usage:             localized{"en": "[number] [#category] [--archived] [--pinned-first]", "ru": "[число] [#категория] [--archived] [--pinned-first]"},
+			handle:            b.handleHistory,
+			safeToAutocorrect: true,
+		},
+		{
+			name:        "preview",
+			description: localized{"en": "Classify text without saving it", "ru": "Классифицировать текст без сохранения"},
+			usage:       localized{"en": "<text>", "ru": "<текст>"},
+			handle:      b.handlePreview,
+		},
+		{
+			name:        "reclassify",
+			description: localized{"en": "Classify all your notes again", "ru": "Заново классифицировать все заметки"},
+			usage:       localized{"en": "[cancel|restart]", "ru": "[cancel|restart]"},
+			handle:      b.handleReclassify,
+		},
+		{
+			name:              "category",
+			description:       localized{"en": "View messages in a category", "ru": "Сообщения в категории"},
+			usage:             localized{"en": "<category_name>", "ru": "<категория>"},
+			handle:            b.handleCategoryFilter,
+			safeToAutocorrect: true,
+		},
+		{
+			name:              "tag",
+			description:       localized{"en": "View messages with a tag", "ru": "Сообщения с тегом"},
+			usage:             localized{"en": "<tag_name>", "ru": "<тег>"},
+			handle:            b.handleTagFilter,
+			safeToAutocorrect: true,
+		},
+		{
+			name:              "stats",
+			description:       localized{"en": "Show a summary of your saved messages", "ru": "Сводка по сохранённым сообщениям"},
+			handle:            b.handleStats,
+			safeToAutocorrect: true,
+		},
+		{
+			name:        "delete",
+			description: localized{"en": "Delete a saved message", "ru": "Удалить сохранённое сообщение"},
+			usage:       localized{"en": "<message_id>", "ru": "<id_сообщения>"},
+			handle:      b.handleDelete,
+		},
+		{
+			name:        "archive",
+			description: localized{"en": "Hide a message from your history", "ru": "Скрыть сообщение из истории"},
+			usage:       localized{"en": "<message_id>", "ru": "<id_сообщения>"},
+			handle:      b.handleArchive,
+		},
+		{
+			name:        "unarchive",
+			description: localized{"en": "Restore an archived message", "ru": "Вернуть сообщение из архива"},
+			usage:       localized{"en": "<message_id>", "ru": "<id_сообщения>"},
+			handle:      b.handleUnarchive,
+		},
+		{
+			name:        "pin",
+			description: localized{"en": "Pin an important message", "ru": "Закрепить важное сообщение"},
+			usage:       localized{"en": "<message_id>", "ru": "<id_сообщения>"},
+			handle:      b.handlePin,
+		},
+		{
+			name:        "unpin",
+			description: localized{"en": "Unpin a message", "ru": "Открепить сообщение"},
+			usage:       localized{"en": "<message_id>", "ru": "<id_сообщения>"},
+			handle:      b.handleUnpin,
+		},
+		{
+			name:              "pinned",
+			description:       localized{"en": "Show your pinned messages", "ru": "Показать закреплённые сообщения"},
+			handle:            b.handlePinned,
+			safeToAutocorrect: true,
+		},
+		{
+			name:        "link",
+			description: localized{"en": "Link two related notes", "ru": "Связать две заметки"},
+			usage:       localized{"en": "<message_id> [message_id]", "ru": "<id_сообщения> [id_сообщения]"},
+			handle:      b.handleLink,
+		},
+		{
+			name:        "dedupe",
+			description: localized{"en": "Find and remove duplicate notes", "ru": "Найти и удалить дубликаты"},
+			usage:       localized{"en": "[confirm]", "ru": "[confirm]"},
+			handle:      b.handleDedupe,
+		},
+		{
+			name:        "dateformat",
+			description: localized{"en": "Set how dates are displayed", "ru": "Формат отображения дат"},
+			usage:       localized{"en": "<iso|us|eu|layout>", "ru": "<iso|us|eu|layout>"},
+			handle:      b.handleDateFormat,
+		},
+		{
+			name:        "timezone",
+			description: localized{"en": "Set the time zone dates are shown in", "ru": "Часовой пояс для дат"},
+			usage:       localized{"en": "<zone>", "ru": "<пояс>"},
+			handle:      b.handleTimezone,
+		},
+		{
+			name:        "temperature",
+			description: localized{"en": "Make tagging more consistent or more creative", "ru": "Более строгие или более творческие теги"},
+			usage:       localized{"en": "<0.0-2.0|default>", "ru": "<0.0-2.0|default>"},
+			handle:      b.handleTemperature,
+		},
+		{
+			name:        "categoryicon",
+			description: localized{"en": "Show an emoji next to a category", "ru": "Эмодзи рядом с категорией"},
+			usage:       localized{"en": "<category_name> <emoji>", "ru": "<категория> <эмодзи>"},
+			handle:      b.handleCategoryIcon,
+		},
+		{
+			name:        "language",
+			description: localized{"en": "Change the bot's language", "ru": "Сменить язык бота"},
+			usage:       localized{"en": "<code>", "ru": "<код>"},
+			handle:      b.handleLanguage,
+		},
+		{
+			name:        "retention",
+			description: localized{"en": "Delete notes after a number of days", "ru": "Удалять заметки через заданное число дней"},
+			usage:       localized{"en": "<days>d|off", "ru": "<дни>d|off"},
+			handle:      b.handleRetention,
+		},
+		{
+			name:        "mute",
+			description: localized{"en": "Stop classifying messages in this chat", "ru": "Не классифицировать сообщения в этом чате"},
+			handle:      b.handleMute,
+		},
+		{
+			name:        "unmute",
+			description: localized{"en": "Resume classifying messages in this chat", "ru": "Снова классифицировать сообщения в этом чате"},
+			handle:      b.handleUnmute,
+		},
+		{
+			name:              "exporttaxonomy",
+			description:       localized{"en": "Export your categories as a shareable file", "ru": "Экспортировать категории в файл"},
+			handle:            b.handleExportTaxonomy,
+			safeToAutocorrect: true,
+		},
+		{
+			name:        "importtaxonomy",
+			description: localized{"en": "Import a shared category file", "ru": "Импортировать файл с категориями"},
+			handle:      b.handleImportTaxonomy,
+		},
+		{
+			name:        "backup",
+			description: localized{"en": "Download all your data as a file", "ru": "Скачать все ваши данные файлом"},
+			handle:      b.handleBackup,
+		},
+		{
+			name:        "restore",
+			description: localized{"en": "Restore a backup file", "ru": "Восстановить данные из резервной копии"},
+			usage:       localized{"en": "[--replace]", "ru": "[--replace]"},
+			handle:      b.handleRestore,
+		},
+		{
+			name:        "forgetme",
+			description: localized{"en": "Delete all your data", "ru": "Удалить все ваши данные"},
+			handle:      b.handleForgetMe,
+		},
+		{
+			name:        "import",
+			description: localized{"en": "Import notes from a text or JSON file", "ru": "Импортировать заметки из текстового или JSON-файла"},
+			handle:      b.handleImport,
+			adminOnly:   true,
+		},
+		{
+			name:        "broadcast",
+			description: localized{"en": "Send a message to every user", "ru": "Отправить сообщение всем пользователям"},
+			usage:       localized{"en": "<message>", "ru": "<сообщение>"},
+			handle:      b.handleBroadcast,
+			adminOnly:   true,
+		},
+	}
+
+	b.commands = make(map[string]command, len(b.commandList))
+	for _, cmd := range b.commandList {
+		b.commands[cmd.name] = cmd
+	}
+}
+
+// handleCommand runs the command in message. An unknown command gets the
+// closest known ones suggested, or run when autocorrection is on, one command
+// is an edit away and it is safe to run unasked.
+func (b *Bot) handleCommand(ctx context.Context, message *tgbotapi.Message) {
+	name := message.Command()
+	if cmd, ok := b.commands[name]; ok {
+		b.runCommand(ctx, cmd, message)
+		return
+	}
+
+	admin := b.isAdmin(message.From.ID)
+	suggestions, distance := b.suggestCommands(name, admin)
+	if len(suggestions) == 0 {
+		b.sendMessage(message.Chat.ID, tr(ctx, msgUnknownCommand))
+		return
+	}
+	if b.autocorrectCommands && len(suggestions) == 1 && distance <= 1 && b.commands[suggestions[0]].safeToAutocorrect {
+		b.log(ctx).Info("Running corrected command",
+			zap.String("command", name),
+			zap.String("corrected", suggestions[0]))
+		b.sendMessage(message.Chat.ID, tr(ctx, msgCommandCorrected, "/"+suggestions[0]))
+		b.runCommand(ctx, b.commands[suggestions[0]], message)
+		return
+	}
+
+	for i, suggestion := range suggestions {
+		suggestions[i] = "/" + suggestion
+	}
+	b.sendMessage(message.Chat.ID, tr(ctx, msgDidYouMean, strings.Join(suggestions, ", ")))
+}
+
+func (b *Bot) runCommand(ctx context.Context, cmd command, message *tgbotapi.Message) {
+	if cmd.adminOnly && !b.isAdmin(message.From.ID) {
+		b.sendErrorMessage(message.Chat.ID, tr(ctx, errMsgPermission))
+		return
+	}
+	cmd.handle(ctx, message)
+}
+
+// suggestCommands finds the commands closest to name, ignoring case, and how
+// many edits away they are. It returns none when even the closest is too far
+// off to be a typo. Admin-only commands are only suggested to admins.
+func (b *Bot) suggestCommands(name string, admin bool) ([]string, int) {
+	name = strings.ToLower(name)
+	maxDistance := 2
+	if len([]rune(name)) < 5 {
+		// Two edits turn most short words into any other
+		maxDistance = 1
+	}
+
+	var suggestions []string
+	best := maxDistance + 1
+	for _, cmd := range b.commandList {
+		if cmd.adminOnly && !admin {
+			continue
+		}
+		distance := classifier.EditDistance(name, cmd.name)
+		if distance > maxDistance {
+			continue
+		}
+		switch {
+		case distance < best:
+			best = distance
+			suggestions = []string{cmd.name}
+		case distance == best:
+			suggestions = append(suggestions, cmd.name)
+		}
+	}
+	sort.Strings(suggestions)
+	return suggestions, best
+}
+
+// helpText lists the commands and their usage in the language of the user
+// being served, followed by the rest of the built-in help. It is MarkdownV2.
+func (b *Bot) helpText(ctx context.Context, admin bool) string {
+	lang := languageFrom(ctx)
+	var list, usage strings.Builder
+	for _, cmd := range b.commandList {
+		if cmd.adminOnly && !admin {
+			continue
+		}
+		list.WriteString("\n/" + escapeMarkdown(cmd.name) + ` \- ` + escapeMarkdown(cmd.description.in(lang)))
+		if len(cmd.usage) > 0 {
+			usage.WriteString("\n/" + escapeMarkdown(cmd.name) + " " + escapeMarkdown(cmd.usage.in(lang)))
+		}
+	}
+	return tr(ctx, msgHelpCommands) + list.String() + "\n\n" +
+		tr(ctx, msgHelpUsage) + usage.String() + "\n\n" +
+		tr(ctx, msgHelp)
+}
+
+// setMenuCommands lists the commands in Telegram's command menu, in every
+// catalog language. Admin-only commands are left out since the menu is the
+// same for everyone.
+func (b *Bot) setMenuCommands() error {
+	for _, lang := range supportedLanguages() {
+		var menu []tgbotapi.BotCommand
+		for _, cmd := range b.commandList {
+			if cmd.adminOnly {
+				continue
+			}
+			menu = append(menu, tgbotapi.BotCommand{
+				Command:     cmd.name,
+				Description: cmd.description.in(lang),
+			})
+		}
+
+		config := tgbotapi.NewSetMyCommands(menu...)
+		if lang != defaultLanguage {
+			// The default list is shown to users whose language has none
+			config = tgbotapi.NewSetMyCommandsWithScopeAndLanguage(tgbotapi.NewBotCommandScopeDefault(), lang, menu...)
+		}
+		if _, err := b.api.Request(config); err != nil {
+			return fmt.Errorf("failed to set %s command menu: %w", lang, err)
+		}
+	}
+	return nil
+}
+
+// publishMenu sets the command menu when the bot starts receiving updates,
+// by polling or by webhook
+func (b *Bot) publishMenu() {
+	if err := b.setMenuCommands(); err != nil {
+		// The commands still work when typed, so this is no reason to stop
+		b.logger.Warn("Failed to set the command menu",
+			zap.Error(err))
+	}
+}
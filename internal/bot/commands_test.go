@@ -0,0 +1,72 @@
+package bot
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/xaenox/memo-bot/internal/storage"
+)
+
+func TestSuggestCommands(t *testing.T) {
+	b, _ := newTestBot(t, Config{}, storage.NewMemoryStorage(storage.Limits{}), &fakeClassifier{}, nopSender{})
+
+	tests := []struct {
+		name         string
+		command      string
+		admin        bool
+		want         []string
+		wantDistance int
+	}{
+		{name: "one edit", command: "histroy", want: []string{"history"}, wantDistance: 2},
+		{name: "case is ignored", command: "Histori", want: []string{"history"}, wantDistance: 1},
+		{name: "short typo", command: "tap", want: []string{"tag"}, wantDistance: 1},
+		{name: "ties are sorted", command: "tagz", want: []string{"tag", "tags"}, wantDistance: 1},
+		{name: "two edits are too many for short names", command: "tga"},
+		{name: "nothing close", command: "xyzzyq"},
+		{name: "admin commands hidden from users", command: "broadcst"},
+		{name: "admin commands suggested to admins", command: "broadcst", admin: true, want: []string{"broadcast"}, wantDistance: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, distance := b.suggestCommands(tt.command, tt.admin)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("suggestCommands(%q) = %v, want %v", tt.command, got, tt.want)
+			}
+			if len(got) > 0 && distance != tt.wantDistance {
+				t.Errorf("suggestCommands(%q) distance = %d, want %d", tt.command, distance, tt.wantDistance)
+			}
+		})
+	}
+}
+
+func TestAutocorrectOnlyRunsReadOnlyCommands(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		// wantRun is true when the corrected command should run
+		wantRun bool
+	}{
+		{name: "read-only", command: "/histori", wantRun: true},
+		{name: "deletes a note", command: "/delet abc"},
+		{name: "replaces data", command: "/restor --replace"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sender := &recordingSender{}
+			b, _ := newTestBot(t, Config{AutocorrectCommands: true}, storage.NewMemoryStorage(storage.Limits{}), &fakeClassifier{}, sender)
+
+			b.handleCommand(context.Background(), commandMessage(1, tt.command))
+
+			texts := sender.sentTexts()
+			if len(texts) == 0 {
+				t.Fatal("no reply")
+			}
+			ran := strings.HasPrefix(texts[0], "Assuming you meant")
+			if ran != tt.wantRun || strings.Contains(texts[0], "Did you mean") == tt.wantRun {
+				t.Errorf("replies = %q, want the command run: %v", texts, tt.wantRun)
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/xaenox/memo-bot/internal/classifier"
+	"github.com/xaenox/memo-bot/internal/models"
+)
+
+// messageContent returns the text to classify for a message. Media sent
+// without a caption gets a placeholder describing the attachment so the
+// assistant still has something to work with. ok is false when there is
+// nothing to classify at all.
+func messageContent(message *tgbotapi.Message) (content string, ok bool) {
+	content = strings.TrimSpace(message.Text)
+	if caption := strings.TrimSpace(message.Caption); caption != "" {
+		content = caption
+	}
+	if content != "" {
+		return content, true
+	}
+
+	if description := describeAttachment(message); description != "" {
+		return description, true
+	}
+	return "", false
+}
+
+// noteCharLimit is the longest note worth classifying for a classifier that
+// truncates its input at maxInputChars
+func noteCharLimit(maxInputChars int) int {
+	return classifier.InputHardLimit(maxInputChars)
+}
+
+// noteTooLong reports whether content is too long to be classified at all.
+// Shorter notes over the input limit are truncated by the classifier.
+func (b *Bot) noteTooLong(content string) bool {
+	return b.maxNoteChars > 0 && utf8.RuneCountInString(content) > b.maxNoteChars
+}
+
+// messageMedia returns the Telegram file and content type of a photo,
+// document or video message. Other messages are plain text without a file.
+func messageMedia(message *tgbotapi.Message) (fileID string, contentType models.ContentType) {
+	switch {
+	case len(message.Photo) > 0:
+		// Sizes are ordered from smallest to largest
+		return message.Photo[len(message.Photo)-1].FileID, models.ImageContent
+	case message.Document != nil:
+		return message.Document.FileID, models.DocumentContent
+	case message.Video != nil:
+		return message.Video.FileID, models.VideoContent
+	}
+	return "", models.TextContent
+}
+
+// classificationPrompt tells the assistant where a forwarded message came
+// from and which kind of media a caption belongs to
+func classificationPrompt(message *tgbotapi.Message, content string, contentType models.ContentType) string {
+	prompt := mediaPrompt(message, content, contentType)
+	if source := messageSource(message); source != "" {
+		prompt = fmt.Sprintf("[Forwarded from %s]\n%s", source, prompt)
+	}
+	return prompt
+}
+
+// messageSource names the channel, chat or person a forwarded message came
+// from. It is empty for messages that weren't forwarded.
+func messageSource(message *tgbotapi.Message) string {
+	switch {
+	case message.ForwardFromChat != nil:
+		chat := message.ForwardFromChat
+		if chat.UserName == "" {
+			return chat.Title
+		}
+		if chat.Title == "" {
+			return "@" + chat.UserName
+		}
+		return fmt.Sprintf("%s (@%s)", chat.Title, chat.UserName)
+	case message.ForwardFrom != nil:
+		user := message.ForwardFrom
+		name := strings.TrimSpace(user.FirstName + " " + user.LastName)
+		if name == "" {
+			return "@" + user.UserName
+		}
+		return name
+	}
+	// Authors who hide their account only leave their name
+	return message.ForwardSenderName
+}
+
+// mediaPrompt tells the assistant which kind of media a caption belongs to.
+// Captionless media is already described by messageContent.
+func mediaPrompt(message *tgbotapi.Message, content string, contentType models.ContentType) string {
+	if strings.TrimSpace(message.Caption) == "" {
+		return content
+	}
+
+	var media string
+	switch contentType {
+	case models.ImageContent:
+		media = "a photo" + describePhoto(message.Photo)
+	case models.DocumentContent:
+		media = "a document: " + describeFile(message.Document.FileName, message.Document.MimeType) + describeSize(message.Document.FileSize)
+	case models.VideoContent:
+		media = "a video" + describeVideo(message.Video)
+	default:
+		return content
+	}
+	return fmt.Sprintf("[Caption of %s]\n%s", media, content)
+}
+
+// describeAttachment builds a short prompt describing the message's media
+func describeAttachment(message *tgbotapi.Message) string {
+	switch {
+	case len(message.Photo) > 0:
+		return fmt.Sprintf("[A photo%s was sent without a caption]", describePhoto(message.Photo))
+	case message.Document != nil:
+		return fmt.Sprintf("[A document was sent without a caption: %s%s]",
+			describeFile(message.Document.FileName, message.Document.MimeType), describeSize(message.Document.FileSize))
+	case message.Video != nil:
+		return fmt.Sprintf("[A %d second video was sent without a caption: %s%s]",
+			message.Video.Duration, describeFile(message.Video.FileName, message.Video.MimeType), describeSize(message.Video.FileSize))
+	case message.Audio != nil:
+		title := strings.TrimSpace(message.Audio.Performer + " - " + message.Audio.Title)
+		if title == "-" {
+			title = message.Audio.FileName
+		}
+		return fmt.Sprintf("[An audio file was sent without a caption: %s]", title)
+	case message.Voice != nil:
+		return fmt.Sprintf("[A %d second voice message was sent]", message.Voice.Duration)
+	case message.Animation != nil:
+		return "[An animation was sent without a caption]"
+	}
+	return ""
+}
+
+// messageKind names the payload of a message we can't classify, for logs
+func messageKind(message *tgbotapi.Message) string {
+	switch {
+	case message.Sticker != nil:
+		return "sticker"
+	case message.Location != nil && message.Venue == nil:
+		return "location"
+	case message.Venue != nil:
+		return "venue"
+	case message.Contact != nil:
+		return "contact"
+	case message.Poll != nil:
+		return "poll"
+	case message.Dice != nil:
+		return "dice"
+	case message.VideoNote != nil:
+		return "video_note"
+	case message.Game != nil:
+		return "game"
+	case message.NewChatMembers != nil, message.LeftChatMember != nil,
+		message.NewChatTitle != "", message.PinnedMessage != nil:
+		return "service"
+	}
+	return "unknown"
+}
+
+func describeFile(name, mimeType string) string {
+	switch {
+	case name != "" && mimeType != "":
+		return fmt.Sprintf("%s (%s)", name, mimeType)
+	case name != "":
+		return name
+	case mimeType != "":
+		return mimeType
+	}
+	return "unnamed file"
+}
+
+// describePhoto gives the size of the largest version of a photo, e.g.
+// " (1280x720, 240 KB)", so the assistant can tell screenshots from photos
+func describePhoto(sizes []tgbotapi.PhotoSize) string {
+	if len(sizes) == 0 {
+		return ""
+	}
+	largest := sizes[len(sizes)-1]
+	return fmt.Sprintf(" (%dx%d%s)", largest.Width, largest.Height, describeSize(largest.FileSize))
+}
+
+// describeVideo gives the length and resolution of a captioned video
+func describeVideo(video *tgbotapi.Video) string {
+	return fmt.Sprintf(" (%d seconds, %dx%d%s)", video.Duration, video.Width, video.Height, describeSize(video.FileSize))
+}
+
+// describeSize formats a file size to append to a description, or "" when
+// Telegram didn't report it
+func describeSize(bytes int) string {
+	switch {
+	case bytes <= 0:
+		return ""
+	case bytes < 1<<10:
+		return fmt.Sprintf(", %d B", bytes)
+	case bytes < 1<<20:
+		return fmt.Sprintf(", %d KB", bytes>>10)
+	}
+	return fmt.Sprintf(", %.1f MB", float64(bytes)/(1<<20))
+}
@@ -0,0 +1,72 @@
+package bot
+
+import (
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestMessageContent(t *testing.T) {
+	tests := []struct {
+		name    string
+		message tgbotapi.Message
+		want    string
+		wantOK  bool
+	}{
+		{
+			name:    "text",
+			message: tgbotapi.Message{Text: " buy milk "},
+			want:    "buy milk",
+			wantOK:  true,
+		},
+		{
+			name:    "caption wins over text",
+			message: tgbotapi.Message{Text: "text", Caption: "caption", Photo: []tgbotapi.PhotoSize{{Width: 10, Height: 10}}},
+			want:    "caption",
+			wantOK:  true,
+		},
+		{
+			name:    "blank caption on a photo",
+			message: tgbotapi.Message{Caption: "  ", Photo: []tgbotapi.PhotoSize{{Width: 1280, Height: 720}}},
+			want:    "[A photo (1280x720) was sent without a caption]",
+			wantOK:  true,
+		},
+		{
+			name:    "unnamed document",
+			message: tgbotapi.Message{Document: &tgbotapi.Document{}},
+			want:    "[A document was sent without a caption: unnamed file]",
+			wantOK:  true,
+		},
+		{
+			name:    "voice message",
+			message: tgbotapi.Message{Voice: &tgbotapi.Voice{Duration: 7}},
+			want:    "[A 7 second voice message was sent]",
+			wantOK:  true,
+		},
+		{
+			name:    "audio without performer or title",
+			message: tgbotapi.Message{Audio: &tgbotapi.Audio{FileName: "track.mp3"}},
+			want:    "[An audio file was sent without a caption: track.mp3]",
+			wantOK:  true,
+		},
+		{
+			name:    "whitespace only",
+			message: tgbotapi.Message{Text: " \n\t"},
+		},
+		{
+			name:    "sticker",
+			message: tgbotapi.Message{Sticker: &tgbotapi.Sticker{}},
+		},
+		{
+			name: "empty",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := messageContent(&tt.message)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("messageContent() = %q, %v, want %q, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/xaenox/memo-bot/internal/storage"
+	"go.uber.org/zap"
+)
+
+const categoryCorrectionPrefix = "category:"
+
+// handleCorrection applies a reply to one of our classification messages as
+// a corrected category and/or tags. It reports whether the message was such
+// a reply, in which case it must not be classified as a new note.
+func (b *Bot) handleCorrection(ctx context.Context, message *tgbotapi.Message) bool {
+	reply := message.ReplyToMessage
+	if reply == nil || reply.From == nil || reply.From.ID != b.api.Self.ID {
+		return false
+	}
+
+	stored, err := b.storage.GetMessageByClassificationReply(ctx, message.Chat.ID, reply.MessageID)
+	if errors.Is(err, storage.ErrNotFound) {
+		return false
+	}
+	if err != nil {
+		b.log(ctx).Error("Failed to look up classified message",
+			zap.Error(err),
+			zap.Int("bot_message_id", reply.MessageID))
+		b.sendStorageError(ctx, message.Chat.ID, err, tr(ctx, errMsgRetrieval))
+		return true
+	}
+
+	// Only the author of a note may correct it, even in group chats
+	if stored.UserID != message.From.ID {
+		return false
+	}
+
+	category, tags := parseCorrection(message.Text)
+	if category == "" && tags == nil {
+		b.sendMessage(message.Chat.ID, "To correct this classification, reply with the new category and/or tags, e.g.\n"+
+			"category: finance\n"+
+			"#budget #bills")
+		return true
+	}
+
+	if category == "" {
+		category = stored.Category
+	}
+	if tags == nil {
+		tags = stored.Tags
+	}
+
+	if err := b.storage.UpdateMessageClassification(ctx, stored.ID, category, tags); err != nil {
+		b.log(ctx).Error("Failed to update message classification",
+			zap.Error(err),
+			zap.String("message_id", stored.ID))
+		b.sendStorageError(ctx, message.Chat.ID, err, "Failed to update the classification. Please try again.")
+		return true
+	}
+
+	if err := b.storage.AddCategory(ctx, message.From.ID, category); err != nil {
+		b.log(ctx).Error("Failed to save category",
+			zap.Error(err),
+			zap.String("category", category))
+	}
+	for _, tag := range tags {
+		if err := b.storage.AddTag(ctx, message.From.ID, tag); err != nil {
+			b.log(ctx).Error("Failed to save tag",
+				zap.Error(err),
+				zap.String("tag", tag))
+		}
+	}
+
+	prefs := b.userDisplayPrefs(ctx, message.From.ID)
+	labels := make([]string, len(tags))
+	for i, tag := range tags {
+		labels[i] = formatLabel(tag)
+	}
+	text := fmt.Sprintf("✏️ Updated. Category: %s", prefs.categoryLabel(category))
+	if len(labels) > 0 {
+		text += "\nTags: " + strings.Join(labels, " ")
+	}
+	b.sendMessage(message.Chat.ID, text)
+	return true
+}
+
+// parseCorrection reads a "category: <name>" line and any #hashtags from a
+// correction reply. tags is nil when no hashtags were given so callers can
+// tell "keep the tags" apart from an explicit change.
+func parseCorrection(text string) (category string, tags []string) {
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(text, "\n") {
+		words := strings.Fields(line)
+		if len(words) > 0 && strings.HasPrefix(strings.ToLower(words[0]), categoryCorrectionPrefix) {
+			// "category:finance" and "category: #finance" are both accepted
+			words[0] = words[0][len(categoryCorrectionPrefix):]
+			if words[0] == "" {
+				words = words[1:]
+			}
+			var name []string
+			for len(words) > 0 && (len(name) == 0 || !strings.HasPrefix(words[0], "#")) {
+				name = append(name, words[0])
+				words = words[1:]
+			}
+			category = storage.NormalizeLabel(strings.Join(name, " "))
+		}
+
+		for _, word := range words {
+			if !strings.HasPrefix(word, "#") {
+				continue
+			}
+			if tag := storage.NormalizeLabel(word); tag != "" && !seen[tag] {
+				seen[tag] = true
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return category, tags
+}
@@ -0,0 +1,100 @@
+package bot
+
+import (
+	"context"
+	"sync"
+
+	"github.com/xaenox/memo-bot/internal/storage"
+	"go.uber.org/zap"
+)
+
+// Where handled update IDs are remembered, selected with Config.UpdateDedup
+const (
+	// DedupMemory keeps recent IDs in this process; it forgets them on restart
+	DedupMemory = "memory"
+	// DedupStorage keeps them in storage, so restarts and other instances
+	// sharing the database see them too
+	DedupStorage = "storage"
+	// DedupOff handles every delivery
+	DedupOff = "off"
+)
+
+// updateGuard recognizes updates Telegram delivers more than once, e.g. when a
+// webhook response timed out
+type updateGuard interface {
+	// firstDelivery records updateID and reports whether it is new
+	firstDelivery(ctx context.Context, updateID int) (bool, error)
+}
+
+func newUpdateGuard(dedup string, store storage.StateStorage) updateGuard {
+	switch dedup {
+	case DedupOff:
+		return nil
+	case DedupStorage:
+		return storedUpdates{storage: store}
+	default:
+		return newRecentUpdates(storage.ProcessedUpdatesWindow)
+	}
+}
+
+// recentUpdates remembers the last size update IDs
+type recentUpdates struct {
+	mu  sync.Mutex
+	ids map[int]struct{}
+	// ring holds the IDs in arrival order; next is the oldest
+	ring []int
+	next int
+}
+
+func newRecentUpdates(size int) *recentUpdates {
+	return &recentUpdates{
+		ids:  make(map[int]struct{}, size),
+		ring: make([]int, 0, size),
+	}
+}
+
+func (r *recentUpdates) firstDelivery(ctx context.Context, updateID int) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, seen := r.ids[updateID]; seen {
+		return false, nil
+	}
+	if len(r.ring) < cap(r.ring) {
+		r.ring = append(r.ring, updateID)
+	} else {
+		delete(r.ids, r.ring[r.next])
+		r.ring[r.next] = updateID
+		r.next = (r.next + 1) % len(r.ring)
+	}
+	r.ids[updateID] = struct{}{}
+	return true, nil
+}
+
+type storedUpdates struct {
+	storage storage.StateStorage
+}
+
+func (s storedUpdates) firstDelivery(ctx context.Context, updateID int) (bool, error) {
+	return s.storage.MarkUpdateProcessed(ctx, updateID)
+}
+
+// firstDelivery reports whether update should be handled. When the guard
+// fails the update is handled anyway; a duplicate beats a lost message.
+func (b *Bot) firstDelivery(ctx context.Context, updateID int) bool {
+	if b.updates == nil {
+		return true
+	}
+	first, err := b.updates.firstDelivery(ctx, updateID)
+	if err != nil {
+		b.logger.Warn("Failed to check for a duplicate update",
+			zap.Error(err),
+			zap.Int("update_id", updateID))
+		return true
+	}
+	if !first {
+		b.logger.Info("Skipping duplicate update",
+			zap.Int("update_id", updateID))
+	}
+	return first
+}
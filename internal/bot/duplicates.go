@@ -0,0 +1,93 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/xaenox/memo-bot/internal/storage"
+	"go.uber.org/zap"
+)
+
+const (
+	duplicateCallbackAction = "duplicate"
+	duplicateSave           = "save"
+	duplicateSkip           = "skip"
+)
+
+// askAboutDuplicate asks whether to save content again when the user already
+// has a note with the same content. It reports whether it asked, in which
+// case the message is handled once the user answers.
+func (b *Bot) askAboutDuplicate(ctx context.Context, message *tgbotapi.Message, content string) bool {
+	existing, err := b.storage.FindSimilarMessage(ctx, message.From.ID, content)
+	if errors.Is(err, storage.ErrNotFound) {
+		return false
+	}
+	if err != nil {
+		b.log(ctx).Warn("Failed to check for duplicate message",
+			zap.Error(err))
+		return false
+	}
+
+	prefs := b.userDisplayPrefs(ctx, message.From.ID)
+	data := func(answer string) string {
+		return duplicateCallbackAction + ":" + strconv.FormatInt(message.From.ID, 10) + ":" + answer
+	}
+	question := tgbotapi.NewMessage(message.Chat.ID, prefs.t(msgDuplicateQuestion,
+		prefs.formatTime(existing.CreatedAt), prefs.categoryLabel(existing.Category)))
+	// The answer handler finds the note to save through this reply
+	question.ReplyToMessageID = message.MessageID
+	question.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(prefs.t(msgDuplicateSave), data(duplicateSave)),
+		tgbotapi.NewInlineKeyboardButtonData(prefs.t(msgDuplicateSkip), data(duplicateSkip)),
+	))
+	if _, err := b.api.Send(question); err != nil {
+		b.log(ctx).Error("Failed to ask about duplicate message",
+			zap.Error(err))
+		return false
+	}
+	return true
+}
+
+// handleDuplicateCallback saves or drops a note the user was asked about
+func (b *Bot) handleDuplicateCallback(ctx context.Context, query *tgbotapi.CallbackQuery, args string) string {
+	rawUserID, answer, _ := strings.Cut(args, ":")
+	userID, err := strconv.ParseInt(rawUserID, 10, 64)
+	if err != nil || query.Message == nil || (answer != duplicateSave && answer != duplicateSkip) {
+		return ""
+	}
+	if userID != query.From.ID {
+		return tr(ctx, msgDuplicateNotYours)
+	}
+
+	chatID, messageID := query.Message.Chat.ID, query.Message.MessageID
+	original := query.Message.ReplyToMessage
+	if answer == duplicateSkip || original == nil {
+		edit := tgbotapi.NewEditMessageText(chatID, messageID, tr(ctx, msgDuplicateSkipped))
+		if _, err := b.api.Send(edit); err != nil {
+			b.log(ctx).Warn("Failed to update duplicate question",
+				zap.Error(err),
+				zap.Int("message_id", messageID))
+		}
+		if original == nil {
+			// The user deleted the note in the meantime
+			return tr(ctx, errMsgMessageNotFound)
+		}
+		return ""
+	}
+
+	if _, err := b.api.Request(tgbotapi.NewDeleteMessage(chatID, messageID)); err != nil {
+		b.log(ctx).Warn("Failed to delete duplicate question",
+			zap.Error(err),
+			zap.Int("message_id", messageID))
+	}
+
+	content, ok := messageContent(original)
+	if !ok {
+		return tr(ctx, errMsgMessageNotFound)
+	}
+	b.classifyAndSave(ctx, original, content)
+	return ""
+}
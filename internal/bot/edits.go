@@ -0,0 +1,114 @@
+package bot
+
+import (
+	"context"
+	"errors"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/xaenox/memo-bot/internal/storage"
+	"go.uber.org/zap"
+)
+
+// handleEditedMessage re-classifies a note when the user edits the message
+// it was saved from and updates our classification reply in place
+func (b *Bot) handleEditedMessage(ctx context.Context, message *tgbotapi.Message) {
+	ctx = withLanguage(ctx, b.userLanguage(ctx, message.From))
+
+	// Editing a command must not run it again
+	if message.IsCommand() || b.isChatMuted(ctx, message.Chat.ID) {
+		return
+	}
+
+	stored, botMessageID, err := b.storage.GetClassificationBySource(ctx, message.Chat.ID, message.MessageID)
+	if errors.Is(err, storage.ErrNotFound) {
+		// Nothing was saved from the original, e.g. it had nothing to
+		// classify, so the edit is handled like a new message
+		b.handleMessage(ctx, message)
+		return
+	}
+	if err != nil {
+		b.log(ctx).Error("Failed to look up edited message",
+			zap.Error(err),
+			zap.Int("source_message_id", message.MessageID))
+		return
+	}
+	if stored.UserID != message.From.ID {
+		return
+	}
+
+	content, ok := messageContent(message)
+	if !ok || content == stored.Content {
+		return
+	}
+	if b.noteTooLong(content) {
+		b.sendMessage(message.Chat.ID, tr(ctx, msgNoteTooLong, b.maxNoteChars))
+		return
+	}
+
+	_, contentType := messageMedia(message)
+	response := b.analyze(ctx, message, classificationPrompt(message, content, contentType), contentType)
+	if response.Category == "" {
+		b.log(ctx).Error("Failed to get GPT analysis for edited message",
+			zap.String("message_id", stored.ID))
+		b.sendErrorMessage(message.Chat.ID, tr(ctx, errMsgClassify))
+		return
+	}
+	response.Category = b.matchCategory(ctx, message.From.ID, response.Category)
+	response.Keywords = b.withCategoryTags(ctx, message.From.ID, response.Category, response.Keywords)
+	if maxTags := b.userMaxTags(ctx, message.From.ID); len(response.Keywords) > maxTags {
+		response.Keywords = response.Keywords[:maxTags]
+	}
+
+	stored.Content = content
+	stored.Category = response.Category
+	stored.Tags = response.Keywords
+	stored.Summary = response.Summary
+	stored.Links = response.Links
+	stored.AttachmentsAnalysis = response.AttachmentsAnalysis
+	err = b.storage.UpdateMessage(ctx, stored)
+	if errors.Is(err, storage.ErrInvalidInput) {
+		b.sendErrorMessage(message.Chat.ID, tr(ctx, errMsgTooLarge))
+		return
+	}
+	if err != nil {
+		b.log(ctx).Error("Failed to update edited message",
+			zap.Error(err),
+			zap.String("message_id", stored.ID))
+		b.sendStorageError(ctx, message.Chat.ID, err, tr(ctx, errMsgSave))
+		return
+	}
+
+	if err := b.storage.AddCategory(ctx, message.From.ID, response.Category); err != nil {
+		b.log(ctx).Error("Failed to save category",
+			zap.Error(err),
+			zap.String("category", response.Category))
+	}
+	for _, tag := range response.Keywords {
+		if err := b.storage.AddTag(ctx, message.From.ID, tag); err != nil {
+			b.log(ctx).Error("Failed to save tag",
+				zap.Error(err),
+				zap.String("tag", tag))
+		}
+	}
+
+	prefs := b.userDisplayPrefs(ctx, message.From.ID)
+	edit := tgbotapi.NewEditMessageText(message.Chat.ID, botMessageID, formatClassification(&response, prefs))
+	edit.ParseMode = "MarkdownV2"
+	if _, err = b.api.Send(edit); err == nil {
+		return
+	}
+	b.log(ctx).Warn("Failed to edit classification reply, sending a new one",
+		zap.Error(err),
+		zap.Int("bot_message_id", botMessageID))
+
+	// The old reply may have been deleted; answer afresh so corrections work
+	sent, err := b.sendClassificationResponse(message.Chat.ID, message.MessageID, &response, stored.Source, prefs, "")
+	if err != nil {
+		return
+	}
+	if err := b.storage.SaveClassificationReply(ctx, message.Chat.ID, sent.MessageID, stored.ID, message.MessageID); err != nil {
+		b.log(ctx).Error("Failed to save classification reply",
+			zap.Error(err),
+			zap.String("message_id", stored.ID))
+	}
+}
@@ -0,0 +1,39 @@
+package bot
+
+import (
+	"context"
+	"errors"
+
+	"github.com/xaenox/memo-bot/internal/storage"
+)
+
+// storageErrorMessages tell the user whether a failed operation was their
+// mistake or worth retrying. They are checked in order.
+var storageErrorMessages = []struct {
+	err error
+	key msgKey
+}{
+	{storage.ErrNotFound, errMsgNotFound},
+	{storage.ErrInvalidInput, errMsgInvalidInput},
+	{storage.ErrConstraint, errMsgInvalidInput},
+	{storage.ErrDuplicate, errMsgDuplicate},
+	{storage.ErrAlreadyExists, errMsgDuplicate},
+	{storage.ErrConnection, errMsgUnavailable},
+}
+
+// errorMessage returns the text explaining err to the user, or fallback when
+// err says no more than that the operation failed
+func errorMessage(ctx context.Context, err error, fallback string) string {
+	for _, m := range storageErrorMessages {
+		if errors.Is(err, m.err) {
+			return tr(ctx, m.key)
+		}
+	}
+	return fallback
+}
+
+// sendStorageError reports a failed storage operation. Callers log err
+// themselves; the user only sees the message chosen for it.
+func (b *Bot) sendStorageError(ctx context.Context, chatID int64, err error, fallback string) {
+	b.sendErrorMessage(chatID, errorMessage(ctx, err, fallback))
+}
@@ -0,0 +1,46 @@
+package bot
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	// maxDownloadSize caps files fetched from Telegram for imports
+	maxDownloadSize = 5 << 20
+	// maxBackupSize is the largest file bots may fetch from Telegram, so the
+	// largest backup /restore can read back
+	maxBackupSize = 20 << 20
+)
+
+var downloadClient = &http.Client{Timeout: 30 * time.Second}
+
+// downloadFile fetches a file the user uploaded to Telegram, refusing files
+// over limit bytes
+func (b *Bot) downloadFile(fileID string, limit int) ([]byte, error) {
+	url, err := b.api.GetFileDirectURL(fileID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file url: %w", err)
+	}
+
+	resp, err := downloadClient.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download file: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download file: status %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, int64(limit)+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	if len(data) > limit {
+		return nil, fmt.Errorf("file exceeds %d bytes", limit)
+	}
+	return data, nil
+}
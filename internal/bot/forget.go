@@ -0,0 +1,76 @@
+package bot
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.uber.org/zap"
+)
+
+const (
+	forgetCallbackAction = "forgetme"
+	forgetConfirm        = "yes"
+	forgetCancel         = "no"
+)
+
+// handleForgetMe asks the user to confirm deleting all of their data
+func (b *Bot) handleForgetMe(ctx context.Context, message *tgbotapi.Message) {
+	data := func(answer string) string {
+		return forgetCallbackAction + ":" + strconv.FormatInt(message.From.ID, 10) + ":" + answer
+	}
+	msg := tgbotapi.NewMessage(message.Chat.ID, tr(ctx, msgForgetQuestion))
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(tr(ctx, msgForgetConfirm), data(forgetConfirm)),
+		tgbotapi.NewInlineKeyboardButtonData(tr(ctx, msgForgetCancel), data(forgetCancel)),
+	))
+	if _, err := b.api.Send(msg); err != nil {
+		b.log(ctx).Error("Failed to send forget me confirmation",
+			zap.Error(err))
+	}
+}
+
+// handleForgetCallback deletes the user's assistant thread, notes and
+// settings once they confirm
+func (b *Bot) handleForgetCallback(ctx context.Context, query *tgbotapi.CallbackQuery, args string) string {
+	rawUserID, answer, _ := strings.Cut(args, ":")
+	userID, err := strconv.ParseInt(rawUserID, 10, 64)
+	if err != nil || query.Message == nil || (answer != forgetConfirm && answer != forgetCancel) {
+		return ""
+	}
+	// Nobody gets to erase someone else's data, even in group chats
+	if userID != query.From.ID {
+		return tr(ctx, errMsgPermission)
+	}
+
+	result := tr(ctx, msgForgetCancelled)
+	if answer == forgetConfirm {
+		result = tr(ctx, msgForgetDone)
+		if err := b.forgetUser(ctx, userID); err != nil {
+			b.log(ctx).Error("Failed to delete user data",
+				zap.Error(err))
+			result = tr(ctx, msgForgetFailed)
+		}
+	}
+
+	edit := tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID, result)
+	if _, err := b.api.Send(edit); err != nil {
+		b.log(ctx).Warn("Failed to update forget me confirmation",
+			zap.Error(err))
+	}
+	return ""
+}
+
+// forgetUser deletes the assistant thread before the stored data, so a
+// failure there leaves the thread known to us and the user can retry
+func (b *Bot) forgetUser(ctx context.Context, userID int64) error {
+	if err := b.classifier.ForgetUser(ctx, userID); err != nil {
+		return err
+	}
+	if err := b.storage.DeleteUser(ctx, userID); err != nil {
+		return err
+	}
+	b.log(ctx).Info("Deleted all user data")
+	return nil
+}
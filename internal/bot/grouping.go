@@ -0,0 +1,99 @@
+package bot
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.uber.org/zap"
+)
+
+// groupKey identifies whose messages are grouped; a user's messages in
+// different chats become different notes
+type groupKey struct {
+	chatID int64
+	userID int64
+}
+
+// noteGroup is the text of quick successive messages waiting to be saved as
+// one note. The note replies to and is linked with the first message.
+type noteGroup struct {
+	ctx   context.Context
+	first *tgbotapi.Message
+	parts []string
+	timer *time.Timer
+}
+
+// noteGrouper buffers text messages sent within a window of each other
+type noteGrouper struct {
+	mu     sync.Mutex
+	window time.Duration
+	groups map[groupKey]*noteGroup
+}
+
+func newNoteGrouper(window time.Duration) *noteGrouper {
+	if window <= 0 {
+		return nil
+	}
+	return &noteGrouper{
+		window: window,
+		groups: make(map[groupKey]*noteGroup),
+	}
+}
+
+// groupMessage adds a text message to its sender's pending note and restarts
+// the wait for more. The note is classified and saved once nothing has been
+// added to it for the window, or earlier when the next part would make it too
+// long.
+func (b *Bot) groupMessage(ctx context.Context, message *tgbotapi.Message, content string) {
+	g := b.grouper
+	key := groupKey{chatID: message.Chat.ID, userID: message.From.ID}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if group, ok := g.groups[key]; ok {
+		joined := strings.Join(group.parts, "\n\n") + "\n\n" + content
+		if !b.noteTooLong(joined) && group.timer.Stop() {
+			group.parts = append(group.parts, content)
+			group.timer.Reset(g.window)
+			b.log(ctx).Debug("Added message to pending note",
+				zap.Int("parts", len(group.parts)))
+			return
+		}
+		// Too long to add, or already being saved: start a new note
+		if group.timer.Stop() {
+			delete(g.groups, key)
+			go b.flushGroup(group)
+		}
+	}
+
+	group := &noteGroup{ctx: ctx, first: message, parts: []string{content}}
+	// Shutdown waits for the note to be saved like for any other handler
+	b.inFlight.Add(1)
+	group.timer = time.AfterFunc(g.window, func() {
+		g.mu.Lock()
+		if g.groups[key] == group {
+			delete(g.groups, key)
+		}
+		g.mu.Unlock()
+		b.flushGroup(group)
+	})
+	g.groups[key] = group
+}
+
+// flushGroup classifies and saves a pending note
+func (b *Bot) flushGroup(group *noteGroup) {
+	defer b.inFlight.Done()
+
+	b.acquireSlot(group.first.Chat.ID)
+	defer b.releaseSlot()
+
+	if len(group.parts) > 1 {
+		b.log(group.ctx).Info("Saving grouped messages as one note",
+			zap.Int("parts", len(group.parts)))
+	}
+	b.classifyAndSave(group.ctx, group.first, strings.Join(group.parts, "\n\n"))
+}
@@ -0,0 +1,180 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/xaenox/memo-bot/internal/classifier"
+	"github.com/xaenox/memo-bot/internal/models"
+	"github.com/xaenox/memo-bot/internal/storage"
+	"go.uber.org/zap"
+)
+
+// testBotID and testBotUserName are who the fake Telegram API says the bot is
+const (
+	testBotID       = 1
+	testBotUserName = "memobot"
+)
+
+// telegramCall is one Bot API request the bot made
+type telegramCall struct {
+	Method string
+	Params map[string]string
+}
+
+// fakeTelegram is a Bot API server that accepts every request and records it
+type fakeTelegram struct {
+	server *httptest.Server
+
+	mu     sync.Mutex
+	calls  []telegramCall
+	nextID int
+}
+
+func newFakeTelegram(t *testing.T) *fakeTelegram {
+	t.Helper()
+	f := &fakeTelegram{nextID: 1000}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+func (f *fakeTelegram) serve(w http.ResponseWriter, r *http.Request) {
+	method := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+	if err := r.ParseMultipartForm(1 << 20); err != nil {
+		_ = r.ParseForm()
+	}
+	params := make(map[string]string, len(r.Form))
+	for key := range r.Form {
+		params[key] = r.Form.Get(key)
+	}
+
+	f.mu.Lock()
+	f.calls = append(f.calls, telegramCall{Method: method, Params: params})
+	f.nextID++
+	id := f.nextID
+	f.mu.Unlock()
+
+	var result any = true
+	switch {
+	case method == "getMe":
+		result = map[string]any{"id": testBotID, "is_bot": true, "first_name": "Memo", "username": testBotUserName}
+	case strings.HasPrefix(method, "send"), strings.HasPrefix(method, "edit"):
+		var chatID int64
+		fmt.Sscan(params["chat_id"], &chatID)
+		result = map[string]any{"message_id": id, "date": 0, "chat": map[string]any{"id": chatID}, "text": params["text"]}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"ok": true, "result": result})
+}
+
+// sent returns the calls made with method
+func (f *fakeTelegram) sent(method string) []telegramCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var calls []telegramCall
+	for _, call := range f.calls {
+		if call.Method == method {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// recordingSender is a nopSender that keeps the texts it was asked to send
+type recordingSender struct {
+	nopSender
+
+	mu    sync.Mutex
+	texts []string
+}
+
+func (s *recordingSender) SendMessage(chatID int64, text string) (tgbotapi.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.texts = append(s.texts, text)
+	return tgbotapi.Message{MessageID: len(s.texts), Chat: &tgbotapi.Chat{ID: chatID}, Text: text}, nil
+}
+
+func (s *recordingSender) sentTexts() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.texts...)
+}
+
+// fakeClassifier answers every analysis with response
+type fakeClassifier struct {
+	response classifier.GPTResponse
+
+	mu       sync.Mutex
+	contents []string
+}
+
+var _ classifier.Classifier = (*fakeClassifier)(nil)
+
+func (c *fakeClassifier) ClassifyContent(ctx context.Context, content string, userID int64) []string {
+	return c.response.Keywords
+}
+
+func (c *fakeClassifier) GetStructuredAnalysis(ctx context.Context, content string, contentType models.ContentType, userID int64) classifier.GPTResponse {
+	c.mu.Lock()
+	c.contents = append(c.contents, content)
+	c.mu.Unlock()
+	response := c.response
+	response.Keywords = append([]string(nil), c.response.Keywords...)
+	return response
+}
+
+func (c *fakeClassifier) ClassifyBatch(ctx context.Context, contents []string, userID int64) ([]classifier.GPTResponse, error) {
+	responses := make([]classifier.GPTResponse, len(contents))
+	for i, content := range contents {
+		responses[i] = c.GetStructuredAnalysis(ctx, content, models.TextContent, userID)
+	}
+	return responses, nil
+}
+
+func (c *fakeClassifier) ForgetUser(ctx context.Context, userID int64) error {
+	return nil
+}
+
+// newTestBot builds a bot on a fake Telegram API. Messages sent through its
+// MessageSender go to sender.
+func newTestBot(t *testing.T, cfg Config, store storage.Storage, c classifier.Classifier, sender MessageSender) (*Bot, *fakeTelegram) {
+	t.Helper()
+	telegram := newFakeTelegram(t)
+	api, err := tgbotapi.NewBotAPIWithAPIEndpoint("test-token", telegram.server.URL+"/bot%s/%s")
+	if err != nil {
+		t.Fatalf("failed to create API client: %v", err)
+	}
+	b, err := newBot(cfg, api, store, c, zap.NewNop())
+	if err != nil {
+		t.Fatalf("failed to create bot: %v", err)
+	}
+	b.sender = sender
+	return b, telegram
+}
+
+// textMessage is a private chat message from userID
+func textMessage(userID int64, messageID int, text string) *tgbotapi.Message {
+	return &tgbotapi.Message{
+		MessageID: messageID,
+		From:      &tgbotapi.User{ID: userID, LanguageCode: "en"},
+		Chat:      &tgbotapi.Chat{ID: userID, Type: "private"},
+		Text:      text,
+	}
+}
+
+// commandMessage is a private chat command from userID
+func commandMessage(userID int64, text string) *tgbotapi.Message {
+	message := textMessage(userID, 1, text)
+	name, _, _ := strings.Cut(text, " ")
+	message.Entities = []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len(name)}}
+	return message
+}
@@ -0,0 +1,396 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/xaenox/memo-bot/internal/models"
+	"github.com/xaenox/memo-bot/internal/storage"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultHistoryLimit = 10
+	maxHistoryLimit     = 50
+	historyPreviewLen   = 200
+
+	historyCallbackAction = "history"
+	// Telegram rejects callback data longer than this
+	maxCallbackDataLen = 64
+)
+
+// historyPage is one page of /history output. It travels in the callback
+// data of the Previous/Next buttons.
+type historyPage struct {
+	userID      int64
+	offset      int
+	limit       int
+	archived    bool
+	pinnedFirst bool
+	category    string
+}
+
+// Bits of the flags field in history callback data
+const (
+	historyArchived    = 1
+	historyPinnedFirst = 2
+)
+
+func (p historyPage) callbackData() string {
+	flags := 0
+	if p.archived {
+		flags |= historyArchived
+	}
+	if p.pinnedFirst {
+		flags |= historyPinnedFirst
+	}
+	return fmt.Sprintf("%s:%d:%d:%d:%d:%s", historyCallbackAction, p.userID, p.offset, p.limit, flags, p.category)
+}
+
+// parseHistoryPage reads the arguments of a history callback, i.e. the
+// callback data without its action
+func parseHistoryPage(args string) (historyPage, bool) {
+	parts := strings.SplitN(args, ":", 5)
+	if len(parts) != 5 {
+		return historyPage{}, false
+	}
+
+	userID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return historyPage{}, false
+	}
+	offset, err := strconv.Atoi(parts[1])
+	if err != nil || offset < 0 {
+		return historyPage{}, false
+	}
+	limit, err := strconv.Atoi(parts[2])
+	if err != nil || limit < 1 || limit > maxHistoryLimit {
+		return historyPage{}, false
+	}
+	flags, err := strconv.Atoi(parts[3])
+	if err != nil || flags < 0 || flags > historyArchived|historyPinnedFirst {
+		return historyPage{}, false
+	}
+	return historyPage{
+		userID:      userID,
+		offset:      offset,
+		limit:       limit,
+		archived:    flags&historyArchived != 0,
+		pinnedFirst: flags&historyPinnedFirst != 0,
+		category:    parts[4],
+	}, true
+}
+
+func (b *Bot) handleHistory(ctx context.Context, message *tgbotapi.Message) {
+	limit := defaultHistoryLimit
+	var (
+		category    string
+		archived    bool
+		pinnedFirst bool
+	)
+
+	for _, arg := range strings.Fields(message.CommandArguments()) {
+		if arg == "--archived" {
+			archived = true
+			continue
+		}
+		if arg == "--pinned-first" {
+			pinnedFirst = true
+			continue
+		}
+		if strings.HasPrefix(arg, "#") {
+			category = storage.NormalizeLabel(arg)
+			continue
+		}
+
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 1 {
+			b.sendMessage(message.Chat.ID, "Usage: /history [number] [#category] [--archived] [--pinned-first]")
+			return
+		}
+		limit = min(n, maxHistoryLimit)
+	}
+
+	if archived && category != "" {
+		b.sendMessage(message.Chat.ID, "Archived messages can't be filtered by category yet.")
+		return
+	}
+	if pinnedFirst && (archived || category != "") {
+		b.sendMessage(message.Chat.ID, "Only your recent messages can be listed pinned first.")
+		return
+	}
+
+	page := historyPage{userID: message.From.ID, limit: limit, archived: archived, pinnedFirst: pinnedFirst, category: category}
+	messages, hasNext, err := b.loadHistoryPage(ctx, page)
+	if err != nil {
+		b.sendStorageError(ctx, message.Chat.ID, err, tr(ctx, errMsgRetrieval))
+		return
+	}
+
+	if len(messages) == 0 {
+		if category != "" {
+			b.sendMessage(message.Chat.ID, fmt.Sprintf("No messages found for category #%s.", category))
+			return
+		}
+		if archived {
+			b.sendMessage(message.Chat.ID, "You don't have any archived messages.")
+			return
+		}
+		b.sendMessage(message.Chat.ID, "You don't have any saved messages yet.")
+		return
+	}
+
+	prefs := b.userDisplayPrefs(ctx, message.From.ID)
+	links := b.messageLinks(ctx, page.userID, messages)
+	var keyboard *tgbotapi.InlineKeyboardMarkup
+	if markup, ok := historyKeyboard(page, hasNext); ok {
+		keyboard = &markup
+	}
+	if err := b.sendMarkdown(message.Chat.ID, formatMessageList(page.title(), messages, prefs, links), keyboard); err != nil {
+		b.log(ctx).Error("Failed to send message list",
+			zap.Error(err))
+		b.sendErrorMessage(message.Chat.ID, prefs.t(errMsgGeneral))
+	}
+}
+
+// handleHistoryCallback replaces a /history message with the requested page
+func (b *Bot) handleHistoryCallback(ctx context.Context, query *tgbotapi.CallbackQuery, args string) string {
+	page, ok := parseHistoryPage(args)
+	if !ok || query.Message == nil {
+		return ""
+	}
+	// Anyone in a group can press the buttons, but only the author may page
+	if page.userID != query.From.ID {
+		return "Only the person who asked can page through these notes."
+	}
+
+	messages, hasNext, err := b.loadHistoryPage(ctx, page)
+	if err != nil {
+		return tr(ctx, errMsgRetrieval)
+	}
+	if len(messages) == 0 {
+		return "No more messages."
+	}
+
+	prefs := b.userDisplayPrefs(ctx, query.From.ID)
+	var keyboard *tgbotapi.InlineKeyboardMarkup
+	if markup, ok := historyKeyboard(page, hasNext); ok {
+		keyboard = &markup
+	}
+	// A page too long for one message continues in new ones, and the
+	// buttons move to the last of them
+	parts := splitMarkdown(formatMessageList(page.title(), messages, prefs, b.messageLinks(ctx, page.userID, messages)), maxMessageLength)
+	edit := tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID, parts[0])
+	edit.ParseMode = "MarkdownV2"
+	if len(parts) == 1 {
+		edit.ReplyMarkup = keyboard
+	}
+	if _, err := b.api.Send(edit); err != nil {
+		b.log(ctx).Error("Failed to edit message list",
+			zap.Error(err),
+			zap.Int("message_id", query.Message.MessageID))
+		return ""
+	}
+	if err := b.sendMarkdownParts(query.Message.Chat.ID, parts[1:], keyboard); err != nil {
+		b.log(ctx).Error("Failed to send the rest of the message list",
+			zap.Error(err))
+	}
+	return ""
+}
+
+// loadHistoryPage fetches the messages of page and reports whether more follow
+func (b *Bot) loadHistoryPage(ctx context.Context, page historyPage) ([]*models.Message, bool, error) {
+	// One extra message tells whether there is a next page
+	var (
+		messages []*models.Message
+		err      error
+	)
+	switch {
+	case page.archived:
+		messages, err = b.storage.GetArchivedMessages(ctx, page.userID, page.limit+1, page.offset)
+	case page.category != "":
+		messages, err = b.storage.GetUserMessagesByCategory(ctx, page.userID, page.category, page.limit+1, page.offset)
+	case page.pinnedFirst:
+		messages, err = b.storage.GetUserMessagesPinnedFirst(ctx, page.userID, page.limit+1, page.offset)
+	default:
+		messages, err = b.storage.GetUserMessages(ctx, page.userID, page.limit+1, page.offset)
+	}
+	if err != nil {
+		b.log(ctx).Error("Failed to get user messages",
+			zap.Error(err),
+			zap.String("category", page.category),
+			zap.Int("offset", page.offset))
+		return nil, false, err
+	}
+
+	if len(messages) > page.limit {
+		return messages[:page.limit], true, nil
+	}
+	return messages, false, nil
+}
+
+func (p historyPage) title() string {
+	title := "Your recent messages"
+	if p.archived {
+		title = "Your archived messages"
+	}
+	if p.category != "" {
+		title = "Messages with category #" + p.category
+	}
+	if p.offset > 0 {
+		title += fmt.Sprintf(" (from #%d)", p.offset+1)
+	}
+	return title + ":"
+}
+
+// historyKeyboard builds the Previous/Next buttons for page. ok is false when
+// there is nothing to page to or the callback data would be too long.
+func historyKeyboard(page historyPage, hasNext bool) (keyboard tgbotapi.InlineKeyboardMarkup, ok bool) {
+	var buttons []tgbotapi.InlineKeyboardButton
+	if page.offset > 0 {
+		prev := page
+		prev.offset = max(page.offset-page.limit, 0)
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData("◀️ Previous", prev.callbackData()))
+	}
+	if hasNext {
+		next := page
+		next.offset = page.offset + page.limit
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData("Next ▶️", next.callbackData()))
+	}
+
+	if len(buttons) == 0 {
+		return keyboard, false
+	}
+	for _, button := range buttons {
+		if len(*button.CallbackData) > maxCallbackDataLen {
+			return keyboard, false
+		}
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(buttons), true
+}
+
+func (b *Bot) handleCategoryFilter(ctx context.Context, message *tgbotapi.Message) {
+	category := storage.NormalizeLabel(message.CommandArguments())
+	if category == "" {
+		b.sendMessage(message.Chat.ID, "Please provide a category name.\nUsage: /category <category_name>")
+		return
+	}
+
+	b.sendFilteredMessages(ctx, message, "category", category, defaultHistoryLimit)
+}
+
+func (b *Bot) handleTagFilter(ctx context.Context, message *tgbotapi.Message) {
+	tag := storage.NormalizeLabel(message.CommandArguments())
+	if tag == "" {
+		b.sendMessage(message.Chat.ID, "Please provide a tag.\nUsage: /tag <tag_name>")
+		return
+	}
+
+	b.sendFilteredMessages(ctx, message, "tag", tag, defaultHistoryLimit)
+}
+
+// sendFilteredMessages lists the user's messages filed under a category or carrying a tag
+func (b *Bot) sendFilteredMessages(ctx context.Context, message *tgbotapi.Message, filter, value string, limit int) {
+	var (
+		messages []*models.Message
+		err      error
+	)
+	if filter == "tag" {
+		messages, err = b.storage.GetUserMessagesByTag(ctx, message.From.ID, value, limit, 0)
+	} else {
+		messages, err = b.storage.GetUserMessagesByCategory(ctx, message.From.ID, value, limit, 0)
+	}
+	if err != nil {
+		b.log(ctx).Error("Failed to get filtered user messages",
+			zap.Error(err),
+			zap.String("filter", filter),
+			zap.String("value", value))
+		b.sendStorageError(ctx, message.Chat.ID, err, tr(ctx, errMsgRetrieval))
+		return
+	}
+
+	if len(messages) == 0 {
+		b.sendMessage(message.Chat.ID, fmt.Sprintf("No messages found for %s #%s.", filter, value))
+		return
+	}
+
+	b.sendMessageList(message.Chat.ID, fmt.Sprintf("Messages with %s #%s:", filter, value), messages, b.userDisplayPrefs(ctx, message.From.ID))
+}
+
+func (b *Bot) sendMessageList(chatID int64, title string, messages []*models.Message, prefs displayPrefs) {
+	if err := b.sendMarkdown(chatID, formatMessageList(title, messages, prefs, nil), nil); err != nil {
+		b.logger.Error("Failed to send message list",
+			zap.Error(err),
+			zap.Int64("chat_id", chatID))
+		b.sendErrorMessage(chatID, prefs.t(errMsgGeneral))
+	}
+}
+
+// Marks media notes in message lists
+var contentTypeIcons = map[models.ContentType]string{
+	models.ImageContent:    "🖼",
+	models.DocumentContent: "📄",
+	models.VideoContent:    "🎬",
+}
+
+// formatMessageList renders messages for MarkdownV2. links maps message IDs to
+// the IDs of linked messages and may be nil.
+func formatMessageList(title string, messages []*models.Message, prefs displayPrefs, links map[string][]string) string {
+	var sb strings.Builder
+	sb.WriteString("*" + escapeMarkdown(title) + "*\n\n")
+
+	for _, m := range messages {
+		header := prefs.formatTime(m.CreatedAt)
+		if icon := contentTypeIcons[m.ContentType]; icon != "" {
+			header = icon + " " + header
+		}
+		if m.IsPinned {
+			header = "📌 " + header
+		}
+		if m.Category != "" {
+			header += " " + prefs.categoryLabel(m.Category)
+		}
+		sb.WriteString(escapeMarkdown(header) + "\n")
+
+		text := m.Summary
+		if text == "" {
+			text = truncateText(m.Content, historyPreviewLen)
+		}
+		if text != "" {
+			sb.WriteString(escapeMarkdown(text) + "\n")
+		}
+
+		if len(m.Tags) > 0 {
+			tags := make([]string, len(m.Tags))
+			for i, tag := range m.Tags {
+				tags[i] = formatLabel(tag)
+			}
+			sb.WriteString(escapeMarkdown(strings.Join(tags, " ")) + "\n")
+		}
+
+		if linked := links[m.ID]; len(linked) > 0 {
+			sb.WriteString("🔗 `" + strings.Join(linked, "` `") + "`\n")
+		}
+
+		sb.WriteString("`" + m.ID + "`\n\n")
+	}
+
+	return sb.String()
+}
+
+// formatLabel renders a category or tag as a hashtag. Labels that already
+// start with "#" are not prefixed twice.
+func formatLabel(label string) string {
+	return "#" + strings.ReplaceAll(strings.TrimPrefix(label, "#"), " ", "_")
+}
+
+func truncateText(text string, maxLen int) string {
+	runes := []rune(text)
+	if len(runes) <= maxLen {
+		return text
+	}
+	return string(runes[:maxLen]) + "…"
+}
@@ -0,0 +1,416 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.uber.org/zap"
+)
+
+const defaultLanguage = "en"
+
+// msgKey identifies a user-facing string in the message catalog
+type msgKey string
+
+const (
+	msgWelcome             msgKey = "welcome"
+	msgHelp                msgKey = "help"
+	msgHelpCommands        msgKey = "help.commands"
+	msgHelpUsage           msgKey = "help.usage"
+	msgUnknownCommand      msgKey = "unknown_command"
+	msgDidYouMean          msgKey = "unknown_command.suggest"
+	msgCommandCorrected    msgKey = "unknown_command.corrected"
+	msgNothingToClassify   msgKey = "nothing_to_classify"
+	msgNoteTooLong         msgKey = "note_too_long"
+	msgPreviewUsage        msgKey = "preview.usage"
+	msgPreviewHeader       msgKey = "preview.header"
+	msgAnalyzing           msgKey = "analyzing"
+	msgLabelCategory       msgKey = "label.category"
+	msgLabelTags           msgKey = "label.tags"
+	msgLabelSummary        msgKey = "label.summary"
+	msgLabelLinks          msgKey = "label.links"
+	msgLabelAttachments    msgKey = "label.attachments"
+	msgForwardedFrom       msgKey = "label.forwarded_from"
+	msgLanguageUsage       msgKey = "language.usage"
+	msgLanguageUnknown     msgKey = "language.unknown"
+	msgLanguageUpdated     msgKey = "language.updated"
+	msgLanguageFailed      msgKey = "language.failed"
+	msgReviewQuestion      msgKey = "review.question"
+	msgReviewConfirm       msgKey = "review.confirm"
+	msgReviewReject        msgKey = "review.reject"
+	msgReviewThanks        msgKey = "review.thanks"
+	msgReviewPrompt        msgKey = "review.prompt"
+	msgReviewNotYours      msgKey = "review.not_yours"
+	msgDuplicateQuestion   msgKey = "duplicate.question"
+	msgDuplicateSave       msgKey = "duplicate.save"
+	msgDuplicateSkip       msgKey = "duplicate.skip"
+	msgDuplicateSkipped    msgKey = "duplicate.skipped"
+	msgDuplicateNotYours   msgKey = "duplicate.not_yours"
+	msgForgetQuestion      msgKey = "forget.question"
+	msgForgetConfirm       msgKey = "forget.confirm"
+	msgForgetCancel        msgKey = "forget.cancel"
+	msgForgetDone          msgKey = "forget.done"
+	msgForgetCancelled     msgKey = "forget.cancelled"
+	msgForgetFailed        msgKey = "forget.failed"
+	msgBudgetExceeded      msgKey = "budget.exceeded"
+	msgBufferedNote        msgKey = "buffered.note"
+	msgBufferedSaved       msgKey = "buffered.saved"
+	msgDateFormatUsage     msgKey = "dateformat.usage"
+	msgDateFormatInvalid   msgKey = "dateformat.invalid"
+	msgDateFormatUpdated   msgKey = "dateformat.updated"
+	msgDateFormatFailed    msgKey = "dateformat.failed"
+	msgTimezoneCurrent     msgKey = "timezone.current"
+	msgTimezoneUnknown     msgKey = "timezone.unknown"
+	msgTimezoneUpdated     msgKey = "timezone.updated"
+	msgTimezoneFailed      msgKey = "timezone.failed"
+	msgTemperatureDefault  msgKey = "temperature.default"
+	msgTemperatureCurrent  msgKey = "temperature.current"
+	msgTemperatureInvalid  msgKey = "temperature.invalid"
+	msgTemperatureReset    msgKey = "temperature.reset"
+	msgTemperatureUpdated  msgKey = "temperature.updated"
+	msgTemperatureFailed   msgKey = "temperature.failed"
+	msgCategoryIconUsage   msgKey = "categoryicon.usage"
+	msgCategoryIconInvalid msgKey = "categoryicon.invalid"
+	msgCategoryIconRemoved msgKey = "categoryicon.removed"
+	msgCategoryIconUpdated msgKey = "categoryicon.updated"
+	msgCategoryIconFailed  msgKey = "categoryicon.failed"
+
+	errMsgGeneral         msgKey = "error.general"
+	errMsgSave            msgKey = "error.save"
+	errMsgTooLarge        msgKey = "error.too_large"
+	errMsgRetrieval       msgKey = "error.retrieval"
+	errMsgClassify        msgKey = "error.classify"
+	errMsgPermission      msgKey = "error.permission"
+	errMsgMessageNotFound msgKey = "error.message_not_found"
+	errMsgNotFound        msgKey = "error.not_found"
+	errMsgInvalidInput    msgKey = "error.invalid_input"
+	errMsgDuplicate       msgKey = "error.duplicate"
+	errMsgUnavailable     msgKey = "error.unavailable"
+	errMsgBufferedLost    msgKey = "error.buffered_lost"
+)
+
+// catalog holds the bot's strings by language. English is complete; other
+// languages may leave keys out, which then fall back to English. The help
+// texts are MarkdownV2, everything else is plain text. Command descriptions
+// live with the commands in registerCommands.
+var catalog = map[string]map[msgKey]string{
+	"en": {
+		msgWelcome: `Welcome to MemoBot! 📝
+I can help you organize your notes, images, and files with automatic classification.
+
+Just send me any message, photo, or document, and I'll:
+• Save it securely
+• Classify it automatically
+• Add relevant tags
+• Make it easily searchable
+
+Available commands:
+/help - Show all commands
+/tags - View your tags
+/categories - View your categories
+/history - View recent messages
+/language - Change the bot's language
+
+Send me something to get started!`,
+		msgHelp: `*I can process:*
+• Text messages
+• Photos with captions
+• Documents
+• Videos
+
+Each message will be automatically:
+• Classified into a category
+• Tagged with relevant keywords
+• Summarized for easy reference
+
+*Tips:*
+• Use hashtags in your messages for custom tags
+• Long press any message to forward it to me
+• Reply to my classification with corrections, e\.g\. category: finance \#budget
+
+Need help? Just send /help again\!`,
+		msgHelpCommands:        "*Available Commands:*",
+		msgHelpUsage:           "*Usage:*",
+		msgUnknownCommand:      "Unknown command. Use /help to see available commands.",
+		msgDidYouMean:          "Unknown command. Did you mean %s? Use /help to see available commands.",
+		msgCommandCorrected:    "Assuming you meant %s.",
+		msgNothingToClassify:   "I can't process this type of message yet. Send some text or add a caption to your media.",
+		msgPreviewUsage:        "Please provide the text to classify, or reply to a message.\nUsage: /preview <text>",
+		msgPreviewHeader:       "Preview: nothing was saved",
+		msgNoteTooLong:         "This note is too long for me to classify. Please shorten it to at most %d characters or split it into several notes.",
+		msgAnalyzing:           "🤔 Analyzing your message...",
+		msgLabelCategory:       "Category:",
+		msgLabelTags:           "Tags:",
+		msgLabelSummary:        "Summary:",
+		msgLabelLinks:          "Links found:",
+		msgLabelAttachments:    "Attachment notes:",
+		msgForwardedFrom:       "Forwarded from %s",
+		msgLanguageUsage:       "Please provide a language code.\nUsage: /language <code>\nAvailable: %s",
+		msgLanguageUnknown:     "Sorry, %q is not supported yet. Available: %s",
+		msgLanguageUpdated:     "Language set to English.",
+		msgLanguageFailed:      "Failed to update language. Please try again.",
+		msgReviewQuestion:      "I'm not sure about this one. Does this look right?",
+		msgReviewConfirm:       "✅ Looks right",
+		msgReviewReject:        "❌ Wrong",
+		msgReviewThanks:        "Thanks for confirming!",
+		msgReviewPrompt:        "Which category should it be? Reply with the category and, optionally, tags, e.g.\ncategory: finance #budget",
+		msgReviewNotYours:      "Only the author of this note can review it.",
+		msgDuplicateQuestion:   "You already saved this note on %s under %s. Save it again?",
+		msgDuplicateSave:       "💾 Save anyway",
+		msgDuplicateSkip:       "Skip",
+		msgDuplicateSkipped:    "Skipped, the note was not saved again.",
+		msgDuplicateNotYours:   "Only the sender of this note can answer.",
+		msgForgetQuestion:      "This permanently deletes all your notes, categories, tags and settings. Continue?",
+		msgForgetConfirm:       "🗑 Delete everything",
+		msgForgetCancel:        "Cancel",
+		msgForgetDone:          "All your data has been deleted. Send a message any time to start over.",
+		msgForgetCancelled:     "Cancelled, nothing was deleted.",
+		msgForgetFailed:        "Sorry, I couldn't delete your data. Please try again later.",
+		msgBudgetExceeded:      "You've reached today's limit for detailed analysis. Your notes are still saved, but classified more simply until the limit resets.",
+		msgBufferedNote:        "⏳ I can't reach my storage right now, so this note will be saved as soon as it's back.",
+		msgBufferedSaved:       "✅ Your note was saved now that my storage is back.",
+		msgDateFormatUsage:     "Please provide a date format.\nUsage: /dateformat <iso|us|eu|layout>\nLayouts use Go's reference time, e.g. /dateformat 02 Jan 2006 15:04",
+		msgDateFormatInvalid:   "%q is not a valid date format: %v.\nTry a preset (iso, us, eu) or a layout like: 02 Jan 2006 15:04",
+		msgDateFormatUpdated:   "Dates will now look like: %s",
+		msgDateFormatFailed:    "Failed to update date format. Please try again.",
+		msgTimezoneCurrent:     "Dates are shown in %s.\nUsage: /timezone <zone>, e.g. /timezone Europe/Berlin, or /timezone UTC",
+		msgTimezoneUnknown:     "%q is not a known time zone. Please use a name from the IANA database, e.g. Europe/Berlin or America/New_York.",
+		msgTimezoneUpdated:     "Dates will now be shown in %s. It is %s there now.",
+		msgTimezoneFailed:      "Failed to update timezone. Please try again.",
+		msgTemperatureDefault:  "Your notes are classified with the default temperature.\nUsage: /temperature <0.0-2.0>; lower values tag more consistently, higher ones more creatively",
+		msgTemperatureCurrent:  "Your notes are classified with temperature %g.\nSend /temperature default to go back to the default.",
+		msgTemperatureInvalid:  "Please provide a temperature between 0.0 and 2.0, e.g. /temperature 0.3, or /temperature default.",
+		msgTemperatureReset:    "Your notes will be classified with the default temperature.",
+		msgTemperatureUpdated:  "Your notes will be classified with temperature %g.",
+		msgTemperatureFailed:   "Failed to update temperature. Please try again.",
+		msgCategoryIconUsage:   "Please provide a category and an emoji.\nUsage: /categoryicon <category_name> <emoji>\nLeave out the emoji to remove the icon.",
+		msgCategoryIconInvalid: "Please use a single emoji as the icon, e.g. /categoryicon work 💼",
+		msgCategoryIconRemoved: "Removed icon for %s",
+		msgCategoryIconUpdated: "Category %s will now be shown as %s %s",
+		msgCategoryIconFailed:  "Failed to update category icon. Please try again.",
+
+		errMsgGeneral:         "Sorry, something went wrong. Please try again later.",
+		errMsgSave:            "Sorry, I couldn't save your message. Please try again.",
+		errMsgTooLarge:        "This note is too large to save. Please shorten it and try again.",
+		errMsgRetrieval:       "Sorry, I couldn't retrieve the information. Please try again later.",
+		errMsgClassify:        "Sorry, I had trouble analyzing your message. Please try again.",
+		errMsgPermission:      "Sorry, you don't have permission to do that.",
+		errMsgMessageNotFound: "Message not found. Use /history to see your message IDs.",
+		errMsgNotFound:        "I couldn't find what you asked for. It may have been deleted already.",
+		errMsgInvalidInput:    "That doesn't look right. Please check the command and try again.",
+		errMsgDuplicate:       "That already exists, so nothing was changed.",
+		errMsgUnavailable:     "I can't reach my storage right now. Please try again in a few minutes.",
+		errMsgBufferedLost:    "Sorry, a note you sent while my storage was unavailable couldn't be saved. Please send it again.",
+	},
+	"ru": {
+		msgWelcome: `Добро пожаловать в MemoBot! 📝
+Я помогу упорядочить ваши заметки, изображения и файлы с помощью автоматической классификации.
+
+Просто отправьте мне сообщение, фото или документ, и я:
+• Надёжно сохраню его
+• Автоматически определю категорию
+• Добавлю подходящие теги
+• Сделаю так, чтобы его было легко найти
+
+Доступные команды:
+/help - Показать все команды
+/tags - Ваши теги
+/categories - Ваши категории
+/history - Последние сообщения
+/language - Сменить язык бота
+
+Отправьте что-нибудь, чтобы начать!`,
+		msgHelp: `*Я умею обрабатывать:*
+• Текстовые сообщения
+• Фото с подписями
+• Документы
+• Видео
+
+Каждое сообщение будет автоматически:
+• Отнесено к категории
+• Помечено подходящими тегами
+• Кратко пересказано
+
+*Советы:*
+• Используйте хештеги в сообщениях, чтобы задать свои теги
+• Пересылайте мне любые сообщения
+• Ответьте на мою классификацию исправлением, например: category: finance \#budget
+
+Нужна помощь? Просто отправьте /help ещё раз\!`,
+		msgHelpCommands:        "*Доступные команды:*",
+		msgHelpUsage:           "*Использование:*",
+		msgUnknownCommand:      "Неизвестная команда. Отправьте /help, чтобы увидеть список команд.",
+		msgDidYouMean:          "Неизвестная команда. Возможно, вы имели в виду %s? Отправьте /help, чтобы увидеть список команд.",
+		msgCommandCorrected:    "Похоже, вы имели в виду %s.",
+		msgNothingToClassify:   "Я пока не умею обрабатывать такие сообщения. Отправьте текст или добавьте подпись к медиа.",
+		msgPreviewUsage:        "Укажите текст для классификации или ответьте на сообщение.\nИспользование: /preview <текст>",
+		msgPreviewHeader:       "Предпросмотр: ничего не сохранено",
+		msgNoteTooLong:         "Эта заметка слишком длинная. Сократите её до %d символов или разбейте на несколько заметок.",
+		msgAnalyzing:           "🤔 Анализирую сообщение...",
+		msgLabelCategory:       "Категория:",
+		msgLabelTags:           "Теги:",
+		msgLabelSummary:        "Кратко:",
+		msgLabelLinks:          "Ссылки:",
+		msgLabelAttachments:    "О вложении:",
+		msgForwardedFrom:       "Переслано из %s",
+		msgLanguageUsage:       "Укажите код языка.\nИспользование: /language <код>\nДоступны: %s",
+		msgLanguageUnknown:     "Извините, язык %q пока не поддерживается. Доступны: %s",
+		msgLanguageUpdated:     "Язык изменён на русский.",
+		msgLanguageFailed:      "Не удалось сменить язык. Попробуйте ещё раз.",
+		msgReviewQuestion:      "Я не уверен в этой классификации. Всё верно?",
+		msgReviewConfirm:       "✅ Верно",
+		msgReviewReject:        "❌ Неверно",
+		msgReviewThanks:        "Спасибо за подтверждение!",
+		msgReviewPrompt:        "Какая категория подойдёт? Ответьте категорией и, если нужно, тегами, например:\ncategory: finance #budget",
+		msgReviewNotYours:      "Проверить заметку может только её автор.",
+		msgDuplicateQuestion:   "Эта заметка уже сохранена %s в категории %s. Сохранить ещё раз?",
+		msgDuplicateSave:       "💾 Всё равно сохранить",
+		msgDuplicateSkip:       "Пропустить",
+		msgDuplicateSkipped:    "Пропущено, заметка не сохранена повторно.",
+		msgDuplicateNotYours:   "Ответить может только отправитель заметки.",
+		msgForgetQuestion:      "Все ваши заметки, категории, теги и настройки будут удалены безвозвратно. Продолжить?",
+		msgForgetConfirm:       "🗑 Удалить всё",
+		msgForgetCancel:        "Отмена",
+		msgForgetDone:          "Все ваши данные удалены. Отправьте сообщение, чтобы начать заново.",
+		msgForgetCancelled:     "Отменено, ничего не удалено.",
+		msgForgetFailed:        "Не удалось удалить ваши данные. Попробуйте позже.",
+		msgBudgetExceeded:      "Вы исчерпали дневной лимит подробного анализа. Заметки по-прежнему сохраняются, но классифицируются упрощённо, пока лимит не обновится.",
+		msgBufferedNote:        "⏳ Хранилище сейчас недоступно, заметка будет сохранена, как только оно вернётся.",
+		msgBufferedSaved:       "✅ Заметка сохранена: хранилище снова доступно.",
+		msgDateFormatUsage:     "Укажите формат даты.\nИспользование: /dateformat <iso|us|eu|шаблон>\nШаблоны строятся по эталонному времени Go, например /dateformat 02 Jan 2006 15:04",
+		msgDateFormatInvalid:   "%q не подходит как формат даты: %v.\nВыберите готовый формат (iso, us, eu) или шаблон вроде: 02 Jan 2006 15:04",
+		msgDateFormatUpdated:   "Теперь даты выглядят так: %s",
+		msgDateFormatFailed:    "Не удалось изменить формат даты. Попробуйте ещё раз.",
+		msgTimezoneCurrent:     "Даты показываются в часовом поясе %s.\nИспользование: /timezone <пояс>, например /timezone Europe/Moscow или /timezone UTC",
+		msgTimezoneUnknown:     "Часовой пояс %q не найден. Укажите название из базы IANA, например Europe/Moscow или America/New_York.",
+		msgTimezoneUpdated:     "Теперь даты показываются в часовом поясе %s. Там сейчас %s.",
+		msgTimezoneFailed:      "Не удалось изменить часовой пояс. Попробуйте ещё раз.",
+		msgTemperatureDefault:  "Ваши заметки классифицируются с температурой по умолчанию.\nИспользование: /temperature <0.0-2.0>; чем ниже значение, тем единообразнее теги, чем выше — тем разнообразнее",
+		msgTemperatureCurrent:  "Ваши заметки классифицируются с температурой %g.\nОтправьте /temperature default, чтобы вернуть значение по умолчанию.",
+		msgTemperatureInvalid:  "Укажите температуру от 0.0 до 2.0, например /temperature 0.3, или /temperature default.",
+		msgTemperatureReset:    "Ваши заметки будут классифицироваться с температурой по умолчанию.",
+		msgTemperatureUpdated:  "Ваши заметки будут классифицироваться с температурой %g.",
+		msgTemperatureFailed:   "Не удалось изменить температуру. Попробуйте ещё раз.",
+		msgCategoryIconUsage:   "Укажите категорию и эмодзи.\nИспользование: /categoryicon <категория> <эмодзи>\nБез эмодзи значок будет удалён.",
+		msgCategoryIconInvalid: "Значком может быть только один эмодзи, например /categoryicon work 💼",
+		msgCategoryIconRemoved: "Значок категории %s удалён",
+		msgCategoryIconUpdated: "Категория %s теперь показывается как %s %s",
+		msgCategoryIconFailed:  "Не удалось изменить значок категории. Попробуйте ещё раз.",
+
+		errMsgGeneral:         "Извините, что-то пошло не так. Попробуйте позже.",
+		errMsgSave:            "Извините, не удалось сохранить сообщение. Попробуйте ещё раз.",
+		errMsgTooLarge:        "Заметка слишком большая. Сократите её и попробуйте ещё раз.",
+		errMsgRetrieval:       "Извините, не удалось получить данные. Попробуйте позже.",
+		errMsgClassify:        "Извините, не удалось проанализировать сообщение. Попробуйте ещё раз.",
+		errMsgPermission:      "Извините, у вас нет прав на это действие.",
+		errMsgMessageNotFound: "Сообщение не найдено. Отправьте /history, чтобы увидеть ID сообщений.",
+		errMsgNotFound:        "Не удалось найти то, что вы запросили. Возможно, оно уже удалено.",
+		errMsgInvalidInput:    "Что-то не так с запросом. Проверьте команду и попробуйте ещё раз.",
+		errMsgDuplicate:       "Это уже существует, ничего не изменено.",
+		errMsgUnavailable:     "Хранилище сейчас недоступно. Попробуйте через несколько минут.",
+		errMsgBufferedLost:    "Извините, заметку, отправленную во время недоступности хранилища, сохранить не удалось. Отправьте её ещё раз.",
+	},
+}
+
+type languageKey struct{}
+
+// withLanguage returns a context carrying the language replies should use
+func withLanguage(ctx context.Context, lang string) context.Context {
+	return context.WithValue(ctx, languageKey{}, lang)
+}
+
+// languageFrom returns the language stored by withLanguage, or the default
+func languageFrom(ctx context.Context) string {
+	if lang, ok := ctx.Value(languageKey{}).(string); ok && lang != "" {
+		return lang
+	}
+	return defaultLanguage
+}
+
+// translate looks key up in lang, falling back to English, and formats it
+// with args if any are given
+func translate(lang string, key msgKey, args ...any) string {
+	text, ok := catalog[lang][key]
+	if !ok {
+		text = catalog[defaultLanguage][key]
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(text, args...)
+	}
+	return text
+}
+
+// tr translates key into the language of the user being served
+func tr(ctx context.Context, key msgKey, args ...any) string {
+	return translate(languageFrom(ctx), key, args...)
+}
+
+// supportedLanguage maps a code such as "ru" or "pt-BR" onto a catalog
+// language, returning "" when there is none
+func supportedLanguage(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	code, _, _ = strings.Cut(code, "-")
+	if _, ok := catalog[code]; ok {
+		return code
+	}
+	return ""
+}
+
+func supportedLanguages() []string {
+	languages := make([]string, 0, len(catalog))
+	for lang := range catalog {
+		languages = append(languages, lang)
+	}
+	sort.Strings(languages)
+	return languages
+}
+
+// userLanguage picks the language for replies to from: their saved choice,
+// else their Telegram client language if we have it, else English
+func (b *Bot) userLanguage(ctx context.Context, from *tgbotapi.User) string {
+	if from == nil {
+		return defaultLanguage
+	}
+
+	user, err := b.storage.GetUser(ctx, from.ID)
+	if err != nil {
+		b.log(ctx).Warn("Failed to load user language",
+			zap.Error(err))
+	} else if lang := supportedLanguage(user.Language); lang != "" {
+		return lang
+	}
+
+	if lang := supportedLanguage(from.LanguageCode); lang != "" {
+		return lang
+	}
+	return defaultLanguage
+}
+
+func (b *Bot) handleLanguage(ctx context.Context, message *tgbotapi.Message) {
+	available := strings.Join(supportedLanguages(), ", ")
+
+	arg := strings.TrimSpace(message.CommandArguments())
+	if arg == "" {
+		b.sendMessage(message.Chat.ID, tr(ctx, msgLanguageUsage, available))
+		return
+	}
+
+	lang := supportedLanguage(arg)
+	if lang == "" {
+		b.sendMessage(message.Chat.ID, tr(ctx, msgLanguageUnknown, arg, available))
+		return
+	}
+
+	if err := b.storage.UpdateUserLanguage(ctx, message.From.ID, lang); err != nil {
+		b.log(ctx).Error("Failed to update language",
+			zap.Error(err),
+			zap.String("language", lang))
+		b.sendStorageError(ctx, message.Chat.ID, err, tr(ctx, msgLanguageFailed))
+		return
+	}
+
+	b.sendMessage(message.Chat.ID, translate(lang, msgLanguageUpdated))
+}
@@ -0,0 +1,205 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/google/uuid"
+	"github.com/xaenox/memo-bot/internal/models"
+	"github.com/xaenox/memo-bot/internal/storage"
+	"go.uber.org/zap"
+)
+
+const maxImportNotes = 500
+
+// Notes in a plain text import are separated by blank lines
+var importSeparator = regexp.MustCompile(`\n\s*\n`)
+
+// handleImport saves notes from an attached file; the command is admin-only
+func (b *Bot) handleImport(ctx context.Context, message *tgbotapi.Message) {
+	doc := message.Document
+	if doc == nil && message.ReplyToMessage != nil {
+		doc = message.ReplyToMessage.Document
+	}
+	if doc == nil {
+		b.sendMessage(message.Chat.ID, "Please attach the notes to import.\n"+
+			"Usage: reply to a .txt file (notes separated by blank lines) or a .json file "+
+			`(["note", ...] or [{"content": "note"}, ...]) with /import`)
+		return
+	}
+
+	data, err := b.downloadFile(doc.FileID, maxDownloadSize)
+	if err != nil {
+		b.log(ctx).Error("Failed to download import file",
+			zap.Error(err))
+		b.sendErrorMessage(message.Chat.ID, "Failed to download the import file. Please try again.")
+		return
+	}
+
+	parsed, err := parseImport(data)
+	if err != nil {
+		b.sendMessage(message.Chat.ID, fmt.Sprintf("Invalid import file: %v", err))
+		return
+	}
+
+	contents := make([]string, 0, len(parsed))
+	for _, content := range parsed {
+		if !b.noteTooLong(content) {
+			contents = append(contents, content)
+		}
+	}
+	if len(contents) == 0 {
+		b.sendMessage(message.Chat.ID, fmt.Sprintf("All notes are longer than %d characters. Please shorten them and try again.", b.maxNoteChars))
+		return
+	}
+
+	b.sendMessage(message.Chat.ID, fmt.Sprintf("⏳ Importing %d notes, this may take a while...", len(contents)))
+
+	responses, err := b.classifier.ClassifyBatch(ctx, contents, message.From.ID)
+	if err != nil {
+		b.log(ctx).Warn("Some imported notes could not be classified",
+			zap.Error(err))
+	}
+
+	var existing, allowed []string
+	maxTags := storage.DefaultMaxTags
+	if user, err := b.storage.GetUser(ctx, message.From.ID); err != nil {
+		b.log(ctx).Warn("Failed to get categories for matching",
+			zap.Error(err))
+	} else {
+		existing = append([]string(nil), user.Categories...)
+		allowed = user.AllowedCategories
+		if user.MaxTags > 0 {
+			maxTags = user.MaxTags
+		}
+	}
+
+	now := time.Now()
+	notes := make([]*models.Message, 0, len(contents))
+	categories := make(map[string]bool)
+	tags := make(map[string]bool)
+	for i, response := range responses {
+		if response.Category == "" {
+			continue
+		}
+		response.Category = b.matchCategoryIn(ctx, response.Category, existing, allowed)
+		if !categories[response.Category] {
+			existing = append(existing, response.Category)
+		}
+		// Tag imported notes like the ones sent in a chat
+		response.Keywords = b.withCategoryTags(ctx, message.From.ID, response.Category, response.Keywords)
+		if len(response.Keywords) > maxTags {
+			response.Keywords = response.Keywords[:maxTags]
+		}
+		notes = append(notes, &models.Message{
+			ID:          uuid.New().String(),
+			UserID:      message.From.ID,
+			Content:     contents[i],
+			Category:    response.Category,
+			Tags:        response.Keywords,
+			Summary:     response.Summary,
+			Links:       response.Links,
+			ContentType: models.TextContent,
+			// Keep the file order when notes are listed newest first
+			CreatedAt: now.Add(time.Duration(i) * time.Millisecond),
+		})
+		categories[response.Category] = true
+		for _, tag := range response.Keywords {
+			tags[tag] = true
+		}
+	}
+
+	if err := b.storage.SaveMessages(ctx, notes); err != nil {
+		if errors.Is(err, storage.ErrInvalidInput) {
+			b.sendMessage(message.Chat.ID, fmt.Sprintf("Nothing was imported: %v", err))
+			return
+		}
+		b.log(ctx).Error("Failed to save imported notes",
+			zap.Error(err),
+			zap.Int("notes", len(notes)))
+		b.sendStorageError(ctx, message.Chat.ID, err, "Failed to save the imported notes. Nothing was imported, please try again.")
+		return
+	}
+
+	for category := range categories {
+		if err := b.storage.AddCategory(ctx, message.From.ID, category); err != nil {
+			b.log(ctx).Error("Failed to save category",
+				zap.Error(err),
+				zap.String("category", category))
+		}
+	}
+	for tag := range tags {
+		if err := b.storage.AddTag(ctx, message.From.ID, tag); err != nil {
+			b.log(ctx).Error("Failed to save tag",
+				zap.Error(err),
+				zap.String("tag", tag))
+		}
+	}
+
+	text := fmt.Sprintf("Imported %d of %d notes.", len(notes), len(parsed))
+	if skipped := len(contents) - len(notes); skipped > 0 {
+		text += fmt.Sprintf(" %d could not be classified and were skipped.", skipped)
+	}
+	if tooLong := len(parsed) - len(contents); tooLong > 0 {
+		text += fmt.Sprintf(" %d were longer than %d characters and were skipped.", tooLong, b.maxNoteChars)
+	}
+	b.sendMessage(message.Chat.ID, text)
+}
+
+// parseImport reads notes from a JSON array of strings or {"content": ...}
+// objects, or from plain text with notes separated by blank lines
+func parseImport(data []byte) ([]string, error) {
+	data = bytes.TrimSpace(data)
+
+	var contents []string
+	if bytes.HasPrefix(data, []byte("[")) {
+		var err error
+		if contents, err = parseImportJSON(data); err != nil {
+			return nil, err
+		}
+	} else {
+		text := strings.ReplaceAll(string(data), "\r\n", "\n")
+		contents = importSeparator.Split(text, -1)
+	}
+
+	notes := make([]string, 0, len(contents))
+	for _, content := range contents {
+		if content = strings.TrimSpace(content); content != "" {
+			notes = append(notes, content)
+		}
+	}
+
+	if len(notes) == 0 {
+		return nil, errors.New("no notes found")
+	}
+	if len(notes) > maxImportNotes {
+		return nil, fmt.Errorf("too many notes (max %d per import)", maxImportNotes)
+	}
+	return notes, nil
+}
+
+func parseImportJSON(data []byte) ([]string, error) {
+	var contents []string
+	if err := json.Unmarshal(data, &contents); err == nil {
+		return contents, nil
+	}
+
+	var items []struct {
+		Content string `json:"content"`
+	}
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, errors.New("expected a JSON array of strings or of objects with a content field")
+	}
+	contents = make([]string, len(items))
+	for i, item := range items {
+		contents[i] = item.Content
+	}
+	return contents, nil
+}
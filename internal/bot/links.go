@@ -0,0 +1,85 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/xaenox/memo-bot/internal/models"
+	"github.com/xaenox/memo-bot/internal/storage"
+	"go.uber.org/zap"
+)
+
+// handleLink connects two notes, or lists the notes linked to one
+func (b *Bot) handleLink(ctx context.Context, message *tgbotapi.Message) {
+	args := strings.Fields(message.CommandArguments())
+	switch len(args) {
+	case 1:
+		b.sendLinkedMessages(ctx, message, args[0])
+		return
+	case 2:
+	default:
+		b.sendMessage(message.Chat.ID, "Please provide the IDs of two notes.\nUsage: /link <message_id> <message_id>\nSend /link <message_id> to see the notes linked to one.")
+		return
+	}
+
+	err := b.storage.LinkMessages(ctx, message.From.ID, args[0], args[1])
+	switch {
+	case err == nil:
+		b.sendMessage(message.Chat.ID, "🔗 Notes linked. /history shows the links under each note.")
+	case errors.Is(err, storage.ErrNotFound):
+		b.sendMessage(message.Chat.ID, tr(ctx, errMsgMessageNotFound))
+	case errors.Is(err, storage.ErrInvalidInput):
+		b.sendMessage(message.Chat.ID, "A note can't be linked to itself.")
+	case errors.Is(err, storage.ErrAlreadyExists):
+		b.sendMessage(message.Chat.ID, "These notes are already linked.")
+	default:
+		b.log(ctx).Error("Failed to link messages",
+			zap.Error(err),
+			zap.String("from_id", args[0]),
+			zap.String("to_id", args[1]))
+		b.sendStorageError(ctx, message.Chat.ID, err, "Failed to link the notes. Please try again.")
+	}
+}
+
+func (b *Bot) sendLinkedMessages(ctx context.Context, message *tgbotapi.Message, id string) {
+	if _, ok := b.getOwnedMessage(ctx, message, id); !ok {
+		return
+	}
+
+	linked, err := b.storage.GetLinkedMessages(ctx, message.From.ID, id)
+	if err != nil {
+		b.log(ctx).Error("Failed to get linked messages",
+			zap.Error(err),
+			zap.String("message_id", id))
+		b.sendStorageError(ctx, message.Chat.ID, err, tr(ctx, errMsgRetrieval))
+		return
+	}
+	if len(linked) == 0 {
+		b.sendMessage(message.Chat.ID, fmt.Sprintf("No notes are linked to %s yet.\nUsage: /link %s <message_id>", id, id))
+		return
+	}
+
+	b.sendMessageList(message.Chat.ID, "Notes linked to "+id, linked, b.userDisplayPrefs(ctx, message.From.ID))
+}
+
+// messageLinks looks up the notes linked to each of messages. Failures only
+// leave the links out.
+func (b *Bot) messageLinks(ctx context.Context, userID int64, messages []*models.Message) map[string][]string {
+	links := make(map[string][]string)
+	for _, m := range messages {
+		linked, err := b.storage.GetLinkedMessages(ctx, userID, m.ID)
+		if err != nil {
+			b.log(ctx).Warn("Failed to get linked messages",
+				zap.Error(err),
+				zap.String("message_id", m.ID))
+			continue
+		}
+		for _, l := range linked {
+			links[m.ID] = append(links[m.ID], l.ID)
+		}
+	}
+	return links
+}
@@ -0,0 +1,171 @@
+package bot
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Every character MarkdownV2 treats as markup. Escaping in a single pass
+// means the backslashes we add are never escaped a second time.
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	"_", `\_`, "*", `\*`, "[", `\[`, "]", `\]`, "(", `\(`, ")", `\)`,
+	"~", `\~`, "`", "\\`", ">", `\>`, "#", `\#`, "+", `\+`, "-", `\-`,
+	"=", `\=`, "|", `\|`, "{", `\{`, "}", `\}`, ".", `\.`, "!", `\!`,
+)
+
+// Inside code spans and link URLs only these need escaping
+var (
+	codeEscaper = strings.NewReplacer(`\`, `\\`, "`", "\\`")
+	urlEscaper  = strings.NewReplacer(`\`, `\\`, ")", `\)`)
+)
+
+// Inline code spans, markdown links and bare URLs in free text
+var inlineMarkup = regexp.MustCompile("`[^`\n]+`" + `|\[[^\]\n]+\]\(https?://[^)\s]+\)|https?://[^\s<>]+`)
+
+func escapeMarkdown(text string) string {
+	return markdownEscaper.Replace(text)
+}
+
+// renderMarkdown escapes free text such as assistant summaries for MarkdownV2
+// while keeping its code spans and links working
+func renderMarkdown(text string) string {
+	var sb strings.Builder
+	last := 0
+	for _, loc := range inlineMarkup.FindAllStringIndex(text, -1) {
+		sb.WriteString(escapeMarkdown(text[last:loc[0]]))
+		sb.WriteString(renderInline(text[loc[0]:loc[1]]))
+		last = loc[1]
+	}
+	sb.WriteString(escapeMarkdown(text[last:]))
+	return sb.String()
+}
+
+func renderInline(token string) string {
+	switch {
+	case strings.HasPrefix(token, "`"):
+		return "`" + codeEscaper.Replace(token[1:len(token)-1]) + "`"
+	case strings.HasPrefix(token, "["):
+		split := strings.Index(token, "](")
+		return markdownLink(token[1:split], token[split+2:len(token)-1])
+	}
+
+	// Sentence punctuation right after a bare URL is not part of it
+	url := strings.TrimRight(token, ".,;:!?)")
+	return markdownLink(url, url) + escapeMarkdown(token[len(url):])
+}
+
+// renderLink makes a clickable link out of an http(s) URL and escapes
+// anything else as plain text
+func renderLink(link string) string {
+	if strings.HasPrefix(link, "http://") || strings.HasPrefix(link, "https://") {
+		return markdownLink(link, link)
+	}
+	return escapeMarkdown(link)
+}
+
+func markdownLink(text, url string) string {
+	return "[" + escapeMarkdown(text) + "](" + urlEscaper.Replace(url) + ")"
+}
+
+// maxMessageLength is the most characters Telegram accepts in one message.
+// Telegram counts UTF-16 code units, so emoji take two.
+const maxMessageLength = 4096
+
+// splitMarkdown breaks MarkdownV2 text into parts of at most limit UTF-16
+// code units, splitting between lines where it can. A line too long on its
+// own is split at a space, or anywhere if it has none, but never between a
+// backslash and the character it escapes. Formatting is expected to close on
+// the line that opens it, as in the lists the bot sends.
+func splitMarkdown(text string, limit int) []string {
+	if utf16Len(text) <= limit {
+		return []string{text}
+	}
+
+	var (
+		parts   []string
+		current strings.Builder
+		size    int
+	)
+	flush := func() {
+		if part := strings.TrimRight(current.String(), "\n"); part != "" {
+			parts = append(parts, part)
+		}
+		current.Reset()
+		size = 0
+	}
+
+	for _, line := range strings.SplitAfter(text, "\n") {
+		lineSize := utf16Len(line)
+		if size+lineSize > limit {
+			flush()
+		}
+		for lineSize > limit {
+			head := splitLine(line, limit)
+			parts = append(parts, head)
+			line = line[len(head):]
+			lineSize = utf16Len(line)
+		}
+		current.WriteString(line)
+		size += lineSize
+	}
+	flush()
+	return parts
+}
+
+// splitLine returns the longest start of line that fits in limit UTF-16 code
+// units and can be cut off safely
+func splitLine(line string, limit int) string {
+	end, size, lastSpace := 0, 0, -1
+	for i, r := range line {
+		size += utf16Units(r)
+		if size > limit {
+			break
+		}
+		end = i + utf8.RuneLen(r)
+		if r == ' ' && !escapedAt(line, i) {
+			lastSpace = end
+		}
+	}
+	if lastSpace > 0 {
+		return line[:lastSpace]
+	}
+	if escapedAt(line, end) {
+		// Keep the backslash with the character it escapes
+		end--
+	}
+	if end == 0 {
+		_, n := utf8.DecodeRuneInString(line)
+		end = n
+	}
+	return line[:end]
+}
+
+// escapedAt reports whether the character at byte offset i of text is
+// escaped, that is preceded by an odd number of backslashes
+func escapedAt(text string, i int) bool {
+	backslashes := 0
+	for i > 0 && text[i-1] == '\\' {
+		backslashes++
+		i--
+	}
+	return backslashes%2 == 1
+}
+
+func utf16Len(text string) int {
+	n := 0
+	for _, r := range text {
+		n += utf16Units(r)
+	}
+	return n
+}
+
+// utf16Units is how many UTF-16 code units encode r: two for characters
+// outside the Basic Multilingual Plane such as most emoji, else one
+func utf16Units(r rune) int {
+	if r > 0xFFFF {
+		return 2
+	}
+	return 1
+}
@@ -0,0 +1,70 @@
+package bot
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitMarkdown(t *testing.T) {
+	const limit = 10
+
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{
+			name: "exactly the limit",
+			text: "0123456789",
+			want: []string{"0123456789"},
+		},
+		{
+			name: "lines exactly the limit",
+			text: "01234\n6789",
+			want: []string{"01234\n6789"},
+		},
+		{
+			name: "lines one above the limit",
+			text: "01234\n67890",
+			want: []string{"01234", "67890"},
+		},
+		{
+			name: "line one above the limit",
+			text: "01234567890",
+			want: []string{"0123456789", "0"},
+		},
+		{
+			name: "line split at a space",
+			text: "01234 6789x",
+			want: []string{"01234 ", "6789x"},
+		},
+		{
+			name: "escape kept together",
+			text: `012345678\.`,
+			want: []string{"012345678", `\.`},
+		},
+		{
+			name: "emoji exactly the limit",
+			text: "😀😀😀😀😀",
+			want: []string{"😀😀😀😀😀"},
+		},
+		{
+			name: "emoji one above the limit",
+			text: "😀😀😀😀😀a",
+			want: []string{"😀😀😀😀😀", "a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitMarkdown(tt.text, limit)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitMarkdown(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+			for _, part := range got {
+				if utf16Len(part) > limit {
+					t.Errorf("part %q is %d UTF-16 units, over the limit of %d", part, utf16Len(part), limit)
+				}
+			}
+		})
+	}
+}
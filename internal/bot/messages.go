@@ -0,0 +1,253 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/xaenox/memo-bot/internal/models"
+	"github.com/xaenox/memo-bot/internal/storage"
+	"go.uber.org/zap"
+)
+
+const dedupePreviewLen = 60
+
+func (b *Bot) handleDelete(ctx context.Context, message *tgbotapi.Message) {
+	id := strings.TrimSpace(message.CommandArguments())
+	if id == "" {
+		b.sendMessage(message.Chat.ID, "Please provide a message ID.\nUsage: /delete <message_id>")
+		return
+	}
+
+	if _, ok := b.getOwnedMessage(ctx, message, id); !ok {
+		return
+	}
+
+	if err := b.storage.DeleteMessage(ctx, id); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			b.sendMessage(message.Chat.ID, tr(ctx, errMsgMessageNotFound))
+			return
+		}
+		b.log(ctx).Error("Failed to delete message",
+			zap.Error(err),
+			zap.String("message_id", id))
+		b.sendStorageError(ctx, message.Chat.ID, err, "Failed to delete message. Please try again.")
+		return
+	}
+
+	b.sendMessage(message.Chat.ID, "🗑 Message deleted.")
+}
+
+func (b *Bot) handleArchive(ctx context.Context, message *tgbotapi.Message) {
+	b.setMessageArchived(ctx, message, true)
+}
+
+func (b *Bot) handleUnarchive(ctx context.Context, message *tgbotapi.Message) {
+	b.setMessageArchived(ctx, message, false)
+}
+
+func (b *Bot) setMessageArchived(ctx context.Context, message *tgbotapi.Message, archived bool) {
+	command := "/" + message.Command()
+	id := strings.TrimSpace(message.CommandArguments())
+	if id == "" {
+		b.sendMessage(message.Chat.ID, fmt.Sprintf("Please provide a message ID.\nUsage: %s <message_id>", command))
+		return
+	}
+
+	if _, ok := b.getOwnedMessage(ctx, message, id); !ok {
+		return
+	}
+
+	update := b.storage.UnarchiveMessage
+	if archived {
+		update = b.storage.ArchiveMessage
+	}
+	if err := update(ctx, id); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			b.sendMessage(message.Chat.ID, tr(ctx, errMsgMessageNotFound))
+			return
+		}
+		b.log(ctx).Error("Failed to update message archive state",
+			zap.Error(err),
+			zap.String("message_id", id),
+			zap.Bool("archived", archived))
+		b.sendStorageError(ctx, message.Chat.ID, err, "Failed to update the message. Please try again.")
+		return
+	}
+
+	if archived {
+		b.sendMessage(message.Chat.ID, "🗄 Message archived. See it with /history --archived and restore it with /unarchive "+id)
+		return
+	}
+	b.sendMessage(message.Chat.ID, "📤 Message restored.")
+}
+
+func (b *Bot) handlePin(ctx context.Context, message *tgbotapi.Message) {
+	b.setMessagePinned(ctx, message, true)
+}
+
+func (b *Bot) handleUnpin(ctx context.Context, message *tgbotapi.Message) {
+	b.setMessagePinned(ctx, message, false)
+}
+
+func (b *Bot) setMessagePinned(ctx context.Context, message *tgbotapi.Message, pinned bool) {
+	command := "/" + message.Command()
+	id := strings.TrimSpace(message.CommandArguments())
+	if id == "" {
+		b.sendMessage(message.Chat.ID, fmt.Sprintf("Please provide a message ID.\nUsage: %s <message_id>", command))
+		return
+	}
+
+	// The storage checks ownership, so IDs of other users read as not found
+	if err := b.storage.SetMessagePinned(ctx, message.From.ID, id, pinned); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			b.sendMessage(message.Chat.ID, tr(ctx, errMsgMessageNotFound))
+			return
+		}
+		b.log(ctx).Error("Failed to update message pin state",
+			zap.Error(err),
+			zap.String("message_id", id),
+			zap.Bool("pinned", pinned))
+		b.sendStorageError(ctx, message.Chat.ID, err, "Failed to update the message. Please try again.")
+		return
+	}
+
+	if pinned {
+		b.sendMessage(message.Chat.ID, "📌 Message pinned. See your pinned messages with /pinned.")
+		return
+	}
+	b.sendMessage(message.Chat.ID, "Message unpinned.")
+}
+
+func (b *Bot) handlePinned(ctx context.Context, message *tgbotapi.Message) {
+	messages, err := b.storage.GetPinnedMessages(ctx, message.From.ID, maxHistoryLimit, 0)
+	if err != nil {
+		b.log(ctx).Error("Failed to get pinned messages",
+			zap.Error(err))
+		b.sendStorageError(ctx, message.Chat.ID, err, tr(ctx, errMsgRetrieval))
+		return
+	}
+
+	if len(messages) == 0 {
+		b.sendMessage(message.Chat.ID, "You don't have any pinned messages. Pin one with /pin <message_id>.")
+		return
+	}
+
+	b.sendMessageList(message.Chat.ID, "Your pinned messages:", messages, b.userDisplayPrefs(ctx, message.From.ID))
+}
+
+// getOwnedMessage loads a stored message and checks it belongs to the sender.
+// Messages of other users are reported as not found so IDs can't be probed.
+func (b *Bot) getOwnedMessage(ctx context.Context, message *tgbotapi.Message, id string) (*models.Message, bool) {
+	stored, err := b.storage.GetMessageByID(ctx, id)
+	if errors.Is(err, storage.ErrNotFound) || (err == nil && stored.UserID != message.From.ID) {
+		b.sendMessage(message.Chat.ID, tr(ctx, errMsgMessageNotFound))
+		return nil, false
+	}
+	if err != nil {
+		b.log(ctx).Error("Failed to get message",
+			zap.Error(err),
+			zap.String("message_id", id))
+		b.sendStorageError(ctx, message.Chat.ID, err, tr(ctx, errMsgRetrieval))
+		return nil, false
+	}
+	return stored, true
+}
+
+func (b *Bot) handleDedupe(ctx context.Context, message *tgbotapi.Message) {
+	arg := strings.ToLower(strings.TrimSpace(message.CommandArguments()))
+	if arg != "" && arg != "confirm" {
+		b.sendMessage(message.Chat.ID, "Usage: /dedupe to list duplicate notes, /dedupe confirm to remove them")
+		return
+	}
+
+	groups, err := b.storage.FindDuplicateMessages(ctx, message.From.ID)
+	if err != nil {
+		b.log(ctx).Error("Failed to find duplicate messages",
+			zap.Error(err))
+		b.sendStorageError(ctx, message.Chat.ID, err, tr(ctx, errMsgRetrieval))
+		return
+	}
+
+	if len(groups) == 0 {
+		b.sendMessage(message.Chat.ID, "No duplicate notes found.")
+		return
+	}
+
+	if arg != "confirm" {
+		// Many groups don't fit in one message
+		if err := b.sendMarkdown(message.Chat.ID, escapeMarkdown(formatDuplicateGroups(groups)), nil); err != nil {
+			b.log(ctx).Error("Failed to send duplicate notes",
+				zap.Error(err))
+			b.sendErrorMessage(message.Chat.ID, tr(ctx, errMsgGeneral))
+		}
+		return
+	}
+
+	removed := 0
+	for _, group := range groups {
+		n, err := b.mergeDuplicates(ctx, group)
+		removed += n
+		if err != nil {
+			b.log(ctx).Error("Failed to merge duplicate messages",
+				zap.Error(err),
+				zap.String("content_hash", group.ContentHash))
+			b.sendStorageError(ctx, message.Chat.ID, err, fmt.Sprintf("Failed to remove all duplicates. %d removed so far, please try again.", removed))
+			return
+		}
+	}
+
+	b.sendMessage(message.Chat.ID, fmt.Sprintf("🧹 Removed %d duplicate notes.", removed))
+}
+
+// mergeDuplicates keeps the earliest message of a group, adds the tags of
+// the others to it and deletes them. It returns how many were deleted.
+func (b *Bot) mergeDuplicates(ctx context.Context, group models.DuplicateGroup) (int, error) {
+	keep := group.Messages[0]
+	tags := append([]string(nil), keep.Tags...)
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		seen[tag] = true
+	}
+	for _, duplicate := range group.Messages[1:] {
+		for _, tag := range duplicate.Tags {
+			if !seen[tag] {
+				seen[tag] = true
+				tags = append(tags, tag)
+			}
+		}
+	}
+
+	if len(tags) != len(keep.Tags) {
+		if err := b.storage.UpdateMessageClassification(ctx, keep.ID, keep.Category, tags); err != nil {
+			return 0, err
+		}
+	}
+
+	removed := 0
+	for _, duplicate := range group.Messages[1:] {
+		if err := b.storage.DeleteMessage(ctx, duplicate.ID); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+func formatDuplicateGroups(groups []models.DuplicateGroup) string {
+	var sb strings.Builder
+	total := 0
+	for i, group := range groups {
+		total += len(group.Messages) - 1
+		// One line per group, so a long list is never split inside a group
+		fmt.Fprintf(&sb, "%d. %d copies of \"%s\", keeping %s\n",
+			i+1, len(group.Messages),
+			truncateText(strings.Join(strings.Fields(group.Messages[0].Content), " "), dedupePreviewLen),
+			group.Messages[0].ID)
+	}
+	fmt.Fprintf(&sb, "\nThe earliest copy of each note is kept and gets the tags of the others. "+
+		"Send /dedupe confirm to remove %d duplicates.", total)
+	return "Duplicate notes:\n\n" + sb.String()
+}
@@ -0,0 +1,54 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xaenox/memo-bot/internal/models"
+	"github.com/xaenox/memo-bot/internal/storage"
+)
+
+func TestDedupeSplitsLongLists(t *testing.T) {
+	const userID = 42
+	ctx := context.Background()
+	store := storage.NewMemoryStorage(storage.Limits{})
+
+	var notes []*models.Message
+	for i := 0; i < 100; i++ {
+		content := fmt.Sprintf("Duplicate note number %d with enough text to fill a line\nand a second line", i)
+		for j := 0; j < 2; j++ {
+			notes = append(notes, &models.Message{
+				ID:        fmt.Sprintf("note-%d-%d", i, j),
+				UserID:    userID,
+				Content:   content,
+				Category:  "work",
+				CreatedAt: time.Now(),
+			})
+		}
+	}
+	if err := store.SaveMessages(ctx, notes); err != nil {
+		t.Fatalf("SaveMessages: %v", err)
+	}
+
+	b, telegram := newTestBot(t, Config{}, store, &fakeClassifier{}, nopSender{})
+	b.handleDedupe(ctx, commandMessage(userID, "/dedupe"))
+
+	sent := telegram.sent("sendMessage")
+	if len(sent) < 2 {
+		t.Fatalf("sent %d messages, want the list split over several", len(sent))
+	}
+	groups := 0
+	for _, call := range sent {
+		text := call.Params["text"]
+		if utf16Len(text) > maxMessageLength {
+			t.Errorf("message of %d UTF-16 units is over the limit", utf16Len(text))
+		}
+		groups += strings.Count(text, "copies of")
+	}
+	if groups != 100 {
+		t.Errorf("listed %d groups, want 100", groups)
+	}
+}
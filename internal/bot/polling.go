@@ -0,0 +1,138 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.uber.org/zap"
+)
+
+// Failed getUpdates calls are retried with exponential backoff between
+// reconnectBaseDelay and reconnectMaxDelay. Polling gives up after
+// maxPollFailures failures in a row, a few minutes of lost connectivity.
+const (
+	reconnectBaseDelay = time.Second
+	reconnectMaxDelay  = time.Minute
+	maxPollFailures    = 10
+)
+
+// pollUpdates long-polls Telegram and sends every new update to out until
+// stop is closed. Unlike GetUpdatesChan, which retries failures forever
+// without telling anyone, it backs off, logs each reconnect attempt and
+// returns an error once Telegram has been unreachable for too long.
+func (b *Bot) pollUpdates(config tgbotapi.UpdateConfig, out chan<- tgbotapi.Update, stop <-chan struct{}) error {
+	delay := reconnectBaseDelay
+	failures := 0
+	for {
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+
+		updates, err := b.api.GetUpdates(config)
+		if err != nil {
+			failures++
+			if failures >= maxPollFailures {
+				return fmt.Errorf("failed to get updates %d times in a row: %w", failures, err)
+			}
+			b.logger.Warn("Failed to get updates, reconnecting",
+				zap.Error(err),
+				zap.Int("attempt", failures),
+				zap.Duration("retry_in", delay))
+			select {
+			case <-stop:
+				return nil
+			case <-time.After(delay):
+			}
+			delay = min(delay*2, reconnectMaxDelay)
+			continue
+		}
+		if failures > 0 {
+			b.logger.Info("Reconnected to Telegram",
+				zap.Int("failed_attempts", failures))
+			failures = 0
+			delay = reconnectBaseDelay
+		}
+
+		for _, update := range updates {
+			if update.UpdateID < config.Offset {
+				continue
+			}
+			config.Offset = update.UpdateID + 1
+			select {
+			case out <- update:
+			case <-stop:
+				return nil
+			}
+		}
+	}
+}
+
+// updateOffsets works out the update polling may resume after. Handlers
+// finish out of order, so that is the one before the oldest update still
+// being handled; a restart then never skips an update that wasn't handled.
+type updateOffsets struct {
+	mu sync.Mutex
+	// pending holds the updates being handled
+	pending map[int]bool
+	// latest is the newest update begun and saved the last offset returned
+	latest int
+	saved  int
+}
+
+func newUpdateOffsets() *updateOffsets {
+	return &updateOffsets{pending: make(map[int]bool)}
+}
+
+// begin records that update id is being handled. A nil tracker ignores it.
+func (o *updateOffsets) begin(id int) {
+	if o == nil {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.latest == 0 {
+		// Everything before the first update was handled before we started
+		o.saved = id - 1
+	}
+	o.pending[id] = true
+	o.latest = max(o.latest, id)
+}
+
+// finish records that update id is done. It returns the offset to save
+// when every update up to a newer one than before is done.
+func (o *updateOffsets) finish(id int) (int, bool) {
+	if o == nil {
+		return 0, false
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	delete(o.pending, id)
+
+	offset := o.latest
+	for pending := range o.pending {
+		offset = min(offset, pending-1)
+	}
+	if offset <= o.saved {
+		return 0, false
+	}
+	o.saved = offset
+	return offset, true
+}
+
+// saveOffset marks update id as handled and persists the offset it allows
+func (b *Bot) saveOffset(ctx context.Context, offsets *updateOffsets, id int) {
+	offset, ok := offsets.finish(id)
+	if !ok {
+		return
+	}
+	if err := b.storage.SetUpdateOffset(ctx, offset); err != nil {
+		b.logger.Error("Failed to save update offset",
+			zap.Error(err),
+			zap.Int("update_id", offset))
+	}
+}
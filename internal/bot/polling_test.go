@@ -0,0 +1,34 @@
+package bot
+
+import "testing"
+
+func TestUpdateOffsets(t *testing.T) {
+	o := newUpdateOffsets()
+	o.begin(10)
+	o.begin(11)
+	o.begin(12)
+
+	// 11 is done, but 10 is still being handled
+	if offset, ok := o.finish(11); ok {
+		t.Fatalf("finish(11) = %d, want no offset while 10 is pending", offset)
+	}
+	if offset, ok := o.finish(10); !ok || offset != 11 {
+		t.Fatalf("finish(10) = %d, %v, want 11", offset, ok)
+	}
+
+	o.begin(13)
+	if offset, ok := o.finish(13); ok {
+		t.Fatalf("finish(13) = %d, want no offset while 12 is pending", offset)
+	}
+	if offset, ok := o.finish(12); !ok || offset != 13 {
+		t.Fatalf("finish(12) = %d, %v, want 13", offset, ok)
+	}
+}
+
+func TestUpdateOffsetsNil(t *testing.T) {
+	var o *updateOffsets
+	o.begin(1)
+	if _, ok := o.finish(1); ok {
+		t.Fatal("nil tracker returned an offset")
+	}
+}
@@ -0,0 +1,53 @@
+package bot
+
+import (
+	"context"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/xaenox/memo-bot/internal/models"
+	"go.uber.org/zap"
+)
+
+// handlePreview classifies the command's text, or the message it replies to,
+// and shows the result without saving the note, its category or its tags
+func (b *Bot) handlePreview(ctx context.Context, message *tgbotapi.Message) {
+	content := strings.TrimSpace(message.CommandArguments())
+	prompt := content
+	contentType := models.TextContent
+	if content == "" && message.ReplyToMessage != nil {
+		var ok bool
+		if content, ok = messageContent(message.ReplyToMessage); ok {
+			_, contentType = messageMedia(message.ReplyToMessage)
+			prompt = classificationPrompt(message.ReplyToMessage, content, contentType)
+		}
+	}
+	if content == "" {
+		b.sendMessage(message.Chat.ID, tr(ctx, msgPreviewUsage))
+		return
+	}
+	if b.noteTooLong(content) {
+		b.sendMessage(message.Chat.ID, tr(ctx, msgNoteTooLong, b.maxNoteChars))
+		return
+	}
+
+	response := b.analyze(ctx, message, prompt, contentType)
+	if response.Category == "" {
+		b.log(ctx).Error("Failed to get GPT analysis for preview")
+		b.sendErrorMessage(message.Chat.ID, tr(ctx, errMsgClassify))
+		return
+	}
+	if maxTags := b.userMaxTags(ctx, message.From.ID); len(response.Keywords) > maxTags {
+		response.Keywords = response.Keywords[:maxTags]
+	}
+
+	prefs := b.userDisplayPrefs(ctx, message.From.ID)
+	text := "_" + escapeMarkdown(prefs.t(msgPreviewHeader)) + "_\n\n" + formatClassification(&response, prefs)
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
+	msg.ParseMode = "MarkdownV2"
+	msg.ReplyToMessageID = message.MessageID
+	if _, err := b.api.Send(msg); err != nil {
+		b.log(ctx).Error("Failed to send preview",
+			zap.Error(err))
+	}
+}
@@ -0,0 +1,291 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/xaenox/memo-bot/internal/models"
+	"go.uber.org/zap"
+)
+
+const (
+	// reclassifyChunk is how many notes are classified at a time; progress
+	// is reported and cancellation noticed between chunks
+	reclassifyChunk = 20
+	// reclassifyCooldown is how long users wait after a finished run before
+	// starting another; admins don't
+	reclassifyCooldown = time.Hour
+	// reclassifyPageSize is how many notes are loaded at a time
+	reclassifyPageSize = 500
+)
+
+// reclassifyJobs tracks /reclassify runs. Progress is only kept in memory,
+// so after a restart a run starts over.
+type reclassifyJobs struct {
+	mu sync.Mutex
+	// running cancels the user's run in progress
+	running map[int64]context.CancelFunc
+	// resumeBefore is where the user's interrupted run picks up: notes
+	// created before it are still to do
+	resumeBefore map[int64]time.Time
+	// finished is when the user's last run completed
+	finished map[int64]time.Time
+}
+
+func newReclassifyJobs() *reclassifyJobs {
+	return &reclassifyJobs{
+		running:      make(map[int64]context.CancelFunc),
+		resumeBefore: make(map[int64]time.Time),
+		finished:     make(map[int64]time.Time),
+	}
+}
+
+func (b *Bot) handleReclassify(ctx context.Context, message *tgbotapi.Message) {
+	userID := message.From.ID
+	jobs := b.reclassify
+
+	arg := strings.ToLower(strings.TrimSpace(message.CommandArguments()))
+	switch arg {
+	case "", "restart":
+	case "cancel":
+		jobs.mu.Lock()
+		cancel, ok := jobs.running[userID]
+		jobs.mu.Unlock()
+		if !ok {
+			b.sendMessage(message.Chat.ID, "No reclassification is running.")
+			return
+		}
+		cancel()
+		return
+	default:
+		b.sendMessage(message.Chat.ID, "Usage: /reclassify to classify all your notes again, "+
+			"/reclassify cancel to stop and /reclassify restart to start over after stopping.")
+		return
+	}
+
+	jobs.mu.Lock()
+	if _, ok := jobs.running[userID]; ok {
+		jobs.mu.Unlock()
+		b.sendMessage(message.Chat.ID, "Your notes are already being reclassified. Send /reclassify cancel to stop.")
+		return
+	}
+	if arg == "restart" {
+		delete(jobs.resumeBefore, userID)
+	}
+	resumeBefore, resuming := jobs.resumeBefore[userID]
+	if wait := time.Until(jobs.finished[userID].Add(reclassifyCooldown)); !resuming && wait > 0 && !b.isAdmin(userID) {
+		jobs.mu.Unlock()
+		b.sendMessage(message.Chat.ID, fmt.Sprintf("Your notes were reclassified recently. Please try again in %d minutes.", int(wait.Minutes())+1))
+		return
+	}
+	// The run outlives this handler and stops with the bot
+	jobCtx, cancel := context.WithCancel(ctx)
+	jobs.running[userID] = cancel
+	jobs.mu.Unlock()
+
+	b.inFlight.Add(1)
+	go func() {
+		defer b.inFlight.Done()
+		defer cancel()
+		b.runReclassify(jobCtx, message.Chat.ID, userID, resumeBefore)
+	}()
+}
+
+// runReclassify classifies the user's notes created before resumeBefore
+// again, newest first, or all of them when resumeBefore is zero
+func (b *Bot) runReclassify(ctx context.Context, chatID, userID int64, resumeBefore time.Time) {
+	jobs := b.reclassify
+	finished := false
+	defer func() {
+		jobs.mu.Lock()
+		delete(jobs.running, userID)
+		if finished {
+			delete(jobs.resumeBefore, userID)
+			jobs.finished[userID] = time.Now()
+		} else if !resumeBefore.IsZero() {
+			jobs.resumeBefore[userID] = resumeBefore
+		}
+		jobs.mu.Unlock()
+	}()
+
+	notes, err := b.reclassifyBacklog(ctx, userID, resumeBefore)
+	if err != nil {
+		b.log(ctx).Error("Failed to load notes to reclassify",
+			zap.Error(err))
+		b.sendStorageError(ctx, chatID, err, tr(ctx, errMsgRetrieval))
+		return
+	}
+	if len(notes) == 0 {
+		finished = true
+		b.sendMessage(chatID, "There are no notes to reclassify.")
+		return
+	}
+
+	user, err := b.storage.GetUser(ctx, userID)
+	if err != nil {
+		b.log(ctx).Error("Failed to get user",
+			zap.Error(err))
+		b.sendStorageError(ctx, chatID, err, tr(ctx, errMsgRetrieval))
+		return
+	}
+	existing := append([]string(nil), user.Categories...)
+	allowed := user.AllowedCategories
+	maxTags := b.userMaxTags(ctx, userID)
+
+	progress, err := b.sender.SendMessage(chatID, fmt.Sprintf("⏳ Reclassifying %d notes. Send /reclassify cancel to stop.", len(notes)))
+	if err != nil {
+		b.log(ctx).Error("Failed to send reclassify progress",
+			zap.Error(err))
+	}
+	report := func(text string) {
+		if progress.MessageID == 0 {
+			b.sendMessage(chatID, text)
+			return
+		}
+		if _, err := b.api.Send(tgbotapi.NewEditMessageText(chatID, progress.MessageID, text)); err != nil {
+			b.log(ctx).Warn("Failed to update reclassify progress",
+				zap.Error(err))
+		}
+	}
+
+	stop := func(done int) {
+		b.log(ctx).Info("Stopped reclassifying notes",
+			zap.Int("done", done),
+			zap.Int("total", len(notes)))
+		report(fmt.Sprintf("Stopped after %d/%d notes. Send /reclassify to continue.", done, len(notes)))
+	}
+
+	updated, failed := 0, 0
+	for start := 0; start < len(notes); start += reclassifyChunk {
+		if b.stopping(ctx) {
+			stop(start)
+			return
+		}
+
+		chunk := notes[start:min(start+reclassifyChunk, len(notes))]
+		contents := make([]string, len(chunk))
+		for i, note := range chunk {
+			contents[i] = note.Content
+		}
+		responses, err := b.classifier.ClassifyBatch(ctx, contents, userID)
+		// A chunk cut short is done again on resume
+		if b.stopping(ctx) {
+			stop(start)
+			return
+		}
+		if err != nil {
+			b.log(ctx).Warn("Some notes could not be reclassified",
+				zap.Error(err))
+		}
+
+		for i, response := range responses {
+			if response.Category == "" {
+				failed++
+				continue
+			}
+			category := b.matchCategoryIn(ctx, response.Category, existing, allowed)
+			tags := b.withCategoryTags(ctx, userID, category, response.Keywords)
+			if len(tags) > maxTags {
+				tags = tags[:maxTags]
+			}
+			if err := b.storage.UpdateMessageClassification(ctx, chunk[i].ID, category, tags); err != nil {
+				b.log(ctx).Error("Failed to update reclassified note",
+					zap.Error(err),
+					zap.String("message_id", chunk[i].ID))
+				failed++
+				continue
+			}
+			b.rememberLabels(ctx, userID, category, tags, &existing)
+			updated++
+		}
+
+		resumeBefore = chunk[len(chunk)-1].CreatedAt
+		report(fmt.Sprintf("⏳ Reclassified %d/%d notes. Send /reclassify cancel to stop.", start+len(chunk), len(notes)))
+	}
+
+	finished = true
+	b.log(ctx).Info("Reclassified notes",
+		zap.Int("updated", updated),
+		zap.Int("failed", failed))
+	text := fmt.Sprintf("✅ Reclassified %d notes.", updated)
+	if failed > 0 {
+		text += fmt.Sprintf(" %d could not be classified and were left as they were.", failed)
+	}
+	report(text)
+}
+
+// stopping reports whether a background job should stop: it was cancelled or
+// the bot is shutting down
+func (b *Bot) stopping(ctx context.Context) bool {
+	select {
+	case <-ctx.Done():
+		return true
+	case <-b.stopPolling:
+		return true
+	default:
+		return false
+	}
+}
+
+// reclassifyBacklog loads the user's notes, archived ones included, created
+// before the given time, or all of them when it is zero. They are sorted
+// newest first so a stopped run can resume from the last note it did.
+func (b *Bot) reclassifyBacklog(ctx context.Context, userID int64, before time.Time) ([]*models.Message, error) {
+	var notes []*models.Message
+	for _, list := range []func(ctx context.Context, userID int64, limit, offset int) ([]*models.Message, error){
+		b.storage.GetUserMessages,
+		b.storage.GetArchivedMessages,
+	} {
+		for offset := 0; ; offset += reclassifyPageSize {
+			page, err := list(ctx, userID, reclassifyPageSize, offset)
+			if err != nil {
+				return nil, err
+			}
+			for _, note := range page {
+				if before.IsZero() || note.CreatedAt.Before(before) {
+					notes = append(notes, note)
+				}
+			}
+			if len(page) < reclassifyPageSize {
+				break
+			}
+		}
+	}
+	sort.SliceStable(notes, func(i, j int) bool {
+		return notes[i].CreatedAt.After(notes[j].CreatedAt)
+	})
+	return notes, nil
+}
+
+// rememberLabels adds a reclassified note's category and tags to the user's
+// lists, like saving a new note does. existing is the category list used for
+// matching and grows with new categories.
+func (b *Bot) rememberLabels(ctx context.Context, userID int64, category string, tags []string, existing *[]string) {
+	known := false
+	for _, c := range *existing {
+		if c == category {
+			known = true
+			break
+		}
+	}
+	if !known {
+		if err := b.storage.AddCategory(ctx, userID, category); err != nil {
+			b.log(ctx).Error("Failed to save category",
+				zap.Error(err),
+				zap.String("category", category))
+		}
+		*existing = append(*existing, category)
+	}
+	for _, tag := range tags {
+		if err := b.storage.AddTag(ctx, userID, tag); err != nil {
+			b.log(ctx).Error("Failed to save tag",
+				zap.Error(err),
+				zap.String("tag", tag))
+		}
+	}
+}
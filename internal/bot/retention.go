@@ -0,0 +1,147 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/xaenox/memo-bot/internal/models"
+	"go.uber.org/zap"
+)
+
+// RetentionPurgeInterval is how often notes past their owner's retention are
+// deleted
+const RetentionPurgeInterval = 24 * time.Hour
+
+const (
+	// maxRetentionDays is about a century; anything longer is a typo
+	maxRetentionDays = 36500
+	// retentionPageSize is how many users are loaded at a time by the purge
+	retentionPageSize = 500
+)
+
+func (b *Bot) handleRetention(ctx context.Context, message *tgbotapi.Message) {
+	arg := strings.ToLower(strings.TrimSpace(message.CommandArguments()))
+	if arg == "" {
+		user, err := b.storage.GetUser(ctx, message.From.ID)
+		if err != nil {
+			b.log(ctx).Error("Failed to get user",
+				zap.Error(err))
+			b.sendStorageError(ctx, message.Chat.ID, err, tr(ctx, errMsgRetrieval))
+			return
+		}
+		if user.RetentionDays == 0 {
+			b.sendMessage(message.Chat.ID, "Your notes are kept until you delete them.\n"+
+				"Usage: /retention <days>d, e.g. /retention 90d, or /retention off")
+			return
+		}
+		b.sendMessage(message.Chat.ID, fmt.Sprintf("Notes older than %d days are deleted automatically.\n"+
+			"Send /retention off to keep them.", user.RetentionDays))
+		return
+	}
+
+	days, ok := parseRetention(arg)
+	if !ok {
+		b.sendMessage(message.Chat.ID, fmt.Sprintf("Please provide a number of days between 1 and %d, e.g. /retention 90d, or /retention off.", maxRetentionDays))
+		return
+	}
+
+	if err := b.storage.UpdateUserRetention(ctx, message.From.ID, days); err != nil {
+		b.log(ctx).Error("Failed to update retention",
+			zap.Error(err),
+			zap.Int("retention_days", days))
+		b.sendStorageError(ctx, message.Chat.ID, err, "Failed to update retention. Please try again.")
+		return
+	}
+
+	if days == 0 {
+		b.sendMessage(message.Chat.ID, "Your notes will be kept until you delete them.")
+		return
+	}
+	b.sendMessage(message.Chat.ID, fmt.Sprintf("Notes older than %d days will be deleted automatically, starting with the next daily cleanup.", days))
+}
+
+// parseRetention reads a retention such as "90d" or "90" as days, or "off"
+// as zero
+func parseRetention(arg string) (int, bool) {
+	if arg == "off" {
+		return 0, true
+	}
+	days, err := strconv.Atoi(strings.TrimSuffix(arg, "d"))
+	if err != nil || days < 1 || days > maxRetentionDays {
+		return 0, false
+	}
+	return days, true
+}
+
+// RunRetentionPurge deletes notes past their owner's retention on start and
+// then every interval until ctx is cancelled. Purging on start keeps a bot
+// that restarts more often than interval from never purging.
+func (b *Bot) RunRetentionPurge(ctx context.Context, interval time.Duration) {
+	b.purgeExpiredMessages(ctx)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			b.purgeExpiredMessages(ctx)
+		}
+	}
+}
+
+// purgeExpiredMessages deletes the notes of every user with a retention that
+// are older than it
+func (b *Bot) purgeExpiredMessages(ctx context.Context) {
+	// Collect everyone first so users who show up meanwhile don't shift pages
+	var users []*models.User
+	for offset := 0; ; offset += retentionPageSize {
+		page, err := b.storage.ListUsers(ctx, retentionPageSize, offset)
+		if err != nil {
+			b.log(ctx).Error("Failed to list users for retention purge",
+				zap.Error(err),
+				zap.Int("offset", offset))
+			return
+		}
+		for _, user := range page {
+			if user.RetentionDays > 0 {
+				users = append(users, user)
+			}
+		}
+		if len(page) < retentionPageSize {
+			break
+		}
+	}
+
+	now := time.Now()
+	total := 0
+	for _, user := range users {
+		if ctx.Err() != nil {
+			return
+		}
+		cutoff := now.AddDate(0, 0, -user.RetentionDays)
+		deleted, err := b.storage.DeleteMessagesOlderThan(ctx, user.ID, cutoff)
+		if err != nil {
+			b.log(ctx).Error("Failed to purge expired messages",
+				zap.Error(err),
+				zap.Int64("user_id", user.ID))
+			continue
+		}
+		if deleted > 0 {
+			b.log(ctx).Info("Purged expired messages",
+				zap.Int64("user_id", user.ID),
+				zap.Int("deleted", deleted),
+				zap.Int("retention_days", user.RetentionDays))
+		}
+		total += deleted
+	}
+	b.log(ctx).Info("Finished retention purge",
+		zap.Int("users", len(users)),
+		zap.Int("deleted", total))
+}
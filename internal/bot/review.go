@@ -0,0 +1,94 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/xaenox/memo-bot/internal/classifier"
+	"github.com/xaenox/memo-bot/internal/storage"
+	"go.uber.org/zap"
+)
+
+const (
+	reviewCallbackAction = "review"
+	reviewConfirm        = "ok"
+	reviewReject         = "no"
+)
+
+// needsReview reports whether the assistant was unsure enough about a
+// classification to ask the user. Responses without a confidence, such as
+// fallbacks, are not reviewed.
+func (b *Bot) needsReview(response classifier.GPTResponse) bool {
+	return !response.Fallback && response.Confidence > 0 && response.Confidence < b.minConfidence
+}
+
+// reviewKeyboard asks whether the classification of note noteID is right
+func reviewKeyboard(noteID string, prefs displayPrefs) tgbotapi.InlineKeyboardMarkup {
+	data := func(answer string) string {
+		return reviewCallbackAction + ":" + noteID + ":" + answer
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(prefs.t(msgReviewConfirm), data(reviewConfirm)),
+		tgbotapi.NewInlineKeyboardButtonData(prefs.t(msgReviewReject), data(reviewReject)),
+	))
+}
+
+// handleReviewCallback records the answer to a "Does this look right?"
+// question. A rejection asks for the right category, which is then applied
+// like any other correction reply.
+func (b *Bot) handleReviewCallback(ctx context.Context, query *tgbotapi.CallbackQuery, args string) string {
+	noteID, answer, _ := strings.Cut(args, ":")
+	if query.Message == nil || (answer != reviewConfirm && answer != reviewReject) {
+		return ""
+	}
+
+	note, err := b.storage.GetMessageByID(ctx, noteID)
+	if errors.Is(err, storage.ErrNotFound) {
+		return tr(ctx, errMsgMessageNotFound)
+	}
+	if err != nil {
+		b.log(ctx).Error("Failed to get reviewed message",
+			zap.Error(err),
+			zap.String("message_id", noteID))
+		return tr(ctx, errMsgRetrieval)
+	}
+	// Only the author of a note may review it, even in group chats
+	if note.UserID != query.From.ID {
+		return tr(ctx, msgReviewNotYours)
+	}
+
+	chatID, messageID := query.Message.Chat.ID, query.Message.MessageID
+	// The question is answered either way, so the buttons go away
+	removeButtons := tgbotapi.NewEditMessageReplyMarkup(chatID, messageID, tgbotapi.InlineKeyboardMarkup{
+		InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{},
+	})
+	if _, err := b.api.Request(removeButtons); err != nil {
+		b.log(ctx).Warn("Failed to remove review buttons",
+			zap.Error(err),
+			zap.Int("message_id", messageID))
+	}
+
+	if answer == reviewConfirm {
+		return tr(ctx, msgReviewThanks)
+	}
+
+	prompt := tgbotapi.NewMessage(chatID, tr(ctx, msgReviewPrompt))
+	prompt.ReplyToMessageID = messageID
+	prompt.ReplyMarkup = tgbotapi.ForceReply{ForceReply: true, Selective: true}
+	sent, err := b.api.Send(prompt)
+	if err != nil {
+		b.log(ctx).Error("Failed to send review prompt",
+			zap.Error(err))
+		return tr(ctx, errMsgGeneral)
+	}
+
+	// Replies to the prompt correct the note just like replies to the classification
+	if err := b.storage.SaveClassificationReply(ctx, chatID, sent.MessageID, note.ID, 0); err != nil {
+		b.log(ctx).Error("Failed to save review prompt",
+			zap.Error(err),
+			zap.String("message_id", note.ID))
+	}
+	return ""
+}
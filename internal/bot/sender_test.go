@@ -0,0 +1,71 @@
+package bot
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.uber.org/zap"
+)
+
+// nopSender is a MessageSender that sends nothing
+type nopSender struct{}
+
+var _ MessageSender = nopSender{}
+
+func (nopSender) SendMessage(chatID int64, text string) (tgbotapi.Message, error) {
+	return tgbotapi.Message{}, nil
+}
+
+func (nopSender) DeleteMessage(chatID int64, messageID int) error {
+	return nil
+}
+
+func (nopSender) SendReplyMessage(chatID int64, text string, replyToID int) (tgbotapi.Message, error) {
+	return tgbotapi.Message{}, nil
+}
+
+func (nopSender) SendChatAction(chatID int64, action string) error {
+	return nil
+}
+
+// actionSender is a nopSender that counts chat actions
+type actionSender struct {
+	nopSender
+
+	mu      sync.Mutex
+	actions []string
+}
+
+func (s *actionSender) SendChatAction(chatID int64, action string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.actions = append(s.actions, action)
+	return nil
+}
+
+func TestKeepTypingSendsTypingAction(t *testing.T) {
+	sender := &actionSender{}
+	b := &Bot{sender: sender, logger: zap.NewNop()}
+
+	stop := b.keepTyping(1)
+	deadline := time.Now().Add(time.Second)
+	for {
+		sender.mu.Lock()
+		n := len(sender.actions)
+		sender.mu.Unlock()
+		if n > 0 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	stop()
+	stop()
+
+	sender.mu.Lock()
+	defer sender.mu.Unlock()
+	if len(sender.actions) == 0 || sender.actions[0] != tgbotapi.ChatTyping {
+		t.Fatalf("actions = %v, want %q first", sender.actions, tgbotapi.ChatTyping)
+	}
+}
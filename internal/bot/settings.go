@@ -0,0 +1,271 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/xaenox/memo-bot/internal/storage"
+	"go.uber.org/zap"
+)
+
+const defaultDateLayout = "2006-01-02 15:04"
+
+// Named date format presets accepted by /dateformat
+var dateFormatPresets = map[string]string{
+	"iso": "2006-01-02 15:04",
+	"us":  "01/02/2006 3:04 PM",
+	"eu":  "02.01.2006 15:04",
+}
+
+func (b *Bot) handleDateFormat(ctx context.Context, message *tgbotapi.Message) {
+	arg := strings.TrimSpace(message.CommandArguments())
+	if arg == "" {
+		b.sendMessage(message.Chat.ID, tr(ctx, msgDateFormatUsage))
+		return
+	}
+
+	layout := arg
+	if preset, ok := dateFormatPresets[strings.ToLower(arg)]; ok {
+		layout = preset
+	}
+
+	if err := validateDateLayout(layout); err != nil {
+		b.sendMessage(message.Chat.ID, tr(ctx, msgDateFormatInvalid, arg, err))
+		return
+	}
+
+	if err := b.storage.UpdateUserDateFormat(ctx, message.From.ID, layout); err != nil {
+		b.log(ctx).Error("Failed to update date format",
+			zap.Error(err),
+			zap.String("date_format", layout))
+		b.sendStorageError(ctx, message.Chat.ID, err, tr(ctx, msgDateFormatFailed))
+		return
+	}
+
+	prefs := b.userDisplayPrefs(ctx, message.From.ID)
+	b.sendMessage(message.Chat.ID, prefs.t(msgDateFormatUpdated, prefs.formatTime(time.Now())))
+}
+
+// validateDateLayout checks that a Go time layout contains date fields and
+// parses back what it formats
+func validateDateLayout(layout string) error {
+	reference := time.Date(2025, time.March, 14, 15, 9, 26, 0, time.UTC)
+	formatted := reference.Format(layout)
+	if formatted == layout {
+		return fmt.Errorf("no date or time fields found")
+	}
+	if _, err := time.Parse(layout, formatted); err != nil {
+		return fmt.Errorf("layout is ambiguous")
+	}
+	return nil
+}
+
+func (b *Bot) handleTimezone(ctx context.Context, message *tgbotapi.Message) {
+	arg := strings.TrimSpace(message.CommandArguments())
+	if arg == "" {
+		prefs := b.userDisplayPrefs(ctx, message.From.ID)
+		b.sendMessage(message.Chat.ID, prefs.t(msgTimezoneCurrent, prefs.location))
+		return
+	}
+
+	location, err := loadTimezone(arg)
+	if err != nil {
+		b.sendMessage(message.Chat.ID, tr(ctx, msgTimezoneUnknown, arg))
+		return
+	}
+
+	// UTC is the default, so it isn't stored
+	timezone := location.String()
+	if location == time.UTC {
+		timezone = ""
+	}
+	if err := b.storage.UpdateUserTimezone(ctx, message.From.ID, timezone); err != nil {
+		b.log(ctx).Error("Failed to update timezone",
+			zap.Error(err),
+			zap.String("timezone", timezone))
+		b.sendStorageError(ctx, message.Chat.ID, err, tr(ctx, msgTimezoneFailed))
+		return
+	}
+
+	b.sendMessage(message.Chat.ID, tr(ctx, msgTimezoneUpdated, location, time.Now().In(location).Format("15:04")))
+}
+
+// loadTimezone looks up an IANA time zone name. Local is refused, since it
+// is whatever zone the server runs in.
+func loadTimezone(name string) (*time.Location, error) {
+	if strings.EqualFold(name, "utc") {
+		return time.UTC, nil
+	}
+	if name == "Local" {
+		return nil, fmt.Errorf("unknown time zone %s", name)
+	}
+	return time.LoadLocation(name)
+}
+
+// maxTemperature is the highest sampling temperature OpenAI accepts
+const maxTemperature = 2.0
+
+func (b *Bot) handleTemperature(ctx context.Context, message *tgbotapi.Message) {
+	arg := strings.ToLower(strings.TrimSpace(message.CommandArguments()))
+	if arg == "" {
+		user, err := b.storage.GetUser(ctx, message.From.ID)
+		if err != nil {
+			b.log(ctx).Error("Failed to get user",
+				zap.Error(err))
+			b.sendStorageError(ctx, message.Chat.ID, err, tr(ctx, errMsgRetrieval))
+			return
+		}
+		if user.Temperature == nil {
+			b.sendMessage(message.Chat.ID, tr(ctx, msgTemperatureDefault))
+			return
+		}
+		b.sendMessage(message.Chat.ID, tr(ctx, msgTemperatureCurrent, *user.Temperature))
+		return
+	}
+
+	var temperature *float64
+	if arg != "default" {
+		// Accept a decimal comma as typed on many keyboards
+		value, err := strconv.ParseFloat(strings.Replace(arg, ",", ".", 1), 64)
+		if err != nil || math.IsNaN(value) || value < 0 || value > maxTemperature {
+			b.sendMessage(message.Chat.ID, tr(ctx, msgTemperatureInvalid))
+			return
+		}
+		temperature = &value
+	}
+
+	if err := b.storage.UpdateUserTemperature(ctx, message.From.ID, temperature); err != nil {
+		b.log(ctx).Error("Failed to update temperature",
+			zap.Error(err))
+		b.sendStorageError(ctx, message.Chat.ID, err, tr(ctx, msgTemperatureFailed))
+		return
+	}
+
+	if temperature == nil {
+		b.sendMessage(message.Chat.ID, tr(ctx, msgTemperatureReset))
+		return
+	}
+	b.sendMessage(message.Chat.ID, tr(ctx, msgTemperatureUpdated, *temperature))
+}
+
+func (b *Bot) handleCategoryIcon(ctx context.Context, message *tgbotapi.Message) {
+	args := strings.Fields(message.CommandArguments())
+	if len(args) == 0 || len(args) > 2 {
+		b.sendMessage(message.Chat.ID, tr(ctx, msgCategoryIconUsage))
+		return
+	}
+
+	category := storage.NormalizeLabel(args[0])
+	var icon string
+	if len(args) == 2 {
+		icon = args[1]
+		if !isValidIcon(icon) {
+			b.sendMessage(message.Chat.ID, tr(ctx, msgCategoryIconInvalid))
+			return
+		}
+	}
+
+	if err := b.storage.SetCategoryIcon(ctx, message.From.ID, category, icon); err != nil {
+		b.log(ctx).Error("Failed to set category icon",
+			zap.Error(err),
+			zap.String("category", category))
+		b.sendStorageError(ctx, message.Chat.ID, err, tr(ctx, msgCategoryIconFailed))
+		return
+	}
+
+	if icon == "" {
+		b.sendMessage(message.Chat.ID, tr(ctx, msgCategoryIconRemoved, formatLabel(category)))
+		return
+	}
+	b.sendMessage(message.Chat.ID, tr(ctx, msgCategoryIconUpdated, formatLabel(category), icon, formatLabel(category)))
+}
+
+// isValidIcon accepts short symbol sequences such as emoji (including
+// modifiers and joiners) and rejects words
+func isValidIcon(icon string) bool {
+	runes := []rune(icon)
+	if len(runes) == 0 || len(runes) > 8 {
+		return false
+	}
+	for _, r := range runes {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// displayPrefs are the per-user settings applied when rendering messages
+type displayPrefs struct {
+	dateLayout string
+	location   *time.Location
+	icons      map[string]string
+	lang       string
+}
+
+// userDisplayPrefs loads the user's display settings, falling back to defaults
+func (b *Bot) userDisplayPrefs(ctx context.Context, userID int64) displayPrefs {
+	prefs := displayPrefs{dateLayout: defaultDateLayout, location: time.UTC, lang: languageFrom(ctx)}
+
+	user, err := b.storage.GetUser(ctx, userID)
+	if err != nil {
+		b.log(ctx).Warn("Failed to load user display preferences",
+			zap.Error(err))
+		return prefs
+	}
+
+	if user.DateFormat != "" {
+		prefs.dateLayout = user.DateFormat
+	}
+	if user.Timezone != "" {
+		location, err := time.LoadLocation(user.Timezone)
+		if err != nil {
+			b.log(ctx).Warn("Failed to load user timezone",
+				zap.Error(err),
+				zap.String("timezone", user.Timezone))
+		} else {
+			prefs.location = location
+		}
+	}
+	prefs.icons = user.CategoryIcons
+	return prefs
+}
+
+// formatTime renders t in the user's timezone and date format
+func (p displayPrefs) formatTime(t time.Time) string {
+	return t.In(p.location).Format(p.dateLayout)
+}
+
+// t translates key into the user's language
+func (p displayPrefs) t(key msgKey, args ...any) string {
+	return translate(p.lang, key, args...)
+}
+
+// userMaxTags returns how many tags the user wants per message
+func (b *Bot) userMaxTags(ctx context.Context, userID int64) int {
+	user, err := b.storage.GetUser(ctx, userID)
+	if err != nil {
+		b.log(ctx).Warn("Failed to load user tag limit",
+			zap.Error(err))
+		return storage.DefaultMaxTags
+	}
+	if user.MaxTags < 1 {
+		return storage.DefaultMaxTags
+	}
+	return user.MaxTags
+}
+
+// categoryLabel formats a category as a hashtag, prefixed with its icon if set
+func (p displayPrefs) categoryLabel(category string) string {
+	label := formatLabel(category)
+	if icon := p.icons[storage.NormalizeLabel(category)]; icon != "" {
+		return icon + " " + label
+	}
+	return label
+}
@@ -0,0 +1,49 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/xaenox/memo-bot/internal/models"
+	"go.uber.org/zap"
+)
+
+func (b *Bot) handleStats(ctx context.Context, message *tgbotapi.Message) {
+	stats, err := b.storage.GetUserStats(ctx, message.From.ID)
+	if err != nil {
+		b.log(ctx).Error("Failed to get user stats",
+			zap.Error(err))
+		b.sendStorageError(ctx, message.Chat.ID, err, tr(ctx, errMsgRetrieval))
+		return
+	}
+
+	if stats.TotalMessages == 0 {
+		b.sendMessage(message.Chat.ID, "You haven't saved any messages yet. Send me something to get started!")
+		return
+	}
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, formatStats(stats, b.userDisplayPrefs(ctx, message.From.ID)))
+	msg.ParseMode = "MarkdownV2"
+	if _, err := b.api.Send(msg); err != nil {
+		b.log(ctx).Error("Failed to send stats message",
+			zap.Error(err))
+		b.sendErrorMessage(message.Chat.ID, tr(ctx, errMsgGeneral))
+	}
+}
+
+func formatStats(stats *models.UserStats, prefs displayPrefs) string {
+	var sb strings.Builder
+	sb.WriteString("*📊 Your stats*\n\n")
+	fmt.Fprintf(&sb, "*Messages:* %d\n", stats.TotalMessages)
+	fmt.Fprintf(&sb, "*Categories:* %d\n", stats.Categories)
+	fmt.Fprintf(&sb, "*Tags:* %d\n", stats.Tags)
+	if stats.TopCategory != "" {
+		fmt.Fprintf(&sb, "*Top category:* %s \\(%d\\)\n",
+			escapeMarkdown(prefs.categoryLabel(stats.TopCategory)), stats.TopCategoryCount)
+	}
+	fmt.Fprintf(&sb, "\n*First note:* %s\n", escapeMarkdown(prefs.formatTime(stats.FirstMessageAt)))
+	fmt.Fprintf(&sb, "*Latest note:* %s", escapeMarkdown(prefs.formatTime(stats.LastMessageAt)))
+	return sb.String()
+}
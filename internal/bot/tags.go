@@ -0,0 +1,124 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/xaenox/memo-bot/internal/storage"
+	"go.uber.org/zap"
+)
+
+// Tags are stored the way they are searched: lowercase words joined by
+// underscores, as in #machine_learning
+var validTag = regexp.MustCompile(`^[\p{L}\p{N}_]+$`)
+
+// parseTag normalizes a tag argument like "#Work" and rejects anything that
+// would not survive as a hashtag
+func parseTag(arg string) (string, error) {
+	tag := storage.NormalizeLabel(arg)
+	if !validTag.MatchString(tag) {
+		return "", fmt.Errorf("%q is not a valid tag. Tags may only contain letters, digits and underscores", arg)
+	}
+	return tag, nil
+}
+
+func (b *Bot) handleAddTag(ctx context.Context, message *tgbotapi.Message) {
+	args := strings.Fields(message.CommandArguments())
+	if len(args) != 1 {
+		b.sendMessage(message.Chat.ID, "Please provide a tag.\nUsage: /addtag <tag_name>")
+		return
+	}
+
+	tag, err := parseTag(args[0])
+	if err != nil {
+		b.sendMessage(message.Chat.ID, err.Error())
+		return
+	}
+
+	err = b.storage.AddTag(ctx, message.From.ID, tag)
+	if errors.Is(err, storage.ErrInvalidInput) {
+		b.sendMessage(message.Chat.ID, "You have too many tags. Remove some with /removetag first.")
+		return
+	}
+	if err != nil {
+		b.log(ctx).Error("Failed to add tag",
+			zap.Error(err),
+			zap.String("tag", tag))
+		b.sendStorageError(ctx, message.Chat.ID, err, "Failed to add tag. Please try again.")
+		return
+	}
+
+	b.sendMessage(message.Chat.ID, "Added tag: "+formatLabel(tag))
+}
+
+func (b *Bot) handleRemoveTag(ctx context.Context, message *tgbotapi.Message) {
+	args := strings.Fields(message.CommandArguments())
+	if len(args) != 1 {
+		b.sendMessage(message.Chat.ID, "Please provide a tag.\nUsage: /removetag <tag_name>")
+		return
+	}
+
+	tag, err := parseTag(args[0])
+	if err != nil {
+		b.sendMessage(message.Chat.ID, err.Error())
+		return
+	}
+
+	err = b.storage.RemoveTag(ctx, message.From.ID, tag)
+	if errors.Is(err, storage.ErrNotFound) {
+		b.sendMessage(message.Chat.ID, "You don't have the tag "+formatLabel(tag)+".")
+		return
+	}
+	if err != nil {
+		b.log(ctx).Error("Failed to remove tag",
+			zap.Error(err),
+			zap.String("tag", tag))
+		b.sendStorageError(ctx, message.Chat.ID, err, "Failed to remove tag. Please try again.")
+		return
+	}
+
+	b.sendMessage(message.Chat.ID, "Removed tag: "+formatLabel(tag)+"\nYour notes keep it; use /renametag to change it everywhere.")
+}
+
+func (b *Bot) handleRenameTag(ctx context.Context, message *tgbotapi.Message) {
+	args := strings.Fields(message.CommandArguments())
+	if len(args) != 2 {
+		b.sendMessage(message.Chat.ID, "Please provide the current and the new tag.\nUsage: /renametag <old_tag> <new_tag>")
+		return
+	}
+
+	oldTag, err := parseTag(args[0])
+	if err != nil {
+		b.sendMessage(message.Chat.ID, err.Error())
+		return
+	}
+	newTag, err := parseTag(args[1])
+	if err != nil {
+		b.sendMessage(message.Chat.ID, err.Error())
+		return
+	}
+	if oldTag == newTag {
+		b.sendMessage(message.Chat.ID, "The new tag is the same as the old one.")
+		return
+	}
+
+	err = b.storage.RenameTag(ctx, message.From.ID, oldTag, newTag)
+	if errors.Is(err, storage.ErrNotFound) {
+		b.sendMessage(message.Chat.ID, "You don't have the tag "+formatLabel(oldTag)+".")
+		return
+	}
+	if err != nil {
+		b.log(ctx).Error("Failed to rename tag",
+			zap.Error(err),
+			zap.String("old_tag", oldTag),
+			zap.String("new_tag", newTag))
+		b.sendStorageError(ctx, message.Chat.ID, err, "Failed to rename tag. Please try again.")
+		return
+	}
+
+	b.sendMessage(message.Chat.ID, fmt.Sprintf("Renamed %s to %s in your tags and notes.", formatLabel(oldTag), formatLabel(newTag)))
+}
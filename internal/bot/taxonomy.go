@@ -0,0 +1,226 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/xaenox/memo-bot/internal/classifier"
+	"github.com/xaenox/memo-bot/internal/models"
+	"github.com/xaenox/memo-bot/internal/storage"
+	"go.uber.org/zap"
+)
+
+const (
+	taxonomyVersion     = 1
+	maxTaxonomySize     = 200
+	maxCategoryNameSize = 64
+)
+
+func (b *Bot) handleExportTaxonomy(ctx context.Context, message *tgbotapi.Message) {
+	categories, err := b.storage.GetUserCategories(ctx, message.From.ID)
+	if err != nil {
+		b.log(ctx).Error("Failed to get user categories",
+			zap.Error(err))
+		b.sendStorageError(ctx, message.Chat.ID, err, tr(ctx, errMsgRetrieval))
+		return
+	}
+
+	if len(categories) == 0 {
+		b.sendMessage(message.Chat.ID, "You don't have any categories to export yet.")
+		return
+	}
+
+	data, err := json.MarshalIndent(models.Taxonomy{
+		Version:    taxonomyVersion,
+		Categories: categories,
+	}, "", "  ")
+	if err != nil {
+		b.log(ctx).Error("Failed to encode taxonomy",
+			zap.Error(err))
+		b.sendErrorMessage(message.Chat.ID, tr(ctx, errMsgGeneral))
+		return
+	}
+
+	doc := tgbotapi.NewDocument(message.Chat.ID, tgbotapi.FileBytes{
+		Name:  "taxonomy.json",
+		Bytes: data,
+	})
+	doc.Caption = "Share this file and import it with /importtaxonomy (reply to the file with the command)."
+	if _, err := b.api.Send(doc); err != nil {
+		b.log(ctx).Error("Failed to send taxonomy export",
+			zap.Error(err))
+		b.sendErrorMessage(message.Chat.ID, tr(ctx, errMsgGeneral))
+	}
+}
+
+func (b *Bot) handleImportTaxonomy(ctx context.Context, message *tgbotapi.Message) {
+	data := []byte(strings.TrimSpace(message.CommandArguments()))
+	if reply := message.ReplyToMessage; len(data) == 0 && reply != nil && reply.Document != nil {
+		var err error
+		data, err = b.downloadFile(reply.Document.FileID, maxDownloadSize)
+		if err != nil {
+			b.log(ctx).Error("Failed to download taxonomy file",
+				zap.Error(err))
+			b.sendErrorMessage(message.Chat.ID, "Failed to download the taxonomy file. Please try again.")
+			return
+		}
+	}
+
+	if len(data) == 0 {
+		b.sendMessage(message.Chat.ID, "Please provide a taxonomy.\n"+
+			"Usage: reply to an exported taxonomy.json with /importtaxonomy, or send\n"+
+			`/importtaxonomy {"version": 1, "categories": ["work", "personal"]}`)
+		return
+	}
+
+	categories, err := parseTaxonomy(data)
+	if err != nil {
+		b.sendMessage(message.Chat.ID, fmt.Sprintf("Invalid taxonomy: %v", err))
+		return
+	}
+
+	existing, err := b.storage.GetUserCategories(ctx, message.From.ID)
+	if err != nil {
+		b.log(ctx).Error("Failed to get user categories",
+			zap.Error(err))
+		b.sendStorageError(ctx, message.Chat.ID, err, tr(ctx, errMsgRetrieval))
+		return
+	}
+	known := make(map[string]struct{}, len(existing))
+	for _, category := range existing {
+		known[category] = struct{}{}
+	}
+
+	// Merge: existing categories are kept, new ones are appended in template order
+	added := 0
+	for _, category := range categories {
+		if _, ok := known[category]; ok {
+			continue
+		}
+		if err := b.storage.AddCategory(ctx, message.From.ID, category); err != nil {
+			b.log(ctx).Error("Failed to import category",
+				zap.Error(err),
+				zap.String("category", category))
+			b.sendStorageError(ctx, message.Chat.ID, err, fmt.Sprintf("Import stopped after adding %d categories. Please try again.", added))
+			return
+		}
+		added++
+	}
+
+	b.sendMessage(message.Chat.ID, fmt.Sprintf("Imported taxonomy: %d new categories added, %d already present.",
+		added, len(categories)-added))
+}
+
+// handleSetTaxonomy restricts classification to the given categories.
+// Without arguments it shows the current list; --clear lifts the restriction.
+func (b *Bot) handleSetTaxonomy(ctx context.Context, message *tgbotapi.Message) {
+	args := strings.Fields(message.CommandArguments())
+	if len(args) == 0 {
+		user, err := b.storage.GetUser(ctx, message.From.ID)
+		if err != nil {
+			b.log(ctx).Error("Failed to get user",
+				zap.Error(err))
+			b.sendStorageError(ctx, message.Chat.ID, err, tr(ctx, errMsgRetrieval))
+			return
+		}
+		usage := "Usage: /settaxonomy <category...> to only allow these categories, /settaxonomy --clear to allow any."
+		if len(user.AllowedCategories) == 0 {
+			b.sendMessage(message.Chat.ID, "Notes can be filed under any category.\n"+usage)
+			return
+		}
+		b.sendMessage(message.Chat.ID, fmt.Sprintf("Notes are only filed under: %s (or %s when none fits).\n%s",
+			formatLabels(user.AllowedCategories), formatLabel(classifier.OtherCategory), usage))
+		return
+	}
+
+	var categories []string
+	if len(args) != 1 || args[0] != "--clear" {
+		names := make([]string, len(args))
+		for i, arg := range args {
+			names[i] = strings.TrimPrefix(arg, "#")
+		}
+		var err error
+		categories, err = normalizeCategories(names)
+		if err != nil {
+			b.sendMessage(message.Chat.ID, fmt.Sprintf("Invalid taxonomy: %v", err))
+			return
+		}
+	}
+
+	if err := b.storage.SetAllowedCategories(ctx, message.From.ID, categories); err != nil {
+		b.log(ctx).Error("Failed to set allowed categories",
+			zap.Error(err))
+		b.sendStorageError(ctx, message.Chat.ID, err, "Failed to update your taxonomy. Please try again.")
+		return
+	}
+
+	if len(categories) == 0 {
+		b.sendMessage(message.Chat.ID, "Taxonomy cleared. Notes can be filed under any category again.")
+		return
+	}
+	// Make the categories show up in /categories right away
+	for _, category := range categories {
+		if err := b.storage.AddCategory(ctx, message.From.ID, category); err != nil {
+			b.log(ctx).Error("Failed to save category",
+				zap.Error(err),
+				zap.String("category", category))
+		}
+	}
+	b.sendMessage(message.Chat.ID, fmt.Sprintf("New notes will only be filed under: %s. Notes that fit none of them go to %s.",
+		formatLabels(categories), formatLabel(classifier.OtherCategory)))
+}
+
+// formatLabels renders labels as space separated hashtags
+func formatLabels(labels []string) string {
+	formatted := make([]string, len(labels))
+	for i, label := range labels {
+		formatted[i] = formatLabel(label)
+	}
+	return strings.Join(formatted, " ")
+}
+
+// parseTaxonomy validates a taxonomy document and returns its normalized,
+// de-duplicated categories in their original order
+func parseTaxonomy(data []byte) ([]string, error) {
+	var taxonomy models.Taxonomy
+	if err := json.Unmarshal(data, &taxonomy); err != nil {
+		return nil, fmt.Errorf("not valid JSON")
+	}
+
+	if taxonomy.Version != 0 && taxonomy.Version != taxonomyVersion {
+		return nil, fmt.Errorf("unsupported version %d", taxonomy.Version)
+	}
+	return normalizeCategories(taxonomy.Categories)
+}
+
+// normalizeCategories normalizes and de-duplicates a list of category names,
+// rejecting empty or overly long lists and names
+func normalizeCategories(names []string) ([]string, error) {
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no categories found")
+	}
+	if len(names) > maxTaxonomySize {
+		return nil, fmt.Errorf("too many categories (max %d)", maxTaxonomySize)
+	}
+
+	seen := make(map[string]struct{}, len(names))
+	categories := make([]string, 0, len(names))
+	for _, raw := range names {
+		category := storage.NormalizeLabel(raw)
+		if category == "" {
+			return nil, fmt.Errorf("empty category name")
+		}
+		if len(category) > maxCategoryNameSize {
+			return nil, fmt.Errorf("category %q is too long", raw)
+		}
+		if _, ok := seen[category]; ok {
+			continue
+		}
+		seen[category] = struct{}{}
+		categories = append(categories, category)
+	}
+	return categories, nil
+}
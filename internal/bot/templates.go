@@ -0,0 +1,58 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"text/template"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.uber.org/zap"
+)
+
+// templateData is what welcome and help templates can refer to. Both values
+// are ready to be used in MarkdownV2.
+type templateData struct {
+	BotName  string
+	Commands string
+}
+
+// parseTemplate parses an operator-provided MarkdownV2 template; an empty
+// text means the built-in message is used
+func parseTemplate(name, text string) (*template.Template, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, nil
+	}
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s template: %w", name, err)
+	}
+	return tmpl, nil
+}
+
+// sendTemplate renders tmpl for the sender of message and replies with it. It
+// reports false when the message could not be rendered so the caller can
+// send the built-in one instead.
+func (b *Bot) sendTemplate(ctx context.Context, message *tgbotapi.Message, tmpl *template.Template) bool {
+	var text strings.Builder
+	err := tmpl.Execute(&text, templateData{
+		BotName:  escapeMarkdown(b.botName),
+		Commands: b.helpText(ctx, b.isAdmin(message.From.ID)),
+	})
+	if err != nil {
+		b.log(ctx).Error("Failed to render message template",
+			zap.Error(err),
+			zap.String("template", tmpl.Name()))
+		return false
+	}
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, text.String())
+	msg.ParseMode = "MarkdownV2"
+	if _, err := b.api.Send(msg); err != nil {
+		b.log(ctx).Error("Failed to send templated message",
+			zap.Error(err),
+			zap.String("template", tmpl.Name()))
+		return false
+	}
+	return true
+}
@@ -0,0 +1,142 @@
+package bot
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.uber.org/zap"
+)
+
+// StartWebhook registers webhookURL with Telegram and serves updates on
+// listenAddr until Stop is called. The HTTP path is taken from webhookURL.
+func (b *Bot) StartWebhook(listenAddr, webhookURL string) error {
+	defer close(b.polling)
+
+	hookURL, err := url.Parse(webhookURL)
+	if err != nil {
+		return fmt.Errorf("invalid webhook url: %w", err)
+	}
+
+	secret, err := newWebhookSecret()
+	if err != nil {
+		return err
+	}
+	// WebhookConfig has no secret_token, so the request is made by hand
+	params := tgbotapi.Params{"url": hookURL.String(), "secret_token": secret}
+	if _, err := b.api.MakeRequest("setWebhook", params); err != nil {
+		return fmt.Errorf("failed to set webhook: %w", err)
+	}
+	b.publishMenu()
+
+	path := hookURL.Path
+	if path == "" {
+		path = "/"
+	}
+
+	updates := make(chan tgbotapi.Update, b.api.Buffer)
+	done := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, b.webhookHandler(secret, updates, done))
+	server := &http.Server{
+		Addr:              listenAddr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	b.webhookMu.Lock()
+	b.webhook = server
+	b.webhookDone = done
+	b.webhookMu.Unlock()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		err := server.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serveErr <- err
+			b.shutdownWebhook(context.Background())
+		}
+	}()
+
+	b.logger.Info("Listening for webhook updates",
+		zap.String("listen_addr", listenAddr),
+		zap.String("path", path))
+
+	b.processUpdates(context.Background(), updates, done, false)
+
+	select {
+	case err := <-serveErr:
+		return fmt.Errorf("webhook server failed: %w", err)
+	default:
+		return nil
+	}
+}
+
+// webhookSecretHeader carries the secret_token set with the webhook on every
+// request Telegram makes
+const webhookSecretHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+// newWebhookSecret makes a secret_token for setWebhook. A new one is made on
+// every start, which replaces the one Telegram had.
+func newWebhookSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate webhook secret: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// webhookHandler passes on updates from requests carrying secret. Anyone
+// else who finds the URL could otherwise send updates as any user.
+func (b *Bot) webhookHandler(secret string, updates chan<- tgbotapi.Update, done <-chan struct{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get(webhookSecretHeader)
+		if subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+			b.logger.Warn("Rejected webhook request without the secret token",
+				zap.String("remote_addr", r.RemoteAddr))
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		update, err := b.api.HandleUpdate(r)
+		if err != nil {
+			b.logger.Warn("Rejected webhook request",
+				zap.Error(err),
+				zap.String("remote_addr", r.RemoteAddr))
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		select {
+		case updates <- *update:
+		case <-done:
+			// Telegram redelivers the update once we are back
+			http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		}
+	}
+}
+
+// shutdownWebhook stops dispatching webhook updates and shuts the server down
+func (b *Bot) shutdownWebhook(ctx context.Context) {
+	b.webhookMu.Lock()
+	server, done := b.webhook, b.webhookDone
+	b.webhookMu.Unlock()
+
+	if server == nil {
+		return
+	}
+
+	b.webhookStopOnce.Do(func() {
+		close(done)
+		if err := server.Shutdown(ctx); err != nil {
+			b.logger.Error("Failed to shut down webhook server", zap.Error(err))
+		}
+	})
+}
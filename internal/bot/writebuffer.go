@@ -0,0 +1,276 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/xaenox/memo-bot/internal/classifier"
+	"github.com/xaenox/memo-bot/internal/metrics"
+	"github.com/xaenox/memo-bot/internal/models"
+	"github.com/xaenox/memo-bot/internal/storage"
+	"go.uber.org/zap"
+)
+
+const (
+	// writeRetryMin and writeRetryMax bound the wait between attempts to
+	// save buffered writes; it doubles while the database stays down
+	writeRetryMin = time.Second
+	writeRetryMax = time.Minute
+	// writeMaxAge is how long a buffered write is kept. The buffer bridges
+	// brief outages; after a long one the user is asked to send the note again.
+	writeMaxAge = 15 * time.Minute
+)
+
+// bufferedWrite is a save that failed because the database was unreachable
+type bufferedWrite struct {
+	// ctx carries the user's language and log fields, without the
+	// handler's cancellation
+	ctx context.Context
+	// name describes the write in logs
+	name     string
+	chatID   int64
+	queuedAt time.Time
+	run      func(ctx context.Context) error
+}
+
+// writeBuffer keeps saves that failed while the database was unreachable so
+// they can be retried once it is back. It only lives in memory: writes the
+// last flush at shutdown can't save are lost.
+type writeBuffer struct {
+	mu      sync.Mutex
+	size    int
+	pending []bufferedWrite
+}
+
+func newWriteBuffer(size int) *writeBuffer {
+	if size <= 0 {
+		return nil
+	}
+	return &writeBuffer{size: size}
+}
+
+// hasRoom reports whether another write can be buffered
+func (w *writeBuffer) hasRoom() bool {
+	if w == nil {
+		return false
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.pending) < w.size
+}
+
+func (w *writeBuffer) len() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.pending)
+}
+
+// next returns the oldest buffered write without removing it
+func (w *writeBuffer) next() (bufferedWrite, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.pending) == 0 {
+		return bufferedWrite{}, false
+	}
+	return w.pending[0], true
+}
+
+// done removes the oldest buffered write
+func (w *writeBuffer) done() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.pending[0] = bufferedWrite{}
+	w.pending = w.pending[1:]
+}
+
+// bufferWrite queues run to be retried once the database is reachable. It
+// returns false when buffering is off or the buffer is full, in which case
+// the write is dropped.
+func (b *Bot) bufferWrite(ctx context.Context, chatID int64, name string, run func(ctx context.Context) error) bool {
+	w := b.writes
+	if w == nil {
+		return false
+	}
+
+	w.mu.Lock()
+	if len(w.pending) >= w.size {
+		w.mu.Unlock()
+		b.log(ctx).Warn("Write buffer is full, dropping write",
+			zap.String("write", name),
+			zap.Int("size", w.size))
+		return false
+	}
+	w.pending = append(w.pending, bufferedWrite{
+		ctx:      context.WithoutCancel(ctx),
+		name:     name,
+		chatID:   chatID,
+		queuedAt: time.Now(),
+		run:      run,
+	})
+	queued := len(w.pending)
+	w.mu.Unlock()
+
+	b.log(ctx).Warn("Database unavailable, buffered write for retry",
+		zap.String("write", name),
+		zap.Int("buffered", queued))
+	return true
+}
+
+// RunWriteBuffer retries buffered writes until ctx is cancelled. It waits
+// longer after every attempt that finds the database still unavailable.
+// Writes left when it returns are saved by FlushWrites.
+func (b *Bot) RunWriteBuffer(ctx context.Context) {
+	w := b.writes
+	if w == nil {
+		return
+	}
+
+	delay := writeRetryMin
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		if b.flushWrites(ctx) {
+			delay = writeRetryMin
+		} else {
+			delay = min(delay*2, writeRetryMax)
+		}
+		timer.Reset(delay)
+	}
+}
+
+// FlushWrites makes a last attempt to save buffered writes. It is meant for
+// shutdown, after Stop, when no handler can buffer any more; writes it can't
+// save before ctx is done are lost.
+func (b *Bot) FlushWrites(ctx context.Context) {
+	w := b.writes
+	if w == nil {
+		return
+	}
+
+	b.flushWrites(ctx)
+	if n := w.len(); n > 0 {
+		b.logger.Warn("Dropping buffered writes at shutdown",
+			zap.Int("buffered", n))
+	}
+}
+
+// flushWrites saves buffered writes oldest first while the database is
+// reachable. It returns false when the database is still unavailable.
+func (b *Bot) flushWrites(ctx context.Context) bool {
+	w := b.writes
+	if w.len() == 0 {
+		return true
+	}
+	if err := b.storage.CheckHealth(ctx); err != nil {
+		b.logger.Debug("Database still unavailable, keeping buffered writes",
+			zap.Error(err),
+			zap.Int("buffered", w.len()))
+		return false
+	}
+
+	for ctx.Err() == nil {
+		write, ok := w.next()
+		if !ok {
+			return true
+		}
+
+		if time.Since(write.queuedAt) > writeMaxAge {
+			w.done()
+			b.log(write.ctx).Warn("Dropping expired buffered write",
+				zap.String("write", write.name),
+				zap.Duration("age", time.Since(write.queuedAt)))
+			b.sendErrorMessage(write.chatID, tr(write.ctx, errMsgBufferedLost))
+			continue
+		}
+
+		err := write.run(write.ctx)
+		if errors.Is(err, storage.ErrConnection) {
+			return false
+		}
+		w.done()
+		if err != nil {
+			b.log(write.ctx).Error("Failed to save buffered write",
+				zap.Error(err),
+				zap.String("write", write.name))
+			b.sendErrorMessage(write.chatID, tr(write.ctx, errMsgBufferedLost))
+			continue
+		}
+		b.log(write.ctx).Info("Saved buffered write",
+			zap.String("write", write.name),
+			zap.Duration("delay", time.Since(write.queuedAt)))
+		b.sendMessage(write.chatID, tr(write.ctx, msgBufferedSaved))
+	}
+	return false
+}
+
+// bufferNote shows the classification of a note the database couldn't take
+// and buffers saving it. It returns false, having sent nothing, when the
+// buffer has no room.
+func (b *Bot) bufferNote(ctx context.Context, message *tgbotapi.Message, note *models.Message, response *classifier.GPTResponse) bool {
+	if !b.writes.hasRoom() {
+		return false
+	}
+
+	// Review buttons would point at a note that doesn't exist yet
+	sent, err := b.sendClassificationResponse(message.Chat.ID, message.MessageID, response, note.Source, b.userDisplayPrefs(ctx, message.From.ID), "")
+	replyID := 0
+	if err == nil {
+		replyID = sent.MessageID
+	}
+
+	save := func(ctx context.Context) error {
+		// Adding the category creates the user the note belongs to, as on
+		// the normal save path, so it has to come first
+		if err := b.storage.AddCategory(ctx, note.UserID, note.Category); err != nil {
+			if errors.Is(err, storage.ErrConnection) {
+				return err
+			}
+			b.log(ctx).Error("Failed to save category",
+				zap.Error(err),
+				zap.String("category", note.Category))
+		}
+		for _, tag := range note.Tags {
+			if err := b.storage.AddTag(ctx, note.UserID, tag); err != nil {
+				b.log(ctx).Error("Failed to save tag",
+					zap.Error(err),
+					zap.String("tag", tag))
+			}
+		}
+		// A save that reached the database before the connection broke
+		// is already done
+		if err := b.storage.SaveMessage(ctx, note); err != nil && !errors.Is(err, storage.ErrDuplicate) {
+			return err
+		}
+		if replyID != 0 {
+			if err := b.storage.SaveClassificationReply(ctx, message.Chat.ID, replyID, note.ID, message.MessageID); err != nil {
+				b.log(ctx).Error("Failed to save classification reply",
+					zap.Error(err),
+					zap.String("message_id", note.ID))
+			}
+		}
+		return nil
+	}
+	if !b.bufferWrite(ctx, message.Chat.ID, "note "+note.ID, save) {
+		metrics.MessagesProcessed.WithLabelValues(metrics.StatusFailed).Inc()
+		b.sendErrorMessage(message.Chat.ID, tr(ctx, errMsgSave))
+		return true
+	}
+
+	if response.Fallback {
+		metrics.MessagesProcessed.WithLabelValues(metrics.StatusFallback).Inc()
+	} else {
+		metrics.MessagesProcessed.WithLabelValues(metrics.StatusClassified).Inc()
+	}
+	b.sendMessage(message.Chat.ID, tr(ctx, msgBufferedNote))
+	return true
+}
@@ -0,0 +1,73 @@
+package classifier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/xaenox/memo-bot/internal/models"
+)
+
+const defaultBatchConcurrency = 4
+
+var errNoAnalysis = errors.New("no analysis returned")
+
+// ClassifyBatch analyzes contents with at most the configured number of
+// assistant runs in flight. The responses line up with contents; items that
+// were not analyzed are left empty and reported in the joined error.
+func (c *GPTClassifier) ClassifyBatch(ctx context.Context, contents []string, userID int64) ([]GPTResponse, error) {
+	return classifyBatch(ctx, contents, c.batchConcurrency, func(content string) GPTResponse {
+		return c.GetStructuredAnalysis(ctx, content, models.TextContent, userID)
+	})
+}
+
+// ClassifyBatch analyzes contents one after another; keyword matching is
+// cheap enough that concurrency would not pay off
+func (c *SimpleClassifier) ClassifyBatch(ctx context.Context, contents []string, userID int64) ([]GPTResponse, error) {
+	return classifyBatch(ctx, contents, 1, func(content string) GPTResponse {
+		return c.GetStructuredAnalysis(ctx, content, models.TextContent, userID)
+	})
+}
+
+// classifyBatch runs analyze over contents with bounded concurrency. Once ctx
+// is done no new items are started, and those are reported as failed.
+func classifyBatch(ctx context.Context, contents []string, concurrency int, analyze func(string) GPTResponse) ([]GPTResponse, error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	responses := make([]GPTResponse, len(contents))
+	itemErrs := make([]error, len(contents))
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	for i, content := range contents {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			itemErrs[i] = ctx.Err()
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, content string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			responses[i] = analyze(content)
+			if responses[i].Category == "" {
+				itemErrs[i] = errNoAnalysis
+			}
+		}(i, content)
+	}
+	wg.Wait()
+
+	var errs []error
+	for i, err := range itemErrs {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("item %d: %w", i+1, err))
+		}
+	}
+	return responses, errors.Join(errs...)
+}
@@ -0,0 +1,92 @@
+package classifier
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// tokenBudget caps the tokens assistant runs may use per window, in total and
+// per user. A zero limit is unlimited. A window is a UTC day; usage is only
+// counted in memory, so a restart also starts a new window.
+type tokenBudget struct {
+	mu         sync.Mutex
+	globalMax  int
+	userMax    int
+	globalUsed int
+	userUsed   map[int64]int
+	notified   map[int64]bool // users told about their budget this window
+}
+
+func newTokenBudget(globalMax, userMax int) *tokenBudget {
+	return &tokenBudget{
+		globalMax: globalMax,
+		userMax:   userMax,
+		userUsed:  make(map[int64]int),
+		notified:  make(map[int64]bool),
+	}
+}
+
+// exceeded reports whether userID may not start another run. notify is set
+// the first time in a window that their own budget turns them away.
+func (b *tokenBudget) exceeded(userID int64) (exceeded, notify bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.userMax > 0 && b.userUsed[userID] >= b.userMax {
+		notify = !b.notified[userID]
+		b.notified[userID] = true
+		return true, notify
+	}
+	return b.globalMax > 0 && b.globalUsed >= b.globalMax, false
+}
+
+// add counts tokens used by a run of userID
+func (b *tokenBudget) add(userID int64, tokens int) {
+	if tokens <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.globalUsed += tokens
+	b.userUsed[userID] += tokens
+}
+
+// reset starts a new window and returns the tokens used in the last one
+func (b *tokenBudget) reset() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	used := b.globalUsed
+	b.globalUsed = 0
+	b.userUsed = make(map[int64]int)
+	b.notified = make(map[int64]bool)
+	return used
+}
+
+// nextBudgetReset is when the window running at now ends: the next UTC
+// midnight
+func nextBudgetReset(now time.Time) time.Time {
+	year, month, day := now.UTC().Date()
+	return time.Date(year, month, day+1, 0, 0, 0, 0, time.UTC)
+}
+
+// RunBudgetReset starts a new token budget window at every UTC midnight
+// until ctx is cancelled
+func (c *GPTClassifier) RunBudgetReset(ctx context.Context) {
+	for {
+		timer := time.NewTimer(time.Until(nextBudgetReset(time.Now())))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+			used := c.budget.reset()
+			c.log(ctx).Info("Reset token budgets",
+				zap.Int("tokens_used", used))
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package classifier
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextBudgetReset(t *testing.T) {
+	berlin := time.FixedZone("CET", 60*60)
+	tests := []struct {
+		name string
+		now  time.Time
+		want time.Time
+	}{
+		{"midday", time.Date(2026, 3, 14, 12, 30, 0, 0, time.UTC), time.Date(2026, 3, 15, 0, 0, 0, 0, time.UTC)},
+		{"at midnight", time.Date(2026, 3, 14, 0, 0, 0, 0, time.UTC), time.Date(2026, 3, 15, 0, 0, 0, 0, time.UTC)},
+		{"end of month", time.Date(2026, 1, 31, 23, 59, 0, 0, time.UTC), time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)},
+		// 00:30 in Berlin is still the previous day in UTC
+		{"local zone", time.Date(2026, 3, 14, 0, 30, 0, 0, berlin), time.Date(2026, 3, 14, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		if got := nextBudgetReset(tt.now); !got.Equal(tt.want) {
+			t.Errorf("%s: nextBudgetReset(%v) = %v, want %v", tt.name, tt.now, got, tt.want)
+		}
+	}
+}
+
+func TestTokenBudget(t *testing.T) {
+	b := newTokenBudget(100, 30)
+
+	b.add(1, 30)
+	if exceeded, notify := b.exceeded(1); !exceeded || !notify {
+		t.Fatalf("user at their budget: exceeded %v, notify %v; want both", exceeded, notify)
+	}
+	if _, notify := b.exceeded(1); notify {
+		t.Error("user notified twice in one window")
+	}
+	if exceeded, _ := b.exceeded(2); exceeded {
+		t.Error("other user refused")
+	}
+
+	b.add(2, 70)
+	if exceeded, notify := b.exceeded(3); !exceeded || notify {
+		t.Errorf("global budget spent: exceeded %v, notify %v; want exceeded only", exceeded, notify)
+	}
+
+	if used := b.reset(); used != 100 {
+		t.Errorf("reset returned %d tokens, want 100", used)
+	}
+	if exceeded, _ := b.exceeded(1); exceeded {
+		t.Error("user still refused after reset")
+	}
+}
@@ -0,0 +1,133 @@
+package classifier
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xaenox/memo-bot/internal/models"
+	"github.com/xaenox/memo-bot/internal/storage"
+)
+
+const (
+	defaultCacheSize = 1000
+	defaultCacheTTL  = 24 * time.Hour
+)
+
+// responseCache is a size-bounded LRU of assistant responses keyed by
+// responseCacheKey. Entries are the responses as parsed and are shared
+// between users with the same run settings; the user's own tags and limits
+// are applied by callers after lookup.
+type responseCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	order   *list.List // front is most recently used
+	entries map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key      string
+	response GPTResponse
+	storedAt time.Time
+}
+
+// responseCacheKey identifies what a response depends on: the normalized
+// content, its type, which changes the instructions, and the user's allowed
+// categories, temperature and suggested tags, which change the run. The
+// assistant may answer with any tag it was shown, so a response is never
+// shared with users who weren't shown the same ones.
+func responseCacheKey(content string, contentType models.ContentType, allowedCategories, promptTags []string, temperature float64) string {
+	allowed := storage.NormalizeLabels(allowedCategories)
+	sort.Strings(allowed)
+	tags := storage.NormalizeLabels(promptTags)
+	sort.Strings(tags)
+	key := strings.Join([]string{
+		storage.ContentHash(content),
+		string(contentType),
+		strconv.FormatFloat(temperature, 'g', -1, 64),
+		strings.Join(allowed, ","),
+		strings.Join(tags, ","),
+	}, "\x00")
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func newResponseCache(size int, ttl time.Duration) *responseCache {
+	if size < 1 {
+		size = defaultCacheSize
+	}
+	if ttl <= 0 {
+		ttl = defaultCacheTTL
+	}
+	return &responseCache{
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// get returns a copy of the cached response for key if it hasn't expired.
+// A nil cache never hits.
+func (c *responseCache) get(key string) (GPTResponse, bool) {
+	if c == nil {
+		return GPTResponse{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return GPTResponse{}, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if time.Since(entry.storedAt) > c.ttl {
+		c.order.Remove(element)
+		delete(c.entries, key)
+		return GPTResponse{}, false
+	}
+
+	c.order.MoveToFront(element)
+	return copyResponse(entry.response), true
+}
+
+// put stores response under key, evicting the least recently used entry
+// when the cache is full. A nil cache ignores the call.
+func (c *responseCache) put(key string, response GPTResponse) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*cacheEntry)
+		entry.response = copyResponse(response)
+		entry.storedAt = time.Now()
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&cacheEntry{
+		key:      key,
+		response: copyResponse(response),
+		storedAt: time.Now(),
+	})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+func copyResponse(r GPTResponse) GPTResponse {
+	r.Keywords = append([]string(nil), r.Keywords...)
+	r.Links = append([]string(nil), r.Links...)
+	return r
+}
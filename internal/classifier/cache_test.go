@@ -0,0 +1,69 @@
+package classifier
+
+import (
+	"context"
+	"testing"
+
+	"github.com/xaenox/memo-bot/internal/models"
+	"github.com/xaenox/memo-bot/internal/storage"
+	"go.uber.org/zap"
+)
+
+func TestResponseCacheKey(t *testing.T) {
+	base := responseCacheKey("Buy milk", models.TextContent, []string{"shopping", "home"}, nil, 0.7)
+
+	same := []struct {
+		name string
+		key  string
+	}{
+		{"content spacing and case", responseCacheKey("  buy   MILK ", models.TextContent, []string{"shopping", "home"}, nil, 0.7)},
+		{"category order and spelling", responseCacheKey("Buy milk", models.TextContent, []string{"#Home", "Shopping"}, nil, 0.7)},
+		{"no suggested tags", responseCacheKey("Buy milk", models.TextContent, []string{"shopping", "home"}, []string{}, 0.7)},
+	}
+	for _, tt := range same {
+		if tt.key != base {
+			t.Errorf("%s: key changed", tt.name)
+		}
+	}
+
+	different := []struct {
+		name string
+		key  string
+	}{
+		{"content", responseCacheKey("Buy bread", models.TextContent, []string{"shopping", "home"}, nil, 0.7)},
+		{"content type", responseCacheKey("Buy milk", models.ImageContent, []string{"shopping", "home"}, nil, 0.7)},
+		{"allowed categories", responseCacheKey("Buy milk", models.TextContent, []string{"shopping"}, nil, 0.7)},
+		{"no allowed categories", responseCacheKey("Buy milk", models.TextContent, nil, nil, 0.7)},
+		{"temperature", responseCacheKey("Buy milk", models.TextContent, []string{"shopping", "home"}, nil, 0.2)},
+		{"suggested tags", responseCacheKey("Buy milk", models.TextContent, []string{"shopping", "home"}, []string{"groceries"}, 0.7)},
+	}
+	for _, tt := range different {
+		if tt.key == base {
+			t.Errorf("%s: key did not change", tt.name)
+		}
+	}
+}
+
+func TestCachedResponseIsFittedToUser(t *testing.T) {
+	ctx := context.Background()
+	const userID = 7
+	store := storage.NewMemoryStorage(storage.Limits{})
+	if err := store.SetAllowedCategories(ctx, userID, []string{"finance", "travel"}); err != nil {
+		t.Fatalf("SetAllowedCategories: %v", err)
+	}
+
+	c := NewGPTClassifier(GPTConfig{CacheEnabled: true}, store, zap.NewNop())
+	key := responseCacheKey("Flight to Rome", models.TextContent, []string{"finance", "travel"}, nil, c.temperature)
+	c.cache.put(key, GPTResponse{Category: "Work", Keywords: []string{"Flight", "#Rome"}, TokensUsed: 50})
+
+	response := c.GetStructuredAnalysis(ctx, "Flight to Rome", models.TextContent, userID)
+	if response.Category != OtherCategory {
+		t.Errorf("category = %q, want %q", response.Category, OtherCategory)
+	}
+	if len(response.Keywords) != 2 || response.Keywords[0] != "flight" || response.Keywords[1] != "rome" {
+		t.Errorf("keywords = %v, want [flight rome]", response.Keywords)
+	}
+	if response.TokensUsed != 0 {
+		t.Errorf("tokens used = %d, want 0 for a cached response", response.TokensUsed)
+	}
+}
@@ -0,0 +1,93 @@
+package classifier
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/xaenox/memo-bot/internal/storage"
+)
+
+// Suffixes stripped before comparing categories, so "finance", "finances"
+// and "financial" share a stem
+var categorySuffixes = []string{"ial", "ies", "al", "es", "s"}
+
+// OtherCategory is where notes go when a user's allowed categories don't
+// fit them
+const OtherCategory = "other"
+
+// Below this similarity an off-list category is filed under OtherCategory
+// rather than the closest allowed one
+const minAllowedSimilarity = 0.6
+
+// Stems shorter than this are left alone: "arts" is not "art" + "s" often
+// enough to be worth the risk
+const minCategoryStem = 4
+
+// MatchCategory finds the existing category most similar to category, if the
+// similarity reaches threshold. Similarity is 1 for the same stem and
+// otherwise one minus the edit distance relative to the longer name. A
+// threshold of 0 disables matching.
+func MatchCategory(category string, existing []string, threshold float64) (string, bool) {
+	if threshold <= 0 || category == "" {
+		return "", false
+	}
+
+	key := storage.NormalizeLabel(category)
+	best, bestScore := "", 0.0
+	for _, candidate := range existing {
+		candidateKey := storage.NormalizeLabel(candidate)
+		if candidateKey == key {
+			return candidate, true
+		}
+		if score := categorySimilarity(key, candidateKey); score >= threshold && score > bestScore {
+			best, bestScore = candidate, score
+		}
+	}
+	return best, best != ""
+}
+
+// ConstrainCategory maps category onto one of allowed: the same category,
+// the most similar one or, failing that, OtherCategory. An empty allowed list
+// accepts any category.
+func ConstrainCategory(category string, allowed []string) string {
+	if len(allowed) == 0 {
+		return category
+	}
+	if match, ok := MatchCategory(category, allowed, minAllowedSimilarity); ok {
+		return match
+	}
+	return OtherCategory
+}
+
+// categoryInstructions restrict the assistant to the user's allowed categories
+func categoryInstructions(allowed []string) string {
+	if len(allowed) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("\nThe category must be exactly one of: %s. If none of them fits, use %q.",
+		strings.Join(allowed, ", "), OtherCategory)
+}
+
+func categorySimilarity(a, b string) float64 {
+	if a == b || categoryStem(a) == categoryStem(b) {
+		return 1
+	}
+	longest := max(len([]rune(a)), len([]rune(b)))
+	if longest == 0 {
+		return 0
+	}
+	return 1 - float64(EditDistance(a, b))/float64(longest)
+}
+
+func categoryStem(key string) string {
+	for _, suffix := range categorySuffixes {
+		if stem, ok := strings.CutSuffix(key, suffix); ok && len([]rune(stem)) >= minCategoryStem {
+			key = stem
+			break
+		}
+	}
+	if stem, ok := strings.CutSuffix(key, "e"); ok && len([]rune(stem)) >= minCategoryStem {
+		key = stem
+	}
+	return key
+}
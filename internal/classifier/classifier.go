@@ -1,13 +1,40 @@
 package classifier
 
 import (
+	"context"
+	"sort"
 	"strings"
+
+	"github.com/xaenox/memo-bot/internal/models"
 )
 
+// Classifier turns message content into tags and a structured analysis
 type Classifier interface {
-	ClassifyContent(content string, userID int64) []string
+	ClassifyContent(ctx context.Context, content string, userID int64) []string
+	GetStructuredAnalysis(ctx context.Context, content string, contentType models.ContentType, userID int64) GPTResponse
+	// ClassifyBatch analyzes many contents at once, e.g. for imports
+	ClassifyBatch(ctx context.Context, contents []string, userID int64) ([]GPTResponse, error)
+	// ForgetUser deletes whatever the classifier keeps about a user
+	ForgetUser(ctx context.Context, userID int64) error
+}
+
+const simpleSummaryLen = 100
+
+// Categories SimpleClassifier recognizes and the keywords that imply them
+var keywordCategories = map[string][]string{
+	"work":      {"project", "meeting", "deadline", "task", "report"},
+	"personal":  {"family", "friend", "home", "birthday", "holiday"},
+	"shopping":  {"buy", "purchase", "store", "shop", "price"},
+	"education": {"study", "learn", "course", "book", "homework"},
+	"travel":    {"trip", "flight", "hotel", "vacation", "booking"},
 }
 
+// Both classifiers must stay interchangeable
+var (
+	_ Classifier = (*SimpleClassifier)(nil)
+	_ Classifier = (*GPTClassifier)(nil)
+)
+
 type SimpleClassifier struct {
 	minConfidence float64
 	maxTags       int
@@ -20,8 +47,13 @@ func NewSimpleClassifier(minConfidence float64, maxTags int) *SimpleClassifier {
 	}
 }
 
+// ForgetUser is a no-op; keyword matching keeps no per-user state
+func (c *SimpleClassifier) ForgetUser(ctx context.Context, userID int64) error {
+	return nil
+}
+
 // ClassifyContent Simple implementation that extracts hashtags and common keywords
-func (c *SimpleClassifier) ClassifyContent(content string, userID int64) []string {
+func (c *SimpleClassifier) ClassifyContent(ctx context.Context, content string, userID int64) []string {
 	words := strings.Fields(content)
 	tags := make(map[string]struct{})
 
@@ -36,16 +68,8 @@ func (c *SimpleClassifier) ClassifyContent(content string, userID int64) []strin
 	}
 
 	// Extract common categories based on keywords
-	categories := map[string][]string{
-		"work":      {"project", "meeting", "deadline", "task", "report"},
-		"personal":  {"family", "friend", "home", "birthday", "holiday"},
-		"shopping":  {"buy", "purchase", "store", "shop", "price"},
-		"education": {"study", "learn", "course", "book", "homework"},
-		"travel":    {"trip", "flight", "hotel", "vacation", "booking"},
-	}
-
 	content = strings.ToLower(content)
-	for category, keywords := range categories {
+	for category, keywords := range keywordCategories {
 		for _, keyword := range keywords {
 			if strings.Contains(content, keyword) {
 				tags[category] = struct{}{}
@@ -67,3 +91,40 @@ func (c *SimpleClassifier) ClassifyContent(content string, userID int64) []strin
 
 	return result
 }
+
+// GetStructuredAnalysis builds a response from keyword matching alone, so the
+// bot can run without an OpenAI account
+func (c *SimpleClassifier) GetStructuredAnalysis(ctx context.Context, content string, contentType models.ContentType, userID int64) GPTResponse {
+	tags := c.ClassifyContent(ctx, content, userID)
+	sort.Strings(tags)
+
+	// The first matching known category wins; everything else is a keyword
+	category := "general"
+	keywords := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if _, known := keywordCategories[tag]; known && category == "general" {
+			category = tag
+			continue
+		}
+		keywords = append(keywords, tag)
+	}
+
+	links := []string{}
+	for _, word := range strings.Fields(content) {
+		if strings.HasPrefix(word, "http://") || strings.HasPrefix(word, "https://") {
+			links = append(links, word)
+		}
+	}
+
+	summary := strings.Join(strings.Fields(content), " ")
+	if runes := []rune(summary); len(runes) > simpleSummaryLen {
+		summary = string(runes[:simpleSummaryLen]) + "…"
+	}
+
+	return GPTResponse{
+		Category: category,
+		Keywords: keywords,
+		Summary:  summary,
+		Links:    links,
+	}
+}
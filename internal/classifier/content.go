@@ -0,0 +1,54 @@
+package classifier
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/xaenox/memo-bot/internal/models"
+)
+
+// Instructions added to a run for notes that aren't plain text, so tags and
+// summaries fit what was actually saved
+var contentTypeInstructions = map[models.ContentType]string{
+	models.ImageContent: "The message describes a photo, usually by its caption. " +
+		"Classify what the photo shows or why it was kept, not the wording of the caption.",
+	models.DocumentContent: "The message is a saved document. Its file name and type are strong hints " +
+		"for the category and tags; mention the document in the summary.",
+	models.VideoContent: "The message describes a video, usually by its caption. " +
+		"Classify what the video is about.",
+}
+
+// attachmentInstructions ask for attachments_analysis on media notes
+const attachmentInstructions = "Describe the attachment itself in attachments_analysis: what kind of file it is, " +
+	"what it most likely contains and anything its name, type or size suggest."
+
+const linkInstructions = "The message is mainly a link. You can't open it, so infer the page's topic " +
+	"from the domain, the path and any text around the link, and summarize what the page is likely about."
+
+var urlPattern = regexp.MustCompile(`https?://[^\s<>]+`)
+
+// Text messages with at most this much besides their URLs count as links
+const maxLinkCommentChars = 80
+
+// contentInstructions returns the instructions for the kind of content being
+// classified, or "" for ordinary text
+func contentInstructions(content string, contentType models.ContentType) string {
+	if instructions, ok := contentTypeInstructions[contentType]; ok {
+		return "\n" + instructions + " " + attachmentInstructions
+	}
+	if isLink(content) {
+		return "\n" + linkInstructions
+	}
+	return ""
+}
+
+// isLink reports whether content is a shared link rather than text that
+// happens to contain one
+func isLink(content string) bool {
+	if !urlPattern.MatchString(content) {
+		return false
+	}
+	comment := strings.TrimSpace(urlPattern.ReplaceAllString(content, ""))
+	return utf8.RuneCountInString(comment) <= maxLinkCommentChars
+}
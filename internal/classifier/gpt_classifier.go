@@ -2,15 +2,22 @@ package classifier
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/xaenox/memo-bot/internal/logging"
+	"github.com/xaenox/memo-bot/internal/metrics"
 	"github.com/xaenox/memo-bot/internal/models"
 	"github.com/xaenox/memo-bot/internal/storage"
+	"github.com/xaenox/memo-bot/internal/tracing"
+	"net/http"
 	"strings"
-	"sync"
 	"time"
+	"unicode/utf8"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sashabaranov/go-openai"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
@@ -20,39 +27,202 @@ type GPTResponse struct {
 	Summary             string   `json:"summary"`
 	AttachmentsAnalysis string   `json:"attachments_analysis"`
 	Links               []string `json:"links"`
+	// Confidence is the assistant's own estimate between 0 and 1; 0 means
+	// it gave none
+	Confidence float64 `json:"confidence"`
+
+	// Fallback is set when the response was produced without the assistant
+	Fallback bool `json:"-"`
+	// TokensUsed is the total token usage reported for the run
+	TokensUsed int `json:"-"`
+	// OverBudget is set on the first fallback response given because the
+	// user's token budget for the window ran out
+	OverBudget bool `json:"-"`
 }
 
+// GPTConfig holds the OpenAI settings used by GPTClassifier
+type GPTConfig struct {
+	APIKey      string
+	AssistantID string
+	Model       string
+	MaxTokens   int
+	Temperature float64
+	MaxTags     int
+
+	// BaseURL replaces the OpenAI endpoint, e.g. for a proxy or gateway; empty
+	// keeps the default. With Azure set it is the Azure OpenAI resource
+	// endpoint and APIVersion, when set, the API version to request.
+	BaseURL    string
+	Azure      bool
+	APIVersion string
+
+	// RetryAttempts is how many times a transient OpenAI failure is tried
+	// in total, RetryBaseDelay the wait before the first retry
+	RetryAttempts  int
+	RetryBaseDelay time.Duration
+	// Timeout bounds a whole analysis, including retries and run polling
+	Timeout time.Duration
+
+	// CacheEnabled reuses responses for identical content for up to
+	// CacheTTL, keeping at most CacheSize entries
+	CacheEnabled bool
+	CacheSize    int
+	CacheTTL     time.Duration
+
+	// BatchConcurrency bounds the assistant runs ClassifyBatch has in flight
+	BatchConcurrency int
+
+	// Instructions, when set, override the assistant's instructions on each run
+	Instructions string
+
+	// Models are tried in order when a model can't serve a run; empty keeps
+	// the assistant's model
+	Models []string
+
+	// MaxInputChars truncates longer content before it is sent; zero
+	// disables truncation
+	MaxInputChars int
+
+	// PreferExistingTags asks the assistant to reuse the user's tags and
+	// snaps near-miss keywords onto them
+	PreferExistingTags bool
+
+	// PollInterval is the first wait between run status checks. It grows
+	// with each check up to PollMaxInterval.
+	PollInterval    time.Duration
+	PollMaxInterval time.Duration
+
+	// Fallback analyzes content when the assistant can't; nil always
+	// answers with a static response
+	Fallback Classifier
+
+	// DailyTokenBudget and UserDailyTokenBudget cap the tokens used per UTC
+	// day in total and per user; once spent, content goes to Fallback. Zero
+	// is unlimited.
+	DailyTokenBudget     int
+	UserDailyTokenBudget int
+}
+
+const defaultAnalysisTimeout = 60 * time.Second
+
+// clientConfig builds the OpenAI client settings for the configured endpoint
+func clientConfig(cfg GPTConfig) openai.ClientConfig {
+	if cfg.Azure {
+		config := openai.DefaultAzureConfig(cfg.APIKey, cfg.BaseURL)
+		if cfg.APIVersion != "" {
+			config.APIVersion = cfg.APIVersion
+		}
+		return config
+	}
+	config := openai.DefaultConfig(cfg.APIKey)
+	if cfg.BaseURL != "" {
+		config.BaseURL = cfg.BaseURL
+	}
+	return config
+}
+
+const (
+	defaultPollInterval    = 300 * time.Millisecond
+	defaultPollMaxInterval = 2 * time.Second
+	// Each wait for a run is this much longer than the previous one
+	pollBackoffFactor = 1.5
+)
+
+// Appended to every run so the assistant rates itself whatever its own
+// instructions say
+const confidenceInstructions = `Also include a "confidence" field in the JSON: a number between 0 and 1 ` +
+	`saying how sure you are that the category fits the message.`
+
 type GPTClassifier struct {
-	client      *openai.Client
-	assistantID string
-	model       string
-	maxTokens   int
-	temperature float64
-	maxTags     int
-	logger      *zap.Logger
-	threads     map[int64]string // In-memory cache
-	threadMutex sync.RWMutex
-	storage     storage.ThreadStorage // Interface from storage package
+	client             *openai.Client
+	assistantID        string
+	model              string
+	maxTokens          int
+	temperature        float64
+	maxTags            int
+	retryAttempts      int
+	retryBaseDelay     time.Duration
+	timeout            time.Duration
+	cache              *responseCache // nil when caching is disabled
+	batchConcurrency   int
+	instructions       string
+	models             []string
+	maxInputChars      int
+	preferExistingTags bool
+	pollInterval       time.Duration
+	pollMaxInterval    time.Duration
+	fallback           Classifier
+	budget             *tokenBudget
+	logger             *zap.Logger
+	storage            Store
 }
 
-func NewGPTClassifier(apiKey string, assistantID string, model string, maxTokens int, temperature float64, maxTags int, storage storage.ThreadStorage, logger *zap.Logger) *GPTClassifier {
+// Store is the persistence the GPT classifier needs: assistant threads and
+// the tags users already have
+type Store interface {
+	storage.ThreadStorage
+	GetUserTags(ctx context.Context, userID int64) ([]string, error)
+	GetUser(ctx context.Context, id int64) (*models.User, error)
+}
+
+func NewGPTClassifier(cfg GPTConfig, storage Store, logger *zap.Logger) *GPTClassifier {
+	if cfg.RetryAttempts < 1 {
+		cfg.RetryAttempts = defaultRetryAttempts
+	}
+	if cfg.RetryBaseDelay <= 0 {
+		cfg.RetryBaseDelay = defaultRetryBaseDelay
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultAnalysisTimeout
+	}
+	if cfg.BatchConcurrency < 1 {
+		cfg.BatchConcurrency = defaultBatchConcurrency
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = defaultPollInterval
+	}
+	if cfg.PollMaxInterval < cfg.PollInterval {
+		cfg.PollMaxInterval = max(defaultPollMaxInterval, cfg.PollInterval)
+	}
+
+	var cache *responseCache
+	if cfg.CacheEnabled {
+		cache = newResponseCache(cfg.CacheSize, cfg.CacheTTL)
+	}
+
 	return &GPTClassifier{
-		client:      openai.NewClient(apiKey),
-		assistantID: assistantID,
-		model:       model,
-		maxTokens:   maxTokens,
-		temperature: temperature,
-		maxTags:     maxTags,
-		logger:      logger,
-		threads:     make(map[int64]string),
-		threadMutex: sync.RWMutex{},
-		storage:     storage,
+		client:             openai.NewClientWithConfig(clientConfig(cfg)),
+		assistantID:        cfg.AssistantID,
+		model:              cfg.Model,
+		maxTokens:          cfg.MaxTokens,
+		temperature:        cfg.Temperature,
+		maxTags:            cfg.MaxTags,
+		retryAttempts:      cfg.RetryAttempts,
+		retryBaseDelay:     cfg.RetryBaseDelay,
+		timeout:            cfg.Timeout,
+		cache:              cache,
+		batchConcurrency:   cfg.BatchConcurrency,
+		instructions:       cfg.Instructions,
+		models:             cfg.Models,
+		maxInputChars:      cfg.MaxInputChars,
+		preferExistingTags: cfg.PreferExistingTags,
+		pollInterval:       cfg.PollInterval,
+		pollMaxInterval:    cfg.PollMaxInterval,
+		fallback:           cfg.Fallback,
+		budget:             newTokenBudget(cfg.DailyTokenBudget, cfg.UserDailyTokenBudget),
+		logger:             logger,
+		storage:            storage,
 	}
 }
 
-func (c *GPTClassifier) ClassifyContent(content string, userID int64) []string {
+// log returns the logger of the request being handled in ctx
+func (c *GPTClassifier) log(ctx context.Context) *zap.Logger {
+	return logging.FromContext(ctx, c.logger)
+}
+
+func (c *GPTClassifier) ClassifyContent(ctx context.Context, content string, userID int64) []string {
 	// Get the structured analysis
-	analysis := c.GetStructuredAnalysis(content, userID)
+	analysis := c.GetStructuredAnalysis(ctx, content, models.TextContent, userID)
 
 	// Combine category and keywords for tags
 	tags := make([]string, 0, len(analysis.Keywords)+1)
@@ -67,203 +237,214 @@ func (c *GPTClassifier) ClassifyContent(content string, userID int64) []string {
 	return tags
 }
 
-// Fallback to simple classification if GPT fails
-func (c *GPTClassifier) fallbackClassification(content string, userID int64) []string {
-	simpleClassifier := NewSimpleClassifier(0.7, c.maxTags)
-	return simpleClassifier.ClassifyContent(content, userID)
+// ValidateAssistant checks that the configured assistant exists and can be
+// used with the API key. Without it every run fails and all notes are
+// answered by the fallback.
+func (c *GPTClassifier) ValidateAssistant(ctx context.Context) error {
+	if c.assistantID == "" {
+		return errors.New("no assistant ID is configured")
+	}
+	_, err := withRetry(ctx, c, "RetrieveAssistant", func() (openai.Assistant, error) {
+		return c.client.RetrieveAssistant(ctx, c.assistantID)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to retrieve assistant %s: %w", c.assistantID, err)
+	}
+	return nil
 }
-func (c *GPTClassifier) getOrCreateThread(ctx context.Context, userID int64) (string, error) {
-	// First check in-memory cache
-	c.threadMutex.RLock()
-	threadID, exists := c.threads[userID]
-	c.threadMutex.RUnlock()
-
-	if exists {
-		// Verify thread still exists and is valid
-		_, err := c.client.RetrieveThread(ctx, threadID)
-		if err == nil {
-			// Update last used timestamp
-			if err := c.storage.UpdateThreadLastUsed(ctx, userID); err != nil {
-				c.logger.Warn("Failed to update thread last used timestamp",
-					zap.Error(err),
-					zap.Int64("user_id", userID))
-			}
-			return threadID, nil
-		}
-		// Thread doesn't exist anymore, remove it from both cache and storage
-		c.threadMutex.Lock()
-		delete(c.threads, userID)
-		c.threadMutex.Unlock()
 
-		if err := c.storage.DeleteThread(ctx, userID); err != nil {
-			c.logger.Error("Failed to delete invalid thread from storage",
-				zap.Error(err),
-				zap.Int64("user_id", userID))
-		}
+// ForgetUser deletes the assistant thread stored for the user, if any, from
+// OpenAI and storage. Notes are analyzed in threads of their own, so only
+// threads kept by earlier versions are stored.
+func (c *GPTClassifier) ForgetUser(ctx context.Context, userID int64) error {
+	thread, err := c.storage.GetThread(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("failed to get thread: %w", err)
+	}
+	if thread == nil {
+		return nil
 	}
 
-	// Check storage if not in cache
-	if !exists {
-		storedThread, err := c.storage.GetThread(ctx, userID)
-		if err != nil {
-			c.logger.Error("Failed to get thread from storage",
-				zap.Error(err),
-				zap.Int64("user_id", userID))
-		} else if storedThread.ID != "" {
-			// Verify stored thread is still valid
-			_, err := c.client.RetrieveThread(ctx, storedThread.ID)
-			if err == nil {
-				// Update cache and last used timestamp
-				c.threadMutex.Lock()
-				c.threads[userID] = storedThread.ID
-				c.threadMutex.Unlock()
-
-				if err := c.storage.UpdateThreadLastUsed(ctx, userID); err != nil {
-					c.logger.Warn("Failed to update thread last used timestamp",
-						zap.Error(err),
-						zap.Int64("user_id", userID))
-				}
-				return storedThread.ID, nil
-			}
-			// Thread invalid, delete from storage
-			if err := c.storage.DeleteThread(ctx, userID); err != nil {
-				c.logger.Error("Failed to delete invalid thread from storage",
-					zap.Error(err),
-					zap.Int64("user_id", userID))
-			}
-		}
+	if err := c.deleteRemoteThread(ctx, thread.ID); err != nil {
+		return fmt.Errorf("failed to delete thread %s: %w", thread.ID, err)
 	}
+	if err := c.storage.DeleteThread(ctx, userID); err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return fmt.Errorf("failed to delete stored thread: %w", err)
+	}
+	return nil
+}
 
-	// Create new thread
-	thread, err := c.client.CreateThread(ctx, openai.ThreadRequest{})
-	if err != nil {
-		return "", fmt.Errorf("failed to create thread: %w", err)
+// deleteRemoteThread deletes a thread from OpenAI. A thread OpenAI no longer
+// knows about is already gone, so that is not an error.
+func (c *GPTClassifier) deleteRemoteThread(ctx context.Context, threadID string) error {
+	_, err := withRetry(ctx, c, "DeleteThread", func() (openai.ThreadDeleteResponse, error) {
+		return c.client.DeleteThread(ctx, threadID)
+	})
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) && apiErr.HTTPStatusCode == http.StatusNotFound {
+		return nil
 	}
+	return err
+}
 
-	// Store in both cache and persistent storage
-	c.threadMutex.Lock()
-	c.threads[userID] = thread.ID
-	c.threadMutex.Unlock()
+// threadDeleteTimeout bounds deleting an analysis thread, which also happens
+// after the analysis itself ran out of time
+const threadDeleteTimeout = 10 * time.Second
 
-	threadModel := &models.Thread{
-		ID:         thread.ID,
-		UserID:     userID,
-		CreatedAt:  time.Now(),
-		LastUsedAt: time.Now(),
-	}
-	if err := c.storage.SaveThread(ctx, threadModel); err != nil {
-		c.logger.Error("Failed to save thread to storage",
+// deleteAnalysisThread deletes the thread an analysis ran in
+func (c *GPTClassifier) deleteAnalysisThread(ctx context.Context, threadID string, userID int64) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), threadDeleteTimeout)
+	defer cancel()
+	if err := c.deleteRemoteThread(ctx, threadID); err != nil {
+		c.log(ctx).Warn("Failed to delete thread",
 			zap.Error(err),
+			zap.String("thread_id", threadID),
+			zap.Int64("user_id", userID))
+	}
+}
+
+// GetStructuredAnalysis runs the assistant on content. Cancelling ctx stops
+// waiting for OpenAI and returns the fallback response.
+func (c *GPTClassifier) GetStructuredAnalysis(ctx context.Context, content string, contentType models.ContentType, userID int64) GPTResponse {
+	ctx, span := tracing.Start(ctx, "classifier.GetStructuredAnalysis",
+		attribute.Int64("user_id", userID),
+		attribute.String("content_type", string(contentType)))
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	existingTags := c.userTags(ctx, userID)
+	allowedCategories, temperature := c.userRunSettings(ctx, userID)
+
+	cacheKey := responseCacheKey(content, contentType, allowedCategories, suggestedTags(existingTags), temperature)
+	if cached, ok := c.cache.get(cacheKey); ok {
+		c.log(ctx).Info("Using cached GPT analysis",
 			zap.Int64("user_id", userID),
-			zap.String("thread_id", thread.ID))
+			zap.String("cache_key", cacheKey))
+		span.SetAttributes(attribute.Bool("classifier.cached", true))
+		// Nothing was spent on this request
+		cached.TokensUsed = 0
+		// Cached results may come from another user's note
+		return c.finishResponse(ctx, cached, existingTags, allowedCategories, userID)
 	}
 
-	return thread.ID, nil
-}
+	if exceeded, notify := c.budget.exceeded(userID); exceeded {
+		c.log(ctx).Warn("Token budget exceeded, skipping the assistant",
+			zap.Int64("user_id", userID))
+		metrics.BudgetRefusals.Inc()
+		response := c.fallbackResponse(ctx, content, contentType, userID)
+		response.OverBudget = notify
+		return response
+	}
 
-func (c *GPTClassifier) GetStructuredAnalysis(content string, userID int64) GPTResponse {
-	ctx := context.Background()
+	timer := prometheus.NewTimer(metrics.ClassificationDuration)
+	defer timer.ObserveDuration()
 
-	// Log the initial request
-	c.logger.Info("Starting GPT analysis",
+	// Log the initial request; notes may be private, so not their text
+	c.log(ctx).Info("Starting GPT analysis",
 		zap.Int64("user_id", userID),
-		zap.String("content", content))
+		zap.Int("length", utf8.RuneCountInString(content)))
 
-	// Create a thread
-	thread, err := c.client.CreateThread(ctx, openai.ThreadRequest{})
-	if err != nil {
-		c.logger.Error("Failed to create thread",
-			zap.Error(err),
+	prompt, truncated := truncateInput(content, c.maxInputChars)
+	if truncated {
+		c.log(ctx).Warn("Truncated long content before analysis",
+			zap.Int("length", utf8.RuneCountInString(content)),
+			zap.Int("max_input_chars", c.maxInputChars),
 			zap.Int64("user_id", userID))
-		return c.fallbackResponse(content)
 	}
-	c.logger.Debug("Created thread",
-		zap.String("thread_id", thread.ID),
-		zap.Int64("user_id", userID))
 
-	// Add a message to the thread
-	message, err := c.client.CreateMessage(ctx, thread.ID, openai.MessageRequest{
-		Role:    "user",
-		Content: content,
+	// Create a thread
+	thread, err := withRetryIfRejected(ctx, c, "CreateThread", func() (openai.Thread, error) {
+		return c.client.CreateThread(ctx, openai.ThreadRequest{})
 	})
 	if err != nil {
-		c.logger.Error("Failed to create message",
+		c.log(ctx).Error("Failed to create thread",
 			zap.Error(err),
-			zap.String("thread_id", thread.ID),
 			zap.Int64("user_id", userID))
-		return c.fallbackResponse(content)
+		return c.fallbackResponse(ctx, content, contentType, userID)
 	}
-	c.logger.Debug("Created message",
-		zap.String("message_id", message.ID),
+	c.log(ctx).Debug("Created thread",
 		zap.String("thread_id", thread.ID),
 		zap.Int64("user_id", userID))
+	span.SetAttributes(attribute.String("thread_id", thread.ID))
+	// The thread is only needed for this analysis, however it ends
+	defer c.deleteAnalysisThread(ctx, thread.ID, userID)
 
-	// Run the assistant
-	run, err := c.client.CreateRun(ctx, thread.ID, openai.RunRequest{
-		AssistantID: c.assistantID,
+	// Add a message to the thread
+	message, err := withRetryIfRejected(ctx, c, "CreateMessage", func() (openai.Message, error) {
+		return c.client.CreateMessage(ctx, thread.ID, openai.MessageRequest{
+			Role:    "user",
+			Content: prompt,
+		})
 	})
 	if err != nil {
-		c.logger.Error("Failed to create run",
+		c.log(ctx).Error("Failed to create message",
 			zap.Error(err),
 			zap.String("thread_id", thread.ID),
-			zap.String("assistant_id", c.assistantID),
 			zap.Int64("user_id", userID))
-		return c.fallbackResponse(content)
+		return c.fallbackResponse(ctx, content, contentType, userID)
 	}
-	c.logger.Debug("Created run",
-		zap.String("run_id", run.ID),
+	c.log(ctx).Debug("Created message",
+		zap.String("message_id", message.ID),
 		zap.String("thread_id", thread.ID),
 		zap.Int64("user_id", userID))
 
-	// Poll for completion
+	// Run the assistant, moving on to the next model when one can't serve it
+	var run openai.Run
 	startTime := time.Now()
-	for {
-		run, err = c.client.RetrieveRun(ctx, thread.ID, run.ID)
-		if err != nil {
-			c.logger.Error("Failed to retrieve run",
-				zap.Error(err),
-				zap.String("run_id", run.ID),
-				zap.String("thread_id", thread.ID),
-				zap.Int64("user_id", userID))
-			return c.fallbackResponse(content)
-		}
-
-		if run.Status == "completed" {
-			c.logger.Debug("Run completed",
-				zap.String("run_id", run.ID),
-				zap.Duration("duration", time.Since(startTime)),
-				zap.Int64("user_id", userID))
+	models := c.runModels()
+	additionalInstructions := runInstructions(existingTags, allowedCategories) + contentInstructions(content, contentType)
+	for i, model := range models {
+		runCtx, runSpan := tracing.Start(ctx, "classifier.runAssistant",
+			attribute.String("thread_id", thread.ID),
+			attribute.String("model", model))
+		run, err = c.runAssistant(runCtx, thread.ID, model, additionalInstructions, temperature, userID)
+		runSpan.SetAttributes(attribute.String("run_id", run.ID),
+			attribute.String("run_status", string(run.Status)))
+		tracing.End(runSpan, err)
+		if err == nil {
 			break
 		}
-
-		if run.Status == "failed" || run.Status == "expired" || run.Status == "cancelled" {
-			c.logger.Error("Run failed",
-				zap.String("status", string(run.Status)),
-				zap.String("run_id", run.ID),
+		if errors.Is(err, errModelUnavailable) && i < len(models)-1 {
+			c.log(ctx).Warn("Model unavailable, trying the next one",
+				zap.Error(err),
+				zap.String("model", model),
+				zap.String("next_model", models[i+1]),
 				zap.String("thread_id", thread.ID),
 				zap.Int64("user_id", userID))
-			return c.fallbackResponse(content)
+			continue
 		}
-
-		time.Sleep(500 * time.Millisecond)
+		c.log(ctx).Error("Assistant run failed",
+			zap.Error(err),
+			zap.String("model", model),
+			zap.String("thread_id", thread.ID),
+			zap.String("assistant_id", c.assistantID),
+			zap.Int64("user_id", userID))
+		return c.fallbackResponse(ctx, content, contentType, userID)
 	}
+	c.budget.add(userID, run.Usage.TotalTokens)
+	span.SetAttributes(attribute.String("model", run.Model),
+		attribute.Int("tokens_used", run.Usage.TotalTokens))
 
 	// Get the messages
-	messages, err := c.client.ListMessage(ctx, thread.ID, nil, nil, nil, nil, nil)
+	messages, err := withRetry(ctx, c, "ListMessage", func() (openai.MessagesList, error) {
+		return c.client.ListMessage(ctx, thread.ID, nil, nil, nil, nil, nil)
+	})
 	if err != nil {
-		c.logger.Error("Failed to list messages",
+		c.log(ctx).Error("Failed to list messages",
 			zap.Error(err),
 			zap.String("thread_id", thread.ID),
 			zap.Int64("user_id", userID))
-		return c.fallbackResponse(content)
+		return c.fallbackResponse(ctx, content, contentType, userID)
 	}
 
 	// Get the last assistant message
 	var lastAssistantMessage string
 	for _, msg := range messages.Messages {
 		if msg.Role == "assistant" {
-			lastAssistantMessage = msg.Content[0].Text.Value
-			c.logger.Debug("Received assistant response",
+			lastAssistantMessage = messageText(msg)
+			c.log(ctx).Debug("Received assistant response",
 				zap.String("message_id", msg.ID),
 				zap.String("thread_id", thread.ID),
 				zap.String("response", lastAssistantMessage),
@@ -273,46 +454,170 @@ func (c *GPTClassifier) GetStructuredAnalysis(content string, userID int64) GPTR
 	}
 
 	if lastAssistantMessage == "" {
-		c.logger.Error("No assistant response found",
+		c.log(ctx).Error("No assistant response found",
 			zap.String("thread_id", thread.ID),
 			zap.Int64("user_id", userID))
-		return c.fallbackResponse(content)
+		return c.fallbackResponse(ctx, content, contentType, userID)
 	}
 
 	// Parse the response
-	var gptResponse GPTResponse
-	if err := json.Unmarshal([]byte(lastAssistantMessage), &gptResponse); err != nil {
-		c.logger.Error("Failed to parse assistant response",
+	gptResponse, repaired, err := parseResponse(lastAssistantMessage)
+	if err != nil {
+		c.log(ctx).Error("Failed to parse assistant response",
 			zap.Error(err),
 			zap.String("response", lastAssistantMessage),
 			zap.String("thread_id", thread.ID),
 			zap.Int64("user_id", userID))
-		return c.fallbackResponse(content)
+		return c.fallbackResponse(ctx, content, contentType, userID)
 	}
-
-	c.logger.Info("Successfully completed GPT analysis",
-		zap.Any("response", gptResponse),
+	if repaired {
+		// The assistant should answer with bare JSON; watch this to tune the prompt
+		c.log(ctx).Warn("Repaired malformed assistant response",
+			zap.String("response", lastAssistantMessage),
+			zap.String("thread_id", thread.ID),
+			zap.Int64("user_id", userID))
+	}
+	gptResponse.TokensUsed = run.Usage.TotalTokens
+	// Cache the response as parsed; fitting it to the user is redone on hits
+	c.cache.put(cacheKey, gptResponse)
+	gptResponse = c.finishResponse(ctx, gptResponse, existingTags, allowedCategories, userID)
+
+	c.log(ctx).Info("Successfully completed GPT analysis",
+		zap.String("category", gptResponse.Category),
+		zap.Int("keywords", len(gptResponse.Keywords)),
+		zap.Int("tokens_used", gptResponse.TokensUsed),
+		zap.String("model", run.Model),
 		zap.String("thread_id", thread.ID),
 		zap.Duration("total_duration", time.Since(startTime)),
 		zap.Int64("user_id", userID))
 
-	// Clean up the thread
-	_, err = c.client.DeleteThread(ctx, thread.ID)
-	if err != nil {
-		c.logger.Warn("Failed to delete thread",
-			zap.Error(err),
-			zap.String("thread_id", thread.ID),
+	return gptResponse
+}
+
+// finishResponse fits a response to the user: labels normalized the way they
+// are stored, tags snapped to the user's own and the category kept within
+// their allowed ones
+func (c *GPTClassifier) finishResponse(ctx context.Context, response GPTResponse, existingTags, allowedCategories []string, userID int64) GPTResponse {
+	// Labels are stored normalized; the reply should show the same names
+	response.Category = storage.NormalizeLabel(response.Category)
+	response.Keywords = storage.NormalizeLabels(snapTags(response.Keywords, existingTags))
+	if category := ConstrainCategory(response.Category, allowedCategories); category != response.Category {
+		c.log(ctx).Info("Assistant chose a category outside the user's taxonomy",
+			zap.String("category", response.Category),
+			zap.String("replacement", category),
 			zap.Int64("user_id", userID))
+		response.Category = category
 	}
+	return response
+}
 
-	return gptResponse
+// runModels lists the models to try in order. An empty name runs with the
+// assistant's own model.
+func (c *GPTClassifier) runModels() []string {
+	if len(c.models) == 0 {
+		return []string{""}
+	}
+	return c.models
+}
+
+// runAssistant starts a run on the thread with model and waits for it to
+// complete. Failures caused by the model itself wrap errModelUnavailable.
+func (c *GPTClassifier) runAssistant(ctx context.Context, threadID, model, additionalInstructions string, temperature float64, userID int64) (openai.Run, error) {
+	runTemperature := float32(temperature)
+	run, err := withRetryIfRejected(ctx, c, "CreateRun", func() (openai.Run, error) {
+		return c.client.CreateRun(ctx, threadID, openai.RunRequest{
+			AssistantID:            c.assistantID,
+			Model:                  model,
+			Instructions:           c.instructions,
+			AdditionalInstructions: additionalInstructions,
+			Temperature:            &runTemperature,
+		})
+	})
+	if err != nil {
+		if isModelError(err) {
+			return run, fmt.Errorf("%w: %v", errModelUnavailable, err)
+		}
+		return run, fmt.Errorf("failed to create run: %w", err)
+	}
+	c.log(ctx).Debug("Created run",
+		zap.String("run_id", run.ID),
+		zap.String("model", run.Model),
+		zap.String("thread_id", threadID),
+		zap.Int64("user_id", userID))
+
+	// Poll for completion, often at first and less so as the run drags on
+	startTime := time.Now()
+	interval := c.pollInterval
+	for {
+		runID := run.ID
+		run, err = withRetry(ctx, c, "RetrieveRun", func() (openai.Run, error) {
+			return c.client.RetrieveRun(ctx, threadID, runID)
+		})
+		if err != nil {
+			return run, fmt.Errorf("failed to retrieve run %s: %w", runID, err)
+		}
+
+		switch run.Status {
+		case openai.RunStatusCompleted:
+			c.log(ctx).Debug("Run completed",
+				zap.String("run_id", run.ID),
+				zap.String("model", run.Model),
+				zap.Duration("duration", time.Since(startTime)),
+				zap.Int64("user_id", userID))
+			return run, nil
+		case openai.RunStatusFailed, openai.RunStatusIncomplete, openai.RunStatusExpired, openai.RunStatusCancelled:
+			err := fmt.Errorf("run %s ended with status %s", run.ID, run.Status)
+			if run.LastError != nil {
+				err = fmt.Errorf("%w: %s: %s", err, run.LastError.Code, run.LastError.Message)
+			}
+			if isModelRunFailure(run) {
+				return run, fmt.Errorf("%w: %v", errModelUnavailable, err)
+			}
+			return run, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return run, fmt.Errorf("run %s did not finish within %s: %w", run.ID, c.timeout, ctx.Err())
+		case <-time.After(interval):
+		}
+		interval = min(time.Duration(float64(interval)*pollBackoffFactor), c.pollMaxInterval)
+	}
+}
+
+// messageText joins the text parts of an assistant message, skipping images
+// and any other non-text content
+func messageText(msg openai.Message) string {
+	var parts []string
+	for _, content := range msg.Content {
+		if content.Text == nil {
+			continue
+		}
+		if text := strings.TrimSpace(content.Text.Value); text != "" {
+			parts = append(parts, text)
+		}
+	}
+	return strings.Join(parts, "\n")
 }
 
-func (c *GPTClassifier) fallbackResponse(content string) GPTResponse {
+// fallbackResponse makes a best effort at content when the assistant failed.
+// The static response is used only when the fallback classifier recognizes
+// nothing either.
+func (c *GPTClassifier) fallbackResponse(ctx context.Context, content string, contentType models.ContentType, userID int64) GPTResponse {
+	metrics.Fallbacks.Inc()
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Bool("classifier.fallback", true))
+	if c.fallback != nil {
+		response := c.fallback.GetStructuredAnalysis(ctx, content, contentType, userID)
+		if response.Category != "" && (response.Category != "general" || len(response.Keywords) > 0) {
+			response.Fallback = true
+			return response
+		}
+	}
 	return GPTResponse{
 		Category: "general",
 		Keywords: []string{"unclassified"},
 		Summary:  "I received your message but I'm having trouble analyzing it right now.",
 		Links:    []string{},
+		Fallback: true,
 	}
 }
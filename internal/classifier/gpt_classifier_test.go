@@ -0,0 +1,34 @@
+package classifier
+
+import (
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestMessageText(t *testing.T) {
+	text := func(value string) openai.MessageContent {
+		return openai.MessageContent{Type: "text", Text: &openai.MessageText{Value: value}}
+	}
+	image := openai.MessageContent{Type: "image_file", ImageFile: &openai.ImageFile{FileID: "file-1"}}
+
+	tests := []struct {
+		name    string
+		content []openai.MessageContent
+		want    string
+	}{
+		{"no parts", nil, ""},
+		{"only an image", []openai.MessageContent{image}, ""},
+		{"blank text", []openai.MessageContent{text("  \n ")}, ""},
+		{"one part", []openai.MessageContent{text(`{"category": "work"}`)}, `{"category": "work"}`},
+		{"several parts", []openai.MessageContent{text(`{"category":`), text(` "work"}`)}, "{\"category\":\n\"work\"}"},
+		{"text around an image", []openai.MessageContent{text("first"), image, text(" second ")}, "first\nsecond"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := messageText(openai.Message{Content: tt.content}); got != tt.want {
+				t.Errorf("messageText() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
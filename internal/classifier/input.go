@@ -0,0 +1,27 @@
+package classifier
+
+import "unicode/utf8"
+
+// DefaultMaxInputChars keeps prompts well inside the models' context windows
+const DefaultMaxInputChars = 8000
+
+// Notes longer than this many times the input limit are refused outright;
+// truncating them would classify only a small fraction of the note
+const inputHardLimitFactor = 4
+
+const truncatedMarker = "\n[truncated]"
+
+// InputHardLimit is the length in characters above which a note should be
+// rejected instead of truncated. Zero means there is no limit.
+func InputHardLimit(maxInputChars int) int {
+	return maxInputChars * inputHardLimitFactor
+}
+
+// truncateInput cuts content to maxChars characters, marking where it was
+// cut, and reports whether it did. A zero maxChars leaves content as is.
+func truncateInput(content string, maxChars int) (string, bool) {
+	if maxChars <= 0 || utf8.RuneCountInString(content) <= maxChars {
+		return content, false
+	}
+	return string([]rune(content)[:maxChars]) + truncatedMarker, true
+}
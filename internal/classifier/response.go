@@ -0,0 +1,79 @@
+package classifier
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// parseResponse decodes the assistant's JSON answer. When the answer is not
+// bare JSON, e.g. wrapped in a ```json fence or surrounded by prose, the
+// first balanced {...} object is decoded instead and repaired is set.
+func parseResponse(text string) (response GPTResponse, repaired bool, err error) {
+	err = json.Unmarshal([]byte(text), &response)
+	if err == nil {
+		return response, false, nil
+	}
+
+	object, ok := extractJSONObject(stripCodeFence(text))
+	if !ok {
+		return GPTResponse{}, false, err
+	}
+
+	response = GPTResponse{}
+	if repairErr := json.Unmarshal([]byte(object), &response); repairErr != nil {
+		return GPTResponse{}, false, errors.Join(err, repairErr)
+	}
+	return response, true, nil
+}
+
+// stripCodeFence returns the body of the first markdown code fence in text,
+// or text unchanged when there is none
+func stripCodeFence(text string) string {
+	start := strings.Index(text, "```")
+	if start < 0 {
+		return text
+	}
+	body := text[start+3:]
+	// Skip the language tag, e.g. ```json
+	if newline := strings.IndexByte(body, '\n'); newline >= 0 {
+		body = body[newline+1:]
+	}
+	if end := strings.Index(body, "```"); end >= 0 {
+		body = body[:end]
+	}
+	return body
+}
+
+// extractJSONObject finds the first balanced {...} block in text, ignoring
+// braces inside JSON strings
+func extractJSONObject(text string) (string, bool) {
+	start := strings.IndexByte(text, '{')
+	if start < 0 {
+		return "", false
+	}
+
+	depth := 0
+	inString := false
+	escaped := false
+	for i := start; i < len(text); i++ {
+		ch := text[i]
+		switch {
+		case escaped:
+			escaped = false
+		case inString && ch == '\\':
+			escaped = true
+		case ch == '"':
+			inString = !inString
+		case inString:
+		case ch == '{':
+			depth++
+		case ch == '}':
+			depth--
+			if depth == 0 {
+				return text[start : i+1], true
+			}
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,73 @@
+package classifier
+
+import "testing"
+
+func TestParseResponse(t *testing.T) {
+	tests := []struct {
+		name         string
+		text         string
+		wantCategory string
+		wantRepaired bool
+		wantErr      bool
+	}{
+		{
+			name:         "bare JSON",
+			text:         `{"category": "work", "keywords": ["meeting"]}`,
+			wantCategory: "work",
+		},
+		{
+			name:         "fenced",
+			text:         "```json\n{\"category\": \"work\", \"keywords\": [\"meeting\"]}\n```",
+			wantCategory: "work",
+			wantRepaired: true,
+		},
+		{
+			name:         "fenced without a language",
+			text:         "```\n{\"category\": \"work\"}\n```",
+			wantCategory: "work",
+			wantRepaired: true,
+		},
+		{
+			name:         "prefixed with prose",
+			text:         `Here is the classification: {"category": "work", "summary": "A meeting"}`,
+			wantCategory: "work",
+			wantRepaired: true,
+		},
+		{
+			name:         "trailing text",
+			text:         `{"category": "work", "summary": "Use {braces} and \"quotes\""} Let me know if you need more.`,
+			wantCategory: "work",
+			wantRepaired: true,
+		},
+		{
+			name:    "no object",
+			text:    "I couldn't classify this note.",
+			wantErr: true,
+		},
+		{
+			name:    "unbalanced object",
+			text:    `Result: {"category": "work"`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, repaired, err := parseResponse(tt.text)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseResponse() = %+v, want an error", response)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseResponse(): %v", err)
+			}
+			if response.Category != tt.wantCategory {
+				t.Errorf("category = %q, want %q", response.Category, tt.wantCategory)
+			}
+			if repaired != tt.wantRepaired {
+				t.Errorf("repaired = %v, want %v", repaired, tt.wantRepaired)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package classifier
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/xaenox/memo-bot/internal/metrics"
+	"github.com/xaenox/memo-bot/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultRetryAttempts  = 3
+	defaultRetryBaseDelay = 500 * time.Millisecond
+)
+
+// withRetry calls fn until it succeeds, fails permanently or runs out of
+// attempts, sleeping with exponential backoff and jitter in between. It gives
+// up early when the next delay would overrun the context deadline. fn must be
+// safe to repeat; see withRetryIfRejected for calls that aren't.
+func withRetry[T any](ctx context.Context, c *GPTClassifier, operation string, fn func() (T, error)) (T, error) {
+	return retry(ctx, c, operation, isRetryable, fn)
+}
+
+// withRetryIfRejected retries fn only when OpenAI refused the request
+// outright. A timeout or server error may come after the request took
+// effect, and repeating a created message or run would duplicate it.
+func withRetryIfRejected[T any](ctx context.Context, c *GPTClassifier, operation string, fn func() (T, error)) (T, error) {
+	return retry(ctx, c, operation, isRejected, fn)
+}
+
+func retry[T any](ctx context.Context, c *GPTClassifier, operation string, retryable func(error) bool, fn func() (T, error)) (T, error) {
+	var (
+		result T
+		err    error
+	)
+	_, span := tracing.Start(ctx, "openai."+operation)
+	defer func() { tracing.End(span, err) }()
+
+	for attempt := 1; ; attempt++ {
+		result, err = fn()
+		span.SetAttributes(attribute.Int("openai.attempts", attempt))
+		if err != nil {
+			metrics.OpenAIErrors.WithLabelValues(operation).Inc()
+		}
+		if err == nil || attempt >= c.retryAttempts || !retryable(err) || ctx.Err() != nil {
+			return result, err
+		}
+
+		delay := backoffDelay(c.retryBaseDelay, attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return result, err
+		}
+
+		c.log(ctx).Warn("Retrying OpenAI request",
+			zap.Error(err),
+			zap.String("operation", operation),
+			zap.Int("attempt", attempt),
+			zap.Duration("delay", delay))
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return result, err
+		case <-timer.C:
+		}
+	}
+}
+
+// backoffDelay doubles the base delay for every failed attempt and picks a
+// random point between half and all of it so concurrent retries spread out
+func backoffDelay(base time.Duration, attempt int) time.Duration {
+	delay := base << (attempt - 1)
+	half := int64(delay / 2)
+	if half <= 0 {
+		return delay
+	}
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+// isRetryable reports whether err is a transient failure: rate limiting,
+// a server side error or a network timeout
+func isRetryable(err error) bool {
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) {
+		return isRetryableStatus(apiErr.HTTPStatusCode)
+	}
+
+	var reqErr *openai.RequestError
+	if errors.As(err, &reqErr) {
+		return isRetryableStatus(reqErr.HTTPStatusCode)
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// isRejected reports whether err says the request was refused without being
+// processed, which rate limiting does
+func isRejected(err error) bool {
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.HTTPStatusCode == http.StatusTooManyRequests
+	}
+	var reqErr *openai.RequestError
+	return errors.As(err, &reqErr) && reqErr.HTTPStatusCode == http.StatusTooManyRequests
+}
+
+// errModelUnavailable marks failures caused by the requested model, such as
+// an unknown model or a prompt beyond its context window
+var errModelUnavailable = errors.New("model unavailable")
+
+// OpenAI error codes that another model may not run into
+var modelErrorCodes = map[string]bool{
+	"model_not_found":         true,
+	"context_length_exceeded": true,
+	"unsupported_model":       true,
+	"invalid_model":           true,
+}
+
+// isModelError reports whether an API error was caused by the requested model
+func isModelError(err error) bool {
+	var apiErr *openai.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	if code, ok := apiErr.Code.(string); ok && modelErrorCodes[code] {
+		return true
+	}
+	return apiErr.Param != nil && *apiErr.Param == "model"
+}
+
+// isModelRunFailure reports whether a run ended because of its model rather
+// than a transient server problem
+func isModelRunFailure(run openai.Run) bool {
+	if run.Status == openai.RunStatusIncomplete {
+		// The prompt or completion outgrew what the model allows
+		return true
+	}
+	if run.LastError == nil {
+		return false
+	}
+	code := string(run.LastError.Code)
+	return modelErrorCodes[code] || strings.Contains(strings.ToLower(run.LastError.Message), "model")
+}
+
+func isRetryableStatus(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusNotImplemented,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
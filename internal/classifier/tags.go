@@ -0,0 +1,140 @@
+package classifier
+
+import (
+	"context"
+	"strings"
+
+	"github.com/xaenox/memo-bot/internal/storage"
+	"go.uber.org/zap"
+)
+
+// Only the most recently added tags are offered to the assistant, which
+// keeps the prompt short for users with large vocabularies
+const maxSuggestedTags = 100
+
+// userRunSettings returns the categories the user restricted classification
+// to, nil when any category is fine, and the temperature for the user's runs
+func (c *GPTClassifier) userRunSettings(ctx context.Context, userID int64) (allowedCategories []string, temperature float64) {
+	user, err := c.storage.GetUser(ctx, userID)
+	if err != nil {
+		c.log(ctx).Warn("Failed to get user settings for the run",
+			zap.Error(err),
+			zap.Int64("user_id", userID))
+		return nil, c.temperature
+	}
+	if user.Temperature != nil {
+		return user.AllowedCategories, *user.Temperature
+	}
+	return user.AllowedCategories, c.temperature
+}
+
+// userTags returns the tags the user already has when the classifier should
+// prefer them, or nil
+func (c *GPTClassifier) userTags(ctx context.Context, userID int64) []string {
+	if !c.preferExistingTags {
+		return nil
+	}
+	tags, err := c.storage.GetUserTags(ctx, userID)
+	if err != nil {
+		c.log(ctx).Warn("Failed to get user tags for suggestions",
+			zap.Error(err),
+			zap.Int64("user_id", userID))
+		return nil
+	}
+	return tags
+}
+
+// runInstructions are appended to the assistant's instructions for a run
+func runInstructions(existingTags, allowedCategories []string) string {
+	instructions := confidenceInstructions + categoryInstructions(allowedCategories)
+	suggested := suggestedTags(existingTags)
+	if len(suggested) == 0 {
+		return instructions
+	}
+	return instructions + "\nThe user already uses these tags; prefer them for the keywords " +
+		"when they fit: " + strings.Join(suggested, ", ")
+}
+
+// suggestedTags are the user's tags named in the run instructions: the most
+// recent maxSuggestedTags of them
+func suggestedTags(existingTags []string) []string {
+	if len(existingTags) > maxSuggestedTags {
+		return existingTags[len(existingTags)-maxSuggestedTags:]
+	}
+	return existingTags
+}
+
+// snapTags replaces keywords that are a typo or an inflection away from one
+// of the user's existing tags with that tag, dropping resulting duplicates
+func snapTags(keywords, existingTags []string) []string {
+	if len(existingTags) == 0 || len(keywords) == 0 {
+		return keywords
+	}
+
+	snapped := make([]string, 0, len(keywords))
+	seen := make(map[string]bool, len(keywords))
+	for _, keyword := range keywords {
+		if tag, ok := closestTag(keyword, existingTags); ok {
+			keyword = tag
+		}
+		if key := storage.NormalizeLabel(keyword); !seen[key] {
+			seen[key] = true
+			snapped = append(snapped, keyword)
+		}
+	}
+	return snapped
+}
+
+// closestTag finds the existing tag nearest to keyword, if it is near enough
+// to be the same tag spelled differently
+func closestTag(keyword string, existingTags []string) (string, bool) {
+	key := storage.NormalizeLabel(keyword)
+	maxDistance := snapDistance(key)
+
+	best, bestDistance := "", maxDistance+1
+	for _, tag := range existingTags {
+		distance := EditDistance(key, storage.NormalizeLabel(tag))
+		if distance < bestDistance {
+			best, bestDistance = tag, distance
+		}
+		if distance == 0 {
+			break
+		}
+	}
+	return best, best != ""
+}
+
+// snapDistance is how many edits still count as the same tag. Short tags
+// must match exactly: "art" and "car" are different words.
+func snapDistance(key string) int {
+	switch n := len([]rune(key)); {
+	case n < 5:
+		return 0
+	case n < 9:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// EditDistance is the Levenshtein distance between a and b, counted in runes
+func EditDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
@@ -0,0 +1,35 @@
+// Package logging carries a request-scoped logger through contexts so that
+// every log line of one update can be correlated.
+package logging
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+type loggerKey struct{}
+
+// NewRequestID returns a short random ID identifying one incoming update
+func NewRequestID() string {
+	return uuid.New().String()[:8]
+}
+
+// WithLogger returns a copy of ctx carrying logger
+func WithLogger(ctx context.Context, logger *zap.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// FromContext returns the logger carried by ctx, or fallback if there is none
+func FromContext(ctx context.Context, fallback *zap.Logger) *zap.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*zap.Logger); ok {
+		return logger
+	}
+	return fallback
+}
+
+// With adds fields to the logger carried by ctx, or to fallback
+func With(ctx context.Context, fallback *zap.Logger, fields ...zap.Field) context.Context {
+	return WithLogger(ctx, FromContext(ctx, fallback).With(fields...))
+}
@@ -0,0 +1,99 @@
+// Package metrics defines the Prometheus metrics exported by the bot.
+package metrics
+
+import (
+	"net/http"
+	"runtime"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "memo_bot"
+
+// Message processing outcomes used as the status label of MessagesProcessed
+const (
+	StatusClassified = "classified"
+	StatusFallback   = "fallback"
+	StatusFailed     = "failed"
+)
+
+var (
+	// MessagesProcessed counts non-command messages by outcome
+	MessagesProcessed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_processed_total",
+		Help:      "Messages handled by the bot, by outcome.",
+	}, []string{"status"})
+
+	// ClassificationDuration tracks how long a full assistant analysis takes
+	ClassificationDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "classification_duration_seconds",
+		Help:      "Time spent classifying a message with the assistant.",
+		Buckets:   []float64{0.5, 1, 2, 4, 8, 15, 30, 60},
+	})
+
+	// OpenAIErrors counts failed OpenAI API calls, retries included
+	OpenAIErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "openai_errors_total",
+		Help:      "Failed OpenAI API calls, by operation.",
+	}, []string{"operation"})
+
+	// Fallbacks counts classifications answered with the fallback response
+	Fallbacks = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "classifier_fallbacks_total",
+		Help:      "Classifications that fell back to the default response.",
+	})
+
+	// BudgetRefusals counts classifications kept from the assistant by a
+	// token budget
+	BudgetRefusals = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "classifier_budget_refusals_total",
+		Help:      "Classifications that skipped the assistant because a token budget was spent.",
+	})
+
+	// DBOperationDuration tracks storage calls by operation
+	DBOperationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_operation_duration_seconds",
+		Help:      "Time spent in database operations, by operation.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	buildInfo = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "build_info",
+		Help:      "Build information, always 1.",
+	}, []string{"version", "go_version"})
+)
+
+// SetBuildInfo publishes the running version as memo_bot_build_info
+func SetBuildInfo(version string) {
+	buildInfo.WithLabelValues(version, runtime.Version()).Set(1)
+}
+
+// ObserveDBOperation starts timing a storage call. Call the returned
+// function when the operation is done, typically with defer.
+func ObserveDBOperation(operation string) func() {
+	start := time.Now()
+	return func() {
+		DBOperationDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	}
+}
+
+// NewServer returns an HTTP server exposing the metrics on /metrics
+func NewServer(addr string) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	return &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+}
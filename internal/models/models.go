@@ -2,23 +2,65 @@ package models
 
 import "time"
 
+// ContentType is the kind of payload a message carried
+type ContentType string
+
+const (
+    TextContent     ContentType = "text"
+    ImageContent    ContentType = "image"
+    DocumentContent ContentType = "document"
+    VideoContent    ContentType = "video"
+)
+
 // Message represents a user message with its classification
 type Message struct {
-    ID        string    `json:"id"`
-    UserID    int64     `json:"user_id"`
-    Content   string    `json:"content"`
-    Category  string    `json:"category"`
-    Tags      []string  `json:"tags"`
-    CreatedAt time.Time `json:"created_at"`
+    ID          string      `json:"id"`
+    UserID      int64       `json:"user_id"`
+    Content     string      `json:"content"`
+    Category    string      `json:"category"`
+    Tags        []string    `json:"tags"`
+    Summary     string      `json:"summary"`
+    FileID      string      `json:"file_id,omitempty"`
+    ContentType ContentType `json:"content_type"`
+    CreatedAt   time.Time   `json:"created_at"`
+    Archived    bool        `json:"archived"`
+    ArchivedAt  *time.Time  `json:"archived_at,omitempty"`
+    // Source names where a forwarded message came from
+    Source      string      `json:"source,omitempty"`
+    // Links are the URLs found in the message when it was classified
+    Links       []string    `json:"links,omitempty"`
+    // AttachmentsAnalysis is the assistant's description of the attached
+    // file, if any
+    AttachmentsAnalysis string `json:"attachments_analysis,omitempty"`
+    // IsPinned keeps the message at hand in /pinned
+    IsPinned    bool        `json:"is_pinned"`
 }
 
 // User represents a bot user with their preferences and metadata
 type User struct {
-    ID         int64     `json:"id"`
-    ThreadID   string    `json:"thread_id,omitempty"`
-    Categories []string  `json:"categories"`
-    Tags       []string  `json:"tags"`
-    LastUsedAt time.Time `json:"last_used_at"`
+    ID            int64             `json:"id"`
+    ThreadID      string            `json:"thread_id,omitempty"`
+    Categories    []string          `json:"categories"`
+    Tags          []string          `json:"tags"`
+    DateFormat    string            `json:"date_format,omitempty"`
+    MaxTags       int               `json:"max_tags"`
+    CategoryIcons map[string]string `json:"category_icons,omitempty"`
+    Language      string            `json:"language,omitempty"`
+    // Timezone is the IANA zone dates are shown in; empty shows UTC
+    Timezone      string            `json:"timezone,omitempty"`
+    LastUsedAt    time.Time         `json:"last_used_at"`
+
+    // AllowedCategories limits classification to these categories; empty
+    // allows any
+    AllowedCategories []string `json:"allowed_categories,omitempty"`
+
+    // RetentionDays is how long notes are kept before they are purged; zero
+    // keeps them forever
+    RetentionDays int `json:"retention_days,omitempty"`
+
+    // Temperature overrides the classifier's sampling temperature for the
+    // user's notes; nil uses the configured default
+    Temperature *float64 `json:"temperature,omitempty"`
 }
 
 // Classification represents the result of content analysis
@@ -37,3 +79,49 @@ type Thread struct {
     CreatedAt  time.Time `json:"created_at"`
     LastUsedAt time.Time `json:"last_used_at"`
 }
+
+// Taxonomy is a shareable, ordered set of categories
+type Taxonomy struct {
+    Version    int      `json:"version"`
+    Categories []string `json:"categories"`
+}
+
+// Backup is everything stored for one user, as written by /backup and read
+// back by /restore
+type Backup struct {
+    Version   int        `json:"version"`
+    // UserID is whose data this is; a backup is only restored for that user
+    UserID    int64      `json:"user_id"`
+    CreatedAt time.Time  `json:"created_at"`
+    // User holds the settings and the category and tag lists
+    User      *User      `json:"user"`
+    Messages  []*Message `json:"messages"`
+    // CategoryTags are the tags added to every new note, by category
+    CategoryTags map[string][]string `json:"category_tags,omitempty"`
+    // Links are the pairs of notes connected with /link
+    Links     []NoteLink `json:"links,omitempty"`
+}
+
+// NoteLink connects two related notes
+type NoteLink struct {
+    FromID string `json:"from_id"`
+    ToID   string `json:"to_id"`
+}
+
+// DuplicateGroup is a set of a user's messages with the same normalized content,
+// oldest first
+type DuplicateGroup struct {
+    ContentHash string     `json:"content_hash"`
+    Messages    []*Message `json:"messages"`
+}
+
+// UserStats summarizes a user's saved messages
+type UserStats struct {
+    TotalMessages    int       `json:"total_messages"`
+    Categories       int       `json:"categories"`
+    Tags             int       `json:"tags"`
+    TopCategory      string    `json:"top_category,omitempty"`
+    TopCategoryCount int       `json:"top_category_count"`
+    FirstMessageAt   time.Time `json:"first_message_at"`
+    LastMessageAt    time.Time `json:"last_message_at"`
+}
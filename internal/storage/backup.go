@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/xaenox/memo-bot/internal/models"
+)
+
+// maxMessageIDLength is the size of the messages.id column
+const maxMessageIDLength = 36
+
+// prepareRestore checks a backup before anything is written and puts it in
+// the stored form: every note belongs to the backup's user and labels are
+// normalized. Archived notes missing the time they were archived get the
+// current time. Links to notes outside the backup and empty category tags
+// are dropped.
+func (l Limits) prepareRestore(backup *models.Backup) error {
+	if backup == nil || backup.UserID == 0 {
+		return fmt.Errorf("%w: backup has no user", ErrInvalidInput)
+	}
+
+	now := time.Now()
+	ids := make(map[string]bool, len(backup.Messages))
+	for _, message := range backup.Messages {
+		if message == nil {
+			return fmt.Errorf("%w: backup has an empty note", ErrInvalidInput)
+		}
+		message.UserID = backup.UserID
+		if err := l.checkMessage(message); err != nil {
+			return err
+		}
+		if len(message.ID) > maxMessageIDLength {
+			return fmt.Errorf("%w: note id %q is too long", ErrInvalidInput, message.ID)
+		}
+		if ids[message.ID] {
+			return fmt.Errorf("%w: note %s appears twice", ErrInvalidInput, message.ID)
+		}
+		ids[message.ID] = true
+		normalizeMessageLabels(message)
+		// Archived notes are listed by when they were archived
+		switch {
+		case message.Archived && message.ArchivedAt == nil:
+			archivedAt := now
+			message.ArchivedAt = &archivedAt
+		case !message.Archived:
+			message.ArchivedAt = nil
+		}
+	}
+
+	links := backup.Links[:0]
+	for _, link := range backup.Links {
+		if link.FromID != link.ToID && ids[link.FromID] && ids[link.ToID] {
+			links = append(links, link)
+		}
+	}
+	backup.Links = links
+
+	categoryTags := make(map[string][]string, len(backup.CategoryTags))
+	for category, tags := range backup.CategoryTags {
+		category, tags = NormalizeLabel(category), NormalizeLabels(tags)
+		if category != "" && len(tags) > 0 {
+			categoryTags[category] = tags
+		}
+	}
+	backup.CategoryTags = categoryTags
+	return nil
+}
+
+// restoredUser is the user metadata to store when restoring backed over
+// current. The assistant thread is always kept.
+func (l Limits) restoredUser(current, backed *models.User, replace bool) (*models.User, error) {
+	if backed == nil {
+		backed = &models.User{}
+	}
+
+	var user *models.User
+	if replace {
+		user = copyUser(backed)
+		user.ID = current.ID
+		user.ThreadID = current.ThreadID
+		user.Categories = NormalizeLabels(backed.Categories)
+		user.Tags = NormalizeLabels(backed.Tags)
+		user.AllowedCategories = NormalizeLabels(backed.AllowedCategories)
+		user.CategoryIcons = nil
+		for category, icon := range backed.CategoryIcons {
+			setIcon(user, category, icon)
+		}
+	} else {
+		user = copyUser(current)
+		user.Categories = NormalizeLabels(append(user.Categories, backed.Categories...))
+		user.Tags = NormalizeLabels(append(user.Tags, backed.Tags...))
+		for category, icon := range backed.CategoryIcons {
+			if _, exists := user.CategoryIcons[NormalizeLabel(category)]; !exists {
+				setIcon(user, category, icon)
+			}
+		}
+		// Only a user restored for the first time has no tag limit yet
+		if user.MaxTags < 1 {
+			user.MaxTags = backed.MaxTags
+		}
+		if user.DateFormat == "" {
+			user.DateFormat = backed.DateFormat
+		}
+		if user.Language == "" {
+			user.Language = backed.Language
+		}
+		if user.Timezone == "" {
+			user.Timezone = backed.Timezone
+		}
+		if user.Temperature == nil && backed.Temperature != nil {
+			temperature := *backed.Temperature
+			user.Temperature = &temperature
+		}
+		if user.RetentionDays == 0 {
+			user.RetentionDays = backed.RetentionDays
+		}
+		if len(user.AllowedCategories) == 0 {
+			user.AllowedCategories = NormalizeLabels(backed.AllowedCategories)
+		}
+	}
+
+	if user.MaxTags < 1 {
+		user.MaxTags = DefaultMaxTags
+	}
+	if user.RetentionDays < 0 {
+		return nil, fmt.Errorf("%w: retention cannot be negative", ErrInvalidInput)
+	}
+	if len(user.Tags) > l.MaxUserTags {
+		return nil, l.tagLimitError()
+	}
+	user.LastUsedAt = time.Now()
+	return user, nil
+}
+
+func setIcon(user *models.User, category, icon string) {
+	category = NormalizeLabel(category)
+	if category == "" || icon == "" {
+		return
+	}
+	if user.CategoryIcons == nil {
+		user.CategoryIcons = make(map[string]string)
+	}
+	user.CategoryIcons[category] = icon
+}
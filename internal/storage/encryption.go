@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/xaenox/memo-bot/internal/models"
+)
+
+const (
+	// encryptedPrefix marks content stored as ciphertext. Rows written before
+	// encryption was enabled lack it and are read as plaintext.
+	encryptedPrefix = "enc:v1:"
+	// escapedPrefix is put before plaintext that starts with markerPrefix,
+	// so a note that happens to start like ciphertext is never read as one
+	escapedPrefix = "enc:plain:"
+	markerPrefix  = "enc:"
+)
+
+// hashKeyLabel derives the content hash key from the encryption key, so the
+// two are never the same bytes
+const hashKeyLabel = "memo-bot content hash v1"
+
+// contentCipher encrypts the text of messages with AES-GCM and keys their
+// content hash. A nil cipher stores them as plaintext with a plain hash.
+type contentCipher struct {
+	aead    cipher.AEAD
+	hashKey []byte
+}
+
+// newContentCipher builds a cipher from a base64 encoded 16, 24 or 32 byte
+// key. An empty key disables encryption and returns nil.
+func newContentCipher(key string) (*contentCipher, error) {
+	if key == "" {
+		return nil, nil
+	}
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("encryption key is not valid base64: %w", err)
+	}
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, fmt.Errorf("encryption key must be 16, 24 or 32 bytes: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, raw)
+	mac.Write([]byte(hashKeyLabel))
+	return &contentCipher{aead: aead, hashKey: mac.Sum(nil)}, nil
+}
+
+// hash is the content_hash stored for content. With a key it is an HMAC, so
+// the database alone can't be used to confirm a guess at a note's text.
+func (c *contentCipher) hash(content string) string {
+	if c == nil {
+		return ContentHash(content)
+	}
+	mac := hmac.New(sha256.New, c.hashKey)
+	mac.Write([]byte(hashNormalized(content)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (c *contentCipher) encrypt(content string) (string, error) {
+	if c == nil {
+		if strings.HasPrefix(content, markerPrefix) {
+			return escapedPrefix + content, nil
+		}
+		return content, nil
+	}
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := c.aead.Seal(nonce, nonce, []byte(content), nil)
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (c *contentCipher) decrypt(stored string) (string, error) {
+	if plain, ok := strings.CutPrefix(stored, escapedPrefix); ok {
+		return plain, nil
+	}
+	encoded, ok := strings.CutPrefix(stored, encryptedPrefix)
+	if !ok {
+		return stored, nil
+	}
+	if c == nil {
+		return "", errors.New("content is encrypted but no encryption key is configured")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < c.aead.NonceSize() {
+		return "", errors.New("ciphertext is too short")
+	}
+	nonce, ciphertext := sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():]
+	content, err := c.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+// sealedMessage holds the columns of a message as they are stored
+type sealedMessage struct {
+	Content             string
+	ContentHash         string
+	Summary             string
+	Links               []string
+	AttachmentsAnalysis string
+}
+
+// sealMessage encrypts the parts of message derived from its content: the
+// content itself, its summary, links and attachment analysis
+func (c *contentCipher) sealMessage(message *models.Message) (sealedMessage, error) {
+	sealed := sealedMessage{
+		ContentHash: c.hash(message.Content),
+		Links:       make([]string, len(message.Links)),
+	}
+	var err error
+	if sealed.Content, err = c.encrypt(message.Content); err != nil {
+		return sealed, err
+	}
+	if sealed.Summary, err = c.encrypt(message.Summary); err != nil {
+		return sealed, err
+	}
+	if sealed.AttachmentsAnalysis, err = c.encrypt(message.AttachmentsAnalysis); err != nil {
+		return sealed, err
+	}
+	for i, link := range message.Links {
+		if sealed.Links[i], err = c.encrypt(link); err != nil {
+			return sealed, err
+		}
+	}
+	return sealed, nil
+}
+
+// decryptMessage replaces the scanned fields of message with their plaintext
+func (c *contentCipher) decryptMessage(message *models.Message) error {
+	fields := []*string{&message.Content, &message.Summary, &message.AttachmentsAnalysis}
+	for i := range message.Links {
+		fields = append(fields, &message.Links[i])
+	}
+	for _, field := range fields {
+		plain, err := c.decrypt(*field)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt message %s: %w", message.ID, err)
+		}
+		*field = plain
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/xaenox/memo-bot/internal/models"
+)
+
+func testCipher(t *testing.T, seed byte) *contentCipher {
+	t.Helper()
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = seed + byte(i)
+	}
+	c, err := newContentCipher(base64.StdEncoding.EncodeToString(key))
+	if err != nil {
+		t.Fatalf("newContentCipher: %v", err)
+	}
+	return c
+}
+
+func TestSealMessageRoundTrip(t *testing.T) {
+	c := testCipher(t, 1)
+	message := &models.Message{
+		ID:                  "m1",
+		Content:             "Call the bank about the mortgage",
+		Summary:             "Reminder to call the bank",
+		Links:               []string{"https://bank.example/mortgage"},
+		AttachmentsAnalysis: "A scanned letter",
+	}
+
+	sealed, err := c.sealMessage(message)
+	if err != nil {
+		t.Fatalf("sealMessage: %v", err)
+	}
+	for name, value := range map[string]string{
+		"content":  sealed.Content,
+		"summary":  sealed.Summary,
+		"link":     sealed.Links[0],
+		"analysis": sealed.AttachmentsAnalysis,
+	} {
+		if !strings.HasPrefix(value, encryptedPrefix) {
+			t.Errorf("%s stored as plaintext: %q", name, value)
+		}
+	}
+
+	stored := &models.Message{
+		ID:                  message.ID,
+		Content:             sealed.Content,
+		Summary:             sealed.Summary,
+		Links:               sealed.Links,
+		AttachmentsAnalysis: sealed.AttachmentsAnalysis,
+	}
+	if err := c.decryptMessage(stored); err != nil {
+		t.Fatalf("decryptMessage: %v", err)
+	}
+	if stored.Content != message.Content || stored.Summary != message.Summary ||
+		stored.Links[0] != message.Links[0] || stored.AttachmentsAnalysis != message.AttachmentsAnalysis {
+		t.Errorf("decrypted %+v, want %+v", stored, message)
+	}
+}
+
+func TestContentCipherHash(t *testing.T) {
+	c := testCipher(t, 1)
+	content := "Buy milk"
+
+	if got := (*contentCipher)(nil).hash(content); got != ContentHash(content) {
+		t.Errorf("hash without a key = %q, want ContentHash", got)
+	}
+	if c.hash(content) == ContentHash(content) {
+		t.Error("keyed hash equals the unkeyed one")
+	}
+	if c.hash(content) != c.hash("  buy   MILK ") {
+		t.Error("keyed hash differs for content differing only in case and spacing")
+	}
+	if c.hash(content) == testCipher(t, 2).hash(content) {
+		t.Error("hashes under different keys are equal")
+	}
+}
+
+func TestPlaintextLookingLikeCiphertext(t *testing.T) {
+	for _, content := range []string{"enc:v1:not a ciphertext", "enc:plain:x", "enc:", "plain note"} {
+		stored, err := (*contentCipher)(nil).encrypt(content)
+		if err != nil {
+			t.Fatalf("encrypt(%q): %v", content, err)
+		}
+		// Notes saved without a key stay readable once a key is set
+		for name, c := range map[string]*contentCipher{"no key": nil, "key": testCipher(t, 1)} {
+			got, err := c.decrypt(stored)
+			if err != nil || got != content {
+				t.Errorf("%s: decrypt(%q) = %q, %v; want %q", name, stored, got, err, content)
+			}
+		}
+	}
+}
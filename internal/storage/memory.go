@@ -3,6 +3,7 @@ package storage
 import (
 	"context"
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
@@ -17,17 +18,49 @@ type threadInfo struct {
 }
 
 type MemoryStorage struct {
-	mu       sync.RWMutex
-	users    map[int64]*models.User
-	messages map[string]*models.Message
-	threads  map[int64]threadInfo
+	mu           sync.RWMutex
+	users        map[int64]*models.User
+	messages     map[string]*models.Message
+	threads      map[int64]threadInfo
+	mutedChats   map[int64]bool
+	replies      map[replyKey]string
+	sources      map[sourceKey]replyKey
+	updateOffset int
+	processed    map[int]struct{}
+	links        map[linkKey]struct{}
+	categoryTags map[int64]map[string][]string
+	limits       Limits
 }
 
-func NewMemoryStorage() *MemoryStorage {
+// replyKey identifies a bot message in a chat
+type replyKey struct {
+	chatID       int64
+	botMessageID int
+}
+
+// linkKey identifies a link between two messages, smaller ID first
+type linkKey struct {
+	first, second string
+}
+
+// sourceKey identifies a user's message in a chat
+type sourceKey struct {
+	chatID          int64
+	sourceMessageID int
+}
+
+func NewMemoryStorage(limits Limits) *MemoryStorage {
 	return &MemoryStorage{
-		users:    make(map[int64]*models.User),
-		messages: make(map[string]*models.Message),
-		threads:  make(map[int64]threadInfo),
+		limits:       limits.withDefaults(),
+		users:        make(map[int64]*models.User),
+		messages:     make(map[string]*models.Message),
+		threads:      make(map[int64]threadInfo),
+		mutedChats:   make(map[int64]bool),
+		replies:      make(map[replyKey]string),
+		sources:      make(map[sourceKey]replyKey),
+		processed:    make(map[int]struct{}),
+		links:        make(map[linkKey]struct{}),
+		categoryTags: make(map[int64]map[string][]string),
 	}
 }
 
@@ -36,25 +69,106 @@ func (s *MemoryStorage) GetUser(ctx context.Context, id int64) (*models.User, er
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
+	// Callers read the user without the lock, so they get their own copy
 	if user, exists := s.users[id]; exists {
+		user = copyUser(user)
+		// Users created by any other setting get the column default, as in
+		// PostgreSQL
+		if user.MaxTags < 1 {
+			user.MaxTags = DefaultMaxTags
+		}
 		return user, nil
 	}
 	return &models.User{
 		ID:         id,
+		MaxTags:    DefaultMaxTags,
 		LastUsedAt: time.Now(),
 	}, nil
 }
 
+func (s *MemoryStorage) ListUsers(ctx context.Context, limit, offset int) ([]*models.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	users := make([]*models.User, 0, len(s.users))
+	for _, user := range s.users {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if !users[i].LastUsedAt.Equal(users[j].LastUsedAt) {
+			return users[i].LastUsedAt.After(users[j].LastUsedAt)
+		}
+		return users[i].ID < users[j].ID
+	})
+
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= len(users) {
+		return []*models.User{}, nil
+	}
+	users = users[offset:]
+	if limit > 0 && limit < len(users) {
+		users = users[:limit]
+	}
+
+	// Callers must not be able to change stored users through the result
+	result := make([]*models.User, len(users))
+	for i, user := range users {
+		result[i] = copyUser(user)
+	}
+	return result, nil
+}
+
+func copyUser(u *models.User) *models.User {
+	c := *u
+	c.Categories = append([]string(nil), u.Categories...)
+	c.Tags = append([]string(nil), u.Tags...)
+	c.AllowedCategories = append([]string(nil), u.AllowedCategories...)
+	if u.Temperature != nil {
+		temperature := *u.Temperature
+		c.Temperature = &temperature
+	}
+	if u.CategoryIcons != nil {
+		c.CategoryIcons = make(map[string]string, len(u.CategoryIcons))
+		for category, icon := range u.CategoryIcons {
+			c.CategoryIcons[category] = icon
+		}
+	}
+	return &c
+}
+
 func (s *MemoryStorage) UpdateUser(ctx context.Context, user *models.User) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	user.LastUsedAt = time.Now()
-	s.users[user.ID] = user
+	s.users[user.ID] = copyUser(user)
+	return nil
+}
+
+func (s *MemoryStorage) DeleteUser(ctx context.Context, userID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, m := range s.messages {
+		if m.UserID == userID {
+			delete(s.messages, id)
+		}
+	}
+	s.dropDangling()
+	delete(s.threads, userID)
+	delete(s.users, userID)
+	delete(s.categoryTags, userID)
 	return nil
 }
 
 func (s *MemoryStorage) AddCategory(ctx context.Context, userID int64, category string) error {
+	category = NormalizeLabel(category)
+	if category == "" {
+		return fmt.Errorf("%w: category cannot be empty", ErrInvalidInput)
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -69,7 +183,7 @@ func (s *MemoryStorage) AddCategory(ctx context.Context, userID int64, category
 
 	// Check if category already exists
 	for _, c := range user.Categories {
-		if c == category {
+		if NormalizeLabel(c) == category {
 			return nil
 		}
 	}
@@ -81,6 +195,11 @@ func (s *MemoryStorage) AddCategory(ctx context.Context, userID int64, category
 }
 
 func (s *MemoryStorage) AddTag(ctx context.Context, userID int64, tag string) error {
+	tag = NormalizeLabel(tag)
+	if tag == "" {
+		return fmt.Errorf("%w: tag cannot be empty", ErrInvalidInput)
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -95,10 +214,13 @@ func (s *MemoryStorage) AddTag(ctx context.Context, userID int64, tag string) er
 
 	// Check if tag already exists
 	for _, t := range user.Tags {
-		if t == tag {
+		if NormalizeLabel(t) == tag {
 			return nil
 		}
 	}
+	if len(user.Tags) >= s.limits.MaxUserTags {
+		return s.limits.tagLimitError()
+	}
 
 	user.Tags = append(user.Tags, tag)
 	user.LastUsedAt = time.Now()
@@ -106,12 +228,93 @@ func (s *MemoryStorage) AddTag(ctx context.Context, userID int64, tag string) er
 	return nil
 }
 
+func (s *MemoryStorage) RemoveTag(ctx context.Context, userID int64, tag string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, exists := s.users[userID]
+	if !exists {
+		return ErrNotFound
+	}
+
+	key := NormalizeLabel(tag)
+	for i, t := range user.Tags {
+		if NormalizeLabel(t) == key {
+			user.Tags = without(user.Tags, i)
+			user.LastUsedAt = time.Now()
+			return nil
+		}
+	}
+
+	return ErrNotFound
+}
+
+// without returns a copy of labels with the one at i left out, leaving
+// labels itself unchanged
+func without(labels []string, i int) []string {
+	result := make([]string, 0, len(labels)-1)
+	result = append(result, labels[:i]...)
+	return append(result, labels[i+1:]...)
+}
+
+func (s *MemoryStorage) RenameTag(ctx context.Context, userID int64, oldTag, newTag string) error {
+	newTag = NormalizeLabel(newTag)
+	if newTag == "" {
+		return fmt.Errorf("%w: new tag cannot be empty", ErrInvalidInput)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	renamed := false
+	if user, exists := s.users[userID]; exists {
+		if tags, ok := renameTag(user.Tags, oldTag, newTag); ok {
+			user.Tags = tags
+			renamed = true
+		}
+	}
+	for _, m := range s.messages {
+		if m.UserID != userID {
+			continue
+		}
+		if tags, ok := renameTag(m.Tags, oldTag, newTag); ok {
+			m.Tags = tags
+			renamed = true
+		}
+	}
+
+	if !renamed {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// renameTag returns a copy of tags with oldTag replaced by newTag, dropping
+// the duplicates that may create, and whether oldTag was present
+func renameTag(tags []string, oldTag, newTag string) ([]string, bool) {
+	key := NormalizeLabel(oldTag)
+	found := false
+	seen := make(map[string]bool, len(tags))
+	renamed := make([]string, 0, len(tags))
+	for _, t := range tags {
+		if NormalizeLabel(t) == key {
+			t = newTag
+			found = true
+		}
+		if !seen[t] {
+			seen[t] = true
+			renamed = append(renamed, t)
+		}
+	}
+	return renamed, found
+}
+
 func (s *MemoryStorage) GetUserCategories(ctx context.Context, userID int64) ([]string, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	if user, exists := s.users[userID]; exists {
-		return user.Categories, nil
+		return append([]string{}, user.Categories...), nil
 	}
 	return []string{}, nil
 }
@@ -121,7 +324,7 @@ func (s *MemoryStorage) GetUserTags(ctx context.Context, userID int64) ([]string
 	defer s.mu.RUnlock()
 
 	if user, exists := s.users[userID]; exists {
-		return user.Tags, nil
+		return append([]string{}, user.Tags...), nil
 	}
 	return []string{}, nil
 }
@@ -131,6 +334,11 @@ func (s *MemoryStorage) Close() error {
 	return nil
 }
 
+func (s *MemoryStorage) CheckHealth(ctx context.Context) error {
+	// In-memory storage is always available
+	return nil
+}
+
 func (s *MemoryStorage) GetThread(ctx context.Context, userID int64) (*models.Thread, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -193,9 +401,10 @@ func (s *MemoryStorage) RemoveCategory(ctx context.Context, userID int64, catego
 	}
 
 	// Find and remove the category
+	key := NormalizeLabel(category)
 	for i, c := range user.Categories {
-		if c == category {
-			user.Categories = append(user.Categories[:i], user.Categories[i+1:]...)
+		if NormalizeLabel(c) == key {
+			user.Categories = without(user.Categories, i)
 			user.LastUsedAt = time.Now()
 			s.users[userID] = user
 			return nil
@@ -205,9 +414,969 @@ func (s *MemoryStorage) RemoveCategory(ctx context.Context, userID int64, catego
 	return ErrNotFound
 }
 
+func (s *MemoryStorage) MergeCategory(ctx context.Context, userID int64, from, into string) (int, error) {
+	into = NormalizeLabel(into)
+	if into == "" {
+		return 0, fmt.Errorf("%w: target category cannot be empty", ErrInvalidInput)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fromKey := NormalizeLabel(from)
+	moved := 0
+	for _, m := range s.messages {
+		if m.UserID == userID && NormalizeLabel(m.Category) == fromKey {
+			m.Category = into
+			moved++
+		}
+	}
+
+	user, exists := s.users[userID]
+	if !exists {
+		if moved == 0 {
+			return 0, ErrNotFound
+		}
+		user = &models.User{ID: userID, MaxTags: DefaultMaxTags}
+		s.users[userID] = user
+	}
+	listed := false
+	intoListed := false
+	categories := make([]string, 0, len(user.Categories)+1)
+	for _, c := range user.Categories {
+		if NormalizeLabel(c) == fromKey {
+			listed = true
+			continue
+		}
+		if NormalizeLabel(c) == NormalizeLabel(into) {
+			intoListed = true
+		}
+		categories = append(categories, c)
+	}
+	if moved == 0 && !listed {
+		return 0, ErrNotFound
+	}
+	if !intoListed {
+		categories = append(categories, into)
+	}
+	user.Categories = categories
+	user.LastUsedAt = time.Now()
+	return moved, nil
+}
+
+func (s *MemoryStorage) RenameCategory(ctx context.Context, userID int64, oldName, newName string) (int, error) {
+	newName = NormalizeLabel(newName)
+	if newName == "" {
+		return 0, fmt.Errorf("%w: new category cannot be empty", ErrInvalidInput)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	oldKey := NormalizeLabel(oldName)
+	moved := 0
+	for _, m := range s.messages {
+		if m.UserID == userID && NormalizeLabel(m.Category) == oldKey {
+			m.Category = newName
+			moved++
+		}
+	}
+
+	listed := false
+	if user, exists := s.users[userID]; exists {
+		if categories, ok := renameTag(user.Categories, oldName, newName); ok {
+			user.Categories = categories
+			user.LastUsedAt = time.Now()
+			listed = true
+		}
+	}
+	if moved == 0 && !listed {
+		return 0, ErrNotFound
+	}
+	return moved, nil
+}
+
 func (s *MemoryStorage) UpdateUserMaxTags(ctx context.Context, userID int64, maxTags int) error {
+	if maxTags < 1 {
+		return fmt.Errorf("%w: max_tags must be at least 1", ErrInvalidInput)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, exists := s.users[userID]
+	if !exists {
+		user = &models.User{ID: userID}
+	}
+
+	user.MaxTags = maxTags
+	user.LastUsedAt = time.Now()
+	s.users[userID] = user
+	return nil
+}
+
+func (s *MemoryStorage) GetUpdateOffset(ctx context.Context) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.updateOffset, nil
+}
+
+func (s *MemoryStorage) SetUpdateOffset(ctx context.Context, offset int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if offset > s.updateOffset {
+		s.updateOffset = offset
+	}
+	return nil
+}
+
+func (s *MemoryStorage) MarkUpdateProcessed(ctx context.Context, updateID int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, seen := s.processed[updateID]; seen {
+		return false, nil
+	}
+	s.processed[updateID] = struct{}{}
+	for id := range s.processed {
+		if id < updateID-ProcessedUpdatesWindow {
+			delete(s.processed, id)
+		}
+	}
+	return true, nil
+}
+
+func (s *MemoryStorage) UpdateUserDateFormat(ctx context.Context, userID int64, format string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, exists := s.users[userID]
+	if !exists {
+		user = &models.User{ID: userID}
+	}
+
+	user.DateFormat = format
+	user.LastUsedAt = time.Now()
+	s.users[userID] = user
+	return nil
+}
+
+func (s *MemoryStorage) UpdateUserRetention(ctx context.Context, userID int64, days int) error {
+	if days < 0 {
+		return fmt.Errorf("%w: retention can't be negative", ErrInvalidInput)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, exists := s.users[userID]
+	if !exists {
+		user = &models.User{ID: userID, MaxTags: DefaultMaxTags}
+	}
+
+	user.RetentionDays = days
+	user.LastUsedAt = time.Now()
+	s.users[userID] = user
+	return nil
+}
+
+func (s *MemoryStorage) UpdateUserLanguage(ctx context.Context, userID int64, language string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, exists := s.users[userID]
+	if !exists {
+		user = &models.User{ID: userID}
+	}
+
+	user.Language = language
+	user.LastUsedAt = time.Now()
+	s.users[userID] = user
+	return nil
+}
+
+func (s *MemoryStorage) UpdateUserTimezone(ctx context.Context, userID int64, timezone string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, exists := s.users[userID]
+	if !exists {
+		user = &models.User{ID: userID}
+	}
+
+	user.Timezone = timezone
+	user.LastUsedAt = time.Now()
+	s.users[userID] = user
+	return nil
+}
+
+func (s *MemoryStorage) UpdateUserTemperature(ctx context.Context, userID int64, temperature *float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, exists := s.users[userID]
+	if !exists {
+		user = &models.User{ID: userID}
+	}
+
+	if temperature != nil {
+		value := *temperature
+		temperature = &value
+	}
+	user.Temperature = temperature
+	user.LastUsedAt = time.Now()
+	s.users[userID] = user
+	return nil
+}
+
+func (s *MemoryStorage) SetAllowedCategories(ctx context.Context, userID int64, categories []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, exists := s.users[userID]
+	if !exists {
+		user = &models.User{ID: userID}
+	}
+
+	user.AllowedCategories = NormalizeLabels(categories)
+	user.LastUsedAt = time.Now()
+	s.users[userID] = user
+	return nil
+}
+
+func (s *MemoryStorage) SetCategoryIcon(ctx context.Context, userID int64, category, icon string) error {
+	category = NormalizeLabel(category)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, exists := s.users[userID]
+	if !exists {
+		user = &models.User{ID: userID}
+	}
+
+	if icon == "" {
+		delete(user.CategoryIcons, category)
+	} else {
+		if user.CategoryIcons == nil {
+			user.CategoryIcons = make(map[string]string)
+		}
+		user.CategoryIcons[category] = icon
+	}
+	user.LastUsedAt = time.Now()
+	s.users[userID] = user
+	return nil
+}
+
+func (s *MemoryStorage) SetCategoryTags(ctx context.Context, userID int64, category string, tags []string) error {
+	if len(tags) == 0 {
+		return fmt.Errorf("%w: no tags given", ErrInvalidInput)
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	// Stub implementation: Add logic to update max tags if needed
+
+	if s.categoryTags[userID] == nil {
+		s.categoryTags[userID] = make(map[string][]string)
+	}
+	s.categoryTags[userID][NormalizeLabel(category)] = NormalizeLabels(tags)
+	return nil
+}
+
+func (s *MemoryStorage) GetCategoryTags(ctx context.Context, userID int64, category string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	tags := s.categoryTags[userID][NormalizeLabel(category)]
+	if tags == nil {
+		return nil, nil
+	}
+	return append([]string(nil), tags...), nil
+}
+
+func (s *MemoryStorage) ClearCategoryTags(ctx context.Context, userID int64, category string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := NormalizeLabel(category)
+	if _, exists := s.categoryTags[userID][key]; !exists {
+		return ErrNotFound
+	}
+	delete(s.categoryTags[userID], key)
+	return nil
+}
+
+// Message methods
+func (s *MemoryStorage) SaveMessage(ctx context.Context, message *models.Message) error {
+	if err := s.limits.checkMessage(message); err != nil {
+		return err
+	}
+	normalizeMessageLabels(message)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.messages[message.ID]; exists {
+		return ErrDuplicate
+	}
+	s.messages[message.ID] = copyMessage(message)
+	return nil
+}
+
+func (s *MemoryStorage) SaveMessages(ctx context.Context, messages []*models.Message) error {
+	for _, message := range messages {
+		if err := s.limits.checkMessage(message); err != nil {
+			return err
+		}
+		normalizeMessageLabels(message)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Check everything first so a duplicate leaves nothing half-imported
+	seen := make(map[string]struct{}, len(messages))
+	for _, message := range messages {
+		if _, exists := s.messages[message.ID]; exists {
+			return ErrDuplicate
+		}
+		if _, exists := seen[message.ID]; exists {
+			return ErrDuplicate
+		}
+		seen[message.ID] = struct{}{}
+	}
+	for _, message := range messages {
+		s.messages[message.ID] = copyMessage(message)
+		if _, exists := s.users[message.UserID]; !exists {
+			s.users[message.UserID] = &models.User{
+				ID:         message.UserID,
+				Categories: []string{},
+				Tags:       []string{},
+				LastUsedAt: time.Now(),
+			}
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStorage) GetUserMessages(ctx context.Context, userID int64, limit, offset int) ([]*models.Message, error) {
+	return s.findMessages(userID, limit, offset, func(m *models.Message) bool {
+		return !m.Archived
+	}), nil
+}
+
+func (s *MemoryStorage) GetUserMessagesByCategory(ctx context.Context, userID int64, category string, limit, offset int) ([]*models.Message, error) {
+	return s.findMessages(userID, limit, offset, func(m *models.Message) bool {
+		return !m.Archived && NormalizeLabel(m.Category) == NormalizeLabel(category)
+	}), nil
+}
+
+func (s *MemoryStorage) GetUserMessagesByTag(ctx context.Context, userID int64, tag string, limit, offset int) ([]*models.Message, error) {
+	return s.findMessages(userID, limit, offset, func(m *models.Message) bool {
+		if m.Archived {
+			return false
+		}
+		for _, t := range m.Tags {
+			if NormalizeLabel(t) == NormalizeLabel(tag) {
+				return true
+			}
+		}
+		return false
+	}), nil
+}
+
+func (s *MemoryStorage) GetArchivedMessages(ctx context.Context, userID int64, limit, offset int) ([]*models.Message, error) {
+	messages := s.findMessages(userID, 0, 0, func(m *models.Message) bool {
+		return m.Archived
+	})
+	sort.SliceStable(messages, func(i, j int) bool {
+		return archivedAt(messages[i]).After(archivedAt(messages[j]))
+	})
+
+	if offset >= len(messages) {
+		return []*models.Message{}, nil
+	}
+	messages = messages[max(offset, 0):]
+	if limit > 0 && limit < len(messages) {
+		messages = messages[:limit]
+	}
+	return messages, nil
+}
+
+// archivedAt is when message was archived, or the zero time if that isn't
+// known, which lists it last like NULLs in the SQL query
+func archivedAt(message *models.Message) time.Time {
+	if message.ArchivedAt == nil {
+		return time.Time{}
+	}
+	return *message.ArchivedAt
+}
+
+func (s *MemoryStorage) GetUserMessagesPinnedFirst(ctx context.Context, userID int64, limit, offset int) ([]*models.Message, error) {
+	messages := s.findMessages(userID, 0, 0, func(m *models.Message) bool {
+		return !m.Archived
+	})
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].IsPinned && !messages[j].IsPinned
+	})
+
+	if offset >= len(messages) {
+		return []*models.Message{}, nil
+	}
+	messages = messages[max(offset, 0):]
+	if limit > 0 && limit < len(messages) {
+		messages = messages[:limit]
+	}
+	return messages, nil
+}
+
+func (s *MemoryStorage) GetPinnedMessages(ctx context.Context, userID int64, limit, offset int) ([]*models.Message, error) {
+	return s.findMessages(userID, limit, offset, func(m *models.Message) bool {
+		return !m.Archived && m.IsPinned
+	}), nil
+}
+
+func (s *MemoryStorage) GetMessageByID(ctx context.Context, id string) (*models.Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	message, exists := s.messages[id]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	return copyMessage(message), nil
+}
+
+func (s *MemoryStorage) ArchiveMessage(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	message, exists := s.messages[id]
+	if !exists {
+		return ErrNotFound
+	}
+	// Archiving twice keeps the original archived_at
+	if !message.Archived {
+		now := time.Now()
+		message.Archived = true
+		message.ArchivedAt = &now
+	}
+	return nil
+}
+
+func (s *MemoryStorage) UnarchiveMessage(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	message, exists := s.messages[id]
+	if !exists {
+		return ErrNotFound
+	}
+	message.Archived = false
+	message.ArchivedAt = nil
+	return nil
+}
+
+func (s *MemoryStorage) SetMessagePinned(ctx context.Context, userID int64, id string, pinned bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	message, exists := s.messages[id]
+	if !exists || message.UserID != userID {
+		return ErrNotFound
+	}
+	message.IsPinned = pinned
+	return nil
+}
+
+func (s *MemoryStorage) DeleteMessage(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.messages[id]; !exists {
+		return ErrNotFound
+	}
+	delete(s.messages, id)
+	s.dropDangling()
+	return nil
+}
+
+func (s *MemoryStorage) DeleteMessagesOlderThan(ctx context.Context, userID int64, cutoff time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deleted := 0
+	for id, message := range s.messages {
+		if message.UserID == userID && message.CreatedAt.Before(cutoff) {
+			delete(s.messages, id)
+			deleted++
+		}
+	}
+	if deleted > 0 {
+		s.dropDangling()
+	}
+	return deleted, nil
+}
+
+// dropDangling removes replies and links to deleted messages, like the
+// foreign keys cascade in Postgres. The caller must hold s.mu.
+func (s *MemoryStorage) dropDangling() {
+	for reply, messageID := range s.replies {
+		if _, exists := s.messages[messageID]; !exists {
+			delete(s.replies, reply)
+		}
+	}
+	for source, reply := range s.sources {
+		if _, exists := s.replies[reply]; !exists {
+			delete(s.sources, source)
+		}
+	}
+	for key := range s.links {
+		_, first := s.messages[key.first]
+		_, second := s.messages[key.second]
+		if !first || !second {
+			delete(s.links, key)
+		}
+	}
+}
+
+func (s *MemoryStorage) LinkMessages(ctx context.Context, userID int64, fromID, toID string) error {
+	if fromID == toID {
+		return fmt.Errorf("%w: a message can't be linked to itself", ErrInvalidInput)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range []string{fromID, toID} {
+		if m, exists := s.messages[id]; !exists || m.UserID != userID {
+			return ErrNotFound
+		}
+	}
+
+	first, second := linkPair(fromID, toID)
+	key := linkKey{first: first, second: second}
+	if _, exists := s.links[key]; exists {
+		return ErrAlreadyExists
+	}
+	s.links[key] = struct{}{}
+	return nil
+}
+
+func (s *MemoryStorage) GetLinkedMessages(ctx context.Context, userID int64, id string) ([]*models.Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var linked []*models.Message
+	for key := range s.links {
+		other := ""
+		switch id {
+		case key.first:
+			other = key.second
+		case key.second:
+			other = key.first
+		default:
+			continue
+		}
+		if m, exists := s.messages[other]; exists && m.UserID == userID {
+			linked = append(linked, copyMessage(m))
+		}
+	}
+	sort.Slice(linked, func(i, j int) bool {
+		return linked[i].CreatedAt.After(linked[j].CreatedAt)
+	})
+	return linked, nil
+}
+
+func (s *MemoryStorage) UpdateMessageClassification(ctx context.Context, id string, category string, tags []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	message, exists := s.messages[id]
+	if !exists {
+		return ErrNotFound
+	}
+	message.Category = NormalizeLabel(category)
+	message.Tags = NormalizeLabels(tags)
+	return nil
+}
+
+func (s *MemoryStorage) UpdateMessage(ctx context.Context, message *models.Message) error {
+	if message == nil {
+		return fmt.Errorf("%w: message cannot be nil", ErrInvalidInput)
+	}
+	if err := s.limits.checkContent(message.Content); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, exists := s.messages[message.ID]
+	if !exists {
+		return ErrNotFound
+	}
+	stored.Content = message.Content
+	normalizeMessageLabels(message)
+	stored.Category = message.Category
+	stored.Tags = append([]string(nil), message.Tags...)
+	stored.Summary = message.Summary
+	stored.Links = append([]string(nil), message.Links...)
+	stored.AttachmentsAnalysis = message.AttachmentsAnalysis
+	return nil
+}
+
+func (s *MemoryStorage) FindSimilarMessage(ctx context.Context, userID int64, content string) (*models.Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	hash := ContentHash(content)
+	var latest *models.Message
+	for _, m := range s.messages {
+		if m.UserID == userID && ContentHash(m.Content) == hash &&
+			(latest == nil || m.CreatedAt.After(latest.CreatedAt)) {
+			latest = m
+		}
+	}
+	if latest == nil {
+		return nil, ErrNotFound
+	}
+	return copyMessage(latest), nil
+}
+
+func (s *MemoryStorage) FindDuplicateMessages(ctx context.Context, userID int64) ([]models.DuplicateGroup, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	byHash := make(map[string][]*models.Message)
+	for _, m := range s.messages {
+		if m.UserID == userID {
+			hash := ContentHash(m.Content)
+			byHash[hash] = append(byHash[hash], copyMessage(m))
+		}
+	}
+
+	var groups []models.DuplicateGroup
+	for hash, messages := range byHash {
+		if len(messages) < 2 {
+			continue
+		}
+		sort.Slice(messages, func(i, j int) bool {
+			return messages[i].CreatedAt.Before(messages[j].CreatedAt)
+		})
+		groups = append(groups, models.DuplicateGroup{ContentHash: hash, Messages: messages})
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].ContentHash < groups[j].ContentHash
+	})
+	return groups, nil
+}
+
+func (s *MemoryStorage) SaveClassificationReply(ctx context.Context, chatID int64, botMessageID int, messageID string, sourceMessageID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.messages[messageID]; !exists {
+		return ErrNotFound
+	}
+	reply := replyKey{chatID: chatID, botMessageID: botMessageID}
+	s.replies[reply] = messageID
+	if sourceMessageID != 0 {
+		s.sources[sourceKey{chatID: chatID, sourceMessageID: sourceMessageID}] = reply
+	}
+	return nil
+}
+
+func (s *MemoryStorage) GetClassificationBySource(ctx context.Context, chatID int64, sourceMessageID int) (*models.Message, int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	reply, exists := s.sources[sourceKey{chatID: chatID, sourceMessageID: sourceMessageID}]
+	if !exists {
+		return nil, 0, ErrNotFound
+	}
+	message, exists := s.messages[s.replies[reply]]
+	if !exists {
+		return nil, 0, ErrNotFound
+	}
+	return copyMessage(message), reply.botMessageID, nil
+}
+
+func (s *MemoryStorage) GetMessageByClassificationReply(ctx context.Context, chatID int64, botMessageID int) (*models.Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	messageID, exists := s.replies[replyKey{chatID: chatID, botMessageID: botMessageID}]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	message, exists := s.messages[messageID]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	return copyMessage(message), nil
+}
+
+func (s *MemoryStorage) GetUserStats(ctx context.Context, userID int64) (*models.UserStats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stats := &models.UserStats{}
+	categoryCounts := make(map[string]int)
+	categoryNames := make(map[string]string)
+	tags := make(map[string]bool)
+	for _, m := range s.messages {
+		if m.UserID != userID {
+			continue
+		}
+		stats.TotalMessages++
+		if stats.FirstMessageAt.IsZero() || m.CreatedAt.Before(stats.FirstMessageAt) {
+			stats.FirstMessageAt = m.CreatedAt
+		}
+		if m.CreatedAt.After(stats.LastMessageAt) {
+			stats.LastMessageAt = m.CreatedAt
+		}
+		if m.Category != "" {
+			key := NormalizeLabel(m.Category)
+			categoryCounts[key]++
+			if name, ok := categoryNames[key]; !ok || m.Category < name {
+				categoryNames[key] = m.Category
+			}
+		}
+		for _, tag := range m.Tags {
+			tags[NormalizeLabel(tag)] = true
+		}
+	}
+
+	stats.Categories = len(categoryCounts)
+	stats.Tags = len(tags)
+	for key, count := range categoryCounts {
+		name := categoryNames[key]
+		if count > stats.TopCategoryCount || (count == stats.TopCategoryCount && name < stats.TopCategory) {
+			stats.TopCategory = name
+			stats.TopCategoryCount = count
+		}
+	}
+	return stats, nil
+}
+
+func (s *MemoryStorage) BackupUser(ctx context.Context, userID int64) (*models.Backup, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	backup := &models.Backup{UserID: userID, CategoryTags: make(map[string][]string)}
+	if user, exists := s.users[userID]; exists {
+		backup.User = copyUser(user)
+		backup.User.ThreadID = ""
+	} else {
+		backup.User = &models.User{ID: userID, MaxTags: DefaultMaxTags, LastUsedAt: time.Now()}
+	}
+
+	for _, m := range s.messages {
+		if m.UserID == userID {
+			backup.Messages = append(backup.Messages, copyMessage(m))
+		}
+	}
+	sort.Slice(backup.Messages, func(i, j int) bool {
+		return backup.Messages[i].CreatedAt.Before(backup.Messages[j].CreatedAt)
+	})
+
+	for category, tags := range s.categoryTags[userID] {
+		backup.CategoryTags[category] = append([]string(nil), tags...)
+	}
+	for key := range s.links {
+		if m := s.messages[key.first]; m != nil && m.UserID == userID {
+			backup.Links = append(backup.Links, models.NoteLink{FromID: key.first, ToID: key.second})
+		}
+	}
+	sort.Slice(backup.Links, func(i, j int) bool {
+		if backup.Links[i].FromID != backup.Links[j].FromID {
+			return backup.Links[i].FromID < backup.Links[j].FromID
+		}
+		return backup.Links[i].ToID < backup.Links[j].ToID
+	})
+	return backup, nil
+}
+
+func (s *MemoryStorage) RestoreUser(ctx context.Context, backup *models.Backup, replace bool) (int, error) {
+	if err := s.limits.prepareRestore(backup); err != nil {
+		return 0, err
+	}
+	userID := backup.UserID
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, exists := s.users[userID]
+	if !exists {
+		current = &models.User{ID: userID}
+	}
+	// Everything that can fail is checked before anything changes
+	user, err := s.limits.restoredUser(current, backup.User, replace)
+	if err != nil {
+		return 0, err
+	}
+
+	if replace {
+		for id, m := range s.messages {
+			if m.UserID == userID {
+				delete(s.messages, id)
+			}
+		}
+		s.dropDangling()
+		delete(s.categoryTags, userID)
+	}
+	s.users[userID] = user
+
+	restored := 0
+	for _, message := range backup.Messages {
+		if _, exists := s.messages[message.ID]; exists {
+			continue
+		}
+		s.messages[message.ID] = copyMessage(message)
+		restored++
+	}
+
+	if len(backup.CategoryTags) > 0 && s.categoryTags[userID] == nil {
+		s.categoryTags[userID] = make(map[string][]string)
+	}
+	for category, tags := range backup.CategoryTags {
+		if _, exists := s.categoryTags[userID][category]; !exists {
+			s.categoryTags[userID][category] = append([]string(nil), tags...)
+		}
+	}
+
+	for _, link := range backup.Links {
+		from, to := s.messages[link.FromID], s.messages[link.ToID]
+		// Notes whose ID another user already has were not restored
+		if from == nil || to == nil || from.UserID != userID || to.UserID != userID {
+			continue
+		}
+		first, second := linkPair(link.FromID, link.ToID)
+		s.links[linkKey{first: first, second: second}] = struct{}{}
+	}
+	return restored, nil
+}
+
+func (s *MemoryStorage) GetCategoryCounts(ctx context.Context, userID int64) (map[string]int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	counts := make(map[string]int)
+	names := make(map[string]string)
+	for _, m := range s.messages {
+		if m.UserID != userID || m.Archived || m.Category == "" {
+			continue
+		}
+		key := NormalizeLabel(m.Category)
+		counts[key]++
+		if name, ok := names[key]; !ok || m.Category < name {
+			names[key] = m.Category
+		}
+	}
+
+	byName := make(map[string]int, len(counts))
+	for key, count := range counts {
+		byName[names[key]] = count
+	}
+	return byName, nil
+}
+
+func (s *MemoryStorage) GetTagCounts(ctx context.Context, userID int64) (map[string]int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	counts := make(map[string]int)
+	names := make(map[string]string)
+	for _, m := range s.messages {
+		if m.UserID != userID || m.Archived {
+			continue
+		}
+		seen := make(map[string]bool, len(m.Tags))
+		for _, tag := range m.Tags {
+			key := NormalizeLabel(tag)
+			if tag == "" || seen[key] {
+				continue
+			}
+			seen[key] = true
+			counts[key]++
+			if name, ok := names[key]; !ok || tag < name {
+				names[key] = tag
+			}
+		}
+	}
+
+	byName := make(map[string]int, len(counts))
+	for key, count := range counts {
+		byName[names[key]] = count
+	}
+	return byName, nil
+}
+
+// findMessages returns copies of the user's messages matching the filter,
+// newest first, paginated the same way as the SQL queries
+func (s *MemoryStorage) findMessages(userID int64, limit, offset int, match func(*models.Message) bool) []*models.Message {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var found []*models.Message
+	for _, m := range s.messages {
+		if m.UserID == userID && match(m) {
+			found = append(found, m)
+		}
+	}
+
+	sort.Slice(found, func(i, j int) bool {
+		return found[i].CreatedAt.After(found[j].CreatedAt)
+	})
+
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= len(found) {
+		return []*models.Message{}
+	}
+	found = found[offset:]
+	if limit > 0 && limit < len(found) {
+		found = found[:limit]
+	}
+
+	result := make([]*models.Message, len(found))
+	for i, m := range found {
+		result[i] = copyMessage(m)
+	}
+	return result
+}
+
+func copyMessage(m *models.Message) *models.Message {
+	c := *m
+	c.Tags = append([]string(nil), m.Tags...)
+	c.Links = append([]string(nil), m.Links...)
+	if m.ArchivedAt != nil {
+		archivedAt := *m.ArchivedAt
+		c.ArchivedAt = &archivedAt
+	}
+	return &c
+}
+
+func (s *MemoryStorage) IsChatMuted(ctx context.Context, chatID int64) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.mutedChats[chatID], nil
+}
+
+func (s *MemoryStorage) SetChatMuted(ctx context.Context, chatID int64, muted bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if muted {
+		s.mutedChats[chatID] = true
+	} else {
+		delete(s.mutedChats, chatID)
+	}
 	return nil
 }
@@ -0,0 +1,378 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xaenox/memo-bot/internal/models"
+)
+
+func TestSaveMessagesCreatesUser(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStorage(Limits{})
+
+	err := s.SaveMessages(ctx, []*models.Message{
+		{ID: "a", UserID: 5, Content: "first", Category: "work", CreatedAt: time.Now()},
+		{ID: "b", UserID: 5, Content: "second", Category: "work", CreatedAt: time.Now()},
+	})
+	if err != nil {
+		t.Fatalf("SaveMessages: %v", err)
+	}
+
+	user, err := s.GetUser(ctx, 5)
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	if user == nil || user.ID != 5 {
+		t.Fatalf("user = %+v, want user 5", user)
+	}
+}
+
+func TestGetUserReturnsCopy(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStorage(Limits{})
+	if err := s.AddCategory(ctx, 1, "work"); err != nil {
+		t.Fatalf("AddCategory: %v", err)
+	}
+
+	user, err := s.GetUser(ctx, 1)
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	user.Categories[0] = "changed"
+	user.Language = "ru"
+
+	stored, err := s.GetUser(ctx, 1)
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	if stored.Categories[0] != "work" || stored.Language != "" {
+		t.Errorf("stored user changed through GetUser's result: %+v", stored)
+	}
+
+	// Readers of a returned user must not race with later writes
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			_ = s.SetCategoryIcon(ctx, 1, "work", "💼")
+			_ = s.UpdateUserLanguage(ctx, 1, "en")
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		user, _ := s.GetUser(ctx, 1)
+		_ = user.CategoryIcons["work"]
+		_ = user.Language
+	}
+	<-done
+}
+
+func TestMessageContentLimit(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStorage(Limits{MaxContentBytes: 10})
+
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{"below the limit", strings.Repeat("a", 9), false},
+		{"at the limit", strings.Repeat("a", 10), false},
+		{"one past the limit", strings.Repeat("a", 11), true},
+		// The limit counts bytes, not characters
+		{"multibyte past the limit", strings.Repeat("ä", 6), true},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := fmt.Sprintf("m%d", i)
+			err := s.SaveMessage(ctx, &models.Message{ID: id, UserID: 1, Content: tt.content, Category: "work", CreatedAt: time.Now()})
+			if tt.wantErr != errors.Is(err, ErrInvalidInput) {
+				t.Fatalf("SaveMessage: %v, want invalid input %v", err, tt.wantErr)
+			}
+
+			// Edits are held to the same limit
+			if tt.wantErr {
+				return
+			}
+			err = s.UpdateMessage(ctx, &models.Message{ID: id, Content: tt.content + "a", Category: "work"})
+			if wantErr := len(tt.content)+1 > 10; wantErr != errors.Is(err, ErrInvalidInput) {
+				t.Errorf("UpdateMessage: %v, want invalid input %v", err, wantErr)
+			}
+		})
+	}
+}
+
+func TestUserTagLimit(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStorage(Limits{MaxUserTags: 3})
+
+	for _, tag := range []string{"one", "two", "three"} {
+		if err := s.AddTag(ctx, 1, tag); err != nil {
+			t.Fatalf("AddTag(%q) below the limit: %v", tag, err)
+		}
+	}
+	// A tag the user already has doesn't count against the limit
+	if err := s.AddTag(ctx, 1, "Two"); err != nil {
+		t.Errorf("AddTag of an existing tag at the limit: %v", err)
+	}
+	if err := s.AddTag(ctx, 1, "four"); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("AddTag one past the limit: %v, want invalid input", err)
+	}
+
+	user, err := s.GetUser(ctx, 1)
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	if len(user.Tags) != 3 {
+		t.Errorf("tags = %v, want 3", user.Tags)
+	}
+}
+
+func TestGetUserMessagesPagination(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStorage(Limits{})
+
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		err := s.SaveMessage(ctx, &models.Message{ID: fmt.Sprintf("m%d", i), UserID: 1, Content: "note", Category: "work", CreatedAt: start.Add(time.Duration(i) * time.Hour)})
+		if err != nil {
+			t.Fatalf("SaveMessage: %v", err)
+		}
+	}
+	// Someone else's note is never listed
+	if err := s.SaveMessage(ctx, &models.Message{ID: "other", UserID: 2, Content: "note", Category: "work", CreatedAt: start}); err != nil {
+		t.Fatalf("SaveMessage: %v", err)
+	}
+
+	tests := []struct {
+		limit, offset int
+		want          []string
+	}{
+		{2, 0, []string{"m4", "m3"}},
+		{2, 2, []string{"m2", "m1"}},
+		{2, 4, []string{"m0"}},
+		{2, 5, nil},
+		{10, 0, []string{"m4", "m3", "m2", "m1", "m0"}},
+	}
+	for _, tt := range tests {
+		messages, err := s.GetUserMessages(ctx, 1, tt.limit, tt.offset)
+		if err != nil {
+			t.Fatalf("GetUserMessages(%d, %d): %v", tt.limit, tt.offset, err)
+		}
+		var ids []string
+		for _, m := range messages {
+			ids = append(ids, m.ID)
+		}
+		if strings.Join(ids, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("GetUserMessages(%d, %d) = %v, want %v", tt.limit, tt.offset, ids, tt.want)
+		}
+	}
+}
+
+func TestGetAndDeleteMessage(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStorage(Limits{})
+	if err := s.SaveMessage(ctx, &models.Message{ID: "a", UserID: 1, Content: "note", Category: "work", CreatedAt: time.Now()}); err != nil {
+		t.Fatalf("SaveMessage: %v", err)
+	}
+
+	message, err := s.GetMessageByID(ctx, "a")
+	if err != nil || message.Content != "note" {
+		t.Fatalf("GetMessageByID = %+v, %v", message, err)
+	}
+	if err := s.DeleteMessage(ctx, "a"); err != nil {
+		t.Fatalf("DeleteMessage: %v", err)
+	}
+	if _, err := s.GetMessageByID(ctx, "a"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetMessageByID after delete: %v, want not found", err)
+	}
+	if err := s.DeleteMessage(ctx, "a"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("DeleteMessage twice: %v, want not found", err)
+	}
+}
+
+func TestMaxTagsRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStorage(Limits{})
+
+	user, err := s.GetUser(ctx, 1)
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	if user.MaxTags != DefaultMaxTags {
+		t.Errorf("new user MaxTags = %d, want %d", user.MaxTags, DefaultMaxTags)
+	}
+
+	// A user created by another setting has the default too
+	if err := s.AddTag(ctx, 2, "home"); err != nil {
+		t.Fatalf("AddTag: %v", err)
+	}
+	if user, err := s.GetUser(ctx, 2); err != nil || user.MaxTags != DefaultMaxTags {
+		t.Errorf("GetUser = %+v, %v; want MaxTags %d", user, err, DefaultMaxTags)
+	}
+
+	if err := s.UpdateUserMaxTags(ctx, 1, 3); err != nil {
+		t.Fatalf("UpdateUserMaxTags: %v", err)
+	}
+	// Other settings leave the limit alone
+	if err := s.AddCategory(ctx, 1, "work"); err != nil {
+		t.Fatalf("AddCategory: %v", err)
+	}
+	if user, err = s.GetUser(ctx, 1); err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	if user.MaxTags != 3 {
+		t.Errorf("MaxTags = %d, want 3", user.MaxTags)
+	}
+
+	if err := s.UpdateUserMaxTags(ctx, 1, 0); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("UpdateUserMaxTags(0): %v, want invalid input", err)
+	}
+	if user, _ = s.GetUser(ctx, 1); user.MaxTags != 3 {
+		t.Errorf("MaxTags after a rejected update = %d, want 3", user.MaxTags)
+	}
+}
+
+func TestBackupRestoreRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	src := NewMemoryStorage(Limits{})
+
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	err := src.SaveMessages(ctx, []*models.Message{
+		{ID: "a", UserID: 1, Content: "first", Category: "work", Tags: []string{"meeting"}, CreatedAt: created},
+		{ID: "b", UserID: 1, Content: "second", Category: "home", Links: []string{"https://example.com"}, CreatedAt: created.Add(time.Hour)},
+		{ID: "c", UserID: 2, Content: "someone else's", Category: "work", CreatedAt: created},
+	})
+	if err != nil {
+		t.Fatalf("SaveMessages: %v", err)
+	}
+	if err := src.AddTag(ctx, 1, "meeting"); err != nil {
+		t.Fatalf("AddTag: %v", err)
+	}
+	if err := src.UpdateUserLanguage(ctx, 1, "ru"); err != nil {
+		t.Fatalf("UpdateUserLanguage: %v", err)
+	}
+	if err := src.UpdateUserMaxTags(ctx, 1, 3); err != nil {
+		t.Fatalf("UpdateUserMaxTags: %v", err)
+	}
+	if err := src.SetCategoryTags(ctx, 1, "work", []string{"job"}); err != nil {
+		t.Fatalf("SetCategoryTags: %v", err)
+	}
+	if err := src.LinkMessages(ctx, 1, "a", "b"); err != nil {
+		t.Fatalf("LinkMessages: %v", err)
+	}
+
+	backup, err := src.BackupUser(ctx, 1)
+	if err != nil {
+		t.Fatalf("BackupUser: %v", err)
+	}
+	if len(backup.Messages) != 2 {
+		t.Fatalf("backup has %d notes, want only user 1's 2", len(backup.Messages))
+	}
+	// The backup travels as a JSON file
+	data, err := json.Marshal(backup)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	var decoded models.Backup
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+
+	dst := NewMemoryStorage(Limits{})
+	restored, err := dst.RestoreUser(ctx, &decoded, false)
+	if err != nil {
+		t.Fatalf("RestoreUser: %v", err)
+	}
+	if restored != 2 {
+		t.Errorf("restored %d notes, want 2", restored)
+	}
+
+	got, err := dst.BackupUser(ctx, 1)
+	if err != nil {
+		t.Fatalf("BackupUser after restore: %v", err)
+	}
+	if !reflect.DeepEqual(got.Messages, backup.Messages) {
+		t.Errorf("restored notes = %+v, want %+v", got.Messages, backup.Messages)
+	}
+	if !reflect.DeepEqual(got.CategoryTags, backup.CategoryTags) {
+		t.Errorf("restored category tags = %v, want %v", got.CategoryTags, backup.CategoryTags)
+	}
+	if !reflect.DeepEqual(got.Links, backup.Links) {
+		t.Errorf("restored links = %v, want %v", got.Links, backup.Links)
+	}
+	if got.User.Language != "ru" || got.User.MaxTags != 3 || !reflect.DeepEqual(got.User.Tags, []string{"meeting"}) {
+		t.Errorf("restored user = %+v, want language ru, 3 max tags and tag meeting", got.User)
+	}
+
+	// Merging the same backup again adds nothing
+	again := decoded
+	if restored, err := dst.RestoreUser(ctx, &again, false); err != nil || restored != 0 {
+		t.Errorf("second RestoreUser = %d, %v; want 0 notes restored", restored, err)
+	}
+}
+
+func TestRestoreArchivedWithoutTime(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStorage(Limits{})
+	archivedAt := time.Now()
+
+	restored, err := s.RestoreUser(ctx, &models.Backup{
+		UserID: 1,
+		Messages: []*models.Message{
+			{ID: "a", Content: "archived", Category: "work", Archived: true, CreatedAt: time.Now()},
+			{ID: "b", Content: "not archived", Category: "work", ArchivedAt: &archivedAt, CreatedAt: time.Now()},
+		},
+	}, false)
+	if err != nil || restored != 2 {
+		t.Fatalf("RestoreUser = %d, %v; want 2 notes restored", restored, err)
+	}
+
+	archived, err := s.GetArchivedMessages(ctx, 1, 10, 0)
+	if err != nil {
+		t.Fatalf("GetArchivedMessages: %v", err)
+	}
+	if len(archived) != 1 || archived[0].ID != "a" || archived[0].ArchivedAt == nil {
+		t.Errorf("archived = %+v, want note a with the time it was archived", archived)
+	}
+	if m, err := s.GetMessageByID(ctx, "b"); err != nil || m.ArchivedAt != nil {
+		t.Errorf("GetMessageByID(b) = %+v, %v; want no archive time", m, err)
+	}
+}
+
+func TestRemoveTagLeavesReadListsAlone(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStorage(Limits{})
+	for _, tag := range []string{"a", "b", "c"} {
+		if err := s.AddTag(ctx, 1, tag); err != nil {
+			t.Fatalf("AddTag: %v", err)
+		}
+		if err := s.AddCategory(ctx, 1, tag); err != nil {
+			t.Fatalf("AddCategory: %v", err)
+		}
+	}
+
+	tags, _ := s.GetUserTags(ctx, 1)
+	categories, _ := s.GetUserCategories(ctx, 1)
+	if err := s.RemoveTag(ctx, 1, "a"); err != nil {
+		t.Fatalf("RemoveTag: %v", err)
+	}
+	if err := s.RemoveCategory(ctx, 1, "a"); err != nil {
+		t.Fatalf("RemoveCategory: %v", err)
+	}
+	if !reflect.DeepEqual(tags, []string{"a", "b", "c"}) || !reflect.DeepEqual(categories, []string{"a", "b", "c"}) {
+		t.Errorf("lists read before the removal changed to %v and %v", tags, categories)
+	}
+
+	tags, _ = s.GetUserTags(ctx, 1)
+	tags[0] = "changed"
+	if stored, _ := s.GetUserTags(ctx, 1); !reflect.DeepEqual(stored, []string{"b", "c"}) {
+		t.Errorf("stored tags = %v, want [b c]", stored)
+	}
+}
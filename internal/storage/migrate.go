@@ -0,0 +1,203 @@
+package storage
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Arbitrary key for the advisory lock that keeps concurrently starting
+// instances from applying the same migration twice
+const migrationLockKey = 7_302_514
+
+// migration is one numbered file from the migrations directory, named like
+// 0003_messages.sql
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+func loadMigrations() ([]migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, fmt.Errorf("error reading migrations: %v", err)
+	}
+
+	migrations := make([]migration, 0, len(entries))
+	seen := make(map[int]string)
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".sql")
+		prefix, _, _ := strings.Cut(name, "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil || version < 1 {
+			return nil, fmt.Errorf("migration %s has no version prefix", entry.Name())
+		}
+		if other, exists := seen[version]; exists {
+			return nil, fmt.Errorf("migrations %s and %s share version %d", other, name, version)
+		}
+		seen[version] = name
+
+		content, err := migrationFiles.ReadFile(path.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("error reading migration %s: %v", entry.Name(), err)
+		}
+		migrations = append(migrations, migration{version: version, name: name, sql: string(content)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+	return migrations, nil
+}
+
+// migrate applies pending migrations in version order, each in its own
+// transaction together with its schema_migrations record
+func (s *PostgresStorage) migrate(ctx context.Context) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, `
+        CREATE TABLE IF NOT EXISTS schema_migrations (
+            version INTEGER PRIMARY KEY,
+            name TEXT NOT NULL,
+            applied_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+        )`)
+	if err != nil {
+		return fmt.Errorf("error creating schema_migrations table: %v", err)
+	}
+
+	applied := 0
+	for _, m := range migrations {
+		ran, err := s.applyMigration(ctx, m)
+		if err != nil {
+			return fmt.Errorf("error applying migration %s: %v", m.name, err)
+		}
+		if ran {
+			applied++
+			s.logger.Info("Applied database migration",
+				zap.Int("version", m.version),
+				zap.String("name", m.name))
+		}
+	}
+
+	if applied == 0 {
+		s.logger.Info("Database schema is up to date")
+	}
+	return nil
+}
+
+// applyMigration runs m unless it was applied before and reports whether it ran
+func (s *PostgresStorage) applyMigration(ctx context.Context, m migration) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", migrationLockKey); err != nil {
+		return false, err
+	}
+
+	var exists bool
+	err = tx.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)", m.version).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+	if exists {
+		return false, nil
+	}
+
+	if _, err := tx.ExecContext(ctx, m.sql); err != nil {
+		return false, err
+	}
+	if _, err := tx.ExecContext(ctx,
+		"INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", m.version, m.name); err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// rehashBatchSize is how many notes rehashContent updates per query
+const rehashBatchSize = 500
+
+// legacyContentHash is the content_hash expression of
+// migrations/0007_message_content_hash.sql, the hash notes get without a key,
+// taken over the content with escapedPrefix removed
+const legacyContentHash = `encode(sha256(convert_to(
+    lower(regexp_replace(btrim(CASE WHEN content LIKE 'enc:plain:%' THEN substr(content, 11) ELSE content END, E' \t\n\r'), E'\\s+', ' ', 'g')), 'UTF8')), 'hex')`
+
+// rehashContent gives notes saved before the encryption key was set the
+// keyed content hash, so duplicate checks keep finding them. Their content
+// stays plaintext until they are next edited.
+func (s *PostgresStorage) rehashContent(ctx context.Context) error {
+	if s.cipher == nil {
+		return nil
+	}
+
+	type legacyNote struct {
+		id      string
+		content string
+	}
+	rehashed := 0
+	for {
+		rows, err := s.db.QueryContext(ctx, `
+            SELECT id, content
+            FROM messages
+            WHERE content NOT LIKE $1 AND content_hash = `+legacyContentHash+`
+            LIMIT $2`, encryptedPrefix+"%", rehashBatchSize)
+		if err != nil {
+			return fmt.Errorf("error finding notes to rehash: %v", err)
+		}
+		var notes []legacyNote
+		for rows.Next() {
+			var note legacyNote
+			if err := rows.Scan(&note.id, &note.content); err != nil {
+				rows.Close()
+				return fmt.Errorf("error reading notes to rehash: %v", err)
+			}
+			notes = append(notes, note)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("error reading notes to rehash: %v", err)
+		}
+
+		for _, note := range notes {
+			content, err := s.cipher.decrypt(note.content)
+			if err != nil {
+				return fmt.Errorf("error reading note %s to rehash: %v", note.id, err)
+			}
+			if _, err := s.db.ExecContext(ctx,
+				"UPDATE messages SET content_hash = $2 WHERE id = $1", note.id, s.cipher.hash(content)); err != nil {
+				return fmt.Errorf("error rehashing note %s: %v", note.id, err)
+			}
+		}
+		rehashed += len(notes)
+		if len(notes) < rehashBatchSize {
+			break
+		}
+	}
+
+	if rehashed > 0 {
+		s.logger.Info("Rehashed notes saved before encryption was enabled",
+			zap.Int("notes", rehashed))
+	}
+	return nil
+}
@@ -0,0 +1,6 @@
+-- Single-row table for bot-wide state such as the polling offset
+CREATE TABLE IF NOT EXISTS bot_state (
+    id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+    update_offset BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
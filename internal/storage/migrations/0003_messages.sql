@@ -0,0 +1,14 @@
+-- Create messages table
+CREATE TABLE IF NOT EXISTS messages (
+    id VARCHAR(36) PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    content TEXT NOT NULL DEFAULT '',
+    category VARCHAR(255) NOT NULL DEFAULT '',
+    tags TEXT[] DEFAULT '{}',
+    summary TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES user_metadata(user_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_messages_user_created ON messages(user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_messages_tags ON messages USING GIN(tags);
@@ -0,0 +1 @@
+ALTER TABLE user_metadata ADD COLUMN IF NOT EXISTS date_format VARCHAR(64) NOT NULL DEFAULT '';
@@ -0,0 +1 @@
+ALTER TABLE user_metadata ADD COLUMN IF NOT EXISTS category_icons JSONB NOT NULL DEFAULT '{}';
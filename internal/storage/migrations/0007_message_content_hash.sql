@@ -0,0 +1,8 @@
+-- Hash of the normalized content for duplicate detection, see storage.ContentHash
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS content_hash VARCHAR(64) NOT NULL DEFAULT '';
+UPDATE messages
+SET content_hash = encode(sha256(convert_to(
+    lower(regexp_replace(btrim(content, E' \t\n\r'), E'\\s+', ' ', 'g')), 'UTF8')), 'hex')
+WHERE content_hash = '';
+
+CREATE INDEX IF NOT EXISTS idx_messages_user_hash ON messages(user_id, content_hash);
@@ -0,0 +1,8 @@
+-- Bot replies showing a message's classification, so users can reply to correct it
+CREATE TABLE IF NOT EXISTS classification_replies (
+    chat_id BIGINT NOT NULL,
+    bot_message_id INTEGER NOT NULL,
+    message_id VARCHAR(36) NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (chat_id, bot_message_id)
+);
@@ -0,0 +1,3 @@
+-- Telegram file of photo, document and video notes
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS file_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS content_type VARCHAR(16) NOT NULL DEFAULT 'text';
@@ -0,0 +1,2 @@
+-- Language of bot replies; empty follows the Telegram client language
+ALTER TABLE user_metadata ADD COLUMN IF NOT EXISTS language VARCHAR(16) NOT NULL DEFAULT '';
@@ -0,0 +1,4 @@
+-- max_tags existed since the initial schema but was nullable and never read
+UPDATE user_metadata SET max_tags = 5 WHERE max_tags IS NULL OR max_tags < 1;
+ALTER TABLE user_metadata ALTER COLUMN max_tags SET DEFAULT 5;
+ALTER TABLE user_metadata ALTER COLUMN max_tags SET NOT NULL;
@@ -0,0 +1,5 @@
+-- The user's Telegram message a classification reply answers, so edits to it
+-- can update the note
+ALTER TABLE classification_replies ADD COLUMN IF NOT EXISTS source_message_id INTEGER;
+CREATE INDEX IF NOT EXISTS idx_classification_replies_source
+    ON classification_replies (chat_id, source_message_id);
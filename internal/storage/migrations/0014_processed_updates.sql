@@ -0,0 +1,6 @@
+-- Telegram updates already handled, so redelivered ones can be skipped.
+-- Only the most recent IDs are kept.
+CREATE TABLE IF NOT EXISTS processed_updates (
+    update_id BIGINT PRIMARY KEY,
+    processed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
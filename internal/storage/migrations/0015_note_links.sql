@@ -0,0 +1,12 @@
+-- Related notes a user connected with /link. Links go both ways, so each pair
+-- is stored once with the smaller ID first.
+CREATE TABLE IF NOT EXISTS note_links (
+    user_id BIGINT NOT NULL,
+    from_id VARCHAR(36) NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    to_id VARCHAR(36) NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (from_id, to_id),
+    CHECK (from_id < to_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_note_links_to ON note_links (to_id);
@@ -0,0 +1,2 @@
+-- Where a forwarded note came from: the channel, chat or author's name
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS source TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,8 @@
+-- Tags added to every note saved in a category, set with /setcategorytags.
+-- Categories are stored lowercased with spaces replaced by underscores.
+CREATE TABLE IF NOT EXISTS category_default_tags (
+    user_id BIGINT NOT NULL,
+    category TEXT NOT NULL,
+    tags TEXT[] NOT NULL,
+    PRIMARY KEY (user_id, category)
+);
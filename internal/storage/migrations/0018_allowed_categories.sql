@@ -0,0 +1,3 @@
+-- Categories a user restricted classification to with /settaxonomy; empty
+-- leaves the assistant free to pick any category
+ALTER TABLE user_metadata ADD COLUMN IF NOT EXISTS allowed_categories TEXT[] NOT NULL DEFAULT '{}';
@@ -0,0 +1,2 @@
+-- URLs the classifier found in a note
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS links TEXT[] NOT NULL DEFAULT '{}';
@@ -0,0 +1,2 @@
+-- Days a user's notes are kept before the purge deletes them; 0 keeps them
+ALTER TABLE user_metadata ADD COLUMN IF NOT EXISTS retention_days INTEGER NOT NULL DEFAULT 0;
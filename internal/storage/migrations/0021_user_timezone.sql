@@ -0,0 +1,2 @@
+-- IANA time zone dates are shown in; empty shows UTC
+ALTER TABLE user_metadata ADD COLUMN IF NOT EXISTS timezone VARCHAR(64) NOT NULL DEFAULT '';
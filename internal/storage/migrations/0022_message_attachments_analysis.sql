@@ -0,0 +1,2 @@
+-- The assistant's description of a note's attached file
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS attachments_analysis TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,4 @@
+-- Notes the user pinned for quick access with /pin
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS is_pinned BOOLEAN NOT NULL DEFAULT false;
+
+CREATE INDEX IF NOT EXISTS idx_messages_pinned ON messages (user_id, created_at DESC) WHERE is_pinned;
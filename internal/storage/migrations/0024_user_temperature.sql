@@ -0,0 +1,2 @@
+-- The user's classifier temperature; NULL uses the configured default
+ALTER TABLE user_metadata ADD COLUMN IF NOT EXISTS temperature DOUBLE PRECISION;
@@ -0,0 +1,57 @@
+-- Categories and tags are stored in one form so "Work" and "work" are the
+-- same label. normalize_label must match storage.NormalizeLabel: trimmed,
+-- without a leading "#", lowercased, whitespace runs joined by "_".
+CREATE FUNCTION pg_temp.normalize_label(label TEXT) RETURNS TEXT AS $$
+    SELECT lower(regexp_replace(regexp_replace(label, '^\s*#?\s*|\s+$', '', 'g'), '\s+', '_', 'g'))
+$$ LANGUAGE SQL IMMUTABLE;
+
+-- Folding a list keeps the first position of labels that become equal
+CREATE FUNCTION pg_temp.normalize_labels(labels TEXT[]) RETURNS TEXT[] AS $$
+    SELECT ARRAY(
+        SELECT label FROM (
+            SELECT pg_temp.normalize_label(l) AS label, MIN(ord) AS ord
+            FROM unnest(labels) WITH ORDINALITY AS u(l, ord)
+            GROUP BY 1
+        ) normalized
+        WHERE label <> ''
+        ORDER BY ord)
+$$ LANGUAGE SQL IMMUTABLE;
+
+UPDATE messages
+SET category = pg_temp.normalize_label(category),
+    tags = pg_temp.normalize_labels(tags)
+WHERE category IS DISTINCT FROM pg_temp.normalize_label(category)
+    OR tags IS DISTINCT FROM pg_temp.normalize_labels(tags);
+
+UPDATE user_metadata
+SET categories = pg_temp.normalize_labels(categories)
+WHERE categories IS DISTINCT FROM pg_temp.normalize_labels(categories);
+
+UPDATE user_metadata
+SET tags = pg_temp.normalize_labels(tags)
+WHERE tags IS DISTINCT FROM pg_temp.normalize_labels(tags);
+
+UPDATE user_metadata
+SET allowed_categories = pg_temp.normalize_labels(allowed_categories)
+WHERE allowed_categories IS DISTINCT FROM pg_temp.normalize_labels(allowed_categories);
+
+UPDATE user_metadata
+SET category_icons = COALESCE((
+    SELECT jsonb_object_agg(pg_temp.normalize_label(key), value)
+    FROM jsonb_each(category_icons)), '{}')
+WHERE category_icons IS NOT NULL AND category_icons <> '{}';
+
+-- Default tags are keyed by category; when two keys fold together only
+-- one of them is kept
+DELETE FROM category_default_tags d
+USING category_default_tags other
+WHERE d.user_id = other.user_id
+    AND d.category <> other.category
+    AND pg_temp.normalize_label(d.category) = pg_temp.normalize_label(other.category)
+    AND d.ctid > other.ctid;
+
+UPDATE category_default_tags
+SET category = pg_temp.normalize_label(category),
+    tags = pg_temp.normalize_labels(tags)
+WHERE category <> pg_temp.normalize_label(category)
+    OR tags IS DISTINCT FROM pg_temp.normalize_labels(tags);
@@ -3,42 +3,73 @@ package storage
 import (
 	"context"
 	"database/sql"
-	"embed"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net"
+	"strings"
 	"time"
 
 	"github.com/lib/pq"
+	"github.com/xaenox/memo-bot/internal/logging"
+	"github.com/xaenox/memo-bot/internal/metrics"
 	"github.com/xaenox/memo-bot/internal/models"
+	"github.com/xaenox/memo-bot/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
 )
 
-//go:embed migrations.sql
-var migrations embed.FS
-
+// DatabaseConfig is the database section of the config file
 type DatabaseConfig struct {
-	Host        string
-	Port        int
-	User        string
-	Password    string
-	DBName      string
-	SSLMode     string
-	UseInMemory bool
+	Host        string `mapstructure:"host"`
+	Port        int    `mapstructure:"port"`
+	User        string `mapstructure:"user"`
+	Password    string `mapstructure:"password"`
+	DBName      string `mapstructure:"dbname"`
+	SSLMode     string `mapstructure:"sslmode"`
+	UseInMemory bool   `mapstructure:"use_in_memory"`
+	// ConnectTimeout is the connection timeout in seconds; 0 waits indefinitely
+	ConnectTimeout int `mapstructure:"connect_timeout"`
+
+	// Connection pool limits; zero values fall back to the defaults below
+	MaxOpenConns    int           `mapstructure:"max_open_conns"`
+	MaxIdleConns    int           `mapstructure:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `mapstructure:"conn_max_lifetime"`
+
+	Limits `mapstructure:",squash"`
+
+	// EncryptionKey is a base64 encoded 16, 24 or 32 byte AES key; when set,
+	// message content is stored encrypted
+	EncryptionKey string `mapstructure:"encryption_key"`
 }
 
+const (
+	defaultMaxOpenConns    = 10
+	defaultMaxIdleConns    = 5
+	defaultConnMaxLifetime = 30 * time.Minute
+)
+
 type PostgresStorage struct {
 	db     *sql.DB
 	logger *zap.Logger
+	limits Limits
+	// cipher encrypts message content; nil stores it as plaintext
+	cipher *contentCipher
 }
 
-func (p *PostgresStorage) handleError(err error, operation string) error {
+func (p *PostgresStorage) handleError(ctx context.Context, err error, operation string) error {
 	if err == nil {
 		return nil
 	}
 
-	// Log the error with context
-	p.logger.Error("database error",
+	// Log the error with the request's fields when there is one, and mark
+	// the operation's span as failed
+	logging.FromContext(ctx, p.logger).Error("database error",
 		zap.Error(err),
 		zap.String("operation", operation))
+	tracing.RecordError(ctx, err)
 
 	// Handle specific postgres errors
 	if pqErr, ok := err.(*pq.Error); ok {
@@ -63,47 +94,132 @@ func (p *PostgresStorage) handleError(err error, operation string) error {
 		return fmt.Errorf("%w: transaction already closed", ErrTransaction)
 	case err == sql.ErrConnDone:
 		return fmt.Errorf("%w: connection already closed", ErrConnection)
+	case isConnectionError(err):
+		return fmt.Errorf("%w: %v", ErrConnection, err)
 	}
 
 	// Generic database error
 	return fmt.Errorf("%w: %v", ErrDatabase, err)
 }
 
+// isConnectionError reports whether err means the server couldn't be reached
+// or dropped the connection, rather than rejecting the query
+func isConnectionError(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.As(err, &netErr)
+}
+
+// observeOperation times a storage operation and traces it as a child of the
+// span in ctx. Queries should run with the returned context, and the returned
+// function called when the operation is done.
+func observeOperation(ctx context.Context, operation string) (context.Context, func()) {
+	done := metrics.ObserveDBOperation(operation)
+	ctx, span := tracing.Start(ctx, "storage."+operation,
+		attribute.String("db.system", "postgresql"),
+		attribute.String("db.operation", operation))
+	return ctx, func() {
+		span.End()
+		done()
+	}
+}
+
 // User-related methods
 func (p *PostgresStorage) GetUser(ctx context.Context, id int64) (*models.User, error) {
+	ctx, end := observeOperation(ctx, "GetUser")
+	defer end()
+
 	if id == 0 {
 		return nil, fmt.Errorf("%w: user_id cannot be zero", ErrInvalidInput)
 	}
 
 	query := `
-        SELECT user_id, thread_id, categories, tags, last_used_at
+        SELECT user_id, COALESCE(thread_id, ''), categories, tags, max_tags, date_format, category_icons, language, last_used_at, allowed_categories, retention_days, timezone, temperature
         FROM user_metadata
         WHERE user_id = $1`
 
-	user := &models.User{ID: id}
-	err := p.db.QueryRowContext(ctx, query, id).Scan(
-		&user.ID,
-		&user.ThreadID,
-		pq.Array(&user.Categories),
-		pq.Array(&user.Tags),
-		&user.LastUsedAt,
-	)
-
+	user, err := scanUser(p.db.QueryRowContext(ctx, query, id).Scan)
 	if err == sql.ErrNoRows {
 		return &models.User{
 			ID:         id,
+			MaxTags:    DefaultMaxTags,
 			LastUsedAt: time.Now(),
 		}, nil
 	}
 
 	if err != nil {
-		return nil, p.handleError(err, "GetUser")
+		return nil, p.handleError(ctx, err, "GetUser")
+	}
+	return user, nil
+}
+
+func (p *PostgresStorage) ListUsers(ctx context.Context, limit, offset int) ([]*models.User, error) {
+	ctx, end := observeOperation(ctx, "ListUsers")
+	defer end()
+
+	// user_id breaks ties so pages don't overlap
+	query := `
+        SELECT user_id, COALESCE(thread_id, ''), categories, tags, max_tags, date_format, category_icons, language, last_used_at, allowed_categories, retention_days, timezone, temperature
+        FROM user_metadata
+        ORDER BY last_used_at DESC, user_id
+        LIMIT $1 OFFSET $2`
+
+	rows, err := p.db.QueryContext(ctx, query, limit, offset)
+	if err != nil {
+		return nil, p.handleError(ctx, err, "ListUsers")
+	}
+	defer rows.Close()
+
+	var users []*models.User
+	for rows.Next() {
+		user, err := scanUser(rows.Scan)
+		if err != nil {
+			return nil, p.handleError(ctx, err, "ListUsers")
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, p.handleError(ctx, err, "ListUsers")
+	}
+	return users, nil
+}
+
+// scanUser reads a user_metadata row selected with the columns of GetUser
+func scanUser(scan func(dest ...any) error) (*models.User, error) {
+	user := &models.User{}
+	var icons []byte
+	err := scan(
+		&user.ID,
+		&user.ThreadID,
+		pq.Array(&user.Categories),
+		pq.Array(&user.Tags),
+		&user.MaxTags,
+		&user.DateFormat,
+		&icons,
+		&user.Language,
+		&user.LastUsedAt,
+		pq.Array(&user.AllowedCategories),
+		&user.RetentionDays,
+		&user.Timezone,
+		&user.Temperature,
+	)
+	if err != nil {
+		return nil, err
 	}
 
+	// Callers wrap this in ErrDatabase through handleError
+	if err := json.Unmarshal(icons, &user.CategoryIcons); err != nil {
+		return nil, fmt.Errorf("invalid category icons: %v", err)
+	}
 	return user, nil
 }
 
 func (p *PostgresStorage) UpdateUser(ctx context.Context, user *models.User) error {
+	ctx, end := observeOperation(ctx, "UpdateUser")
+	defer end()
+
 	// Input validation
 	if user == nil {
 		return fmt.Errorf("%w: user cannot be nil", ErrInvalidInput)
@@ -129,17 +245,26 @@ func (p *PostgresStorage) UpdateUser(ctx context.Context, user *models.User) err
 		user.LastUsedAt,
 	)
 
-	return p.handleError(err, "UpdateUser")
+	return p.handleError(ctx, err, "UpdateUser")
 }
 
 func NewPostgresStorage(config DatabaseConfig, logger *zap.Logger) (*PostgresStorage, error) {
 	connStr := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
 		config.Host, config.Port, config.User, config.Password, config.DBName, config.SSLMode)
+	if config.ConnectTimeout > 0 {
+		connStr += fmt.Sprintf(" connect_timeout=%d", config.ConnectTimeout)
+	}
+
+	cipher, err := newContentCipher(config.EncryptionKey)
+	if err != nil {
+		return nil, err
+	}
 
 	db, err := sql.Open("postgres", connStr)
 	if err != nil {
 		return nil, fmt.Errorf("error opening database: %v", err)
 	}
+	configurePool(db, config)
 
 	// Test the connection
 	if err := db.Ping(); err != nil {
@@ -149,30 +274,48 @@ func NewPostgresStorage(config DatabaseConfig, logger *zap.Logger) (*PostgresSto
 	storage := &PostgresStorage{
 		db:     db,
 		logger: logger,
+		limits: config.Limits.withDefaults(),
+		cipher: cipher,
+	}
+	if cipher != nil {
+		// Only the bot can read notes now; queries on content in the
+		// database itself see ciphertext
+		logger.Info("Message content is encrypted at rest; it can't be searched in the database")
 	}
 
-	// Initialize database schema
-	if err := storage.initializeSchema(); err != nil {
+	// Bring the database schema up to date
+	if err := storage.migrate(context.Background()); err != nil {
 		return nil, fmt.Errorf("error initializing database schema: %v", err)
 	}
+	if err := storage.rehashContent(context.Background()); err != nil {
+		return nil, err
+	}
 
 	return storage, nil
 }
 
-func (s *PostgresStorage) initializeSchema() error {
-	// Read migrations file
-	migrationSQL, err := migrations.ReadFile("migrations.sql")
-	if err != nil {
-		return fmt.Errorf("error reading migrations file: %v", err)
+// configurePool bounds the connection pool so concurrent updates can't
+// exhaust the server's connections or keep stale ones around
+func configurePool(db *sql.DB, config DatabaseConfig) {
+	maxOpen := config.MaxOpenConns
+	if maxOpen <= 0 {
+		maxOpen = defaultMaxOpenConns
 	}
-
-	// Execute migrations
-	_, err = s.db.Exec(string(migrationSQL))
-	if err != nil {
-		return fmt.Errorf("error executing migrations: %v", err)
+	maxIdle := config.MaxIdleConns
+	if maxIdle <= 0 {
+		maxIdle = defaultMaxIdleConns
+	}
+	if maxIdle > maxOpen {
+		maxIdle = maxOpen
+	}
+	lifetime := config.ConnMaxLifetime
+	if lifetime <= 0 {
+		lifetime = defaultConnMaxLifetime
 	}
 
-	return nil
+	db.SetMaxOpenConns(maxOpen)
+	db.SetMaxIdleConns(maxIdle)
+	db.SetConnMaxLifetime(lifetime)
 }
 
 func (s *PostgresStorage) Close() error {
@@ -183,19 +326,52 @@ func (p *PostgresStorage) CheckHealth(ctx context.Context) error {
 	// Check if connection is alive
 	err := p.db.PingContext(ctx)
 	if err != nil {
-		return p.handleError(err, "CheckHealth")
+		return p.handleError(ctx, err, "CheckHealth")
 	}
 
 	// Optional: Check if we can perform a simple query
 	_, err = p.db.ExecContext(ctx, "SELECT 1")
 	if err != nil {
-		return p.handleError(err, "CheckHealth")
+		return p.handleError(ctx, err, "CheckHealth")
 	}
 
 	return nil
 }
 
+func (p *PostgresStorage) DeleteUser(ctx context.Context, userID int64) error {
+	ctx, end := observeOperation(ctx, "DeleteUser")
+	defer end()
+
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return p.handleError(ctx, err, "DeleteUser")
+	}
+	defer tx.Rollback()
+
+	// Children first; classification replies go with their messages
+	for _, query := range []string{
+		"DELETE FROM threads WHERE user_id = $1",
+		"DELETE FROM category_default_tags WHERE user_id = $1",
+		"DELETE FROM messages WHERE user_id = $1",
+		"DELETE FROM user_metadata WHERE user_id = $1",
+	} {
+		if _, err := tx.ExecContext(ctx, query, userID); err != nil {
+			return p.handleError(ctx, err, "DeleteUser")
+		}
+	}
+
+	return p.handleError(ctx, tx.Commit(), "DeleteUser")
+}
+
 func (p *PostgresStorage) AddCategory(ctx context.Context, userID int64, category string) error {
+	ctx, end := observeOperation(ctx, "AddCategory")
+	defer end()
+
+	category = NormalizeLabel(category)
+	if category == "" {
+		return fmt.Errorf("%w: category cannot be empty", ErrInvalidInput)
+	}
+
 	query := `
         INSERT INTO user_metadata (user_id, categories, last_used_at)
         VALUES ($1, ARRAY[$2], NOW())
@@ -205,16 +381,25 @@ func (p *PostgresStorage) AddCategory(ctx context.Context, userID int64, categor
                 $2
             ),
             last_used_at = NOW()
-        WHERE NOT ($2 = ANY(user_metadata.categories))`
+        WHERE NOT $2 = ANY(
+            SELECT replace(lower(c), ' ', '_') FROM unnest(COALESCE(user_metadata.categories, '{}')) AS c)`
 
 	_, err := p.db.ExecContext(ctx, query, userID, category)
 	if err != nil {
-		return fmt.Errorf("failed to add category: %w", err)
+		return p.handleError(ctx, err, "AddCategory")
 	}
 	return nil
 }
 
 func (p *PostgresStorage) AddTag(ctx context.Context, userID int64, tag string) error {
+	ctx, end := observeOperation(ctx, "AddTag")
+	defer end()
+
+	tag = NormalizeLabel(tag)
+	if tag == "" {
+		return fmt.Errorf("%w: tag cannot be empty", ErrInvalidInput)
+	}
+
 	query := `
         INSERT INTO user_metadata (user_id, tags, last_used_at)
         VALUES ($1, ARRAY[$2], NOW())
@@ -224,16 +409,118 @@ func (p *PostgresStorage) AddTag(ctx context.Context, userID int64, tag string)
                 $2
             ),
             last_used_at = NOW()
-        WHERE NOT ($2 = ANY(user_metadata.tags))`
+        WHERE NOT $2 = ANY(
+                SELECT replace(lower(t), ' ', '_') FROM unnest(COALESCE(user_metadata.tags, '{}')) AS t)
+            AND COALESCE(cardinality(user_metadata.tags), 0) < $3`
+
+	result, err := p.db.ExecContext(ctx, query, userID, tag, p.limits.MaxUserTags)
+	if err != nil {
+		return p.handleError(ctx, err, "AddTag")
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return p.handleError(ctx, err, "AddTag")
+	}
+	if rows > 0 {
+		return nil
+	}
 
-	_, err := p.db.ExecContext(ctx, query, userID, tag)
+	// Nothing changed: either the tag is already there or the list is full
+	var exists bool
+	err = p.db.QueryRowContext(ctx,
+		`SELECT $2 = ANY(SELECT replace(lower(t), ' ', '_') FROM unnest(COALESCE(tags, '{}')) AS t)
+        FROM user_metadata WHERE user_id = $1`,
+		userID, tag).Scan(&exists)
 	if err != nil {
-		return fmt.Errorf("failed to add tag: %w", err)
+		return p.handleError(ctx, err, "AddTag")
+	}
+	if !exists {
+		return p.limits.tagLimitError()
+	}
+	return nil
+}
+
+func (p *PostgresStorage) RemoveTag(ctx context.Context, userID int64, tag string) error {
+	ctx, end := observeOperation(ctx, "RemoveTag")
+	defer end()
+
+	query := `
+        UPDATE user_metadata
+        SET tags = ARRAY(
+            SELECT t FROM unnest(tags) WITH ORDINALITY AS u(t, ord)
+            WHERE replace(lower(t), ' ', '_') <> replace(lower($2), ' ', '_')
+            ORDER BY ord)
+        WHERE user_id = $1 AND replace(lower($2), ' ', '_') = ANY(
+            SELECT replace(lower(t), ' ', '_') FROM unnest(tags) AS t)`
+
+	result, err := p.db.ExecContext(ctx, query, userID, tag)
+	if err != nil {
+		return p.handleError(ctx, err, "RemoveTag")
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return p.handleError(ctx, err, "RemoveTag")
+	}
+	if rows == 0 {
+		return ErrNotFound
 	}
 	return nil
 }
 
+// renameTagSQL rewrites the tags column of the rows selected by $1 so that
+// entries matching $2 become $3, keeping the first position of duplicates
+const renameTagSQL = `
+        SET tags = ARRAY(
+            SELECT tag FROM (
+                SELECT CASE WHEN replace(lower(t), ' ', '_') = replace(lower($2), ' ', '_')
+                            THEN $3 ELSE t END AS tag,
+                       MIN(ord) AS ord
+                FROM unnest(tags) WITH ORDINALITY AS u(t, ord)
+                GROUP BY 1
+            ) renamed
+            ORDER BY ord)
+        WHERE user_id = $1 AND replace(lower($2), ' ', '_') = ANY(
+            SELECT replace(lower(t), ' ', '_') FROM unnest(tags) AS t)`
+
+func (p *PostgresStorage) RenameTag(ctx context.Context, userID int64, oldTag, newTag string) error {
+	ctx, end := observeOperation(ctx, "RenameTag")
+	defer end()
+
+	newTag = NormalizeLabel(newTag)
+	if newTag == "" {
+		return fmt.Errorf("%w: new tag cannot be empty", ErrInvalidInput)
+	}
+
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return p.handleError(ctx, err, "RenameTag")
+	}
+	defer tx.Rollback()
+
+	renamed := int64(0)
+	for _, table := range []string{"user_metadata", "messages"} {
+		result, err := tx.ExecContext(ctx, "UPDATE "+table+renameTagSQL, userID, oldTag, newTag)
+		if err != nil {
+			return p.handleError(ctx, err, "RenameTag")
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return p.handleError(ctx, err, "RenameTag")
+		}
+		renamed += rows
+	}
+	if renamed == 0 {
+		return ErrNotFound
+	}
+
+	return p.handleError(ctx, tx.Commit(), "RenameTag")
+}
+
 func (p *PostgresStorage) GetUserCategories(ctx context.Context, userID int64) ([]string, error) {
+	ctx, end := observeOperation(ctx, "GetUserCategories")
+	defer end()
+
 	query := `
         SELECT categories
         FROM user_metadata
@@ -251,6 +538,9 @@ func (p *PostgresStorage) GetUserCategories(ctx context.Context, userID int64) (
 }
 
 func (p *PostgresStorage) GetUserTags(ctx context.Context, userID int64) ([]string, error) {
+	ctx, end := observeOperation(ctx, "GetUserTags")
+	defer end()
+
 	query := `
         SELECT tags
         FROM user_metadata
@@ -268,19 +558,26 @@ func (p *PostgresStorage) GetUserTags(ctx context.Context, userID int64) ([]stri
 }
 
 func (p *PostgresStorage) RemoveCategory(ctx context.Context, userID int64, category string) error {
+	ctx, end := observeOperation(ctx, "RemoveCategory")
+	defer end()
+
 	query := `
-		UPDATE user_metadata 
-		SET categories = array_remove(categories, $2)
-		WHERE user_id = $1`
+        UPDATE user_metadata
+        SET categories = ARRAY(
+            SELECT c FROM unnest(categories) WITH ORDINALITY AS u(c, ord)
+            WHERE replace(lower(c), ' ', '_') <> $2
+            ORDER BY ord)
+        WHERE user_id = $1 AND $2 = ANY(
+            SELECT replace(lower(c), ' ', '_') FROM unnest(categories) AS c)`
 
-	result, err := p.db.ExecContext(ctx, query, userID, category)
+	result, err := p.db.ExecContext(ctx, query, userID, NormalizeLabel(category))
 	if err != nil {
-		return p.handleError(err, "RemoveCategory")
+		return p.handleError(ctx, err, "RemoveCategory")
 	}
 
 	rows, err := result.RowsAffected()
 	if err != nil {
-		return p.handleError(err, "RemoveCategory")
+		return p.handleError(ctx, err, "RemoveCategory")
 	}
 	if rows == 0 {
 		return ErrNotFound
@@ -288,35 +585,332 @@ func (p *PostgresStorage) RemoveCategory(ctx context.Context, userID int64, cate
 	return nil
 }
 
+func (p *PostgresStorage) MergeCategory(ctx context.Context, userID int64, from, into string) (int, error) {
+	ctx, end := observeOperation(ctx, "MergeCategory")
+	defer end()
+
+	into = NormalizeLabel(into)
+	if into == "" {
+		return 0, fmt.Errorf("%w: target category cannot be empty", ErrInvalidInput)
+	}
+
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, p.handleError(ctx, err, "MergeCategory")
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+        UPDATE messages
+        SET category = $3
+        WHERE user_id = $1 AND replace(lower(category), ' ', '_') = replace(lower($2), ' ', '_')`,
+		userID, from, into)
+	if err != nil {
+		return 0, p.handleError(ctx, err, "MergeCategory")
+	}
+	moved, err := result.RowsAffected()
+	if err != nil {
+		return 0, p.handleError(ctx, err, "MergeCategory")
+	}
+
+	result, err = tx.ExecContext(ctx, `
+        UPDATE user_metadata
+        SET categories = ARRAY(
+            SELECT c FROM unnest(categories) WITH ORDINALITY AS u(c, ord)
+            WHERE replace(lower(c), ' ', '_') <> replace(lower($2), ' ', '_')
+            ORDER BY ord)
+        WHERE user_id = $1 AND replace(lower($2), ' ', '_') = ANY(
+            SELECT replace(lower(c), ' ', '_') FROM unnest(categories) AS c)`,
+		userID, from)
+	if err != nil {
+		return 0, p.handleError(ctx, err, "MergeCategory")
+	}
+	listed, err := result.RowsAffected()
+	if err != nil {
+		return 0, p.handleError(ctx, err, "MergeCategory")
+	}
+	if moved == 0 && listed == 0 {
+		return 0, ErrNotFound
+	}
+
+	_, err = tx.ExecContext(ctx, `
+        UPDATE user_metadata
+        SET categories = array_append(COALESCE(categories, '{}'), $2)
+        WHERE user_id = $1 AND NOT replace(lower($2), ' ', '_') = ANY(
+            SELECT replace(lower(c), ' ', '_') FROM unnest(COALESCE(categories, '{}')) AS c)`,
+		userID, into)
+	if err != nil {
+		return 0, p.handleError(ctx, err, "MergeCategory")
+	}
+
+	return int(moved), p.handleError(ctx, tx.Commit(), "MergeCategory")
+}
+
+func (p *PostgresStorage) RenameCategory(ctx context.Context, userID int64, oldName, newName string) (int, error) {
+	ctx, end := observeOperation(ctx, "RenameCategory")
+	defer end()
+
+	newName = NormalizeLabel(newName)
+	if newName == "" {
+		return 0, fmt.Errorf("%w: new category cannot be empty", ErrInvalidInput)
+	}
+
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, p.handleError(ctx, err, "RenameCategory")
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+        UPDATE messages
+        SET category = $3
+        WHERE user_id = $1 AND replace(lower(category), ' ', '_') = replace(lower($2), ' ', '_')`,
+		userID, oldName, newName)
+	if err != nil {
+		return 0, p.handleError(ctx, err, "RenameCategory")
+	}
+	moved, err := result.RowsAffected()
+	if err != nil {
+		return 0, p.handleError(ctx, err, "RenameCategory")
+	}
+
+	// Same as renameTagSQL: a name already in the list keeps its first
+	// position, which merges the two categories
+	result, err = tx.ExecContext(ctx, `
+        UPDATE user_metadata
+        SET categories = ARRAY(
+            SELECT category FROM (
+                SELECT CASE WHEN replace(lower(c), ' ', '_') = replace(lower($2), ' ', '_')
+                            THEN $3 ELSE c END AS category,
+                       MIN(ord) AS ord
+                FROM unnest(categories) WITH ORDINALITY AS u(c, ord)
+                GROUP BY 1
+            ) renamed
+            ORDER BY ord),
+            last_used_at = NOW()
+        WHERE user_id = $1 AND replace(lower($2), ' ', '_') = ANY(
+            SELECT replace(lower(c), ' ', '_') FROM unnest(categories) AS c)`,
+		userID, oldName, newName)
+	if err != nil {
+		return 0, p.handleError(ctx, err, "RenameCategory")
+	}
+	listed, err := result.RowsAffected()
+	if err != nil {
+		return 0, p.handleError(ctx, err, "RenameCategory")
+	}
+	if moved == 0 && listed == 0 {
+		return 0, ErrNotFound
+	}
+
+	return int(moved), p.handleError(ctx, tx.Commit(), "RenameCategory")
+}
+
 func (p *PostgresStorage) UpdateUserMaxTags(ctx context.Context, userID int64, maxTags int) error {
+	ctx, end := observeOperation(ctx, "UpdateUserMaxTags")
+	defer end()
+
+	if maxTags < 1 {
+		return fmt.Errorf("%w: max_tags must be at least 1", ErrInvalidInput)
+	}
+
+	query := `
+        INSERT INTO user_metadata (user_id, max_tags, last_used_at)
+        VALUES ($1, $2, NOW())
+        ON CONFLICT (user_id) DO UPDATE SET
+            max_tags = EXCLUDED.max_tags,
+            last_used_at = NOW()`
+
+	_, err := p.db.ExecContext(ctx, query, userID, maxTags)
+	return p.handleError(ctx, err, "UpdateUserMaxTags")
+}
+
+func (p *PostgresStorage) UpdateUserDateFormat(ctx context.Context, userID int64, format string) error {
+	ctx, end := observeOperation(ctx, "UpdateUserDateFormat")
+	defer end()
+
+	query := `
+        INSERT INTO user_metadata (user_id, date_format, last_used_at)
+        VALUES ($1, $2, NOW())
+        ON CONFLICT (user_id) DO UPDATE SET
+            date_format = EXCLUDED.date_format,
+            last_used_at = NOW()`
+
+	_, err := p.db.ExecContext(ctx, query, userID, format)
+	return p.handleError(ctx, err, "UpdateUserDateFormat")
+}
+
+func (p *PostgresStorage) UpdateUserLanguage(ctx context.Context, userID int64, language string) error {
+	ctx, end := observeOperation(ctx, "UpdateUserLanguage")
+	defer end()
+
+	query := `
+        INSERT INTO user_metadata (user_id, language, last_used_at)
+        VALUES ($1, $2, NOW())
+        ON CONFLICT (user_id) DO UPDATE SET
+            language = EXCLUDED.language,
+            last_used_at = NOW()`
+
+	_, err := p.db.ExecContext(ctx, query, userID, language)
+	return p.handleError(ctx, err, "UpdateUserLanguage")
+}
+
+func (p *PostgresStorage) UpdateUserTimezone(ctx context.Context, userID int64, timezone string) error {
+	ctx, end := observeOperation(ctx, "UpdateUserTimezone")
+	defer end()
+
+	query := `
+        INSERT INTO user_metadata (user_id, timezone, last_used_at)
+        VALUES ($1, $2, NOW())
+        ON CONFLICT (user_id) DO UPDATE SET
+            timezone = EXCLUDED.timezone,
+            last_used_at = NOW()`
+
+	_, err := p.db.ExecContext(ctx, query, userID, timezone)
+	return p.handleError(ctx, err, "UpdateUserTimezone")
+}
+
+func (p *PostgresStorage) UpdateUserTemperature(ctx context.Context, userID int64, temperature *float64) error {
+	ctx, end := observeOperation(ctx, "UpdateUserTemperature")
+	defer end()
+
+	query := `
+        INSERT INTO user_metadata (user_id, temperature, last_used_at)
+        VALUES ($1, $2, NOW())
+        ON CONFLICT (user_id) DO UPDATE SET
+            temperature = EXCLUDED.temperature,
+            last_used_at = NOW()`
+
+	_, err := p.db.ExecContext(ctx, query, userID, temperature)
+	return p.handleError(ctx, err, "UpdateUserTemperature")
+}
+
+func (p *PostgresStorage) UpdateUserRetention(ctx context.Context, userID int64, days int) error {
+	ctx, end := observeOperation(ctx, "UpdateUserRetention")
+	defer end()
+
+	if days < 0 {
+		return fmt.Errorf("%w: retention can't be negative", ErrInvalidInput)
+	}
+
+	query := `
+        INSERT INTO user_metadata (user_id, retention_days, last_used_at)
+        VALUES ($1, $2, NOW())
+        ON CONFLICT (user_id) DO UPDATE SET
+            retention_days = EXCLUDED.retention_days,
+            last_used_at = NOW()`
+
+	_, err := p.db.ExecContext(ctx, query, userID, days)
+	return p.handleError(ctx, err, "UpdateUserRetention")
+}
+
+func (p *PostgresStorage) SetAllowedCategories(ctx context.Context, userID int64, categories []string) error {
+	ctx, end := observeOperation(ctx, "SetAllowedCategories")
+	defer end()
+
+	categories = NormalizeLabels(categories)
+
+	query := `
+        INSERT INTO user_metadata (user_id, allowed_categories, last_used_at)
+        VALUES ($1, $2, NOW())
+        ON CONFLICT (user_id) DO UPDATE SET
+            allowed_categories = EXCLUDED.allowed_categories,
+            last_used_at = NOW()`
+
+	_, err := p.db.ExecContext(ctx, query, userID, pq.Array(categories))
+	return p.handleError(ctx, err, "SetAllowedCategories")
+}
+
+func (p *PostgresStorage) SetCategoryIcon(ctx context.Context, userID int64, category, icon string) error {
+	ctx, end := observeOperation(ctx, "SetCategoryIcon")
+	defer end()
+
+	category = NormalizeLabel(category)
+
+	if icon == "" {
+		_, err := p.db.ExecContext(ctx, `
+            UPDATE user_metadata
+            SET category_icons = category_icons - $2
+            WHERE user_id = $1`,
+			userID, category,
+		)
+		return p.handleError(ctx, err, "SetCategoryIcon")
+	}
+
+	query := `
+        INSERT INTO user_metadata (user_id, category_icons, last_used_at)
+        VALUES ($1, jsonb_build_object($2::text, $3::text), NOW())
+        ON CONFLICT (user_id) DO UPDATE SET
+            category_icons = user_metadata.category_icons || EXCLUDED.category_icons,
+            last_used_at = NOW()`
+
+	_, err := p.db.ExecContext(ctx, query, userID, category, icon)
+	return p.handleError(ctx, err, "SetCategoryIcon")
+}
+
+func (p *PostgresStorage) SetCategoryTags(ctx context.Context, userID int64, category string, tags []string) error {
+	ctx, end := observeOperation(ctx, "SetCategoryTags")
+	defer end()
+
+	if len(tags) == 0 {
+		return fmt.Errorf("%w: no tags given", ErrInvalidInput)
+	}
+
+	query := `
+        INSERT INTO category_default_tags (user_id, category, tags)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (user_id, category) DO UPDATE SET
+            tags = EXCLUDED.tags`
+
+	_, err := p.db.ExecContext(ctx, query, userID, NormalizeLabel(category), pq.Array(NormalizeLabels(tags)))
+	return p.handleError(ctx, err, "SetCategoryTags")
+}
+
+func (p *PostgresStorage) GetCategoryTags(ctx context.Context, userID int64, category string) ([]string, error) {
+	ctx, end := observeOperation(ctx, "GetCategoryTags")
+	defer end()
+
 	query := `
-		UPDATE user_metadata 
-		SET max_tags = $2
-		WHERE user_id = $1`
+        SELECT tags
+        FROM category_default_tags
+        WHERE user_id = $1 AND category = $2`
 
-	result, err := p.db.ExecContext(ctx, query, userID, maxTags)
+	var tags []string
+	err := p.db.QueryRowContext(ctx, query, userID, NormalizeLabel(category)).Scan(pq.Array(&tags))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
 	if err != nil {
-		return p.handleError(err, "UpdateUserMaxTags")
+		return nil, p.handleError(ctx, err, "GetCategoryTags")
 	}
+	return tags, nil
+}
+
+func (p *PostgresStorage) ClearCategoryTags(ctx context.Context, userID int64, category string) error {
+	ctx, end := observeOperation(ctx, "ClearCategoryTags")
+	defer end()
+
+	query := `
+        DELETE FROM category_default_tags
+        WHERE user_id = $1 AND category = $2`
 
+	result, err := p.db.ExecContext(ctx, query, userID, NormalizeLabel(category))
+	if err != nil {
+		return p.handleError(ctx, err, "ClearCategoryTags")
+	}
 	rows, err := result.RowsAffected()
 	if err != nil {
-		return p.handleError(err, "UpdateUserMaxTags")
+		return p.handleError(ctx, err, "ClearCategoryTags")
 	}
 	if rows == 0 {
-		// Insert if not exists
-		query = `
-			INSERT INTO user_metadata (user_id, max_tags)
-			VALUES ($1, $2)`
-		_, err = p.db.ExecContext(ctx, query, userID, maxTags)
-		if err != nil {
-			return p.handleError(err, "UpdateUserMaxTags")
-		}
+		return ErrNotFound
 	}
 	return nil
 }
 
 func (p *PostgresStorage) GetThread(ctx context.Context, userID int64) (*models.Thread, error) {
+	ctx, end := observeOperation(ctx, "GetThread")
+	defer end()
+
 	query := `
         SELECT id, user_id, created_at, last_used_at
         FROM threads
@@ -340,6 +934,9 @@ func (p *PostgresStorage) GetThread(ctx context.Context, userID int64) (*models.
 }
 
 func (p *PostgresStorage) SaveThread(ctx context.Context, thread *models.Thread) error {
+	ctx, end := observeOperation(ctx, "SaveThread")
+	defer end()
+
 	query := `
         INSERT INTO threads (id, user_id, created_at, last_used_at)
         VALUES ($1, $2, $3, $4)
@@ -361,6 +958,9 @@ func (p *PostgresStorage) SaveThread(ctx context.Context, thread *models.Thread)
 }
 
 func (p *PostgresStorage) UpdateThreadLastUsed(ctx context.Context, userID int64) error {
+	ctx, end := observeOperation(ctx, "UpdateThreadLastUsed")
+	defer end()
+
 	query := `
         UPDATE threads
         SET last_used_at = NOW()
@@ -382,6 +982,9 @@ func (p *PostgresStorage) UpdateThreadLastUsed(ctx context.Context, userID int64
 }
 
 func (p *PostgresStorage) DeleteThread(ctx context.Context, userID int64) error {
+	ctx, end := observeOperation(ctx, "DeleteThread")
+	defer end()
+
 	result, err := p.db.ExecContext(ctx, `
         DELETE FROM threads 
         WHERE user_id = $1`,
@@ -400,3 +1003,1140 @@ func (p *PostgresStorage) DeleteThread(ctx context.Context, userID int64) error
 	}
 	return nil
 }
+
+func (p *PostgresStorage) GetUpdateOffset(ctx context.Context) (int, error) {
+	ctx, end := observeOperation(ctx, "GetUpdateOffset")
+	defer end()
+
+	query := `
+        SELECT update_offset
+        FROM bot_state
+        WHERE id = TRUE`
+
+	var offset int
+	err := p.db.QueryRowContext(ctx, query).Scan(&offset)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, p.handleError(ctx, err, "GetUpdateOffset")
+	}
+	return offset, nil
+}
+
+func (p *PostgresStorage) SetUpdateOffset(ctx context.Context, offset int) error {
+	ctx, end := observeOperation(ctx, "SetUpdateOffset")
+	defer end()
+
+	query := `
+        INSERT INTO bot_state (id, update_offset, updated_at)
+        VALUES (TRUE, $1, NOW())
+        ON CONFLICT (id) DO UPDATE SET
+            update_offset = GREATEST(bot_state.update_offset, EXCLUDED.update_offset),
+            updated_at = NOW()`
+
+	_, err := p.db.ExecContext(ctx, query, offset)
+	return p.handleError(ctx, err, "SetUpdateOffset")
+}
+
+func (p *PostgresStorage) MarkUpdateProcessed(ctx context.Context, updateID int) (bool, error) {
+	ctx, end := observeOperation(ctx, "MarkUpdateProcessed")
+	defer end()
+
+	query := `
+        INSERT INTO processed_updates (update_id)
+        VALUES ($1)
+        ON CONFLICT (update_id) DO NOTHING`
+
+	result, err := p.db.ExecContext(ctx, query, updateID)
+	if err != nil {
+		return false, p.handleError(ctx, err, "MarkUpdateProcessed")
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, p.handleError(ctx, err, "MarkUpdateProcessed")
+	}
+	if rows == 0 {
+		return false, nil
+	}
+
+	// Update IDs only grow, so everything far enough below this one is stale
+	query = `
+        DELETE FROM processed_updates
+        WHERE update_id < $1`
+
+	_, err = p.db.ExecContext(ctx, query, updateID-ProcessedUpdatesWindow)
+	if err != nil {
+		return true, p.handleError(ctx, err, "MarkUpdateProcessed")
+	}
+	return true, nil
+}
+
+// Message-related methods
+func (p *PostgresStorage) SaveMessage(ctx context.Context, message *models.Message) error {
+	ctx, end := observeOperation(ctx, "SaveMessage")
+	defer end()
+
+	if err := p.limits.checkMessage(message); err != nil {
+		return err
+	}
+	normalizeMessageLabels(message)
+
+	sealed, err := p.cipher.sealMessage(message)
+	if err != nil {
+		return p.handleError(ctx, err, "SaveMessage")
+	}
+
+	query := `
+        INSERT INTO messages (id, user_id, content, category, tags, summary, file_id, content_type, content_hash, created_at, source, links, attachments_analysis)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`
+
+	_, err = p.db.ExecContext(ctx, query,
+		message.ID,
+		message.UserID,
+		sealed.Content,
+		message.Category,
+		pq.Array(message.Tags),
+		sealed.Summary,
+		message.FileID,
+		message.ContentType,
+		sealed.ContentHash,
+		message.CreatedAt,
+		message.Source,
+		pq.Array(sealed.Links),
+		sealed.AttachmentsAnalysis,
+	)
+	return p.handleError(ctx, err, "SaveMessage")
+}
+
+func (p *PostgresStorage) SaveMessages(ctx context.Context, messages []*models.Message) error {
+	ctx, end := observeOperation(ctx, "SaveMessages")
+	defer end()
+
+	for _, message := range messages {
+		if err := p.limits.checkMessage(message); err != nil {
+			return err
+		}
+		normalizeMessageLabels(message)
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return p.handleError(ctx, err, "SaveMessages")
+	}
+	defer tx.Rollback()
+
+	// messages.user_id references user_metadata, which has no row yet for
+	// users whose first notes are imported
+	userIDs := make([]int64, 0, 1)
+	seen := make(map[int64]bool)
+	for _, message := range messages {
+		if !seen[message.UserID] {
+			seen[message.UserID] = true
+			userIDs = append(userIDs, message.UserID)
+		}
+	}
+	_, err = tx.ExecContext(ctx, `
+        INSERT INTO user_metadata (user_id, last_used_at)
+        SELECT id, NOW() FROM unnest($1::bigint[]) AS id
+        ON CONFLICT (user_id) DO NOTHING`, pq.Array(userIDs))
+	if err != nil {
+		return p.handleError(ctx, err, "SaveMessages")
+	}
+
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("messages",
+		"id", "user_id", "content", "category", "tags", "summary", "file_id", "content_type", "content_hash", "created_at", "source", "links", "attachments_analysis"))
+	if err != nil {
+		return p.handleError(ctx, err, "SaveMessages")
+	}
+	defer stmt.Close()
+
+	for _, message := range messages {
+		sealed, err := p.cipher.sealMessage(message)
+		if err != nil {
+			return p.handleError(ctx, err, "SaveMessages")
+		}
+		_, err = stmt.ExecContext(ctx,
+			message.ID,
+			message.UserID,
+			sealed.Content,
+			message.Category,
+			pq.Array(message.Tags),
+			sealed.Summary,
+			message.FileID,
+			message.ContentType,
+			sealed.ContentHash,
+			message.CreatedAt,
+			message.Source,
+			pq.Array(sealed.Links),
+			sealed.AttachmentsAnalysis,
+		)
+		if err != nil {
+			return p.handleError(ctx, err, "SaveMessages")
+		}
+	}
+
+	// The buffered rows are only sent once the statement is flushed
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		return p.handleError(ctx, err, "SaveMessages")
+	}
+	if err := stmt.Close(); err != nil {
+		return p.handleError(ctx, err, "SaveMessages")
+	}
+	return p.handleError(ctx, tx.Commit(), "SaveMessages")
+}
+
+func (p *PostgresStorage) GetUserMessages(ctx context.Context, userID int64, limit, offset int) ([]*models.Message, error) {
+	ctx, end := observeOperation(ctx, "GetUserMessages")
+	defer end()
+
+	query := `
+        SELECT id, user_id, content, category, tags, summary, file_id, content_type, created_at, archived, archived_at, source, links, attachments_analysis, is_pinned
+        FROM messages
+        WHERE user_id = $1 AND archived = false
+        ORDER BY created_at DESC
+        LIMIT $2 OFFSET $3`
+
+	return p.queryMessages(ctx, "GetUserMessages", query, userID, limit, offset)
+}
+
+func (p *PostgresStorage) GetUserMessagesByCategory(ctx context.Context, userID int64, category string, limit, offset int) ([]*models.Message, error) {
+	ctx, end := observeOperation(ctx, "GetUserMessagesByCategory")
+	defer end()
+
+	query := `
+        SELECT id, user_id, content, category, tags, summary, file_id, content_type, created_at, archived, archived_at, source, links, attachments_analysis, is_pinned
+        FROM messages
+        WHERE user_id = $1 AND archived = false
+            AND replace(lower(category), ' ', '_') = replace(lower($2), ' ', '_')
+        ORDER BY created_at DESC
+        LIMIT $3 OFFSET $4`
+
+	return p.queryMessages(ctx, "GetUserMessagesByCategory", query, userID, category, limit, offset)
+}
+
+func (p *PostgresStorage) GetUserMessagesByTag(ctx context.Context, userID int64, tag string, limit, offset int) ([]*models.Message, error) {
+	ctx, end := observeOperation(ctx, "GetUserMessagesByTag")
+	defer end()
+
+	query := `
+        SELECT id, user_id, content, category, tags, summary, file_id, content_type, created_at, archived, archived_at, source, links, attachments_analysis, is_pinned
+        FROM messages
+        WHERE user_id = $1 AND archived = false AND replace(lower($2), ' ', '_') = ANY(
+            SELECT replace(lower(t), ' ', '_') FROM unnest(tags) AS t)
+        ORDER BY created_at DESC
+        LIMIT $3 OFFSET $4`
+
+	return p.queryMessages(ctx, "GetUserMessagesByTag", query, userID, tag, limit, offset)
+}
+
+func (p *PostgresStorage) GetArchivedMessages(ctx context.Context, userID int64, limit, offset int) ([]*models.Message, error) {
+	ctx, end := observeOperation(ctx, "GetArchivedMessages")
+	defer end()
+
+	query := `
+        SELECT id, user_id, content, category, tags, summary, file_id, content_type, created_at, archived, archived_at, source, links, attachments_analysis, is_pinned
+        FROM messages
+        WHERE user_id = $1 AND archived = true
+        ORDER BY archived_at DESC NULLS LAST, created_at DESC
+        LIMIT $2 OFFSET $3`
+
+	return p.queryMessages(ctx, "GetArchivedMessages", query, userID, limit, offset)
+}
+
+func (p *PostgresStorage) GetUserMessagesPinnedFirst(ctx context.Context, userID int64, limit, offset int) ([]*models.Message, error) {
+	ctx, end := observeOperation(ctx, "GetUserMessagesPinnedFirst")
+	defer end()
+
+	query := `
+        SELECT id, user_id, content, category, tags, summary, file_id, content_type, created_at, archived, archived_at, source, links, attachments_analysis, is_pinned
+        FROM messages
+        WHERE user_id = $1 AND archived = false
+        ORDER BY is_pinned DESC, created_at DESC
+        LIMIT $2 OFFSET $3`
+
+	return p.queryMessages(ctx, "GetUserMessagesPinnedFirst", query, userID, limit, offset)
+}
+
+func (p *PostgresStorage) GetPinnedMessages(ctx context.Context, userID int64, limit, offset int) ([]*models.Message, error) {
+	ctx, end := observeOperation(ctx, "GetPinnedMessages")
+	defer end()
+
+	query := `
+        SELECT id, user_id, content, category, tags, summary, file_id, content_type, created_at, archived, archived_at, source, links, attachments_analysis, is_pinned
+        FROM messages
+        WHERE user_id = $1 AND archived = false AND is_pinned
+        ORDER BY created_at DESC
+        LIMIT $2 OFFSET $3`
+
+	return p.queryMessages(ctx, "GetPinnedMessages", query, userID, limit, offset)
+}
+
+func (p *PostgresStorage) GetMessageByID(ctx context.Context, id string) (*models.Message, error) {
+	ctx, end := observeOperation(ctx, "GetMessageByID")
+	defer end()
+
+	query := `
+        SELECT id, user_id, content, category, tags, summary, file_id, content_type, created_at, archived, archived_at, source, links, attachments_analysis, is_pinned
+        FROM messages
+        WHERE id = $1`
+
+	message := &models.Message{}
+	err := p.db.QueryRowContext(ctx, query, id).Scan(messageFields(message)...)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, p.handleError(ctx, err, "GetMessageByID")
+	}
+	if err := p.cipher.decryptMessage(message); err != nil {
+		return nil, p.handleError(ctx, err, "GetMessageByID")
+	}
+	return message, nil
+}
+
+func (p *PostgresStorage) LinkMessages(ctx context.Context, userID int64, fromID, toID string) error {
+	ctx, end := observeOperation(ctx, "LinkMessages")
+	defer end()
+
+	if fromID == toID {
+		return fmt.Errorf("%w: a message can't be linked to itself", ErrInvalidInput)
+	}
+
+	var owned int
+	err := p.db.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM messages WHERE user_id = $1 AND id IN ($2, $3)`,
+		userID, fromID, toID).Scan(&owned)
+	if err != nil {
+		return p.handleError(ctx, err, "LinkMessages")
+	}
+	if owned != 2 {
+		return ErrNotFound
+	}
+
+	query := `
+        INSERT INTO note_links (user_id, from_id, to_id)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (from_id, to_id) DO NOTHING`
+
+	first, second := linkPair(fromID, toID)
+	result, err := p.db.ExecContext(ctx, query, userID, first, second)
+	if err != nil {
+		return p.handleError(ctx, err, "LinkMessages")
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return p.handleError(ctx, err, "LinkMessages")
+	}
+	if rows == 0 {
+		return ErrAlreadyExists
+	}
+	return nil
+}
+
+func (p *PostgresStorage) GetLinkedMessages(ctx context.Context, userID int64, id string) ([]*models.Message, error) {
+	ctx, end := observeOperation(ctx, "GetLinkedMessages")
+	defer end()
+
+	query := `
+        SELECT m.id, m.user_id, m.content, m.category, m.tags, m.summary, m.file_id, m.content_type, m.created_at, m.archived, m.archived_at, m.source, m.links, m.attachments_analysis, m.is_pinned
+        FROM note_links l
+        JOIN messages m ON m.id = CASE WHEN l.from_id = $2 THEN l.to_id ELSE l.from_id END
+        WHERE l.user_id = $1 AND (l.from_id = $2 OR l.to_id = $2)
+        ORDER BY m.created_at DESC`
+
+	return p.queryMessages(ctx, "GetLinkedMessages", query, userID, id)
+}
+
+func (p *PostgresStorage) DeleteMessage(ctx context.Context, id string) error {
+	ctx, end := observeOperation(ctx, "DeleteMessage")
+	defer end()
+
+	result, err := p.db.ExecContext(ctx, `
+        DELETE FROM messages
+        WHERE id = $1`,
+		id,
+	)
+	if err != nil {
+		return p.handleError(ctx, err, "DeleteMessage")
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return p.handleError(ctx, err, "DeleteMessage")
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (p *PostgresStorage) DeleteMessagesOlderThan(ctx context.Context, userID int64, cutoff time.Time) (int, error) {
+	ctx, end := observeOperation(ctx, "DeleteMessagesOlderThan")
+	defer end()
+
+	result, err := p.db.ExecContext(ctx, `
+        DELETE FROM messages
+        WHERE user_id = $1 AND created_at < $2`,
+		userID, cutoff,
+	)
+	if err != nil {
+		return 0, p.handleError(ctx, err, "DeleteMessagesOlderThan")
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, p.handleError(ctx, err, "DeleteMessagesOlderThan")
+	}
+	return int(rows), nil
+}
+
+func (p *PostgresStorage) ArchiveMessage(ctx context.Context, id string) error {
+	ctx, end := observeOperation(ctx, "ArchiveMessage")
+	defer end()
+
+	// Archiving twice keeps the original archived_at
+	query := `
+        UPDATE messages
+        SET archived = true, archived_at = COALESCE(archived_at, NOW())
+        WHERE id = $1`
+
+	return p.execMessageUpdate(ctx, "ArchiveMessage", query, id)
+}
+
+func (p *PostgresStorage) UnarchiveMessage(ctx context.Context, id string) error {
+	ctx, end := observeOperation(ctx, "UnarchiveMessage")
+	defer end()
+
+	query := `
+        UPDATE messages
+        SET archived = false, archived_at = NULL
+        WHERE id = $1`
+
+	return p.execMessageUpdate(ctx, "UnarchiveMessage", query, id)
+}
+
+func (p *PostgresStorage) SetMessagePinned(ctx context.Context, userID int64, id string, pinned bool) error {
+	ctx, end := observeOperation(ctx, "SetMessagePinned")
+	defer end()
+
+	query := `
+        UPDATE messages
+        SET is_pinned = $3
+        WHERE id = $1 AND user_id = $2`
+
+	result, err := p.db.ExecContext(ctx, query, id, userID, pinned)
+	if err != nil {
+		return p.handleError(ctx, err, "SetMessagePinned")
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return p.handleError(ctx, err, "SetMessagePinned")
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// execMessageUpdate runs an UPDATE of a single message by id, returning
+// ErrNotFound when there is no such message
+func (p *PostgresStorage) execMessageUpdate(ctx context.Context, operation, query, id string) error {
+	result, err := p.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return p.handleError(ctx, err, operation)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return p.handleError(ctx, err, operation)
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (p *PostgresStorage) UpdateMessage(ctx context.Context, message *models.Message) error {
+	ctx, end := observeOperation(ctx, "UpdateMessage")
+	defer end()
+
+	if message == nil {
+		return fmt.Errorf("%w: message cannot be nil", ErrInvalidInput)
+	}
+	if err := p.limits.checkContent(message.Content); err != nil {
+		return err
+	}
+	normalizeMessageLabels(message)
+
+	sealed, err := p.cipher.sealMessage(message)
+	if err != nil {
+		return p.handleError(ctx, err, "UpdateMessage")
+	}
+
+	query := `
+        UPDATE messages
+        SET content = $2, content_hash = $3, category = $4, tags = $5, summary = $6, links = $7, attachments_analysis = $8
+        WHERE id = $1`
+
+	result, err := p.db.ExecContext(ctx, query,
+		message.ID,
+		sealed.Content,
+		sealed.ContentHash,
+		message.Category,
+		pq.Array(message.Tags),
+		sealed.Summary,
+		pq.Array(sealed.Links),
+		sealed.AttachmentsAnalysis,
+	)
+	if err != nil {
+		return p.handleError(ctx, err, "UpdateMessage")
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return p.handleError(ctx, err, "UpdateMessage")
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (p *PostgresStorage) UpdateMessageClassification(ctx context.Context, id string, category string, tags []string) error {
+	ctx, end := observeOperation(ctx, "UpdateMessageClassification")
+	defer end()
+
+	query := `
+        UPDATE messages
+        SET category = $2, tags = $3
+        WHERE id = $1`
+
+	result, err := p.db.ExecContext(ctx, query, id, NormalizeLabel(category), pq.Array(NormalizeLabels(tags)))
+	if err != nil {
+		return p.handleError(ctx, err, "UpdateMessageClassification")
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return p.handleError(ctx, err, "UpdateMessageClassification")
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (p *PostgresStorage) FindSimilarMessage(ctx context.Context, userID int64, content string) (*models.Message, error) {
+	ctx, end := observeOperation(ctx, "FindSimilarMessage")
+	defer end()
+
+	query := `
+        SELECT id, user_id, content, category, tags, summary, file_id, content_type, created_at, archived, archived_at, source, links, attachments_analysis, is_pinned
+        FROM messages
+        WHERE user_id = $1 AND content_hash = $2
+        ORDER BY created_at DESC
+        LIMIT 1`
+
+	message := &models.Message{}
+	err := p.db.QueryRowContext(ctx, query, userID, p.cipher.hash(content)).Scan(messageFields(message)...)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, p.handleError(ctx, err, "FindSimilarMessage")
+	}
+	if err := p.cipher.decryptMessage(message); err != nil {
+		return nil, p.handleError(ctx, err, "FindSimilarMessage")
+	}
+	return message, nil
+}
+
+func (p *PostgresStorage) FindDuplicateMessages(ctx context.Context, userID int64) ([]models.DuplicateGroup, error) {
+	ctx, end := observeOperation(ctx, "FindDuplicateMessages")
+	defer end()
+
+	query := `
+        SELECT id, user_id, content, category, tags, summary, file_id, content_type, created_at, archived, archived_at, source, links, attachments_analysis, is_pinned, content_hash
+        FROM messages
+        WHERE user_id = $1 AND content_hash IN (
+            SELECT content_hash
+            FROM messages
+            WHERE user_id = $1
+            GROUP BY content_hash
+            HAVING COUNT(*) > 1)
+        ORDER BY content_hash, created_at ASC`
+
+	rows, err := p.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, p.handleError(ctx, err, "FindDuplicateMessages")
+	}
+	defer rows.Close()
+
+	var groups []models.DuplicateGroup
+	for rows.Next() {
+		message := &models.Message{}
+		var hash string
+		if err := rows.Scan(append(messageFields(message), &hash)...); err != nil {
+			return nil, p.handleError(ctx, err, "FindDuplicateMessages")
+		}
+		if err := p.cipher.decryptMessage(message); err != nil {
+			return nil, p.handleError(ctx, err, "FindDuplicateMessages")
+		}
+
+		if len(groups) == 0 || groups[len(groups)-1].ContentHash != hash {
+			groups = append(groups, models.DuplicateGroup{ContentHash: hash})
+		}
+		groups[len(groups)-1].Messages = append(groups[len(groups)-1].Messages, message)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, p.handleError(ctx, err, "FindDuplicateMessages")
+	}
+	return groups, nil
+}
+
+func (p *PostgresStorage) SaveClassificationReply(ctx context.Context, chatID int64, botMessageID int, messageID string, sourceMessageID int) error {
+	ctx, end := observeOperation(ctx, "SaveClassificationReply")
+	defer end()
+
+	query := `
+        INSERT INTO classification_replies (chat_id, bot_message_id, message_id, source_message_id)
+        VALUES ($1, $2, $3, NULLIF($4, 0))
+        ON CONFLICT (chat_id, bot_message_id) DO UPDATE
+        SET message_id = EXCLUDED.message_id,
+            source_message_id = EXCLUDED.source_message_id`
+
+	_, err := p.db.ExecContext(ctx, query, chatID, botMessageID, messageID, sourceMessageID)
+	if err != nil {
+		return p.handleError(ctx, err, "SaveClassificationReply")
+	}
+	return nil
+}
+
+func (p *PostgresStorage) GetMessageByClassificationReply(ctx context.Context, chatID int64, botMessageID int) (*models.Message, error) {
+	ctx, end := observeOperation(ctx, "GetMessageByClassificationReply")
+	defer end()
+
+	query := `
+        SELECT id, user_id, content, category, tags, summary, file_id, content_type, created_at, archived, archived_at, source, links, attachments_analysis, is_pinned
+        FROM messages
+        WHERE id = (
+            SELECT message_id
+            FROM classification_replies
+            WHERE chat_id = $1 AND bot_message_id = $2)`
+
+	message := &models.Message{}
+	err := p.db.QueryRowContext(ctx, query, chatID, botMessageID).Scan(messageFields(message)...)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, p.handleError(ctx, err, "GetMessageByClassificationReply")
+	}
+	if err := p.cipher.decryptMessage(message); err != nil {
+		return nil, p.handleError(ctx, err, "GetMessageByClassificationReply")
+	}
+	return message, nil
+}
+
+func (p *PostgresStorage) GetClassificationBySource(ctx context.Context, chatID int64, sourceMessageID int) (*models.Message, int, error) {
+	ctx, end := observeOperation(ctx, "GetClassificationBySource")
+	defer end()
+
+	query := `
+        SELECT m.id, m.user_id, m.content, m.category, m.tags, m.summary, m.file_id, m.content_type, m.created_at, m.archived, m.archived_at, m.source, m.links, m.attachments_analysis, m.is_pinned,
+               r.bot_message_id
+        FROM classification_replies r
+        JOIN messages m ON m.id = r.message_id
+        WHERE r.chat_id = $1 AND r.source_message_id = $2
+        ORDER BY r.created_at DESC
+        LIMIT 1`
+
+	message := &models.Message{}
+	var botMessageID int
+	err := p.db.QueryRowContext(ctx, query, chatID, sourceMessageID).Scan(append(messageFields(message), &botMessageID)...)
+	if err == sql.ErrNoRows {
+		return nil, 0, ErrNotFound
+	}
+	if err != nil {
+		return nil, 0, p.handleError(ctx, err, "GetClassificationBySource")
+	}
+	if err := p.cipher.decryptMessage(message); err != nil {
+		return nil, 0, p.handleError(ctx, err, "GetClassificationBySource")
+	}
+	return message, botMessageID, nil
+}
+
+func (p *PostgresStorage) GetUserStats(ctx context.Context, userID int64) (*models.UserStats, error) {
+	ctx, end := observeOperation(ctx, "GetUserStats")
+	defer end()
+
+	query := `
+        SELECT
+            COUNT(*),
+            COUNT(DISTINCT replace(lower(NULLIF(category, '')), ' ', '_')),
+            (SELECT COUNT(DISTINCT replace(lower(t), ' ', '_'))
+             FROM messages m, unnest(m.tags) AS t
+             WHERE m.user_id = $1),
+            MIN(created_at),
+            MAX(created_at)
+        FROM messages
+        WHERE user_id = $1`
+
+	stats := &models.UserStats{}
+	var first, last sql.NullTime
+	err := p.db.QueryRowContext(ctx, query, userID).Scan(
+		&stats.TotalMessages,
+		&stats.Categories,
+		&stats.Tags,
+		&first,
+		&last,
+	)
+	if err != nil {
+		return nil, p.handleError(ctx, err, "GetUserStats")
+	}
+	stats.FirstMessageAt = first.Time
+	stats.LastMessageAt = last.Time
+
+	topQuery := `
+        SELECT MIN(category), COUNT(*)
+        FROM messages
+        WHERE user_id = $1 AND category <> ''
+        GROUP BY replace(lower(category), ' ', '_')
+        ORDER BY COUNT(*) DESC, MIN(category)
+        LIMIT 1`
+
+	err = p.db.QueryRowContext(ctx, topQuery, userID).Scan(&stats.TopCategory, &stats.TopCategoryCount)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, p.handleError(ctx, err, "GetUserStats")
+	}
+	return stats, nil
+}
+
+// queryer runs queries on either the pool or a transaction
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+func (p *PostgresStorage) BackupUser(ctx context.Context, userID int64) (*models.Backup, error) {
+	ctx, end := observeOperation(ctx, "BackupUser")
+	defer end()
+
+	// One snapshot, so notes saved meanwhile can't leave links dangling
+	tx, err := p.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, p.handleError(ctx, err, "BackupUser")
+	}
+	defer tx.Rollback()
+
+	backup := &models.Backup{UserID: userID, CategoryTags: make(map[string][]string)}
+
+	user, err := scanUser(tx.QueryRowContext(ctx, `
+        SELECT user_id, '', categories, tags, max_tags, date_format, category_icons, language, last_used_at, allowed_categories, retention_days, timezone, temperature
+        FROM user_metadata
+        WHERE user_id = $1`, userID).Scan)
+	if err == sql.ErrNoRows {
+		user = &models.User{ID: userID, MaxTags: DefaultMaxTags, LastUsedAt: time.Now()}
+	} else if err != nil {
+		return nil, p.handleError(ctx, err, "BackupUser")
+	}
+	backup.User = user
+
+	backup.Messages, err = p.queryMessagesWith(ctx, tx, "BackupUser", `
+        SELECT id, user_id, content, category, tags, summary, file_id, content_type, created_at, archived, archived_at, source, links, attachments_analysis, is_pinned
+        FROM messages
+        WHERE user_id = $1
+        ORDER BY created_at`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+        SELECT category, tags
+        FROM category_default_tags
+        WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, p.handleError(ctx, err, "BackupUser")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			category string
+			tags     []string
+		)
+		if err := rows.Scan(&category, pq.Array(&tags)); err != nil {
+			return nil, p.handleError(ctx, err, "BackupUser")
+		}
+		backup.CategoryTags[category] = tags
+	}
+	if err := rows.Err(); err != nil {
+		return nil, p.handleError(ctx, err, "BackupUser")
+	}
+
+	rows, err = tx.QueryContext(ctx, `
+        SELECT from_id, to_id
+        FROM note_links
+        WHERE user_id = $1
+        ORDER BY created_at`, userID)
+	if err != nil {
+		return nil, p.handleError(ctx, err, "BackupUser")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var link models.NoteLink
+		if err := rows.Scan(&link.FromID, &link.ToID); err != nil {
+			return nil, p.handleError(ctx, err, "BackupUser")
+		}
+		backup.Links = append(backup.Links, link)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, p.handleError(ctx, err, "BackupUser")
+	}
+
+	return backup, p.handleError(ctx, tx.Commit(), "BackupUser")
+}
+
+// restoreColumns are the messages columns a restore fills in
+var restoreColumns = []string{
+	"id", "user_id", "content", "category", "tags", "summary", "file_id", "content_type", "content_hash",
+	"created_at", "archived", "archived_at", "source", "links", "attachments_analysis", "is_pinned",
+}
+
+func (p *PostgresStorage) RestoreUser(ctx context.Context, backup *models.Backup, replace bool) (int, error) {
+	ctx, end := observeOperation(ctx, "RestoreUser")
+	defer end()
+
+	if err := p.limits.prepareRestore(backup); err != nil {
+		return 0, err
+	}
+	userID := backup.UserID
+
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, p.handleError(ctx, err, "RestoreUser")
+	}
+	defer tx.Rollback()
+
+	current, err := scanUser(tx.QueryRowContext(ctx, `
+        SELECT user_id, COALESCE(thread_id, ''), categories, tags, max_tags, date_format, category_icons, language, last_used_at, allowed_categories, retention_days, timezone, temperature
+        FROM user_metadata
+        WHERE user_id = $1
+        FOR UPDATE`, userID).Scan)
+	if err == sql.ErrNoRows {
+		current = &models.User{ID: userID}
+	} else if err != nil {
+		return 0, p.handleError(ctx, err, "RestoreUser")
+	}
+	user, err := p.limits.restoredUser(current, backup.User, replace)
+	if err != nil {
+		return 0, err
+	}
+
+	if replace {
+		// Classification replies go with their messages
+		for _, query := range []string{
+			"DELETE FROM note_links WHERE user_id = $1",
+			"DELETE FROM category_default_tags WHERE user_id = $1",
+			"DELETE FROM messages WHERE user_id = $1",
+		} {
+			if _, err := tx.ExecContext(ctx, query, userID); err != nil {
+				return 0, p.handleError(ctx, err, "RestoreUser")
+			}
+		}
+	}
+
+	icons, err := json.Marshal(user.CategoryIcons)
+	if err != nil {
+		return 0, p.handleError(ctx, err, "RestoreUser")
+	}
+	if user.CategoryIcons == nil {
+		icons = []byte("{}")
+	}
+	_, err = tx.ExecContext(ctx, `
+        INSERT INTO user_metadata (user_id, categories, tags, max_tags, date_format, category_icons, language, allowed_categories, retention_days, timezone, temperature, last_used_at)
+        VALUES ($1, $2, $3, $4, $5, $6::jsonb, $7, $8, $9, $10, $11, $12)
+        ON CONFLICT (user_id) DO UPDATE SET
+            categories = EXCLUDED.categories,
+            tags = EXCLUDED.tags,
+            max_tags = EXCLUDED.max_tags,
+            date_format = EXCLUDED.date_format,
+            category_icons = EXCLUDED.category_icons,
+            language = EXCLUDED.language,
+            allowed_categories = EXCLUDED.allowed_categories,
+            retention_days = EXCLUDED.retention_days,
+            timezone = EXCLUDED.timezone,
+            temperature = EXCLUDED.temperature,
+            last_used_at = EXCLUDED.last_used_at`,
+		userID,
+		pq.Array(user.Categories),
+		pq.Array(user.Tags),
+		user.MaxTags,
+		user.DateFormat,
+		string(icons),
+		user.Language,
+		pq.Array(user.AllowedCategories),
+		user.RetentionDays,
+		user.Timezone,
+		user.Temperature,
+		user.LastUsedAt,
+	)
+	if err != nil {
+		return 0, p.handleError(ctx, err, "RestoreUser")
+	}
+
+	restored, err := p.restoreMessages(ctx, tx, backup.Messages)
+	if err != nil {
+		return 0, err
+	}
+
+	for category, tags := range backup.CategoryTags {
+		_, err := tx.ExecContext(ctx, `
+            INSERT INTO category_default_tags (user_id, category, tags)
+            VALUES ($1, $2, $3)
+            ON CONFLICT (user_id, category) DO NOTHING`,
+			userID, category, pq.Array(tags))
+		if err != nil {
+			return 0, p.handleError(ctx, err, "RestoreUser")
+		}
+	}
+
+	for _, link := range backup.Links {
+		first, second := linkPair(link.FromID, link.ToID)
+		// Notes whose ID another user already has were not restored
+		_, err := tx.ExecContext(ctx, `
+            INSERT INTO note_links (user_id, from_id, to_id)
+            SELECT $1::bigint, $2::varchar, $3::varchar
+            WHERE (SELECT COUNT(*) FROM messages WHERE user_id = $1 AND id IN ($2, $3)) = 2
+            ON CONFLICT (from_id, to_id) DO NOTHING`,
+			userID, first, second)
+		if err != nil {
+			return 0, p.handleError(ctx, err, "RestoreUser")
+		}
+	}
+
+	return restored, p.handleError(ctx, tx.Commit(), "RestoreUser")
+}
+
+// restoreMessages copies messages into a scratch table and moves those whose
+// ID is not taken into messages, returning how many moved
+func (p *PostgresStorage) restoreMessages(ctx context.Context, tx *sql.Tx, messages []*models.Message) (int, error) {
+	if len(messages) == 0 {
+		return 0, nil
+	}
+
+	_, err := tx.ExecContext(ctx, `CREATE TEMP TABLE restored_messages (LIKE messages INCLUDING DEFAULTS) ON COMMIT DROP`)
+	if err != nil {
+		return 0, p.handleError(ctx, err, "RestoreUser")
+	}
+
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("restored_messages", restoreColumns...))
+	if err != nil {
+		return 0, p.handleError(ctx, err, "RestoreUser")
+	}
+	defer stmt.Close()
+
+	for _, message := range messages {
+		sealed, err := p.cipher.sealMessage(message)
+		if err != nil {
+			return 0, p.handleError(ctx, err, "RestoreUser")
+		}
+		_, err = stmt.ExecContext(ctx,
+			message.ID,
+			message.UserID,
+			sealed.Content,
+			message.Category,
+			pq.Array(message.Tags),
+			sealed.Summary,
+			message.FileID,
+			message.ContentType,
+			sealed.ContentHash,
+			message.CreatedAt,
+			message.Archived,
+			message.ArchivedAt,
+			message.Source,
+			pq.Array(sealed.Links),
+			sealed.AttachmentsAnalysis,
+			message.IsPinned,
+		)
+		if err != nil {
+			return 0, p.handleError(ctx, err, "RestoreUser")
+		}
+	}
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		return 0, p.handleError(ctx, err, "RestoreUser")
+	}
+	if err := stmt.Close(); err != nil {
+		return 0, p.handleError(ctx, err, "RestoreUser")
+	}
+
+	columns := strings.Join(restoreColumns, ", ")
+	result, err := tx.ExecContext(ctx, `
+        INSERT INTO messages (`+columns+`)
+        SELECT `+columns+` FROM restored_messages
+        ON CONFLICT (id) DO NOTHING`)
+	if err != nil {
+		return 0, p.handleError(ctx, err, "RestoreUser")
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, p.handleError(ctx, err, "RestoreUser")
+	}
+	return int(rows), nil
+}
+
+func (p *PostgresStorage) GetCategoryCounts(ctx context.Context, userID int64) (map[string]int, error) {
+	ctx, end := observeOperation(ctx, "GetCategoryCounts")
+	defer end()
+
+	query := `
+        SELECT MIN(category), COUNT(*)
+        FROM messages
+        WHERE user_id = $1 AND archived = false AND category <> ''
+        GROUP BY replace(lower(category), ' ', '_')`
+
+	rows, err := p.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, p.handleError(ctx, err, "GetCategoryCounts")
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var (
+			category string
+			count    int
+		)
+		if err := rows.Scan(&category, &count); err != nil {
+			return nil, p.handleError(ctx, err, "GetCategoryCounts")
+		}
+		counts[category] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, p.handleError(ctx, err, "GetCategoryCounts")
+	}
+	return counts, nil
+}
+
+func (p *PostgresStorage) GetTagCounts(ctx context.Context, userID int64) (map[string]int, error) {
+	ctx, end := observeOperation(ctx, "GetTagCounts")
+	defer end()
+
+	// A message tagged twice with spellings of the same tag counts once
+	query := `
+        SELECT MIN(tag), COUNT(DISTINCT id)
+        FROM messages, unnest(tags) AS tag
+        WHERE user_id = $1 AND archived = false AND tag <> ''
+        GROUP BY replace(lower(tag), ' ', '_')`
+
+	rows, err := p.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, p.handleError(ctx, err, "GetTagCounts")
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var (
+			tag   string
+			count int
+		)
+		if err := rows.Scan(&tag, &count); err != nil {
+			return nil, p.handleError(ctx, err, "GetTagCounts")
+		}
+		counts[tag] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, p.handleError(ctx, err, "GetTagCounts")
+	}
+	return counts, nil
+}
+
+// messageFields returns scan destinations matching the message column list
+// used by the SELECT queries above
+func messageFields(message *models.Message) []any {
+	return []any{
+		&message.ID,
+		&message.UserID,
+		&message.Content,
+		&message.Category,
+		pq.Array(&message.Tags),
+		&message.Summary,
+		&message.FileID,
+		&message.ContentType,
+		&message.CreatedAt,
+		&message.Archived,
+		&message.ArchivedAt,
+		&message.Source,
+		pq.Array(&message.Links),
+		&message.AttachmentsAnalysis,
+		&message.IsPinned,
+	}
+}
+
+func (p *PostgresStorage) queryMessages(ctx context.Context, operation string, query string, args ...any) ([]*models.Message, error) {
+	return p.queryMessagesWith(ctx, p.db, operation, query, args...)
+}
+
+// queryMessagesWith is queryMessages inside a transaction or on the pool
+func (p *PostgresStorage) queryMessagesWith(ctx context.Context, q queryer, operation string, query string, args ...any) ([]*models.Message, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, p.handleError(ctx, err, operation)
+	}
+	defer rows.Close()
+
+	messages := []*models.Message{}
+	for rows.Next() {
+		message := &models.Message{}
+		if err := rows.Scan(messageFields(message)...); err != nil {
+			return nil, p.handleError(ctx, err, operation)
+		}
+		if err := p.cipher.decryptMessage(message); err != nil {
+			return nil, p.handleError(ctx, err, operation)
+		}
+		messages = append(messages, message)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, p.handleError(ctx, err, operation)
+	}
+	return messages, nil
+}
+
+func (p *PostgresStorage) IsChatMuted(ctx context.Context, chatID int64) (bool, error) {
+	ctx, end := observeOperation(ctx, "IsChatMuted")
+	defer end()
+
+	query := `
+        SELECT muted
+        FROM chat_settings
+        WHERE chat_id = $1`
+
+	var muted bool
+	err := p.db.QueryRowContext(ctx, query, chatID).Scan(&muted)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, p.handleError(ctx, err, "IsChatMuted")
+	}
+	return muted, nil
+}
+
+func (p *PostgresStorage) SetChatMuted(ctx context.Context, chatID int64, muted bool) error {
+	ctx, end := observeOperation(ctx, "SetChatMuted")
+	defer end()
+
+	query := `
+        INSERT INTO chat_settings (chat_id, muted, updated_at)
+        VALUES ($1, $2, NOW())
+        ON CONFLICT (chat_id) DO UPDATE SET
+            muted = EXCLUDED.muted,
+            updated_at = NOW()`
+
+	_, err := p.db.ExecContext(ctx, query, chatID, muted)
+	return p.handleError(ctx, err, "SetChatMuted")
+}
@@ -2,8 +2,15 @@ package storage
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"github.com/xaenox/memo-bot/internal/models"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
 )
 
 var (
@@ -17,6 +24,65 @@ var (
 	ErrConstraint    = errors.New("constraint violation")
 )
 
+// DefaultMaxTags is the per-user tag limit until /maxtags changes it. It must
+// match the max_tags column default in migrations/0011_user_max_tags.sql.
+const DefaultMaxTags = 5
+
+// Limits bound what a single user can store. Zero values fall back to the
+// defaults below.
+type Limits struct {
+	// MaxContentBytes is the longest message content accepted
+	MaxContentBytes int `mapstructure:"max_content_bytes"`
+	// MaxUserTags is the most tags a user's tag list may hold
+	MaxUserTags int `mapstructure:"max_user_tags"`
+}
+
+const (
+	DefaultMaxContentBytes = 128 << 10
+	DefaultMaxUserTags     = 1000
+)
+
+func (l Limits) withDefaults() Limits {
+	if l.MaxContentBytes <= 0 {
+		l.MaxContentBytes = DefaultMaxContentBytes
+	}
+	if l.MaxUserTags <= 0 {
+		l.MaxUserTags = DefaultMaxUserTags
+	}
+	return l
+}
+
+// checkMessage rejects messages that can't or shouldn't be stored
+func (l Limits) checkMessage(message *models.Message) error {
+	if message == nil {
+		return fmt.Errorf("%w: message cannot be nil", ErrInvalidInput)
+	}
+	if message.ID == "" || message.UserID == 0 {
+		return fmt.Errorf("%w: message id and user_id are required", ErrInvalidInput)
+	}
+	return l.checkContent(message.Content)
+}
+
+func (l Limits) checkContent(content string) error {
+	if len(content) > l.MaxContentBytes {
+		return fmt.Errorf("%w: content is %d bytes, the limit is %d", ErrInvalidInput, len(content), l.MaxContentBytes)
+	}
+	return nil
+}
+
+// tagLimitError is returned when adding a tag would exceed MaxUserTags
+func (l Limits) tagLimitError() error {
+	return fmt.Errorf("%w: a user may have at most %d tags", ErrInvalidInput, l.MaxUserTags)
+}
+
+// linkPair orders the IDs of a link so each pair has one representation
+func linkPair(fromID, toID string) (string, string) {
+	if fromID > toID {
+		return toID, fromID
+	}
+	return fromID, toID
+}
+
 // IsDatabaseError checks if an error is a database-related error
 func IsDatabaseError(err error) bool {
 	return errors.Is(err, ErrDatabase) ||
@@ -25,10 +91,83 @@ func IsDatabaseError(err error) bool {
 		errors.Is(err, ErrConstraint)
 }
 
+// ContentHash identifies messages whose text differs only in case or whitespace.
+// It must match the content_hash expression in migrations/0007_message_content_hash.sql.
+func ContentHash(content string) string {
+	sum := sha256.Sum256([]byte(hashNormalized(content)))
+	return hex.EncodeToString(sum[:])
+}
+
+// hashNormalized is content as it is hashed: lowercased, with whitespace
+// runs collapsed to a space
+func hashNormalized(content string) string {
+	return strings.ToLower(strings.Join(strings.Fields(content), " "))
+}
+
+// NormalizeLabel is the stored form of a category or tag name: trimmed,
+// without a leading "#", lowercased, with whitespace runs joined by "_", so
+// "Work", "#work" and " work " are one label. It must match the expression in
+// migrations/0025_normalize_labels.sql.
+func NormalizeLabel(label string) string {
+	label = strings.TrimPrefix(strings.TrimSpace(label), "#")
+	return strings.ToLower(strings.Join(strings.Fields(label), "_"))
+}
+
+// NormalizeLabels normalizes each label, dropping empty ones and the repeats
+// that may create. The first occurrence keeps its position.
+func NormalizeLabels(labels []string) []string {
+	seen := make(map[string]bool, len(labels))
+	normalized := make([]string, 0, len(labels))
+	for _, label := range labels {
+		label = NormalizeLabel(label)
+		if label != "" && !seen[label] {
+			seen[label] = true
+			normalized = append(normalized, label)
+		}
+	}
+	return normalized
+}
+
+// normalizeMessageLabels puts the category and tags of m in their stored form
+func normalizeMessageLabels(m *models.Message) {
+	m.Category = NormalizeLabel(m.Category)
+	m.Tags = NormalizeLabels(m.Tags)
+}
+
+// Both backends must stay interchangeable
+var (
+	_ Storage = (*MemoryStorage)(nil)
+	_ Storage = (*PostgresStorage)(nil)
+)
+
+// New returns the storage selected by config: notes kept in memory when
+// UseInMemory is set, PostgreSQL otherwise
+func New(config DatabaseConfig, logger *zap.Logger) (Storage, error) {
+	if config.UseInMemory {
+		logger.Info("Using in-memory storage")
+		if config.EncryptionKey != "" {
+			logger.Warn("database.encryption_key only applies to PostgreSQL; in-memory notes are not encrypted")
+		}
+		return NewMemoryStorage(config.Limits), nil
+	}
+
+	logger.Info("Using PostgreSQL storage")
+	store, err := NewPostgresStorage(config, logger)
+	if err != nil {
+		// A nil *PostgresStorage would make a non-nil Storage
+		return nil, err
+	}
+	return store, nil
+}
+
 // Storage combines all storage interfaces
 type Storage interface {
 	UserStorage
+	MessageStorage
 	ThreadStorage
+	StateStorage
+	ChatStorage
+	CheckHealth(ctx context.Context) error
 	Close() error
 }
 
@@ -36,12 +175,122 @@ type Storage interface {
 type UserStorage interface {
 	GetUser(ctx context.Context, id int64) (*models.User, error)
 	UpdateUser(ctx context.Context, user *models.User) error
+	// ListUsers pages through all stored users, most recently active first
+	ListUsers(ctx context.Context, limit, offset int) ([]*models.User, error)
+	// DeleteUser removes the user's metadata, messages and stored thread
+	DeleteUser(ctx context.Context, userID int64) error
 	AddCategory(ctx context.Context, userID int64, category string) error
 	RemoveCategory(ctx context.Context, userID int64, category string) error
 	UpdateUserMaxTags(ctx context.Context, userID int64, maxTags int) error
+	UpdateUserDateFormat(ctx context.Context, userID int64, format string) error
+	UpdateUserLanguage(ctx context.Context, userID int64, language string) error
+	// UpdateUserTimezone sets the IANA zone the user's dates are shown in;
+	// empty shows UTC
+	UpdateUserTimezone(ctx context.Context, userID int64, timezone string) error
+	// UpdateUserTemperature sets the classifier temperature for the user's
+	// notes; nil goes back to the configured one
+	UpdateUserTemperature(ctx context.Context, userID int64, temperature *float64) error
+	// UpdateUserRetention sets how many days the user's notes are kept; zero
+	// keeps them forever
+	UpdateUserRetention(ctx context.Context, userID int64, days int) error
+	SetCategoryIcon(ctx context.Context, userID int64, category, icon string) error
+	// SetAllowedCategories restricts classification to categories; an empty
+	// list lifts the restriction
+	SetAllowedCategories(ctx context.Context, userID int64, categories []string) error
+	// SetCategoryTags replaces the tags added to every new note in category.
+	// GetCategoryTags returns them, or nil if the category has none, and
+	// ClearCategoryTags removes them or returns ErrNotFound.
+	SetCategoryTags(ctx context.Context, userID int64, category string, tags []string) error
+	GetCategoryTags(ctx context.Context, userID int64, category string) ([]string, error)
+	ClearCategoryTags(ctx context.Context, userID int64, category string) error
 	AddTag(ctx context.Context, userID int64, tag string) error
+	// RemoveTag drops a tag from the user's tag list; notes keep it
+	RemoveTag(ctx context.Context, userID int64, tag string) error
+	// RenameTag replaces a tag in the user's tag list and in all of the
+	// user's messages. Tags are matched ignoring case and spaces.
+	RenameTag(ctx context.Context, userID int64, oldTag, newTag string) error
+	// MergeCategory moves the user's messages from one category to another
+	// and drops the old one from the category list. It returns how many
+	// messages moved, or ErrNotFound if the user has no such category.
+	MergeCategory(ctx context.Context, userID int64, from, into string) (int, error)
+	// RenameCategory renames a category in the user's category list, keeping
+	// its position, and moves the user's messages to the new name. When the
+	// new name is already listed the two categories are merged. It returns
+	// how many messages moved, or ErrNotFound if the user has no such
+	// category.
+	RenameCategory(ctx context.Context, userID int64, oldName, newName string) (int, error)
 	GetUserCategories(ctx context.Context, userID int64) ([]string, error)
 	GetUserTags(ctx context.Context, userID int64) ([]string, error)
+	GetUserStats(ctx context.Context, userID int64) (*models.UserStats, error)
+	// BackupUser collects the user's settings, lists, notes, category tags
+	// and links as one consistent snapshot. The assistant thread is left
+	// out and Version and CreatedAt are left for the caller to set.
+	BackupUser(ctx context.Context, userID int64) (*models.Backup, error)
+	// RestoreUser writes a backup for backup.UserID, all of it or, on error,
+	// nothing. With replace the user's notes, lists, category tags, links
+	// and settings become the backup's. Otherwise the backup is merged in:
+	// notes not stored yet are added along with missing categories, tags,
+	// icons, category tags and links, and only settings the user never
+	// changed are taken from the backup. Notes are matched by ID, so
+	// restoring a backup again adds nothing. It returns how many notes were
+	// added.
+	RestoreUser(ctx context.Context, backup *models.Backup, replace bool) (int, error)
+}
+
+// MessageStorage handles saved notes and the bot messages about them
+type MessageStorage interface {
+	SaveMessage(ctx context.Context, message *models.Message) error
+	// SaveMessages stores all messages or, on error, none of them. Users
+	// without any data yet are created.
+	SaveMessages(ctx context.Context, messages []*models.Message) error
+	GetUserMessages(ctx context.Context, userID int64, limit, offset int) ([]*models.Message, error)
+	GetUserMessagesByCategory(ctx context.Context, userID int64, category string, limit, offset int) ([]*models.Message, error)
+	GetUserMessagesByTag(ctx context.Context, userID int64, tag string, limit, offset int) ([]*models.Message, error)
+	// GetArchivedMessages lists archived messages, most recently archived first.
+	// The other listings leave archived messages out.
+	GetArchivedMessages(ctx context.Context, userID int64, limit, offset int) ([]*models.Message, error)
+	// GetPinnedMessages lists the user's pinned unarchived messages, newest
+	// first
+	GetPinnedMessages(ctx context.Context, userID int64, limit, offset int) ([]*models.Message, error)
+	// GetUserMessagesPinnedFirst lists like GetUserMessages but with the
+	// pinned messages ahead of the others
+	GetUserMessagesPinnedFirst(ctx context.Context, userID int64, limit, offset int) ([]*models.Message, error)
+	GetMessageByID(ctx context.Context, id string) (*models.Message, error)
+	DeleteMessage(ctx context.Context, id string) error
+	// DeleteMessagesOlderThan deletes the user's messages created before
+	// cutoff, archived ones included, and returns how many were deleted
+	DeleteMessagesOlderThan(ctx context.Context, userID int64, cutoff time.Time) (int, error)
+	ArchiveMessage(ctx context.Context, id string) error
+	UnarchiveMessage(ctx context.Context, id string) error
+	// SetMessagePinned pins or unpins one of the user's messages. It returns
+	// ErrNotFound unless the message belongs to the user.
+	SetMessagePinned(ctx context.Context, userID int64, id string, pinned bool) error
+	UpdateMessageClassification(ctx context.Context, id string, category string, tags []string) error
+	// UpdateMessage replaces a message's content and classification
+	UpdateMessage(ctx context.Context, message *models.Message) error
+	FindDuplicateMessages(ctx context.Context, userID int64) ([]models.DuplicateGroup, error)
+	// FindSimilarMessage returns the user's latest note with the same
+	// normalized content, or ErrNotFound
+	FindSimilarMessage(ctx context.Context, userID int64, content string) (*models.Message, error)
+	// SaveClassificationReply links a bot message to the note it is about.
+	// sourceMessageID is the user's message that was classified, or 0.
+	SaveClassificationReply(ctx context.Context, chatID int64, botMessageID int, messageID string, sourceMessageID int) error
+	GetMessageByClassificationReply(ctx context.Context, chatID int64, botMessageID int) (*models.Message, error)
+	// GetClassificationBySource finds the note saved from a user's message and
+	// the bot message showing its classification
+	GetClassificationBySource(ctx context.Context, chatID int64, sourceMessageID int) (message *models.Message, botMessageID int, err error)
+	// LinkMessages connects two of the user's messages both ways. It returns
+	// ErrNotFound unless both belong to the user, ErrInvalidInput for a
+	// self-link and ErrAlreadyExists if they are already linked.
+	LinkMessages(ctx context.Context, userID int64, fromID, toID string) error
+	// GetLinkedMessages lists the messages linked to id, newest first
+	GetLinkedMessages(ctx context.Context, userID int64, id string) ([]*models.Message, error)
+	// GetCategoryCounts counts the user's unarchived messages per category.
+	// Categories differing only in case or spaces are counted together.
+	GetCategoryCounts(ctx context.Context, userID int64) (map[string]int, error)
+	// GetTagCounts counts the user's unarchived messages per tag, like
+	// GetCategoryCounts
+	GetTagCounts(ctx context.Context, userID int64) (map[string]int, error)
 }
 
 // ThreadStorage handles AI assistant thread operations
@@ -51,3 +300,22 @@ type ThreadStorage interface {
 	UpdateThreadLastUsed(ctx context.Context, userID int64) error
 	DeleteThread(ctx context.Context, userID int64) error
 }
+
+// StateStorage handles bot-wide state that must survive restarts
+type StateStorage interface {
+	GetUpdateOffset(ctx context.Context) (int, error)
+	SetUpdateOffset(ctx context.Context, offset int) error
+	// MarkUpdateProcessed records an update as handled and reports whether
+	// it was new. Only the last ProcessedUpdatesWindow IDs are remembered.
+	MarkUpdateProcessed(ctx context.Context, updateID int) (bool, error)
+}
+
+// ProcessedUpdatesWindow is how many update IDs below the newest one are
+// still recognized as duplicates
+const ProcessedUpdatesWindow = 10000
+
+// ChatStorage handles per-chat settings
+type ChatStorage interface {
+	IsChatMuted(ctx context.Context, chatID int64) (bool, error)
+	SetChatMuted(ctx context.Context, chatID int64, muted bool) error
+}
@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xaenox/memo-bot/internal/models"
+)
+
+func TestNormalizeLabel(t *testing.T) {
+	tests := []struct {
+		label string
+		want  string
+	}{
+		{"work", "work"},
+		{"Work", "work"},
+		{"WORK", "work"},
+		{"#work", "work"},
+		{"  Work  ", "work"},
+		{"Side Project", "side_project"},
+		{"side   project", "side_project"},
+		{"#", ""},
+		{"   ", ""},
+	}
+	for _, tt := range tests {
+		if got := NormalizeLabel(tt.label); got != tt.want {
+			t.Errorf("NormalizeLabel(%q) = %q, want %q", tt.label, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeLabels(t *testing.T) {
+	got := NormalizeLabels([]string{"Work", "meeting", "work", "#Meeting", " ", "Side Project"})
+	want := []string{"work", "meeting", "side_project"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("NormalizeLabels = %v, want %v", got, want)
+	}
+}
+
+func TestLabelsCollapseOnSave(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStorage(Limits{})
+
+	for _, category := range []string{"Work", "work", "#WORK"} {
+		if err := s.AddCategory(ctx, 1, category); err != nil {
+			t.Fatalf("AddCategory(%q): %v", category, err)
+		}
+	}
+	for _, tag := range []string{"Meeting", "meeting"} {
+		if err := s.AddTag(ctx, 1, tag); err != nil {
+			t.Fatalf("AddTag(%q): %v", tag, err)
+		}
+	}
+	user, err := s.GetUser(ctx, 1)
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	if strings.Join(user.Categories, ",") != "work" || strings.Join(user.Tags, ",") != "meeting" {
+		t.Errorf("categories %v and tags %v, want [work] and [meeting]", user.Categories, user.Tags)
+	}
+
+	note := &models.Message{ID: "a", UserID: 1, Content: "note", Category: "Work", Tags: []string{"Meeting", "#meeting", "Q3 Plan"}, CreatedAt: time.Now()}
+	if err := s.SaveMessage(ctx, note); err != nil {
+		t.Fatalf("SaveMessage: %v", err)
+	}
+	saved, err := s.GetMessageByID(ctx, "a")
+	if err != nil {
+		t.Fatalf("GetMessageByID: %v", err)
+	}
+	if saved.Category != "work" || strings.Join(saved.Tags, ",") != "meeting,q3_plan" {
+		t.Errorf("saved category %q and tags %v, want work and [meeting q3_plan]", saved.Category, saved.Tags)
+	}
+
+	// Filters match whatever spelling the user types
+	for _, category := range []string{"work", "Work", "#work"} {
+		messages, err := s.GetUserMessagesByCategory(ctx, 1, category, 10, 0)
+		if err != nil || len(messages) != 1 {
+			t.Errorf("GetUserMessagesByCategory(%q) = %d notes, %v; want 1", category, len(messages), err)
+		}
+	}
+}
+
+// messageStorageContract drives the basic message operations through the
+// MessageStorage interface alone
+func messageStorageContract(t *testing.T, s MessageStorage) {
+	t.Helper()
+	ctx := context.Background()
+
+	message := &models.Message{ID: "contract-1", UserID: 9, Content: "Buy milk", Category: "Shopping", CreatedAt: time.Now()}
+	if err := s.SaveMessage(ctx, message); err != nil {
+		t.Fatalf("SaveMessage: %v", err)
+	}
+
+	got, err := s.GetMessageByID(ctx, message.ID)
+	if err != nil {
+		t.Fatalf("GetMessageByID: %v", err)
+	}
+	if got.Content != message.Content || got.UserID != message.UserID || got.Category != "shopping" {
+		t.Errorf("GetMessageByID = %+v, want the saved note with its category normalized", got)
+	}
+
+	messages, err := s.GetUserMessages(ctx, message.UserID, 10, 0)
+	if err != nil {
+		t.Fatalf("GetUserMessages: %v", err)
+	}
+	if len(messages) != 1 || messages[0].ID != message.ID {
+		t.Errorf("GetUserMessages = %v, want only %s", messages, message.ID)
+	}
+
+	if err := s.DeleteMessage(ctx, message.ID); err != nil {
+		t.Fatalf("DeleteMessage: %v", err)
+	}
+	if _, err := s.GetMessageByID(ctx, message.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetMessageByID after delete: %v, want ErrNotFound", err)
+	}
+	if err := s.DeleteMessage(ctx, message.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second DeleteMessage: %v, want ErrNotFound", err)
+	}
+}
+
+func TestMemoryStorageMessageStorage(t *testing.T) {
+	var s MessageStorage = NewMemoryStorage(Limits{})
+	messageStorageContract(t, s)
+}
@@ -1,50 +1,410 @@
 package config
 
 import (
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"github.com/spf13/viper"
+	"github.com/xaenox/memo-bot/internal/storage"
+	"go.uber.org/zap/zapcore"
+	"io/fs"
 	"net/url"
+	"os"
+	"reflect"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type Config struct {
-	Telegram   TelegramConfig   `mapstructure:"telegram"`
-	Database   DatabaseConfig   `mapstructure:"database"`
-	Classifier ClassifierConfig `mapstructure:"classifier"`
-	OpenAI     OpenAIConfig     `mapstructure:"openai"`
+	Telegram   TelegramConfig         `mapstructure:"telegram"`
+	Database   storage.DatabaseConfig `mapstructure:"database"`
+	Classifier ClassifierConfig       `mapstructure:"classifier"`
+	OpenAI     OpenAIConfig           `mapstructure:"openai"`
+	Metrics    MetricsConfig          `mapstructure:"metrics"`
+	Health     HealthConfig           `mapstructure:"health"`
+	API        APIConfig              `mapstructure:"api"`
+	Telemetry  TelemetryConfig        `mapstructure:"telemetry"`
+	Logging    LoggingConfig          `mapstructure:"logging"`
 }
 
 type TelegramConfig struct {
-	Token string `mapstructure:"token"`
+	Token                string `mapstructure:"token"`
+	MaxConcurrentUpdates int    `mapstructure:"max_concurrent_updates"`
+	WebhookURL           string `mapstructure:"webhook_url"`
+	ListenAddr           string `mapstructure:"listen_addr"`
+	// AdminIDs are the Telegram users allowed to run admin commands such as /import
+	AdminIDs []int64 `mapstructure:"admin_ids"`
+	// LoadingMessage posts an "Analyzing..." reply while classifying, in
+	// addition to the typing indicator
+	LoadingMessage bool `mapstructure:"loading_message"`
+	// DetectDuplicates asks before saving a note identical to a saved one
+	DetectDuplicates bool `mapstructure:"detect_duplicates"`
+	// UpdateDedup is where handled update IDs are remembered so redelivered
+	// updates are skipped
+	UpdateDedup string `mapstructure:"update_dedup"`
+	// BotName fills {{.BotName}} in the templates; empty uses the bot's
+	// Telegram name
+	BotName string `mapstructure:"bot_name"`
+	// WelcomeTemplate and HelpTemplate replace the /start and /help texts.
+	// They are MarkdownV2 text/templates; the *File settings are read into
+	// them when set.
+	WelcomeTemplate     string `mapstructure:"welcome_template"`
+	WelcomeTemplateFile string `mapstructure:"welcome_template_file"`
+	HelpTemplate        string `mapstructure:"help_template"`
+	HelpTemplateFile    string `mapstructure:"help_template_file"`
+	// GroupWindow joins text messages sent within this long of each other
+	// into one note; zero disables grouping
+	GroupWindow time.Duration `mapstructure:"group_window"`
+	// RespondInGroups classifies every message in group chats; otherwise
+	// only messages that mention the bot or reply to it are
+	RespondInGroups bool `mapstructure:"respond_in_groups"`
+	// WriteBufferSize is how many notes are kept in memory for a retry while
+	// the database is unreachable; zero disables the buffer
+	WriteBufferSize int `mapstructure:"write_buffer_size"`
+	// AutocorrectCommands runs a mistyped read-only command when one known
+	// command is an edit away instead of only suggesting it
+	AutocorrectCommands bool `mapstructure:"autocorrect_commands"`
 }
 
-type DatabaseConfig struct {
-	Host        string `mapstructure:"host"`
-	Port        int    `mapstructure:"port"`
-	User        string `mapstructure:"user"`
-	Password    string `mapstructure:"password"`
-	DBName      string `mapstructure:"dbname"`
-	SSLMode     string `mapstructure:"sslmode"`
-	UseInMemory bool   `mapstructure:"use_in_memory"`
-}
+// OpenAI API flavours selectable with openai.api_type
+const (
+	APITypeOpenAI = "openai"
+	APITypeAzure  = "azure"
+)
+
+// How openai.assistant_check treats an assistant that can't be retrieved at
+// startup
+const (
+	AssistantCheckStrict = "strict"
+	AssistantCheckWarn   = "warn"
+	AssistantCheckOff    = "off"
+)
+
+// Classifier providers selectable with classifier.provider
+const (
+	ProviderGPT    = "gpt"
+	ProviderSimple = "simple"
+)
+
+// Stores selectable with telegram.update_dedup
+const (
+	DedupMemory  = "memory"
+	DedupStorage = "storage"
+	DedupOff     = "off"
+)
+
+// Log formats selectable with logging.format
+const (
+	LogFormatJSON    = "json"
+	LogFormatConsole = "console"
+)
+
+// Answers selectable with classifier.fallback when the assistant fails
+const (
+	FallbackSimple = "simple"
+	FallbackStatic = "static"
+)
 
 type ClassifierConfig struct {
-	MinConfidence float64 `mapstructure:"min_confidence"`
-	MaxTags       int     `mapstructure:"max_tags"`
+	Provider      string        `mapstructure:"provider"`
+	Fallback      string        `mapstructure:"fallback"` // used when the GPT classifier fails
+	MinConfidence float64       `mapstructure:"min_confidence"`
+	MaxTags       int           `mapstructure:"max_tags"`
+	CacheEnabled  bool          `mapstructure:"cache_enabled"`
+	CacheSize     int           `mapstructure:"cache_size"`
+	CacheTTL      time.Duration `mapstructure:"cache_ttl"`
+	// BatchConcurrency bounds parallel classifications during imports
+	BatchConcurrency int `mapstructure:"batch_concurrency"`
+	// MaxInputChars truncates longer notes before classification; notes
+	// several times longer are refused. Zero disables both.
+	MaxInputChars int `mapstructure:"max_input_chars"`
+	// PreferExistingTags steers classification towards the user's tags
+	PreferExistingTags bool `mapstructure:"prefer_existing_tags"`
+	// CategoryMatchThreshold is the similarity (0-1) at which a new
+	// category is replaced by an existing one. Zero disables matching.
+	CategoryMatchThreshold float64 `mapstructure:"category_match_threshold"`
+	// DailyTokenBudget and UserDailyTokenBudget cap the OpenAI tokens used
+	// per UTC day in total and per user; the fallback classifier takes over
+	// once they're spent. Zero is unlimited.
+	DailyTokenBudget     int `mapstructure:"daily_token_budget"`
+	UserDailyTokenBudget int `mapstructure:"user_daily_token_budget"`
+	// PollInterval is the first wait between run status checks; it grows
+	// up to PollMaxInterval
+	PollInterval    time.Duration `mapstructure:"poll_interval"`
+	PollMaxInterval time.Duration `mapstructure:"poll_max_interval"`
+	// Instructions replace the assistant's own instructions for every run.
+	// InstructionsFile is read into Instructions when set.
+	Instructions     string `mapstructure:"instructions"`
+	InstructionsFile string `mapstructure:"instructions_file"`
+	// Models are tried in order when one can't serve a run; empty keeps the
+	// assistant's model
+	Models []string `mapstructure:"models"`
 }
 
+// Fields the assistant must return; they match the JSON tags of
+// classifier.GPTResponse, so custom instructions have to ask for them
+var instructionsFields = []string{"category", "keywords", "summary"}
+
 type OpenAIConfig struct {
-	APIKey      string  `mapstructure:"api_key"`
-	AssistantID string  `mapstructure:"assistant_id"`
-	Model       string  `mapstructure:"model"`
-	MaxTokens   int     `mapstructure:"max_tokens"`
-	Temperature float64 `mapstructure:"temperature"`
+	APIKey string `mapstructure:"api_key"`
+	// BaseURL overrides the API endpoint, e.g. a proxy or an Azure OpenAI
+	// resource; empty uses api.openai.com
+	BaseURL string `mapstructure:"base_url"`
+	// APIType is APITypeOpenAI or APITypeAzure; APIVersion is the Azure API
+	// version, empty for the client's default
+	APIType        string        `mapstructure:"api_type"`
+	APIVersion     string        `mapstructure:"api_version"`
+	AssistantID    string        `mapstructure:"assistant_id"`
+	Model          string        `mapstructure:"model"`
+	MaxTokens      int           `mapstructure:"max_tokens"`
+	Temperature    float64       `mapstructure:"temperature"`
+	RetryAttempts  int           `mapstructure:"retry_attempts"`
+	RetryBaseDelay time.Duration `mapstructure:"retry_base_delay"`
+	Timeout        time.Duration `mapstructure:"timeout"`
+	// AssistantCheck retrieves the assistant at startup: AssistantCheckStrict
+	// refuses to start when that fails, AssistantCheckWarn only logs it
+	AssistantCheck string `mapstructure:"assistant_check"`
+}
+
+type MetricsConfig struct {
+	// ListenAddr is where /metrics is served; empty disables the endpoint
+	ListenAddr string `mapstructure:"listen_addr"`
 }
 
-func parseDatabaseURL(dbURL string) (DatabaseConfig, error) {
+type HealthConfig struct {
+	// ListenAddr is where /healthz and /readyz are served; empty disables them
+	ListenAddr string `mapstructure:"listen_addr"`
+}
+
+type TelemetryConfig struct {
+	// OTLPEndpoint is the OTLP/HTTP collector URL traces are sent to, e.g.
+	// http://localhost:4318; empty disables tracing
+	OTLPEndpoint string `mapstructure:"otlp_endpoint"`
+}
+
+type LoggingConfig struct {
+	// Level is the lowest level logged: debug, info, warn or error
+	Level string `mapstructure:"level"`
+	// Format is LogFormatJSON for log collectors or LogFormatConsole for
+	// reading logs in a terminal
+	Format string `mapstructure:"format"`
+}
+
+type APIConfig struct {
+	// Enabled serves the read-only notes API on ListenAddr
+	Enabled    bool   `mapstructure:"enabled"`
+	ListenAddr string `mapstructure:"listen_addr"`
+	// Token is the bearer token every API request must carry
+	Token string `mapstructure:"token"`
+}
+
+// Validate checks that all required settings are present and within range.
+// All problems are reported at once rather than one per run.
+func (c *Config) Validate() error {
+	var errs []error
+
+	if c.Telegram.Token == "" {
+		errs = append(errs, errors.New("telegram.token is required (or set TELEGRAM_TOKEN)"))
+	}
+	if c.Telegram.MaxConcurrentUpdates < 1 {
+		errs = append(errs, fmt.Errorf("telegram.max_concurrent_updates must be at least 1, got %d", c.Telegram.MaxConcurrentUpdates))
+	}
+	if c.Telegram.WebhookURL != "" {
+		if u, err := url.Parse(c.Telegram.WebhookURL); err != nil || u.Scheme != "https" || u.Host == "" {
+			errs = append(errs, fmt.Errorf("telegram.webhook_url must be an absolute https URL, got %q", c.Telegram.WebhookURL))
+		}
+		if c.Telegram.ListenAddr == "" {
+			errs = append(errs, errors.New("telegram.listen_addr is required when telegram.webhook_url is set"))
+		}
+	}
+	if c.Telegram.GroupWindow < 0 {
+		errs = append(errs, fmt.Errorf("telegram.group_window must not be negative, got %s", c.Telegram.GroupWindow))
+	}
+	if c.Telegram.WriteBufferSize < 0 {
+		errs = append(errs, fmt.Errorf("telegram.write_buffer_size must not be negative, got %d", c.Telegram.WriteBufferSize))
+	}
+
+	if c.Database.EncryptionKey != "" {
+		if key, err := base64.StdEncoding.DecodeString(c.Database.EncryptionKey); err != nil || (len(key) != 16 && len(key) != 24 && len(key) != 32) {
+			errs = append(errs, errors.New("database.encryption_key must be a base64 encoded 16, 24 or 32 byte key"))
+		}
+	}
+	if !c.Database.UseInMemory {
+		if c.Database.Host == "" {
+			errs = append(errs, errors.New("database.host is required unless database.use_in_memory is set"))
+		}
+		if c.Database.Port < 1 || c.Database.Port > 65535 {
+			errs = append(errs, fmt.Errorf("database.port must be between 1 and 65535, got %d", c.Database.Port))
+		}
+		if c.Database.User == "" {
+			errs = append(errs, errors.New("database.user is required unless database.use_in_memory is set"))
+		}
+		if c.Database.DBName == "" {
+			errs = append(errs, errors.New("database.dbname is required unless database.use_in_memory is set"))
+		}
+		if c.Database.MaxOpenConns < 1 {
+			errs = append(errs, fmt.Errorf("database.max_open_conns must be at least 1, got %d", c.Database.MaxOpenConns))
+		}
+		if c.Database.MaxIdleConns < 0 {
+			errs = append(errs, fmt.Errorf("database.max_idle_conns must not be negative, got %d", c.Database.MaxIdleConns))
+		}
+		if c.Database.ConnectTimeout < 0 {
+			errs = append(errs, fmt.Errorf("database.connect_timeout must not be negative, got %d", c.Database.ConnectTimeout))
+		}
+		if c.Database.ConnMaxLifetime < 0 {
+			errs = append(errs, fmt.Errorf("database.conn_max_lifetime must not be negative, got %s", c.Database.ConnMaxLifetime))
+		}
+	}
+
+	if c.Classifier.MinConfidence < 0 || c.Classifier.MinConfidence > 1 {
+		errs = append(errs, fmt.Errorf("classifier.min_confidence must be between 0 and 1, got %g", c.Classifier.MinConfidence))
+	}
+	if c.Classifier.MaxTags < 1 {
+		errs = append(errs, fmt.Errorf("classifier.max_tags must be at least 1, got %d", c.Classifier.MaxTags))
+	}
+	if c.Classifier.CacheEnabled {
+		if c.Classifier.CacheSize < 1 {
+			errs = append(errs, fmt.Errorf("classifier.cache_size must be at least 1, got %d", c.Classifier.CacheSize))
+		}
+		if c.Classifier.CacheTTL <= 0 {
+			errs = append(errs, fmt.Errorf("classifier.cache_ttl must be positive, got %s", c.Classifier.CacheTTL))
+		}
+	}
+
+	if c.Classifier.BatchConcurrency < 1 {
+		errs = append(errs, fmt.Errorf("classifier.batch_concurrency must be at least 1, got %d", c.Classifier.BatchConcurrency))
+	}
+	if c.Classifier.PollInterval <= 0 {
+		errs = append(errs, fmt.Errorf("classifier.poll_interval must be positive, got %s", c.Classifier.PollInterval))
+	}
+	if c.Classifier.PollMaxInterval < c.Classifier.PollInterval {
+		errs = append(errs, fmt.Errorf("classifier.poll_max_interval (%s) must not be less than classifier.poll_interval (%s)",
+			c.Classifier.PollMaxInterval, c.Classifier.PollInterval))
+	}
+	if c.Classifier.MaxInputChars < 0 {
+		errs = append(errs, fmt.Errorf("classifier.max_input_chars must not be negative, got %d", c.Classifier.MaxInputChars))
+	}
+
+	if c.Classifier.Instructions != "" {
+		for _, field := range instructionsFields {
+			if !strings.Contains(c.Classifier.Instructions, `"`+field+`"`) {
+				errs = append(errs, fmt.Errorf("classifier.instructions must describe the JSON output, but the %q field is not mentioned", field))
+			}
+		}
+	}
+
+	switch c.Classifier.Provider {
+	case ProviderGPT:
+		if c.OpenAI.APIKey == "" {
+			errs = append(errs, errors.New("openai.api_key is required (or set OPENAI_API_KEY)"))
+		}
+		if c.OpenAI.APIType == APITypeAzure && c.OpenAI.BaseURL == "" {
+			errs = append(errs, errors.New("openai.base_url is required when openai.api_type is \"azure\""))
+		}
+	case ProviderSimple:
+	default:
+		errs = append(errs, fmt.Errorf("classifier.provider must be %q or %q, got %q", ProviderGPT, ProviderSimple, c.Classifier.Provider))
+	}
+	if c.API.Enabled {
+		if c.API.Token == "" {
+			errs = append(errs, errors.New("api.token is required when api.enabled is set"))
+		}
+		if c.API.ListenAddr == "" {
+			errs = append(errs, errors.New("api.listen_addr is required when api.enabled is set"))
+		}
+	}
+	switch c.Telegram.UpdateDedup {
+	case DedupMemory, DedupStorage, DedupOff:
+	default:
+		errs = append(errs, fmt.Errorf("telegram.update_dedup must be %q, %q or %q, got %q", DedupMemory, DedupStorage, DedupOff, c.Telegram.UpdateDedup))
+	}
+	if c.Classifier.CategoryMatchThreshold < 0 || c.Classifier.CategoryMatchThreshold > 1 {
+		errs = append(errs, fmt.Errorf("classifier.category_match_threshold must be between 0 and 1, got %g", c.Classifier.CategoryMatchThreshold))
+	}
+	if c.Classifier.DailyTokenBudget < 0 {
+		errs = append(errs, fmt.Errorf("classifier.daily_token_budget must not be negative, got %d", c.Classifier.DailyTokenBudget))
+	}
+	if c.Classifier.UserDailyTokenBudget < 0 {
+		errs = append(errs, fmt.Errorf("classifier.user_daily_token_budget must not be negative, got %d", c.Classifier.UserDailyTokenBudget))
+	}
+	switch c.Classifier.Fallback {
+	case FallbackSimple, FallbackStatic:
+	default:
+		errs = append(errs, fmt.Errorf("classifier.fallback must be %q or %q, got %q", FallbackSimple, FallbackStatic, c.Classifier.Fallback))
+	}
+	if c.OpenAI.BaseURL != "" {
+		if u, err := url.Parse(c.OpenAI.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("openai.base_url must be an absolute http or https URL, got %q", c.OpenAI.BaseURL))
+		}
+	}
+	switch c.OpenAI.AssistantCheck {
+	case AssistantCheckStrict, AssistantCheckWarn, AssistantCheckOff:
+	default:
+		errs = append(errs, fmt.Errorf("openai.assistant_check must be %q, %q or %q, got %q", AssistantCheckStrict, AssistantCheckWarn, AssistantCheckOff, c.OpenAI.AssistantCheck))
+	}
+	switch c.OpenAI.APIType {
+	case APITypeOpenAI, APITypeAzure:
+	default:
+		errs = append(errs, fmt.Errorf("openai.api_type must be %q or %q, got %q", APITypeOpenAI, APITypeAzure, c.OpenAI.APIType))
+	}
+	if c.OpenAI.Temperature < 0 || c.OpenAI.Temperature > 2 {
+		errs = append(errs, fmt.Errorf("openai.temperature must be between 0 and 2, got %g", c.OpenAI.Temperature))
+	}
+	if c.OpenAI.RetryAttempts < 1 {
+		errs = append(errs, fmt.Errorf("openai.retry_attempts must be at least 1, got %d", c.OpenAI.RetryAttempts))
+	}
+	if c.OpenAI.RetryBaseDelay <= 0 {
+		errs = append(errs, fmt.Errorf("openai.retry_base_delay must be positive, got %s", c.OpenAI.RetryBaseDelay))
+	}
+	if c.OpenAI.Timeout <= 0 {
+		errs = append(errs, fmt.Errorf("openai.timeout must be positive, got %s", c.OpenAI.Timeout))
+	}
+	if c.Telemetry.OTLPEndpoint != "" {
+		if u, err := url.Parse(c.Telemetry.OTLPEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("telemetry.otlp_endpoint must be an absolute http or https URL, got %q", c.Telemetry.OTLPEndpoint))
+		}
+	}
+
+	if _, err := zapcore.ParseLevel(c.Logging.Level); err != nil {
+		errs = append(errs, fmt.Errorf("logging.level must be debug, info, warn or error, got %q", c.Logging.Level))
+	}
+	switch c.Logging.Format {
+	case LogFormatJSON, LogFormatConsole:
+	default:
+		errs = append(errs, fmt.Errorf("logging.format must be %q or %q, got %q", LogFormatJSON, LogFormatConsole, c.Logging.Format))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// readTemplateFile loads the template at path into *template, refusing to
+// have both the inline setting key and key_file set
+func readTemplateFile(template *string, path, key string) error {
+	if path == "" {
+		return nil
+	}
+	if *template != "" {
+		return fmt.Errorf("set only one of %s and %s_file", key, key)
+	}
+	text, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s_file: %v", key, err)
+	}
+	*template = strings.TrimSpace(string(text))
+	return nil
+}
+
+// applyDatabaseURL replaces the connection settings of db with those in
+// dbURL. The URL only describes the connection, so pool and storage settings
+// are kept.
+func applyDatabaseURL(db *storage.DatabaseConfig, dbURL string) error {
 	u, err := url.Parse(dbURL)
 	if err != nil {
-		return DatabaseConfig{}, err
+		return err
 	}
 
 	password, _ := u.User.Password()
@@ -56,37 +416,169 @@ func parseDatabaseURL(dbURL string) (DatabaseConfig, error) {
 	// Remove leading slash from path to get database name
 	dbName := strings.TrimPrefix(u.Path, "/")
 
-	return DatabaseConfig{
-		Host:     u.Hostname(),
-		Port:     port,
-		User:     u.User.Username(),
-		Password: password,
-		DBName:   dbName,
-		SSLMode:  "disable",
-	}, nil
+	// Managed providers usually require TLS and say so in the query string
+	query := u.Query()
+	sslMode := query.Get("sslmode")
+	if sslMode == "" {
+		sslMode = "disable"
+	}
+
+	var connectTimeout int
+	if value := query.Get("connect_timeout"); value != "" {
+		connectTimeout, err = strconv.Atoi(value)
+		if err != nil || connectTimeout < 0 {
+			return fmt.Errorf("invalid connect_timeout %q", value)
+		}
+	}
+
+	db.Host = u.Hostname()
+	db.Port = port
+	db.User = u.User.Username()
+	db.Password = password
+	db.DBName = dbName
+	db.SSLMode = sslMode
+	db.ConnectTimeout = connectTimeout
+	return nil
+}
+
+// EnvPrefix starts the environment variable of every config key
+const EnvPrefix = "MEMOBOT"
+
+// envName is the prefixed environment variable of a config key
+func envName(key string) string {
+	return EnvPrefix + "_" + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// bindEnv registers the environment variable of each key in t. Viper only
+// consults the environment for keys it already knows, so keys missing from
+// the file would otherwise be ignored.
+func bindEnv(v *viper.Viper, t reflect.Type, prefix string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := field.Tag.Get("mapstructure")
+		if key == ",squash" {
+			// Embedded struct whose keys sit at this level
+			bindEnv(v, field.Type, prefix)
+			continue
+		}
+		if key == "" {
+			continue
+		}
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		if field.Type.Kind() == reflect.Struct {
+			bindEnv(v, field.Type, key)
+			continue
+		}
+		v.BindEnv(key)
+	}
+}
+
+// isConfigFileMissing reports whether ReadInConfig failed only because there
+// is no file to read
+func isConfigFileMissing(err error) bool {
+	var notFound viper.ConfigFileNotFoundError
+	return errors.As(err, &notFound) || errors.Is(err, fs.ErrNotExist)
 }
 
 func LoadConfig(path string) (*Config, error) {
 	v := viper.New()
 
 	// Set default values
+	v.SetDefault("telegram.max_concurrent_updates", 10)
+	v.SetDefault("telegram.listen_addr", ":8080")
+	v.SetDefault("telegram.update_dedup", DedupMemory)
+	v.SetDefault("telegram.group_window", "0s")
+	v.SetDefault("telegram.respond_in_groups", false)
+	v.SetDefault("telegram.write_buffer_size", 100)
+	v.SetDefault("telegram.autocorrect_commands", false)
 	v.SetDefault("database.port", 5432)
 	v.SetDefault("database.host", "localhost")
 	v.SetDefault("database.user", "postgres")
 	v.SetDefault("database.sslmode", "disable")
 	v.SetDefault("database.use_in_memory", false)
+	v.SetDefault("database.max_open_conns", 10)
+	v.SetDefault("database.max_idle_conns", 5)
+	v.SetDefault("database.conn_max_lifetime", "30m")
+	v.SetDefault("database.max_content_bytes", 131072)
+	v.SetDefault("database.max_user_tags", 1000)
+	v.SetDefault("classifier.provider", ProviderGPT)
+	v.SetDefault("classifier.fallback", FallbackSimple)
 	v.SetDefault("classifier.min_confidence", 0.7)
 	v.SetDefault("classifier.max_tags", 5)
+	v.SetDefault("classifier.cache_enabled", false)
+	v.SetDefault("classifier.cache_size", 1000)
+	v.SetDefault("classifier.cache_ttl", "24h")
+	v.SetDefault("classifier.batch_concurrency", 4)
+	v.SetDefault("classifier.max_input_chars", 8000)
+	v.SetDefault("classifier.prefer_existing_tags", true)
+	v.SetDefault("classifier.category_match_threshold", 0.85)
+	v.SetDefault("classifier.daily_token_budget", 0)
+	v.SetDefault("classifier.user_daily_token_budget", 0)
+	v.SetDefault("classifier.poll_interval", "300ms")
+	v.SetDefault("classifier.poll_max_interval", "2s")
+	v.SetDefault("openai.api_type", APITypeOpenAI)
 	v.SetDefault("openai.model", "gpt-4o")
 	v.SetDefault("openai.max_tokens", 500)
 	v.SetDefault("openai.temperature", 0.7)
+	v.SetDefault("openai.retry_attempts", 3)
+	v.SetDefault("openai.retry_base_delay", "500ms")
+	v.SetDefault("openai.timeout", "60s")
+	v.SetDefault("openai.assistant_check", AssistantCheckWarn)
+	v.SetDefault("metrics.listen_addr", ":9090")
+	v.SetDefault("health.listen_addr", ":8081")
+	v.SetDefault("telemetry.otlp_endpoint", "")
+	v.SetDefault("api.enabled", false)
+	v.SetDefault("api.listen_addr", ":8082")
+	v.SetDefault("logging.level", "info")
+	v.SetDefault("logging.format", LogFormatJSON)
 
-	// Enable environment variable support
+	// Every key can be overridden from the environment, e.g. openai.model
+	// with MEMOBOT_OPENAI_MODEL. The older unprefixed names still work but
+	// lose to the prefixed ones.
+	v.SetEnvPrefix(EnvPrefix)
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 	v.AutomaticEnv()
+	bindEnv(v, reflect.TypeOf(Config{}), "")
+	for key, legacy := range map[string]string{
+		"telegram.token":             "TELEGRAM_TOKEN",
+		"openai.api_key":             "OPENAI_API_KEY",
+		"openai.assistant_id":        "OPENAI_ASSISTANT_ID",
+		"database.max_open_conns":    "DB_MAX_OPEN_CONNS",
+		"database.max_idle_conns":    "DB_MAX_IDLE_CONNS",
+		"database.conn_max_lifetime": "DB_CONN_MAX_LIFETIME",
+		"logging.level":              "LOG_LEVEL",
+	} {
+		// Viper takes the first of the names that is set
+		v.BindEnv(key, envName(key), legacy)
+	}
 
-	// Read the config file
+	// DATABASE_URL sets each connection setting its prefixed variable doesn't
+	if dbURL := os.Getenv("DATABASE_URL"); dbURL != "" {
+		var db storage.DatabaseConfig
+		if err := applyDatabaseURL(&db, dbURL); err != nil {
+			return nil, fmt.Errorf("failed to parse DATABASE_URL: %v", err)
+		}
+		for key, value := range map[string]any{
+			"database.host":            db.Host,
+			"database.port":            db.Port,
+			"database.user":            db.User,
+			"database.password":        db.Password,
+			"database.dbname":          db.DBName,
+			"database.sslmode":         db.SSLMode,
+			"database.connect_timeout": db.ConnectTimeout,
+		} {
+			if _, set := os.LookupEnv(envName(key)); !set {
+				v.Set(key, value)
+			}
+		}
+	}
+
+	// Read the config file. Without one, settings come from the environment
+	// and the defaults; Validate reports whatever required value is missing.
 	v.SetConfigFile(path)
-	if err := v.ReadInConfig(); err != nil {
+	if err := v.ReadInConfig(); err != nil && !isConfigFileMissing(err) {
 		return nil, err
 	}
 
@@ -95,26 +587,26 @@ func LoadConfig(path string) (*Config, error) {
 		return nil, err
 	}
 
-	// Check for DATABASE_URL environment variable
-	if dbURL := v.GetString("DATABASE_URL"); dbURL != "" {
-		dbConfig, err := parseDatabaseURL(dbURL)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse DATABASE_URL: %v", err)
-		}
-		config.Database = dbConfig
+	if err := readTemplateFile(&config.Telegram.WelcomeTemplate, config.Telegram.WelcomeTemplateFile, "telegram.welcome_template"); err != nil {
+		return nil, err
 	}
-
-	// Get other environment variables
-	if token := v.GetString("TELEGRAM_TOKEN"); token != "" {
-		config.Telegram.Token = token
+	if err := readTemplateFile(&config.Telegram.HelpTemplate, config.Telegram.HelpTemplateFile, "telegram.help_template"); err != nil {
+		return nil, err
 	}
 
-	if apiKey := v.GetString("OPENAI_API_KEY"); apiKey != "" {
-		config.OpenAI.APIKey = apiKey
+	if path := config.Classifier.InstructionsFile; path != "" {
+		if config.Classifier.Instructions != "" {
+			return nil, errors.New("set only one of classifier.instructions and classifier.instructions_file")
+		}
+		instructions, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read classifier.instructions_file: %v", err)
+		}
+		config.Classifier.Instructions = strings.TrimSpace(string(instructions))
 	}
 
-	if apiKey := v.GetString("OPENAI_ASSISTANT_ID"); apiKey != "" {
-		config.OpenAI.AssistantID = apiKey
+	if err := config.Validate(); err != nil {
+		return nil, err
 	}
 
 	return &config, nil